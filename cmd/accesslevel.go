@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// accessLevels are the Gitlab access levels psync grants. Owners are only granted to the groups configured so,
+// the owners of the parent group are managed by hand.
+var accessLevels = map[string]gitlab.AccessLevelValue{
+	"guest":      gitlab.GuestPermissions,
+	"reporter":   gitlab.ReporterPermissions,
+	"developer":  gitlab.DeveloperPermissions,
+	"maintainer": gitlab.MaintainerPermissions,
+	"owner":      gitlab.OwnerPermissions,
+}
+
+// groupAccessLevel returns the access level GROUP_ACCESS_LEVELS sets for the members of the Okta group,
+// zero to add them with their own access level. Keys are group names, or patterns like lead_* matched
+// against the Okta name of the group, e.g. so that the lead_ groups get maintainer.
+func groupAccessLevel(g OktaGroup) (gitlab.AccessLevelValue, error) {
+	levels := viper.GetStringMapString("GROUP_ACCESS_LEVELS")
+	name, ok := levels[strings.ToLower(g.Name)]
+	if !ok {
+		patterns := make([]string, 0, len(levels))
+		for p := range levels {
+			patterns = append(patterns, p)
+		}
+		// The first matching pattern in alphabetical order wins, so that the level doesn't change between runs
+		sort.Strings(patterns)
+		for _, p := range patterns {
+			if matched, _ := path.Match(p, strings.ToLower(g.OktaName)); matched {
+				name, ok = levels[p], true
+				break
+			}
+		}
+	}
+	if !ok {
+		return 0, nil
+	}
+	level, known := accessLevels[strings.ToLower(name)]
+	if !known {
+		return 0, fmt.Errorf("unknown access level %q of %s", name, g.Name)
+	}
+	return level, nil
+}
+
+// oktaAccessLevels collects the access levels requested with the ACCESS_LEVEL_ATTRIBUTE profile attribute
+// of the Okta users listed during the run, by user ID
+var oktaAccessLevels = map[string]string{}
+
+// recordAccessLevel keeps the access level requested in the profile of the Okta user, if any.
+func recordAccessLevel(id string, profile map[string]interface{}) {
+	attr := viper.GetString("ACCESS_LEVEL_ATTRIBUTE")
+	if attr == "" {
+		return
+	}
+	if level, ok := profile[attr].(string); ok && level != "" {
+		runMu.Lock()
+		oktaAccessLevels[id] = strings.ToLower(level)
+		runMu.Unlock()
+	}
+}
+
+// AccessLevelFor returns the access level to add the Okta user to the group with.
+// Users are developers, unless their ACCESS_LEVEL_ATTRIBUTE profile attribute requests another level
+// up to the ceiling of the group, set in GROUP_ACCESS_LEVEL_CEILINGS or else ACCESS_LEVEL_CEILING.
+func AccessLevelFor(group, userID string) gitlab.AccessLevelValue {
+	runMu.Lock()
+	requested, ok := oktaAccessLevels[userID]
+	runMu.Unlock()
+	if !ok {
+		return gitlab.DeveloperPermissions
+	}
+	level, ok := accessLevels[requested]
+	if !ok {
+		warnDataQuality("Okta user %s requests unknown access level %q, using developer", userID, requested)
+		return gitlab.DeveloperPermissions
+	}
+	ceilingName := viper.GetString("ACCESS_LEVEL_CEILING")
+	if c := viper.GetStringMapString("GROUP_ACCESS_LEVEL_CEILINGS")[strings.ToLower(group)]; c != "" {
+		ceilingName = c
+	}
+	ceiling, ok := accessLevels[strings.ToLower(ceilingName)]
+	if !ok {
+		warnDataQuality("unknown access level ceiling %q of %s, using developer", ceilingName, group)
+		return gitlab.DeveloperPermissions
+	}
+	if level > ceiling {
+		warnDataQuality("Okta user %s requests %s in %s above its ceiling %s, using developer", userID, requested, group, ceilingName)
+		return gitlab.DeveloperPermissions
+	}
+	return level
+}
+
+// accessLevelName returns the name of the access level.
+func accessLevelName(level gitlab.AccessLevelValue) string {
+	for name, l := range accessLevels {
+		if l == level {
+			return name
+		}
+	}
+	return fmt.Sprintf("access level %d", level)
+}
+
+// protectedAccessLevel returns the PROTECTED_ACCESS_LEVEL. Members with this access level or a higher one are read,
+// so that the membership counts are complete, but psync only changes the ones it added itself. Owners by default.
+func protectedAccessLevel() (gitlab.AccessLevelValue, error) {
+	name := viper.GetString("PROTECTED_ACCESS_LEVEL")
+	level, ok := accessLevels[strings.ToLower(name)]
+	if !ok || level < gitlab.ReporterPermissions {
+		return 0, fmt.Errorf("unknown PROTECTED_ACCESS_LEVEL %q, expected reporter, developer, maintainer or owner", name)
+	}
+	return level, nil
+}
+
+// isProtectedMember tells whether the Gitlab member is at or above PROTECTED_ACCESS_LEVEL, owner when it is invalid.
+// Group members psync added lose the protection again, see unprotectManaged.
+func isProtectedMember(m *gitlab.GroupMember) bool {
+	level, err := protectedAccessLevel()
+	if err != nil {
+		level = gitlab.OwnerPermissions
+	}
+	return m.AccessLevel >= level
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// alerts collects the conditions raised during the run that need the attention of an operator
+var alerts []string
+
+// raiseAlert prints and records an alert.
+func raiseAlert(format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	runMu.Lock()
+	defer runMu.Unlock()
+	logger.Error(msg, "alert", true)
+	alerts = append(alerts, msg)
+	writeRecord(OutputRecord{Type: "alert", RunID: runID(), Message: msg})
+}
+
+// highAlertPrefix marks the alerts of security-relevant failures, such as users keeping access they lost in Okta
+const highAlertPrefix = "HIGH: "
+
+// raiseHighAlert prints and records a high-severity alert, which is sent ahead of the other alerts.
+func raiseHighAlert(format string, a ...interface{}) {
+	raiseAlert(highAlertPrefix+format, a...)
+}
+
+// sendAlerts sends the alerts of the run in one notification to ALERT_RECIPIENT
+// through the ALERT_NOTIFIER backend, if configured.
+func sendAlerts(runID string) error {
+	kind := viper.GetString("ALERT_NOTIFIER")
+	if len(alerts) == 0 || kind == "" {
+		return nil
+	}
+	n, err := NewNotifier(kind)
+	if err != nil {
+		return err
+	}
+	// High-severity alerts first, so they aren't buried in the list
+	sorted := make([]string, 0, len(alerts))
+	high := 0
+	for _, a := range alerts {
+		if strings.HasPrefix(a, highAlertPrefix) {
+			sorted = append(sorted, a)
+			high++
+		}
+	}
+	for _, a := range alerts {
+		if !strings.HasPrefix(a, highAlertPrefix) {
+			sorted = append(sorted, a)
+		}
+	}
+	subject := fmt.Sprintf("psync run %s raised %d alerts", runID, len(alerts))
+	if high > 0 {
+		subject = fmt.Sprintf("psync run %s raised %d high-severity alerts, %d alerts in total", runID, high, len(alerts))
+	}
+	return n.Send(viper.GetString("ALERT_RECIPIENT"), Notification{
+		Subject: subject,
+		Text:    "- " + strings.Join(sorted, "\n- "),
+	})
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// GrafanaAnnotator marks the change window of a run on the Grafana dashboards with an annotation,
+// so that permission changes can be correlated with incidents and deployments.
+// The annotation is created when the run starts applying changes and closed when it ends.
+type GrafanaAnnotator struct {
+	URL    string
+	Token  string
+	Client *http.Client
+	// Tags are added to the psync tag, so that dashboards can filter the annotations
+	Tags []string
+
+	// id of the annotation of the current run, zero before Start
+	id int
+}
+
+// NewGrafanaAnnotator returns the annotator of the GRAFANA_URL Grafana instance, authenticated with the service
+// account token stored in the GRAFANA_TOKEN_SECRET secret, or nil if annotations are disabled.
+func NewGrafanaAnnotator() (*GrafanaAnnotator, error) {
+	base := strings.TrimRight(viper.GetString("GRAFANA_URL"), "/")
+	if base == "" {
+		return nil, nil
+	}
+	token, err := credential(nil, "GRAFANA_TOKEN_SECRET")
+	if err != nil {
+		return nil, err
+	}
+	return &GrafanaAnnotator{
+		URL:    base,
+		Token:  strings.TrimSpace(string(token)),
+		Client: &http.Client{Timeout: viper.GetDuration("GRAFANA_TIMEOUT")},
+		Tags:   viper.GetStringSlice("GRAFANA_ANNOTATION_TAGS"),
+	}, nil
+}
+
+// Start creates the annotation of the run at the time changes start being applied.
+// Failing to annotate never fails the sync, the error is only reported.
+func (a *GrafanaAnnotator) Start(run *Run) {
+	if a == nil {
+		return
+	}
+	body := map[string]interface{}{
+		"time": clock.Now().UnixNano() / 1e6,
+		"tags": append([]string{"psync", "run:" + run.ID}, a.Tags...),
+		"text": fmt.Sprintf("psync run %s applying %d changes", run.ID, run.Summary.Drift),
+	}
+	var created struct {
+		ID int `json:"id"`
+	}
+	if err := a.call(http.MethodPost, "/api/annotations", body, &created); err != nil {
+		logger.Warn("Could not annotate the start of the run", "error", err)
+		return
+	}
+	a.id = created.ID
+}
+
+// End closes the annotation of the run, turning it into a region over the change window, with the outcome of the run.
+func (a *GrafanaAnnotator) End(run *Run, outcome string) {
+	if a == nil || a.id == 0 {
+		return
+	}
+	s := run.Summary
+	body := map[string]interface{}{
+		"timeEnd": clock.Now().UnixNano() / 1e6,
+		"text": fmt.Sprintf("psync run %s %s: added %d, removed %d of %d changes",
+			run.ID, outcome, s.Added, s.Removed, s.Drift),
+	}
+	if err := a.call(http.MethodPatch, fmt.Sprintf("/api/annotations/%d", a.id), body, nil); err != nil {
+		logger.Warn("Could not annotate the end of the run", "error", err)
+	}
+	a.id = 0
+}
+
+// call invokes a Grafana HTTP API method.
+func (a *GrafanaAnnotator) call(method, path string, body, v interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, a.URL+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+a.Token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("grafana %s %s: %s", method, path, resp.Status)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	auditSampleSize int
+	auditSeed       int64
+)
+
+// auditCmd groups the commands that verify the synced memberships
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Verify the synced memberships",
+}
+
+// auditSampleCmd re-verifies a random sample of the managed memberships
+var auditSampleCmd = &cobra.Command{
+	Use:   "sample",
+	Short: "Re-verify a random sample of managed memberships against Okta and Gitlab",
+	Long: `Randomly sample user-group pairs of the Okta groups mapped in the state and verify each of them live:
+active Okta group members must be members of the Gitlab group, deprovisioned or suspended ones must not.
+Prints the mismatches and a 95% confidence interval of the mismatch rate of all managed memberships,
+and fails if any mismatch is found.`,
+	Example: `  psync audit sample --n 50`,
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if auditSampleSize <= 0 {
+			cobra.CheckErr("--n must be positive")
+		}
+		ctx, client, gitlabClt, err := NewClients()
+		cobra.CheckErr(err)
+		state, err := LoadState()
+		cobra.CheckErr(err)
+		if len(state.Groups) == 0 {
+			cobra.CheckErr(errors.New("no managed groups in the state, run a sync first"))
+		}
+
+		// The population is every member of the mapped Okta groups, in a stable order so that seeds are reproducible
+		type pair struct {
+			oktaGroupID, group, user string
+			gitlabID                 int
+		}
+		oktaIDs := make([]string, 0, len(state.Groups))
+		for id := range state.Groups {
+			oktaIDs = append(oktaIDs, id)
+		}
+		sort.Strings(oktaIDs)
+		population := make([]pair, 0)
+		for _, id := range oktaIDs {
+			m := state.Groups[id]
+			active, deprovisioned, err := ListOktaGroupUsers(ctx, client, id)
+			cobra.CheckErr(err)
+			for _, u := range append(active, deprovisioned...) {
+				population = append(population, pair{id, m.OktaName, u, m.GitlabID})
+			}
+		}
+		if len(population) == 0 {
+			cobra.CheckErr(errors.New("the mapped Okta groups have no members"))
+		}
+		identities := make(map[string]int)
+		afklMembers, _, err := ParentGroupMembers(gitlabClt)
+		cobra.CheckErr(err)
+		for id, uid := range GitlabIdentities(afklMembers) {
+			identities[uid] = id
+		}
+
+		seed := auditSeed
+		if seed == 0 {
+			seed = clock.Now().UnixNano()
+		}
+		n := auditSampleSize
+		if n > len(population) {
+			n = len(population)
+		}
+		sample := rand.New(rand.NewSource(seed)).Perm(len(population))[:n]
+
+		verified, mismatches := 0, make([]string, 0)
+		for _, i := range sample {
+			p := population[i]
+			user, resp, err := client.User.GetUser(ctx, p.user)
+			oktaRateLimit.Observe(resp)
+			cobra.CheckErr(err)
+			groups, resp, err := client.User.ListUserGroups(ctx, p.user)
+			oktaRateLimit.Observe(resp)
+			cobra.CheckErr(err)
+			inGroup := false
+			for _, g := range groups {
+				inGroup = inGroup || g.Id == p.oktaGroupID
+			}
+			// Users who left the group since it was listed are not managed by it anymore
+			if !inGroup {
+				continue
+			}
+			gitlabUser, ok := identities[p.user]
+			if !ok {
+				fmt.Printf("Cannot verify %s in %s: no parent group member with their SAML identity\n", p.user, p.group)
+				continue
+			}
+			_, glResp, err := gitlabClt.GroupMembers.GetGroupMember(p.gitlabID, gitlabUser)
+			if err != nil && (glResp == nil || glResp.StatusCode != http.StatusNotFound) {
+				cobra.CheckErr(err)
+			}
+			isMember := err == nil
+			shouldBeMember := user.Status != "DEPROVISIONED" && user.Status != "SUSPENDED"
+			verified++
+			if isMember != shouldBeMember {
+				mismatch := fmt.Sprintf("%s: Okta user %s is %s, Gitlab member: %t", p.group, p.user, user.Status, isMember)
+				fmt.Printf("Mismatch in %s\n", mismatch)
+				mismatches = append(mismatches, mismatch)
+			}
+		}
+
+		fmt.Printf("Audit sample (seed %d): verified %d of %d managed memberships, %d mismatches\n",
+			seed, verified, len(population), len(mismatches))
+		if verified > 0 {
+			low, high := wilsonInterval(len(mismatches), verified, 1.96)
+			fmt.Printf("Mismatch rate %.1f%%, 95%% confidence interval %.1f%%-%.1f%% (about %d-%d memberships)\n",
+				100*float64(len(mismatches))/float64(verified), 100*low, 100*high,
+				int(math.Floor(low*float64(len(population)))), int(math.Ceil(high*float64(len(population)))))
+		}
+		if len(mismatches) > 0 {
+			cobra.CheckErr(fmt.Errorf("%d of %d sampled memberships don't match", len(mismatches), verified))
+		}
+	},
+}
+
+// wilsonInterval returns the Wilson score interval of a proportion of k successes in n trials.
+// Unlike the normal approximation it stays meaningful for small samples and proportions close to zero.
+func wilsonInterval(k, n int, z float64) (low, high float64) {
+	p, nf := float64(k)/float64(n), float64(n)
+	center := (p + z*z/(2*nf)) / (1 + z*z/nf)
+	margin := z / (1 + z*z/nf) * math.Sqrt(p*(1-p)/nf+z*z/(4*nf*nf))
+	return math.Max(0, center-margin), math.Min(1, center+margin)
+}
+
+func init() {
+	auditSampleCmd.Flags().IntVar(&auditSampleSize, "n", 50, "number of memberships to sample")
+	auditSampleCmd.Flags().Int64Var(&auditSeed, "seed", 0, "random seed of the sample (default is time-based)")
+	auditCmd.AddCommand(auditSampleCmd)
+	rootCmd.AddCommand(auditCmd)
+}
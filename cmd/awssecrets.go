@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/spf13/viper"
+)
+
+// awsSecretPrefix marks the secrets of AWS Secrets Manager given by name, e.g. aws:psync/gitlab-token
+const awsSecretPrefix = "aws:"
+
+// isAWSSecret tells whether the secret name is an AWS Secrets Manager secret, by name or ARN,
+// rather than a GCP Secret Manager secret version.
+func isAWSSecret(name string) bool {
+	return strings.HasPrefix(name, awsSecretPrefix) || strings.HasPrefix(name, "arn:aws:secretsmanager:")
+}
+
+// accessAWSSecretVersion fetches the payload of an AWS Secrets Manager secret, together with the name of its version.
+// Like GCP secret versions, the name may end with /versions/<version>: a version ID, a staging label such as
+// AWSPENDING, or latest for AWSCURRENT, the default. The region is the one of the ARN, or AWS_REGION, and
+// the credentials are the usual ones of the AWS SDK: environment, shared config or the instance role.
+func accessAWSSecretVersion(name string) (string, []byte, error) {
+	id, version := strings.TrimPrefix(name, awsSecretPrefix), "latest"
+	if i := strings.LastIndex(id, "/versions/"); i >= 0 {
+		id, version = id[:i], id[i+len("/versions/"):]
+	}
+	cfg := aws.NewConfig()
+	if parts := strings.Split(id, ":"); strings.HasPrefix(id, "arn:") && len(parts) > 3 {
+		cfg = cfg.WithRegion(parts[3])
+	} else if region := viper.GetString("AWS_REGION"); region != "" {
+		cfg = cfg.WithRegion(region)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{Config: *cfg, SharedConfigState: session.SharedConfigEnable})
+	if err != nil {
+		return "", nil, err
+	}
+	req := &secretsmanager.GetSecretValueInput{SecretId: aws.String(id)}
+	switch {
+	case version == "latest":
+		req.VersionStage = aws.String("AWSCURRENT")
+	case strings.HasPrefix(version, "AWS"):
+		req.VersionStage = aws.String(version)
+	default:
+		req.VersionId = aws.String(version)
+	}
+	secret, err := secretsmanager.New(sess).GetSecretValue(req)
+	if err != nil {
+		return "", nil, fmt.Errorf("getting the AWS secret %s: %w", id, err)
+	}
+	payload := secret.SecretBinary
+	if secret.SecretString != nil {
+		payload = []byte(*secret.SecretString)
+	}
+	return aws.StringValue(secret.ARN) + "/versions/" + aws.StringValue(secret.VersionId), payload, nil
+}
@@ -0,0 +1,217 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// graphURL is the Microsoft Graph API
+const graphURL = "https://graph.microsoft.com/v1.0"
+
+// AzureSource reads the groups from Azure AD (Entra ID) through Microsoft Graph, with the client credentials
+// of the AZURE_CLIENT_ID app registration, which needs the GroupMember.Read.All and User.Read.All permissions.
+// Groups are selected and named like Okta groups, with OKTA_GROUP_PREFIX, OKTA_GROUP_PATTERN and OKTA_GROUP_NAME_TEMPLATE.
+// Enabled members are synced, disabled members are removed like deprovisioned Okta users.
+// Users are identified by their AZURE_USER_ID_ATTRIBUTE, which must match the SAML identities in Gitlab.
+type AzureSource struct {
+	Tenant       string
+	ClientID     string
+	ClientSecret string
+	Client       *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// NewAzureSource reads the client secret stored in the AZURE_CLIENT_SECRET secret, or its version active in the state.
+func NewAzureSource(state *State) (*AzureSource, error) {
+	tenant, clientID := viper.GetString("AZURE_TENANT_ID"), viper.GetString("AZURE_CLIENT_ID")
+	if tenant == "" || clientID == "" {
+		return nil, fmt.Errorf("the azure identity provider requires AZURE_TENANT_ID and AZURE_CLIENT_ID")
+	}
+	secret, err := credential(state, "AZURE_CLIENT_SECRET")
+	if err != nil {
+		return nil, err
+	}
+	transport, err := NewTransport("AZURE")
+	if err != nil {
+		return nil, err
+	}
+	return &AzureSource{
+		Tenant:       tenant,
+		ClientID:     clientID,
+		ClientSecret: strings.TrimSpace(string(secret)),
+		Client: &http.Client{
+			Transport: transportHook("AZURE", &TracingTransport{Provider: "azure", Next: &MetricsTransport{Provider: "azure", Next: NewCircuitBreaker("AZURE", transport)}}),
+			Timeout:   viper.GetDuration("AZURE_TIMEOUT"),
+		},
+	}, nil
+}
+
+type graphGroup struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"displayName"`
+}
+
+type graphUser struct {
+	ID                string `json:"id"`
+	UserPrincipalName string `json:"userPrincipalName"`
+	Mail              string `json:"mail"`
+	AccountEnabled    bool   `json:"accountEnabled"`
+}
+
+// Groups fetches the members of the Azure AD groups selected by their name.
+// Groups whose members cannot be listed are left out with a data-quality warning, so the others are still synced.
+func (s *AzureSource) Groups(run *Run) ([]OktaGroup, error) {
+	namer, err := NewOktaGroupNamer()
+	if err != nil {
+		return nil, err
+	}
+	q := url.Values{"$select": {"id,displayName"}}
+	if namer.Prefix != "" {
+		q.Set("$filter", fmt.Sprintf("startswith(displayName,'%s')", strings.ReplaceAll(namer.Prefix, "'", "''")))
+	}
+	var found []graphGroup
+	if err := s.list("/groups?"+q.Encode(), &found); err != nil {
+		return nil, fmt.Errorf("listing the Azure AD %s groups: %w", namer.Prefix, err)
+	}
+	groups := make([]OktaGroup, 0, len(found))
+	for _, g := range found {
+		name, ok, err := namer.Name(g.DisplayName)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		gr := OktaGroup{ID: g.ID, Name: name, OktaName: g.DisplayName, Users: []string{}, Deprovisioned: []string{}}
+		// Members of nested groups are members too, like in Azure AD app assignments
+		var users []graphUser
+		path := fmt.Sprintf("/groups/%s/transitiveMembers/microsoft.graph.user?$select=id,userPrincipalName,mail,accountEnabled", url.PathEscape(g.ID))
+		if err := s.list(path, &users); err != nil {
+			warnDataQuality("Azure AD group %s is not synced: %v", g.DisplayName, err)
+			continue
+		}
+		for _, u := range users {
+			id := u.ID
+			if viper.GetString("AZURE_USER_ID_ATTRIBUTE") == "userPrincipalName" {
+				id = u.UserPrincipalName
+			}
+			if u.AccountEnabled {
+				gr.Users = append(gr.Users, id)
+			} else {
+				gr.Deprovisioned = append(gr.Deprovisioned, id)
+			}
+		}
+		groups = append(groups, gr)
+	}
+	return groups, nil
+}
+
+// UserEmail returns the mail of the Azure AD user, or their user principal name if they have no mailbox.
+func (s *AzureSource) UserEmail(userID string) (string, error) {
+	var u graphUser
+	if err := s.get("/users/"+url.PathEscape(userID)+"?$select=id,userPrincipalName,mail", &u); err != nil {
+		return "", err
+	}
+	if u.Mail != "" {
+		return u.Mail, nil
+	}
+	return u.UserPrincipalName, nil
+}
+
+// list reads all pages of a Graph collection into v, a pointer to a slice.
+func (s *AzureSource) list(path string, v interface{}) error {
+	all := []json.RawMessage{}
+	next := graphURL + path
+	for next != "" {
+		var page struct {
+			Value    []json.RawMessage `json:"value"`
+			NextLink string            `json:"@odata.nextLink"`
+		}
+		if err := s.getURL(next, &page); err != nil {
+			return err
+		}
+		all = append(all, page.Value...)
+		next = page.NextLink
+	}
+	data, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// get reads a Graph resource into v.
+func (s *AzureSource) get(path string, v interface{}) error {
+	return s.getURL(graphURL+path, v)
+}
+
+func (s *AzureSource) getURL(u string, v interface{}) error {
+	token, err := s.accessToken()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("GET %s: %s: %s", u, resp.Status, strings.TrimSpace(string(data)))
+	}
+	return json.Unmarshal(data, v)
+}
+
+// accessToken returns a Graph access token from the client credentials, renewed shortly before it expires.
+func (s *AzureSource) accessToken() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && clock.Now().Before(s.expires) {
+		return s.token, nil
+	}
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {s.ClientID},
+		"client_secret": {s.ClientSecret},
+		"scope":         {"https://graph.microsoft.com/.default"},
+	}
+	endpoint := fmt.Sprintf("https://login.microsoftonline.com/%s/oauth2/v2.0/token", url.PathEscape(s.Tenant))
+	resp, err := s.Client.PostForm(endpoint, form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+		Error       string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("reading the Azure AD token: %w", err)
+	}
+	if resp.StatusCode >= http.StatusBadRequest || token.AccessToken == "" {
+		return "", fmt.Errorf("getting an Azure AD token: %s: %s", resp.Status, token.Error)
+	}
+	s.token = token.AccessToken
+	s.expires = clock.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return s.token, nil
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/spf13/viper"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// Backoff returns the wait before the next retry, given the number of the failed attempt, from zero,
+// the base wait and the previous wait.
+type Backoff func(attempt int, base, previous time.Duration) time.Duration
+
+// backoffs are the strategies that can be selected with RETRY_BACKOFF
+var backoffs = map[string]Backoff{
+	"fixed": func(attempt int, base, previous time.Duration) time.Duration {
+		return base
+	},
+	"exponential": func(attempt int, base, previous time.Duration) time.Duration {
+		return base << uint(attempt)
+	},
+	// Decorrelated jitter spreads the retries of concurrent clients, see
+	// https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+	"decorrelated_jitter": func(attempt int, base, previous time.Duration) time.Duration {
+		if previous < base {
+			previous = base
+		}
+		return base + time.Duration(rand.Int63n(int64(previous)*3-int64(base)+1))
+	},
+}
+
+// runDeadline is when the current run must be done, set with RUN_TIMEOUT, zero without deadline.
+// Retries are given up near it, so that a Cloud Function isn't killed in the middle of the changes of a run:
+// set RUN_TIMEOUT below the function timeout by at least the provider timeouts.
+var runDeadline time.Time
+
+// setRunDeadline sets the deadline of the run started at the time.
+func setRunDeadline(started time.Time) {
+	runMu.Lock()
+	defer runMu.Unlock()
+	runDeadline = time.Time{}
+	if timeout := viper.GetDuration("RUN_TIMEOUT"); timeout > 0 {
+		runDeadline = started.Add(timeout)
+	}
+}
+
+// retryWithBackoff calls fn until it succeeds, waiting after each retryable failure as the RETRY_BACKOFF strategy says,
+// up to RETRY_MAX_WAIT. Rate limited, server and network errors are retried, any other error is returned immediately.
+// No attempt is made after the deadline of the run, nor a retry whose wait would end after it.
+func retryWithBackoff(attempts int, wait time.Duration, fn func() (*gitlab.Response, error)) error {
+	name := viper.GetString("RETRY_BACKOFF")
+	backoff, ok := backoffs[name]
+	if !ok {
+		return fmt.Errorf("unknown RETRY_BACKOFF %q, expected fixed, exponential or decorrelated_jitter", name)
+	}
+	maxWait := viper.GetDuration("RETRY_MAX_WAIT")
+	runMu.Lock()
+	deadline := runDeadline
+	runMu.Unlock()
+
+	var err error
+	previous := wait
+	for i := 0; i < attempts; i++ {
+		if !deadline.IsZero() && !clock.Now().Before(deadline) {
+			return fmt.Errorf("the run deadline %s passed", deadline.Format(time.RFC3339))
+		}
+		var resp *gitlab.Response
+		resp, err = fn()
+		if err == nil || !retryable(resp) {
+			return err
+		}
+		if i < attempts-1 {
+			next := backoff(i, wait, previous)
+			if maxWait > 0 && next > maxWait {
+				next = maxWait
+			}
+			if !deadline.IsZero() && clock.Now().Add(next).After(deadline) {
+				return fmt.Errorf("not retrying, the run deadline %s is too close: %w", deadline.Format(time.RFC3339), err)
+			}
+			clock.Sleep(next)
+			previous = next
+		}
+	}
+	return err
+}
+
+// retryable reports whether a failed request may succeed when retried.
+func retryable(resp *gitlab.Response) bool {
+	if resp == nil || resp.Response == nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+}
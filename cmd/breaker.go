@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// CircuitBreaker is an HTTP transport that fails fast once a provider failed Threshold times in a row.
+// After Cooldown one request is let through again, and the breaker closes when it succeeds.
+// Network errors and server errors count as failures, rate limiting doesn't.
+type CircuitBreaker struct {
+	Provider  string
+	Next      http.RoundTripper
+	Threshold int
+	Cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+// NewCircuitBreaker wraps the transport of the provider, e.g. OKTA or GITLAB, with the breaker configured
+// with <PROVIDER>_BREAKER_THRESHOLD and <PROVIDER>_BREAKER_COOLDOWN. A threshold of 0 disables the breaker.
+func NewCircuitBreaker(provider string, next http.RoundTripper) http.RoundTripper {
+	threshold := viper.GetInt(provider + "_BREAKER_THRESHOLD")
+	if threshold <= 0 {
+		return next
+	}
+	return &CircuitBreaker{
+		Provider:  provider,
+		Next:      next,
+		Threshold: threshold,
+		Cooldown:  viper.GetDuration(provider + "_BREAKER_COOLDOWN"),
+	}
+}
+
+// RoundTrip sends the request unless the breaker is open.
+func (b *CircuitBreaker) RoundTrip(req *http.Request) (*http.Response, error) {
+	b.mu.Lock()
+	if now := clock.Now(); b.failures >= b.Threshold && now.Before(b.openUntil) {
+		b.mu.Unlock()
+		return nil, fmt.Errorf("%s circuit breaker open after %d consecutive failures, retrying after %s",
+			b.Provider, b.failures, b.openUntil.Format(time.RFC3339))
+	} else if b.failures >= b.Threshold {
+		// Let one request through while the others keep failing fast
+		b.openUntil = now.Add(b.Cooldown)
+	}
+	b.mu.Unlock()
+
+	resp, err := b.Next.RoundTrip(req)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil || resp.StatusCode >= http.StatusInternalServerError {
+		b.failures++
+		if b.failures >= b.Threshold {
+			b.openUntil = clock.Now().Add(b.Cooldown)
+		}
+	} else {
+		b.failures = 0
+	}
+	return resp, err
+}
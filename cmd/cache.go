@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ETagCache is an HTTP transport that caches GET responses carrying an ETag on disk
+// and revalidates them with If-None-Match, so unchanged member lists don't count against the API quota.
+type ETagCache struct {
+	Dir    string
+	Next   http.RoundTripper
+	Hits   int
+	Misses int
+
+	mu sync.Mutex
+}
+
+// cachedResponse is a response stored on disk
+type cachedResponse struct {
+	ETag   string      `json:"etag"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// NewETagCache returns the cache transport storing its entries in the directory.
+func NewETagCache(dir string) (*ETagCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &ETagCache{Dir: dir, Next: http.DefaultTransport}, nil
+}
+
+// RoundTrip sends the request, answering it from the cache when the server reports it unchanged.
+func (c *ETagCache) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return c.Next.RoundTrip(req)
+	}
+	path := c.path(req)
+	cached := c.load(path)
+	if cached != nil {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+	resp, err := c.Next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		c.count(&c.Hits)
+		_ = resp.Body.Close()
+		return &http.Response{
+			Status:        "200 OK",
+			StatusCode:    http.StatusOK,
+			Proto:         resp.Proto,
+			ProtoMajor:    resp.ProtoMajor,
+			ProtoMinor:    resp.ProtoMinor,
+			Header:        cached.Header,
+			Body:          ioutil.NopCloser(bytes.NewReader(cached.Body)),
+			ContentLength: int64(len(cached.Body)),
+			Request:       req,
+		}, nil
+	}
+	c.count(&c.Misses)
+
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" {
+		return resp, nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	c.save(path, &cachedResponse{ETag: etag, Header: resp.Header, Body: body})
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// path returns the cache file of the request. The credentials are part of the key,
+// since different tokens may see different data.
+func (c *ETagCache) path(req *http.Request) string {
+	h := sha256.New()
+	_, _ = fmt.Fprintln(h, req.URL.String())
+	_, _ = fmt.Fprintln(h, req.Header.Get("Private-Token"), req.Header.Get("Authorization"))
+	return filepath.Join(c.Dir, hex.EncodeToString(h.Sum(nil))+".json")
+}
+
+// load reads a cache entry, a missing or corrupt entry is a cache miss.
+func (c *ETagCache) load(path string) *cachedResponse {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	lc, err := LocalCipher()
+	if err != nil {
+		return nil
+	}
+	if data, err = lc.Open(data); err != nil {
+		return nil
+	}
+	cached := new(cachedResponse)
+	if err := json.Unmarshal(data, cached); err != nil || cached.ETag == "" {
+		return nil
+	}
+	return cached
+}
+
+// save writes a cache entry. Failing to cache is not an error.
+func (c *ETagCache) save(path string, cached *cachedResponse) {
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return
+	}
+	lc, err := LocalCipher()
+	if err != nil {
+		return
+	}
+	if data, err = lc.Seal(data); err != nil {
+		return
+	}
+	_ = ioutil.WriteFile(path, data, 0600)
+}
+
+// String summarizes the cache usage of the run.
+func (c *ETagCache) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return fmt.Sprintf("%d unchanged, %d fetched", c.Hits, c.Misses)
+}
+
+// count increments a counter of the cache.
+func (c *ETagCache) count(counter *int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*counter++
+}
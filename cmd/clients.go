@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+	"github.com/okta/okta-sdk-golang/v2/okta"
+	"github.com/spf13/viper"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+	"go.opentelemetry.io/otel/attribute"
+	secretmanagerpb "google.golang.org/genproto/googleapis/cloud/secretmanager/v1"
+)
+
+// gitlabCache is the response cache of the Gitlab client, nil when caching is disabled
+var gitlabCache *ETagCache
+
+// transportHook wraps the transports of the API clients of a provider, e.g. to replay a snapshot of the provider
+var transportHook = func(provider string, rt http.RoundTripper) http.RoundTripper { return rt }
+
+// NewClients fetches the API tokens from the secret manager and initializes the Okta and Gitlab clients.
+func NewClients() (context.Context, *okta.Client, *gitlab.Client, error) {
+	// Read the token versions activated by rotate-check, if any
+	state, err := LoadState()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ctx, client, err := NewOktaClient(state)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	gitlabClt, err := NewGitlabClient(state)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return ctx, client, gitlabClt, nil
+}
+
+// NewOktaClient fetches the Okta API token, see apiToken, and initializes the Okta client.
+func NewOktaClient(state *State) (context.Context, *okta.Client, error) {
+	oktaToken, err := apiToken(state, "OKTA")
+	if err != nil {
+		return nil, nil, err
+	}
+	// Initialize Okta Client
+	oktaTransport, err := NewTransport("OKTA")
+	if err != nil {
+		return nil, nil, err
+	}
+	var oktaRoundTripper http.RoundTripper = NewCircuitBreaker("OKTA", oktaTransport)
+	if viper.GetBool("LEAN_API") {
+		oktaRoundTripper = &LeanOktaTransport{Next: oktaRoundTripper}
+	}
+	ctx, client, err := okta.NewClient(context.Background(),
+		okta.WithHttpClient(http.Client{Transport: transportHook("OKTA", &UserAgentTransport{Next: &TracingTransport{Provider: "okta", Next: &MetricsTransport{Provider: "okta", Next: oktaRoundTripper}}})}),
+		okta.WithOrgUrl(viper.GetString("OKTA_ORG_URL")),
+		// Plain HTTP is only for local mocks, see psync mock serve
+		okta.WithTestingDisableHttpsCheck(isLoopbackURL(viper.GetString("OKTA_ORG_URL"))),
+		okta.WithToken(oktaToken),
+		okta.WithRequestTimeout(int64(viper.GetDuration("OKTA_TIMEOUT").Seconds())),
+		okta.WithRateLimitMaxRetries(3))
+	if err != nil {
+		return nil, nil, err
+	}
+	if viper.GetBool("OKTA_PRECHECK") {
+		if err := checkOktaHealth(ctx, client); err != nil {
+			return nil, nil, err
+		}
+	}
+	return ctx, client, nil
+}
+
+// NewGitlabClient fetches the Gitlab API token, see apiToken, and initializes the Gitlab client.
+func NewGitlabClient(state *State) (*gitlab.Client, error) {
+	gitlabToken, err := apiToken(state, "GITLAB")
+	if err != nil {
+		return nil, err
+	}
+
+	// Initialize Gitlab Client, revalidating cached responses when GITLAB_CACHE_DIR is set
+	gitlabTransport, err := NewTransport("GITLAB")
+	if err != nil {
+		return nil, err
+	}
+	transport := NewCircuitBreaker("GITLAB", gitlabTransport)
+	if dir := viper.GetString("GITLAB_CACHE_DIR"); dir != "" {
+		if gitlabCache, err = NewETagCache(dir); err != nil {
+			return nil, err
+		}
+		gitlabCache.Next = transport
+		transport = gitlabCache
+	}
+	return gitlab.NewClient(gitlabToken, gitlab.WithBaseURL(viper.GetString("GITLAB_URL")), gitlab.WithHTTPClient(&http.Client{
+		Transport: transportHook("GITLAB", &UserAgentTransport{Next: &TracingTransport{Provider: "gitlab", Next: &MetricsTransport{Provider: "gitlab", Next: transport}}}),
+		Timeout:   viper.GetDuration("GITLAB_TIMEOUT"),
+	}))
+}
+
+// AccessSecret fetches the payload of the secret version from GCP Secret Manager, or AWS Secrets Manager for aws: names and ARNs.
+func AccessSecret(name string) ([]byte, error) {
+	_, payload, err := AccessSecretVersion(name)
+	return payload, err
+}
+
+// AccessSecretVersion fetches the payload of the secret version from GCP Secret Manager, or AWS Secrets Manager,
+// together with the name of the version, resolving aliases such as latest.
+func AccessSecretVersion(name string) (string, []byte, error) {
+	span := startSpan("psync.secret.access", attribute.String("psync.secret", name))
+	if isAWSSecret(name) {
+		runQuota.secretAccessed("aws")
+	} else {
+		runQuota.secretAccessed("gcp")
+	}
+	version, payload, err := accessSecretVersion(name)
+	span.end(err)
+	return version, payload, err
+}
+
+func accessSecretVersion(name string) (string, []byte, error) {
+	if isAWSSecret(name) {
+		return accessAWSSecretVersion(name)
+	}
+	// Create the GCP client
+	gcpCtx := context.Background()
+	gcpClient, err := secretmanager.NewClient(gcpCtx, gcpOptions()...)
+	if err != nil {
+		return "", nil, err
+	}
+	defer gcpClient.Close()
+	req := &secretmanagerpb.AccessSecretVersionRequest{Name: name}
+	secret, err := gcpClient.AccessSecretVersion(gcpCtx, req)
+	if err != nil {
+		return "", nil, err
+	}
+	return secret.Name, secret.Payload.Data, nil
+}
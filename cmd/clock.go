@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Clock abstracts the passing of time, so that time-dependent behavior can be tested deterministically.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+// SystemClock is the real clock.
+type SystemClock struct{}
+
+// Now returns the current time.
+func (SystemClock) Now() time.Time { return time.Now() }
+
+// Sleep pauses the current goroutine.
+func (SystemClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// ManualClock is a clock that only moves when it sleeps.
+type ManualClock struct {
+	T time.Time
+}
+
+// Now returns the time of the clock.
+func (c *ManualClock) Now() time.Time { return c.T }
+
+// Sleep advances the clock without pausing.
+func (c *ManualClock) Sleep(d time.Duration) { c.T = c.T.Add(d) }
+
+// IDGenerator generates the IDs of runs and the records they produce.
+type IDGenerator interface {
+	NewID() string
+}
+
+// RandomIDs generates IDs made of the current time and a random suffix, so that they sort chronologically.
+type RandomIDs struct {
+	Clock Clock
+}
+
+// NewID returns a new unique ID.
+func (g RandomIDs) NewID() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return g.Clock.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b)
+}
+
+// SequentialIDs generates predictable IDs made of a prefix and a counter.
+type SequentialIDs struct {
+	Prefix string
+	n      int
+}
+
+// NewID returns the next ID in the sequence.
+func (g *SequentialIDs) NewID() string {
+	g.n++
+	return fmt.Sprintf("%s%d", g.Prefix, g.n)
+}
+
+// clock and ids are used by all time-dependent code, replace them to make runs deterministic
+var (
+	clock Clock       = SystemClock{}
+	ids   IDGenerator = RandomIDs{Clock: SystemClock{}}
+)
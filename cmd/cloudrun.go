@@ -0,0 +1,232 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/oauth2/google"
+)
+
+var cloudRunListen string
+
+// RunCheckpoint is the progress of a run applied over several requests by psync cloudrun.
+type RunCheckpoint struct {
+	Started time.Time `json:"started"`
+	// Slice is the number of requests that worked on the run so far
+	Slice int `json:"slice"`
+	// Remaining are the IDs of the Okta groups left to apply
+	Remaining []string `json:"remaining,omitempty"`
+}
+
+// cloudRunRequest is the body of the Cloud Tasks task resuming a run. Scheduled requests have none.
+type cloudRunRequest struct {
+	// Slice is the slice of the run the task resumes, to drop the tasks delivered twice
+	Slice int `json:"slice"`
+}
+
+// cloudRunCmd serves the syncs as requests, for Cloud Run
+var cloudRunCmd = &cobra.Command{
+	Use:   "cloudrun",
+	Short: "Serve the syncs as resumable requests, for Cloud Run",
+	Long: `Serve the syncs as requests to POST /run, e.g. from Cloud Scheduler, for a Cloud Run service whose CPU is
+only allocated during the requests. Unlike psync serve, nothing runs between the requests.
+
+Each request works on the run for at most CLOUDRUN_SLICE, applying the groups by chunks of CLOUDRUN_CHUNK_GROUPS
+and saving the state after each chunk, with the groups left. Set CLOUDRUN_SLICE below the request timeout of
+the service by the time a chunk can take. When groups are left, the request enqueues a task to CLOUDRUN_TASKS_QUEUE
+that resumes the run, reading only the groups left, with an OIDC token of CLOUDRUN_TASKS_SERVICE_ACCOUNT.
+Without a queue, the next scheduled request resumes it. A run left for longer than CLOUDRUN_RESUME_MAX_AGE
+is dropped, and the next request starts a new one.
+
+A STATE_FILE in a bucket keeps the checkpoints across instances. Set the maximum instances of the service to 1,
+as a request arriving while another one works on the run is turned down with 429 Too Many Requests.
+The port is PORT, set by Cloud Run.`,
+	Example: `  # Deploy with a Cloud Tasks queue to resume the long runs
+  gcloud run deploy psync --image ghcr.io/example/psync --args cloudrun --max-instances 1 --timeout 15m \
+    --set-env-vars STATE_FILE=gs://psync/state.json,CLOUDRUN_SLICE=12m,CLOUDRUN_TASKS_QUEUE=projects/p/locations/europe-west1/queues/psync
+
+  # Trigger a run every hour
+  gcloud scheduler jobs create http psync --schedule "0 * * * *" --http-method POST \
+    --uri https://psync-abc123-ew.a.run.app/run --oidc-service-account-email psync-invoker@p.iam.gserviceaccount.com`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if cloudRunListen == "" {
+			port := os.Getenv("PORT")
+			if port == "" {
+				port = "8080"
+			}
+			cloudRunListen = ":" + port
+		}
+		status := &serveStatus{}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprintln(w, "ok")
+		})
+		mux.HandleFunc("/status.json", status.ServeJSON)
+		mux.HandleFunc("/metrics", ServeMetrics)
+		mux.HandleFunc("POST /run", func(w http.ResponseWriter, r *http.Request) {
+			serveCloudRunSlice(w, r, status)
+		})
+		logger.Info("Listening", "address", cloudRunListen, "slice", viper.GetDuration("CLOUDRUN_SLICE").String())
+		cobra.CheckErr(http.ListenAndServe(cloudRunListen, mux))
+	},
+}
+
+// serveCloudRunSlice works on the run in progress, or starts one, until CLOUDRUN_SLICE passed,
+// and enqueues the task resuming it when groups are left.
+func serveCloudRunSlice(w http.ResponseWriter, r *http.Request, status *serveStatus) {
+	if !syncMu.TryLock() {
+		http.Error(w, "a request is working on the run already", http.StatusTooManyRequests)
+		return
+	}
+	defer syncMu.Unlock()
+	var req cloudRunRequest
+	if body, _ := ioutil.ReadAll(r.Body); len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	store, err := NewStateStore()
+	var state *State
+	if err == nil {
+		state, err = store.Load()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	checkpoint := state.Checkpoint
+	if checkpoint != nil && clock.Now().Sub(checkpoint.Started) > viper.GetDuration("CLOUDRUN_RESUME_MAX_AGE") {
+		logger.Warn("Dropping the run left for too long", "started", checkpoint.Started.Format(time.RFC3339), "remaining", len(checkpoint.Remaining))
+		checkpoint = nil
+	}
+	// A task is delivered at least once, the slice it resumes may be done already
+	if req.Slice > 0 && (checkpoint == nil || checkpoint.Slice != req.Slice) {
+		logger.Info("Ignoring the task of a slice done already", "slice", req.Slice)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if checkpoint == nil {
+		checkpoint = &RunCheckpoint{Started: clock.Now()}
+	}
+	checkpoint.Slice++
+	state.Checkpoint = checkpoint
+	if err := store.Save(state); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	opts := []SyncerOption{WithCheckpoints(store, viper.GetInt("CLOUDRUN_CHUNK_GROUPS"), clock.Now().Add(viper.GetDuration("CLOUDRUN_SLICE")))}
+	if checkpoint.Remaining != nil {
+		events := make([]WebhookEvent, 0, len(checkpoint.Remaining))
+		for _, id := range checkpoint.Remaining {
+			events = append(events, WebhookEvent{Provider: "okta", Type: "psync.resume", GroupID: id})
+		}
+		opts = append(opts, WithEvents(events))
+	}
+	logger.Info("Working on the run", "slice", checkpoint.Slice, "started", checkpoint.Started.Format(time.RFC3339), "remaining", len(checkpoint.Remaining))
+	started := clock.Now()
+	status.start()
+	summary, err := Sync(opts...)
+	if err != nil {
+		summary = failedSummary(summary, started, err)
+	}
+	recordRunMetrics(summary)
+	status.finish(summary)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if state, err = store.Load(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res := struct {
+		Summary   *RunSummary `json:"summary"`
+		Slice     int         `json:"slice"`
+		Remaining int         `json:"remaining"`
+		Resumed   bool        `json:"resumed"`
+	}{Summary: summary, Slice: checkpoint.Slice}
+	code := http.StatusOK
+	if state.Checkpoint != nil {
+		res.Remaining = len(state.Checkpoint.Remaining)
+		code = http.StatusAccepted
+		if err := enqueueCloudRunSlice(r, state.Checkpoint.Slice); err != nil {
+			logger.Error("Could not enqueue the task resuming the run, the next scheduled request resumes it", "error", err)
+		} else {
+			res.Resumed = viper.GetString("CLOUDRUN_TASKS_QUEUE") != ""
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// enqueueCloudRunSlice creates the Cloud Tasks task resuming the run after the slice, if CLOUDRUN_TASKS_QUEUE is set.
+// The task targets CLOUDRUN_URL, or else the host of the request.
+func enqueueCloudRunSlice(r *http.Request, slice int) error {
+	queue := viper.GetString("CLOUDRUN_TASKS_QUEUE")
+	if queue == "" {
+		return nil
+	}
+	target := viper.GetString("CLOUDRUN_URL")
+	if target == "" {
+		target = "https://" + r.Host
+	}
+	body, err := json.Marshal(cloudRunRequest{Slice: slice})
+	if err != nil {
+		return err
+	}
+	httpRequest := map[string]interface{}{
+		"url":        target + "/run",
+		"httpMethod": "POST",
+		"headers":    map[string]string{"Content-Type": "application/json"},
+		"body":       body,
+	}
+	if sa := viper.GetString("CLOUDRUN_TASKS_SERVICE_ACCOUNT"); sa != "" {
+		httpRequest["oidcToken"] = map[string]string{"serviceAccountEmail": sa, "audience": target}
+	}
+	task, err := json.Marshal(map[string]interface{}{"task": map[string]interface{}{"httpRequest": httpRequest}})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	clt, err := google.DefaultClient(ctx, "https://www.googleapis.com/auth/cloud-platform")
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://cloudtasks.googleapis.com/v2/"+queue+"/tasks", bytes.NewReader(task))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent())
+	resp, err := clt.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("creating the task in %s: %s: %s", queue, resp.Status, bytes.TrimSpace(msg))
+	}
+	logger.Info("Enqueued the task resuming the run", "queue", queue, "slice", slice)
+	return nil
+}
+
+func init() {
+	cloudRunCmd.Flags().StringVar(&cloudRunListen, "listen", "", "address of the HTTP endpoint, :$PORT by default")
+	rootCmd.AddCommand(cloudRunCmd)
+}
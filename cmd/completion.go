@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// completionCmd generates the shell completion scripts
+var completionCmd = &cobra.Command{
+	Use:   "completion [bash|zsh|fish|powershell]",
+	Short: "Generate the shell completion script",
+	Long: `Generate the completion script of psync for the given shell.
+Commands, flags and the Okta group names mapped in the state are completed.`,
+	Example: `  # Load the completions in the current bash session
+  source <(psync completion bash)
+
+  # Load the completions for every new zsh session
+  psync completion zsh > "${fpath[1]}/_psync"
+
+  # Load the completions for every new fish session
+  psync completion fish > ~/.config/fish/completions/psync.fish`,
+	ValidArgs:             []string{"bash", "zsh", "fish", "powershell"},
+	Args:                  cobra.ExactValidArgs(1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		switch args[0] {
+		case "bash":
+			cobra.CheckErr(rootCmd.GenBashCompletion(os.Stdout))
+		case "zsh":
+			cobra.CheckErr(rootCmd.GenZshCompletion(os.Stdout))
+		case "fish":
+			cobra.CheckErr(rootCmd.GenFishCompletion(os.Stdout, true))
+		case "powershell":
+			cobra.CheckErr(rootCmd.GenPowerShellCompletionWithDesc(os.Stdout))
+		}
+	},
+}
+
+// completeConfigFiles completes the YAML config files.
+func completeConfigFiles(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return []string{"yaml", "yml"}, cobra.ShellCompDirectiveFilterFileExt
+}
+
+// completeMappedGroups completes the names of the Okta groups mapped in the state.
+func completeMappedGroups(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	state, err := LoadState()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	names := make([]string, 0, len(state.Groups))
+	for _, m := range state.Groups {
+		if strings.HasPrefix(m.OktaName, toComplete) {
+			names = append(names, m.OktaName)
+		}
+	}
+	sort.Strings(names)
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+func init() {
+	remapCmd.ValidArgsFunction = completeMappedGroups
+	rootCmd.AddCommand(completionCmd)
+}
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// The tests of this file exercise the data shared by the parallel group workers, run them with go test -race
+// like the race job of the CI workflow does.
+
+// workers is the number of goroutines writing concurrently, as many group workers would
+const workers = 16
+
+// quietLogger discards the logs of the test.
+func quietLogger(t *testing.T) {
+	saved := logger
+	logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	t.Cleanup(func() { logger = saved })
+}
+
+// parallel calls f from the workers at once and waits for them.
+func parallel(f func(i int)) {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			f(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestRunReportsConcurrently(t *testing.T) {
+	quietLogger(t)
+	run := &Run{ID: "run"}
+	parallel(func(i int) {
+		group := fmt.Sprintf("group-%d", i%4)
+		run.report(&run.Conflicts, "conflict %d", i)
+		run.report(&run.Skipped, "%s", group)
+		run.reportChange(group, "change %d", i)
+		run.audit("added", fmt.Sprintf("00u%d", i), fmt.Sprintf("user%d", i), group, i, 0, gitlab.DeveloperPermissions)
+	})
+	for name, n := range map[string]int{"conflicts": len(run.Conflicts), "skipped": len(run.Skipped),
+		"changes": len(run.Changes), "audit records": len(run.Audit)} {
+		if n != workers {
+			t.Errorf("%d %s, want %d", n, name, workers)
+		}
+	}
+	changes := 0
+	for _, lines := range run.groupChanges {
+		changes += len(lines)
+	}
+	if changes != workers {
+		t.Errorf("%d group changes, want %d", changes, workers)
+	}
+}
+
+func TestRunCollectorsConcurrently(t *testing.T) {
+	quietLogger(t)
+	resetRun()
+	t.Cleanup(resetRun)
+	parallel(func(i int) {
+		warnDataQuality("warning %d", i)
+		raiseAlert("alert %d", i)
+	})
+	if len(dataWarnings) != workers || len(alerts) != workers {
+		t.Errorf("%d warnings and %d alerts, want %d of each", len(dataWarnings), len(alerts), workers)
+	}
+}
+
+func TestStateConcurrently(t *testing.T) {
+	state := &State{Groups: map[string]GroupMapping{}}
+	expires := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	parallel(func(i int) {
+		oktaID, gitlabID, user := fmt.Sprintf("00g%d", i), i, fmt.Sprintf("00u%d", i)
+		state.SetGroupMapping(oktaID, fmt.Sprintf("group-%d", i), gitlabID)
+		state.GitlabGroupID(oktaID)
+		state.OktaGroupIDs(gitlabID)
+		state.SetManaged(gitlabID, user, true)
+		state.IsManaged(gitlabID, user)
+		state.SetMembership(gitlabID, []string{user})
+		state.Membership(gitlabID)
+		state.Revoke("00gshared", user)
+		state.Revoked("00gshared")
+		state.AddGrant(oktaID, gitlabID, user, expires)
+	})
+	if len(state.Groups) != workers || len(state.Managed) != workers || len(state.Memberships) != workers {
+		t.Errorf("%d mappings, %d managed groups and %d memberships, want %d of each",
+			len(state.Groups), len(state.Managed), len(state.Memberships), workers)
+	}
+	if len(state.Grants) != workers || len(state.Revoked("00gshared")) != workers {
+		t.Errorf("%d grants and %d revocations, want %d of each", len(state.Grants), len(state.Revoked("00gshared")), workers)
+	}
+	parallel(func(i int) {
+		state.Unrevoke("00gshared", fmt.Sprintf("00u%d", i))
+	})
+	if revoked := state.Revoked("00gshared"); len(revoked) != 0 {
+		t.Errorf("revoked %v after the revocations ended, want none", revoked)
+	}
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// maxIncludeDepth limits how deep config files can include each other, guarding against include cycles
+const maxIncludeDepth = 5
+
+// envPattern matches ${ENV_VAR} and ${ENV_VAR:-default} references in config files
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandEnv replaces the environment variable references in the config file contents.
+// Referencing an unset variable without a default is an error.
+func expandEnv(data []byte) ([]byte, error) {
+	var missing []string
+	expanded := envPattern.ReplaceAllFunc(data, func(ref []byte) []byte {
+		m := envPattern.FindSubmatch(ref)
+		if v, ok := os.LookupEnv(string(m[1])); ok {
+			return []byte(v)
+		}
+		if len(m[2]) > 0 {
+			return m[3]
+		}
+		missing = append(missing, string(m[1]))
+		return nil
+	})
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("undefined environment variables: %s", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+// mergeConfigFile reads the config file, interpolates environment variables and merges it into viper.
+// The files listed under INCLUDE are merged first, so the including file overrides their values.
+// Relative include paths are resolved against the directory of the including file.
+func mergeConfigFile(path string, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("config includes nested deeper than %d levels at %s", maxIncludeDepth, path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	data, err = expandEnv(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	configType := strings.TrimPrefix(filepath.Ext(path), ".")
+
+	layer := viper.New()
+	layer.SetConfigType(configType)
+	if err := layer.ReadConfig(bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, include := range layer.GetStringSlice("INCLUDE") {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(path), include)
+		}
+		if err := mergeConfigFile(include, depth+1); err != nil {
+			return err
+		}
+	}
+
+	viper.SetConfigType(configType)
+	return viper.MergeConfig(bytes.NewReader(data))
+}
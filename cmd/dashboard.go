@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// metricsCmd groups the commands about the exposed metrics
+var metricsCmd = &cobra.Command{
+	Use:   "metrics",
+	Short: "Inspect the metrics psync exposes in serve mode",
+}
+
+// metricsDashboardCmd prints the Grafana dashboard of the metrics
+var metricsDashboardCmd = &cobra.Command{
+	Use:   "dashboard",
+	Short: "Print a Grafana dashboard of the psync metrics",
+	Long: `Print a Grafana dashboard JSON with a panel for each metric psync exposes at /metrics in serve mode.
+The dashboard is generated from the metrics registry, so it always matches the metrics of the binary.
+Import it in Grafana and select the Prometheus data source scraping psync.`,
+	Example: `  psync metrics dashboard > psync-dashboard.json`,
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		cobra.CheckErr(enc.Encode(Dashboard(metrics)))
+	},
+}
+
+// Dashboard returns the Grafana dashboard model with a time series panel per metric, two panels per row.
+// Counters are shown as their increase per hour, histograms as their 95th percentile, gauges as their value.
+func Dashboard(metrics []*Metric) map[string]interface{} {
+	panels := make([]map[string]interface{}, 0, len(metrics))
+	for i, m := range metrics {
+		expr, legend := m.Name, m.Help
+		if len(m.Labels) > 0 {
+			legend = "{{" + strings.Join(m.Labels, "}} {{") + "}}"
+		}
+		if m.Type == MetricHistogram {
+			expr, legend = fmt.Sprintf("histogram_quantile(0.95, sum by (le) (rate(%s_bucket[1h])))", m.Name), "p95"
+		}
+		if m.Type == MetricCounter {
+			expr = fmt.Sprintf("increase(%s[1h])", m.Name)
+			if len(m.Labels) > 0 {
+				expr = fmt.Sprintf("sum by (%s) (%s)", strings.Join(m.Labels, ", "), expr)
+			}
+		}
+		panels = append(panels, map[string]interface{}{
+			"id":          i + 1,
+			"type":        "timeseries",
+			"title":       m.Help,
+			"description": m.Name,
+			"datasource":  "${datasource}",
+			"gridPos":     map[string]int{"h": 8, "w": 12, "x": (i % 2) * 12, "y": (i / 2) * 8},
+			"targets": []map[string]interface{}{
+				{"refId": "A", "expr": expr, "legendFormat": legend},
+			},
+		})
+	}
+	return map[string]interface{}{
+		"title":         "psync",
+		"uid":           "psync",
+		"tags":          []string{"psync"},
+		"schemaVersion": 27,
+		"refresh":       "1m",
+		"time":          map[string]string{"from": "now-7d", "to": "now"},
+		"templating": map[string]interface{}{
+			"list": []map[string]interface{}{
+				{"name": "datasource", "label": "Data source", "type": "datasource", "query": "prometheus"},
+			},
+		},
+		"panels": panels,
+	}
+}
+
+func init() {
+	metricsCmd.AddCommand(metricsDashboardCmd)
+	rootCmd.AddCommand(metricsCmd)
+}
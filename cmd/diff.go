@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"psync/internal/set"
+)
+
+var diffNoColor bool
+
+// ANSI colors of the diff lines
+const (
+	diffRed    = "\033[31m"
+	diffGreen  = "\033[32m"
+	diffYellow = "\033[33m"
+	diffBold   = "\033[1m"
+	diffReset  = "\033[0m"
+)
+
+// diffCmd prints the drift between the Gitlab memberships and the Okta groups
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Print the drift between the Gitlab group members and the Okta groups, and fail if there is any",
+	Long: `Compare the members of each Gitlab group with the members of its Okta group, with the configured policies,
+and print per group the users to add (+), to remove (-) and the members whose access level differs (~).
+Okta and Gitlab are read through a read-only snapshot and the state is not saved, like psync plan.
+The command fails when users are to be added or removed, so that a CI pipeline can check that a sync is not needed.
+The sync leaves the access level of existing members alone, so differing access levels are reported without failing:
+change them by hand, or remove the members for the next sync to add them again.
+Colors are left out when the output is not a terminal, with NO_COLOR set or with --no-color.`,
+	Example: `  # Fail the pipeline when the Gitlab groups drifted from Okta
+  psync diff --config prod.yaml --no-color`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		transportHook = func(provider string, rt http.RoundTripper) http.RoundTripper {
+			return NewSnapshot(rt)
+		}
+		ctx, client, gitlabClt, err := NewClients()
+		cobra.CheckErr(err)
+		state, err := LoadState()
+		cobra.CheckErr(err)
+		var saved bytes.Buffer
+		cobra.CheckErr(EncodeState(&saved, state))
+		_, syncs, err := planRun(ctx, client, gitlabClt, saved.Bytes())
+		cobra.CheckErr(err)
+
+		drifted := printDiff(os.Stdout, syncs, diffColor())
+		if drifted > 0 {
+			cobra.CheckErr(fmt.Errorf("%d of %d groups drifted from Okta", drifted, len(syncs)))
+		}
+		fmt.Printf("No drift in %d groups\n", len(syncs))
+	},
+}
+
+// diffColor tells whether to color the diff: only on a terminal, without NO_COLOR or --no-color.
+func diffColor() bool {
+	if diffNoColor || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// printDiff prints the drift of each group and returns the number of groups that drifted, those with users
+// to add or remove. Access level mismatches are printed but are no drift, as a sync does not fix them.
+// Members to remove and access level mismatches are named by their Gitlab username, users to add by their Okta ID.
+func printDiff(w io.Writer, syncs []GroupSync, color bool) (drifted int) {
+	paint := func(c, s string) string {
+		if !color {
+			return s
+		}
+		return c + s + diffReset
+	}
+	for _, gs := range syncs {
+		usernames := map[string]string{}
+		lines := make([]string, 0)
+		for _, m := range gs.Members {
+			usernames[m.SAMLID] = m.User.Username
+		}
+		for _, u := range gs.Plan.Add {
+			lines = append(lines, paint(diffGreen, fmt.Sprintf("+ %s as %s", u, accessLevelName(memberAccessLevel(gs, u)))))
+		}
+		for _, u := range gs.Plan.Remove {
+			name := usernames[u]
+			if name == "" {
+				name = u
+			}
+			lines = append(lines, paint(diffRed, fmt.Sprintf("- %s", name)))
+		}
+		changes := len(lines)
+		for _, m := range gs.Members {
+			if m.Protected || !set.Contains(gs.Group.Users, m.SAMLID) || set.Contains(gs.Plan.Remove, m.SAMLID) {
+				continue
+			}
+			if want := memberAccessLevel(gs, m.SAMLID); m.User.AccessLevel != want {
+				lines = append(lines, paint(diffYellow, fmt.Sprintf("~ %s is %s, should be %s",
+					m.User.Username, accessLevelName(m.User.AccessLevel), accessLevelName(want))))
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		if changes > 0 {
+			drifted++
+		}
+		fmt.Fprintln(w, paint(diffBold, fmt.Sprintf("%s (Gitlab group %d)", gitlabGroupLabel(gs), gs.GitlabID)))
+		for _, l := range lines {
+			fmt.Fprintf(w, "  %s\n", l)
+		}
+	}
+	return drifted
+}
+
+func init() {
+	diffCmd.Flags().BoolVar(&diffNoColor, "no-color", false, "print the diff without colors")
+	rootCmd.AddCommand(diffCmd)
+}
@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+func TestPrintDiffAccessLevelsAreNoDrift(t *testing.T) {
+	member := func(id, username string, level gitlab.AccessLevelValue) GitlabMember {
+		return GitlabMember{User: &gitlab.GroupMember{Username: username, AccessLevel: level}, SAMLID: id}
+	}
+	// GROUP_ACCESS_LEVELS now makes the members of payments maintainers, anna is still a developer
+	levels := GroupSync{Group: OktaGroup{Name: "payments", Users: []string{"00uanna"}}, GitlabID: 201,
+		AccessLevel: gitlab.MaintainerPermissions, Members: []GitlabMember{member("00uanna", "anna", gitlab.DeveloperPermissions)}}
+	var out bytes.Buffer
+	if drifted := printDiff(&out, []GroupSync{levels}, false); drifted != 0 {
+		t.Errorf("%d groups drifted, want access levels reported without drift", drifted)
+	}
+	if !strings.Contains(out.String(), "~ anna is developer, should be maintainer") {
+		t.Errorf("diff %q does not report the access level of anna", out.String())
+	}
+
+	removal := GroupSync{Group: OktaGroup{Name: "search", Deprovisioned: []string{"00udan"}}, GitlabID: 202,
+		Members: []GitlabMember{member("00udan", "dan", gitlab.DeveloperPermissions)}, Plan: GroupPlan{Remove: []string{"00udan"}}}
+	out.Reset()
+	if drifted := printDiff(&out, []GroupSync{levels, removal}, false); drifted != 1 {
+		t.Errorf("%d groups drifted, want only the group with a removal", drifted)
+	}
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Digest accumulates the changes of the runs since the last digest was sent.
+type Digest struct {
+	Since time.Time `json:"since"`
+	Runs  int       `json:"runs"`
+	// Changes are the changes by Okta group
+	Changes map[string][]string `json:"changes,omitempty"`
+}
+
+// digestRun adds the changes of the run to the digest in the state, and sends the digest to DIGEST_RECIPIENT
+// with the DIGEST_NOTIFIER once DIGEST_INTERVAL has passed since the previous one, so that daemons syncing
+// many small webhook events send one summary a day rather than a message per run. Alerts are still sent
+// right away by each run. Does nothing without a DIGEST_NOTIFIER.
+// A digest that fails to send is kept and sent with the next run.
+func digestRun(run *Run) error {
+	kind := viper.GetString("DIGEST_NOTIFIER")
+	if kind == "" {
+		return nil
+	}
+	now := clock.Now()
+	run.State.mu.Lock()
+	d := run.State.Digest
+	if d == nil {
+		d = &Digest{Since: now}
+		run.State.Digest = d
+	}
+	if d.Changes == nil {
+		d.Changes = map[string][]string{}
+	}
+	d.Runs++
+	run.mu.Lock()
+	for g, changes := range run.groupChanges {
+		d.Changes[g] = append(d.Changes[g], changes...)
+	}
+	run.mu.Unlock()
+	run.State.mu.Unlock()
+
+	if now.Sub(d.Since) < viper.GetDuration("DIGEST_INTERVAL") {
+		return nil
+	}
+	n, err := NewNotifier(kind)
+	if err != nil {
+		return err
+	}
+	if err := n.Send(viper.GetString("DIGEST_RECIPIENT"), d.Notification(now)); err != nil {
+		return fmt.Errorf("sending the digest: %w", err)
+	}
+	run.State.mu.Lock()
+	run.State.Digest = &Digest{Since: now}
+	run.State.mu.Unlock()
+	return nil
+}
+
+// Notification summarizes the digest, listing the changes by Okta group.
+func (d *Digest) Notification(now time.Time) Notification {
+	groups := make([]string, 0, len(d.Changes))
+	total := 0
+	for g, changes := range d.Changes {
+		groups = append(groups, g)
+		total += len(changes)
+	}
+	sort.Strings(groups)
+	var text strings.Builder
+	if total == 0 {
+		text.WriteString("No membership changes.")
+	}
+	for _, g := range groups {
+		fmt.Fprintf(&text, "%s, %d changes:\n- %s\n", g, len(d.Changes[g]), strings.Join(d.Changes[g], "\n- "))
+	}
+	return Notification{
+		Subject: fmt.Sprintf("psync made %d changes to %d groups in %d runs since %s", total, len(groups), d.Runs, d.Since.Format(time.RFC3339)),
+		Text:    text.String(),
+	}
+}
@@ -0,0 +1,20 @@
+// Package cmd implements the psync commands.
+//
+// # Concurrency model
+//
+// A sync run executes on one goroutine at a time, and runs never overlap: serve starts the next run only
+// after the previous one returned. The HTTP handlers of serve run concurrently with the runs and only read
+// through mutex-protected types: serveStatus, ProgressBroker and Metric.
+//
+// The data shared by the stages of a run is safe for parallel group workers:
+//   - the per-run collectors, dataWarnings, alerts and the Okta user attributes, are written under runMu
+//     and reset by resetRun before a run starts;
+//   - the Run reports and audit records are appended with Run.report and Run.audit, and the State mappings and grants are changed through
+//     its methods, which hold their locks;
+//   - OktaRateLimit and ETagCache guard their counters;
+//   - the identity indices, such as GitlabIdentities, are built before the groups are planned and only read afterwards.
+//
+// Policies run one after the other on the run goroutine and may change the group syncs and the state directly.
+// New shared data must follow the same rules. The concurrency tests write the shared data from parallel goroutines,
+// run them with go test -race ./..., and add the new shared data to them.
+package cmd
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// NewTransport returns the HTTP transport for the outbound connections to a provider, e.g. OKTA or GITLAB.
+// <PROVIDER>_PROXY routes the connections through an http, https or socks5 proxy URL,
+// <PROVIDER>_SOURCE binds them to a local IP address or the first address of a network interface,
+// for networks where the providers are reachable through different egress paths only.
+func NewTransport(provider string) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if p := viper.GetString(provider + "_PROXY"); p != "" {
+		proxy, err := url.Parse(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s_PROXY: %w", provider, err)
+		}
+		switch proxy.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("%s_PROXY: unsupported proxy scheme %q, expected http, https or socks5", provider, proxy.Scheme)
+		}
+		t.Proxy = http.ProxyURL(proxy)
+	}
+	if source := viper.GetString(provider + "_SOURCE"); source != "" {
+		ip, err := sourceIP(source)
+		if err != nil {
+			return nil, fmt.Errorf("%s_SOURCE: %w", provider, err)
+		}
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			LocalAddr: &net.TCPAddr{IP: ip},
+		}
+		t.DialContext = dialer.DialContext
+	}
+	return t, nil
+}
+
+// sourceIP resolves the source address, given as an IP address or a network interface name.
+func sourceIP(source string) (net.IP, error) {
+	if ip := net.ParseIP(source); ip != nil {
+		return ip, nil
+	}
+	iface, err := net.InterfaceByName(source)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok {
+			return n.IP, nil
+		}
+	}
+	return nil, fmt.Errorf("interface %s has no IP address", source)
+}
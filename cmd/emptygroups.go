@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/spf13/viper"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// Actions on the Gitlab groups left without members, see EMPTY_GROUP_ACTION
+const (
+	EmptyGroupAlert    = "alert"
+	EmptyGroupArchive  = "archive"
+	EmptyGroupTransfer = "transfer"
+)
+
+// handleEmptyGroups applies the EMPTY_GROUP_ACTION to the Gitlab groups the run left without members because their
+// Okta group has none left, e.g. a team dissolved in Okta: raise an alert, archive the group, or transfer it to the
+// EMPTY_GROUP_HOLDING_GROUP. Protected members, owners by default, don't count as members. Just-in-time groups are left alone,
+// they are empty whenever no grant is active. A group that cannot be handled raises an alert without failing the run,
+// the memberships being applied already.
+func (t *GitlabTarget) handleEmptyGroups(run *Run, syncs []GroupSync) error {
+	action := viper.GetString("EMPTY_GROUP_ACTION")
+	switch action {
+	case EmptyGroupAlert, EmptyGroupArchive, EmptyGroupTransfer:
+	default:
+		return fmt.Errorf("unknown EMPTY_GROUP_ACTION %q, expected alert, archive or transfer", action)
+	}
+	for _, gs := range syncs {
+		if gs.Tier != "" || gs.Adopted || isJITGroup(gs.Group) || len(gs.Group.Users) > 0 || gs.Routed > 0 || len(managedMemberIDs(gs.Members))-len(gs.Plan.Remove)+len(gs.Plan.Add) > 0 {
+			continue
+		}
+		var err error
+		switch action {
+		case EmptyGroupAlert:
+			raiseAlert("the Gitlab group of %s has no members left", gs.Group.Name)
+		case EmptyGroupArchive:
+			err = t.archiveGroup(run, gs)
+		case EmptyGroupTransfer:
+			err = t.transferGroup(run, gs)
+		}
+		if err != nil {
+			raiseAlert("EMPTY_GROUP_ACTION %s of %s: %v", action, gs.Group.Name, err)
+		}
+	}
+	return nil
+}
+
+// archiveGroup archives the Gitlab group. Gitlab versions that cannot archive groups get all their projects archived.
+// Archived groups are skipped by the next runs.
+func (t *GitlabTarget) archiveGroup(run *Run, gs GroupSync) error {
+	req, err := t.Client.NewRequest(http.MethodPost, fmt.Sprintf("groups/%d/archive", gs.GitlabID), nil, nil)
+	if err != nil {
+		return err
+	}
+	_, err = t.Client.Do(req, nil)
+	var resp *gitlab.ErrorResponse
+	if errors.As(err, &resp) && resp.Response != nil && resp.Response.StatusCode == http.StatusNotFound {
+		err = t.archiveGroupProjects(gs.GitlabID)
+	}
+	if err != nil {
+		return fmt.Errorf("archiving Gitlab group %d: %w", gs.GitlabID, err)
+	}
+	logger.Info("Archived the empty Gitlab group", "group", gs.Group.Name, "gitlab_id", gs.GitlabID)
+	run.reportChange(gs.Group.Name, "Archived the Gitlab group of %s: its Okta group has no members left", gs.Group.Name)
+	return nil
+}
+
+// archiveGroupProjects archives the projects of the Gitlab group and of its subgroups.
+func (t *GitlabTarget) archiveGroupProjects(id int) error {
+	opt := &gitlab.ListGroupProjectsOptions{
+		ListOptions:      gitlab.ListOptions{PerPage: 100},
+		Archived:         gitlab.Bool(false),
+		IncludeSubGroups: gitlab.Bool(true),
+	}
+	for {
+		projects, resp, err := t.Client.Groups.ListGroupProjects(id, opt)
+		if err != nil {
+			return err
+		}
+		for _, p := range projects {
+			if _, _, err := t.Client.Projects.ArchiveProject(p.ID); err != nil {
+				return fmt.Errorf("archiving project %s: %w", p.PathWithNamespace, err)
+			}
+		}
+		if resp.NextPage == 0 {
+			return nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+// transferGroup moves the Gitlab group under the EMPTY_GROUP_HOLDING_GROUP, unless it is there already.
+func (t *GitlabTarget) transferGroup(run *Run, gs GroupSync) error {
+	holding := viper.GetString("EMPTY_GROUP_HOLDING_GROUP")
+	if holding == "" {
+		return errors.New("EMPTY_GROUP_HOLDING_GROUP is not set")
+	}
+	holdingID, err := FindGitlabGroupID(t.Client, holding)
+	if err != nil {
+		return err
+	}
+	group, _, err := t.Client.Groups.GetGroup(gs.GitlabID, &gitlab.GetGroupOptions{WithProjects: gitlab.Bool(false)})
+	if err != nil {
+		return fmt.Errorf("getting Gitlab group %d: %w", gs.GitlabID, err)
+	}
+	if group.ParentID == holdingID {
+		return nil
+	}
+	moved, _, err := t.Client.Groups.TransferSubGroup(gs.GitlabID, &gitlab.TransferSubGroupOptions{GroupID: &holdingID})
+	if err != nil {
+		return fmt.Errorf("transferring %s to %s: %w", group.FullPath, holding, err)
+	}
+	logger.Info("Transferred the empty Gitlab group", "group", gs.Group.Name, "gitlab_id", gs.GitlabID, "from", group.FullPath, "to", moved.FullPath)
+	run.reportChange(gs.Group.Name, "Moved the Gitlab group %s to %s: its Okta group has no members left", group.FullPath, moved.FullPath)
+	return nil
+}
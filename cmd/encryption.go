@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// encryptedPrefix marks encrypted files, files without it are read as plaintext
+var encryptedPrefix = []byte("psync-aesgcm-v1:")
+
+// Cipher encrypts the data psync writes to disk.
+type Cipher interface {
+	Seal(plaintext []byte) ([]byte, error)
+	Open(data []byte) ([]byte, error)
+}
+
+// AESGCM encrypts with AES-256 in GCM mode. Each file gets a random nonce.
+type AESGCM struct {
+	aead cipher.AEAD
+}
+
+// NewAESGCM returns the cipher for the 32 byte key.
+func NewAESGCM(key []byte) (*AESGCM, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &AESGCM{aead: aead}, nil
+}
+
+// Seal encrypts the plaintext.
+func (c *AESGCM) Seal(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	out := append(append([]byte{}, encryptedPrefix...), nonce...)
+	return c.aead.Seal(out, nonce, plaintext, encryptedPrefix), nil
+}
+
+// Open decrypts data written by Seal. Plaintext data is returned as is,
+// so that existing files are encrypted the next time they are written.
+func (c *AESGCM) Open(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, encryptedPrefix) {
+		return data, nil
+	}
+	data = data[len(encryptedPrefix):]
+	if len(data) < c.aead.NonceSize() {
+		return nil, errors.New("encrypted data is truncated")
+	}
+	nonce, ciphertext := data[:c.aead.NonceSize()], data[c.aead.NonceSize():]
+	return c.aead.Open(nil, nonce, ciphertext, encryptedPrefix)
+}
+
+// plaintext is the cipher used when encryption is not configured
+type plaintext struct{}
+
+func (plaintext) Seal(data []byte) ([]byte, error) { return data, nil }
+
+func (plaintext) Open(data []byte) ([]byte, error) {
+	if bytes.HasPrefix(data, encryptedPrefix) {
+		return nil, errors.New("data is encrypted but ENCRYPTION_KEY_SECRET is not configured")
+	}
+	return data, nil
+}
+
+// localCipher is the cipher of the state file and the on-disk caches, loaded once
+var localCipher Cipher
+
+// LocalCipher returns the cipher keyed with the ENCRYPTION_KEY_SECRET secret,
+// which holds a base64 encoded 32 byte key. Without the secret, data is stored in plaintext.
+func LocalCipher() (Cipher, error) {
+	if localCipher != nil {
+		return localCipher, nil
+	}
+	if !hasCredential("ENCRYPTION_KEY_SECRET") {
+		localCipher = plaintext{}
+		return localCipher, nil
+	}
+	secret, err := credential(nil, "ENCRYPTION_KEY_SECRET")
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(secret)))
+	if err != nil {
+		return nil, fmt.Errorf("decoding encryption key: %w", err)
+	}
+	c, err := NewAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	localCipher = c
+	return localCipher, nil
+}
+
+// writeLocalFile writes the data to the file at the path, encrypted with the LocalCipher.
+func writeLocalFile(path string, data []byte, perm os.FileMode) error {
+	c, err := LocalCipher()
+	if err != nil {
+		return err
+	}
+	if data, err = c.Seal(data); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, perm)
+}
+
+// readLocalFile reads the file at the path written by writeLocalFile, or a plaintext file.
+func readLocalFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := LocalCipher()
+	if err != nil {
+		return nil, err
+	}
+	return c.Open(data)
+}
+
+// sealLine encrypts a line of a JSON lines file with the cipher. Encrypted lines are base64 encoded,
+// so that the file stays one record per line.
+func sealLine(c Cipher, line []byte) ([]byte, error) {
+	if _, ok := c.(plaintext); ok {
+		return line, nil
+	}
+	sealed, err := c.Seal(line)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+// openLine decrypts a line written by sealLine. Plaintext JSON lines are returned as is.
+func openLine(c Cipher, line []byte) ([]byte, error) {
+	if len(line) > 0 && line[0] == '{' {
+		return line, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(string(line))
+	if err != nil {
+		return nil, fmt.Errorf("decoding encrypted line: %w", err)
+	}
+	if !bytes.HasPrefix(sealed, encryptedPrefix) {
+		return nil, errors.New("line is neither JSON nor encrypted")
+	}
+	return c.Open(sealed)
+}
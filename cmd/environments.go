@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+var promoteYes bool
+
+// applyEnvironment overrides the config with the keys of the named environment under ENVIRONMENTS,
+// e.g. the GITLAB_URL, GITLAB_SECRET and STATE_FILE of a staging Gitlab instance. Does nothing for no name.
+func applyEnvironment(name string) error {
+	if name == "" {
+		return nil
+	}
+	if !viper.IsSet("ENVIRONMENTS." + name) {
+		return fmt.Errorf("unknown environment %q, not in ENVIRONMENTS", name)
+	}
+	for key, value := range viper.GetStringMap("ENVIRONMENTS." + name) {
+		viper.Set(key, value)
+	}
+	viper.Set("ENVIRONMENT", name)
+	return nil
+}
+
+// promoteCmd copies the group mappings validated in one environment to another
+var promoteCmd = &cobra.Command{
+	Use:   "promote <from environment> <to environment>",
+	Short: "Promote the group mappings rehearsed in one environment to another",
+	Long: `Plan the sync in the first environment, e.g. a staging Gitlab instance, and stop if the plan fails.
+The Gitlab groups its Okta groups are mapped to are then looked up by path in the second environment,
+and the sync is planned there with the promoted mappings. Both plans run against read-only snapshots of Okta and Gitlab.
+With --yes the promoted mappings are saved in the state of the second environment, to be applied by its next sync.
+Both environments are expected to sync the same Okta org, and are defined under ENVIRONMENTS in the config.`,
+	Example: `  # Review the production changes of the mappings rehearsed on staging
+  psync promote staging production
+
+  # Save them
+  psync promote staging production --yes`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if viper.GetString("ENVIRONMENT") != "" {
+			cobra.CheckErr("promote takes the environments as arguments, not with --env")
+		}
+		from, to := args[0], args[1]
+		file := viper.ConfigFileUsed()
+		transportHook = func(provider string, rt http.RoundTripper) http.RoundTripper {
+			return NewSnapshot(rt)
+		}
+
+		fmt.Printf("Planning in %s\n", from)
+		cobra.CheckErr(loadConfig(file))
+		cobra.CheckErr(applyEnvironment(from))
+		ctx, client, gitlabClt, err := NewClients()
+		cobra.CheckErr(err)
+		state, err := LoadState()
+		cobra.CheckErr(err)
+		var saved bytes.Buffer
+		cobra.CheckErr(EncodeState(&saved, state))
+		run, _, err := planRun(ctx, client, gitlabClt, saved.Bytes())
+		if err != nil {
+			cobra.CheckErr(fmt.Errorf("the plan fails in %s, nothing to promote: %w", from, err))
+		}
+		// Gitlab group IDs differ between instances, the paths are what is promoted
+		paths := make(map[string]string, len(run.State.Groups))
+		for oktaID, m := range run.State.Groups {
+			group, _, err := gitlabClt.Groups.GetGroup(m.GitlabID, nil)
+			cobra.CheckErr(err)
+			paths[oktaID] = group.FullPath
+		}
+
+		fmt.Printf("Promoting %d group mappings to %s\n", len(paths), to)
+		cobra.CheckErr(loadConfig(file))
+		cobra.CheckErr(applyEnvironment(to))
+		ctx, client, gitlabClt, err = NewClients()
+		cobra.CheckErr(err)
+		store, err := NewStateStore()
+		cobra.CheckErr(err)
+		state, err = store.Load()
+		cobra.CheckErr(err)
+		oktaIDs := make([]string, 0, len(paths))
+		for oktaID := range paths {
+			oktaIDs = append(oktaIDs, oktaID)
+		}
+		sort.Strings(oktaIDs)
+		missing := 0
+		for _, oktaID := range oktaIDs {
+			name := run.State.Groups[oktaID].OktaName
+			id, err := promotedGroupID(gitlabClt, paths[oktaID])
+			if err != nil {
+				fmt.Printf("  %s: %v\n", name, err)
+				missing++
+				continue
+			}
+			if old, ok := state.GitlabGroupID(oktaID); !ok || old != id {
+				fmt.Printf("  %s: %s (%d)\n", name, paths[oktaID], id)
+				state.SetGroupMapping(oktaID, name, id)
+			}
+		}
+		if missing > 0 {
+			cobra.CheckErr(fmt.Errorf("%d Gitlab groups of %s are missing in %s", missing, from, to))
+		}
+
+		saved.Reset()
+		cobra.CheckErr(EncodeState(&saved, state))
+		changes := planOnly(ctx, client, gitlabClt, saved.Bytes())
+		lines := make([]string, 0, len(changes))
+		for c := range changes {
+			lines = append(lines, c)
+		}
+		sort.Strings(lines)
+		fmt.Printf("\nWith the promoted mappings, the next sync in %s makes %d changes:\n", to, len(lines))
+		for _, c := range lines {
+			fmt.Printf("  %s\n", c)
+		}
+		if !promoteYes {
+			fmt.Println("\nRun again with --yes to save the promoted mappings.")
+			return
+		}
+		cobra.CheckErr(store.Save(state))
+		fmt.Printf("\nSaved the promoted mappings in the state of %s\n", to)
+	},
+}
+
+// promotedGroupID finds the Gitlab group with the path in the configured namespaces.
+func promotedGroupID(clt *gitlab.Client, path string) (int, error) {
+	group, resp, err := clt.Groups.GetGroup(path, nil)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return 0, fmt.Errorf("no Gitlab group %s", path)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !inGitlabNamespaces(group.FullPath) {
+		return 0, errors.New(group.FullPath + " is outside of GITLAB_NAMESPACES")
+	}
+	return group.ID, nil
+}
+
+func init() {
+	promoteCmd.Flags().BoolVar(&promoteYes, "yes", false, "save the promoted mappings")
+	rootCmd.AddCommand(promoteCmd)
+}
@@ -0,0 +1,253 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"psync/internal/set"
+)
+
+var (
+	exportFormat string
+	exportBaseDN string
+)
+
+// exportCmd writes the memberships the sync aims for in a standard format
+var exportCmd = &cobra.Command{
+	Use:   "export [file]",
+	Short: "Export the desired memberships as LDIF or SCIM JSON",
+	Long: `Plan a sync like psync plan and write the memberships of the Gitlab groups once the plan is applied,
+for identity systems that ingest group memberships, to a file or stdout. Nothing is changed and the state is not saved.
+With ENCRYPTION_KEY_SECRET, the file is encrypted like the state file, write to stdout to hand the memberships over.
+
+With --format ldif, each Gitlab group is a groupOfNames entry cn=<group>,ou=groups,<base DN> whose members are
+uid=<Gitlab username>,ou=people,<base DN>. Groups without members have their own DN as member, as groupOfNames
+requires one.
+With --format scim, the groups are a SCIM 2.0 ListResponse of Group resources with the Okta group ID as externalId,
+whose members are the Okta users, and with the Gitlab group ID and access levels in a psync extension.`,
+	Example: `  # Feed the memberships to a legacy LDAP consumer
+  psync export --format ldif --base-dn dc=example,dc=com memberships.ldif
+
+  # Hand them to another IGA tool
+  psync export --format scim > memberships.json`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		write, ok := exportWriters[exportFormat]
+		if !ok {
+			cobra.CheckErr(fmt.Errorf("unknown --format %q, expected ldif or scim", exportFormat))
+		}
+		// Like with the JSON output, stdout only receives the export and the logs go to stderr
+		stdout := os.Stdout
+		if len(args) == 0 {
+			os.Stdout = os.Stderr
+			cobra.CheckErr(setupLogging(viper.GetString("LOG_LEVEL"), viper.GetString("LOG_FORMAT")))
+		}
+		groups, err := DesiredMemberships()
+		cobra.CheckErr(err)
+		var out bytes.Buffer
+		cobra.CheckErr(write(&out, groups))
+		if len(args) == 0 {
+			_, err = out.WriteTo(stdout)
+			cobra.CheckErr(err)
+			return
+		}
+		cobra.CheckErr(writeLocalFile(args[0], out.Bytes(), 0o600))
+		logger.Info("Exported the memberships", "groups", len(groups), "format", exportFormat, "file", args[0])
+	},
+}
+
+// DesiredGroup is a Gitlab group with the members it has once the plan of the run is applied.
+type DesiredGroup struct {
+	OktaID   string
+	OktaName string
+	// Name is the name of the Gitlab group, or of its tier
+	Name     string
+	GitlabID int
+	Members  []DesiredMember
+}
+
+// DesiredMember is a member of a DesiredGroup.
+type DesiredMember struct {
+	OktaID      string
+	Username    string
+	AccessLevel string
+}
+
+// DesiredMemberships plans a run through a read-only snapshot, like psync plan, and returns the memberships
+// of the groups once it is applied: the members kept and the members added, sorted by group and username.
+// The access level of the members is the one the sync adds them with, or their own for protected members.
+func DesiredMemberships() ([]DesiredGroup, error) {
+	transportHook = func(provider string, rt http.RoundTripper) http.RoundTripper {
+		return NewSnapshot(rt)
+	}
+	ctx, client, gitlabClt, err := NewClients()
+	if err != nil {
+		return nil, err
+	}
+	state, err := LoadState()
+	if err != nil {
+		return nil, err
+	}
+	target, err := NewGitlabTarget(gitlabClt)
+	if err != nil {
+		return nil, err
+	}
+	source, err := NewOktaSource(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	syncer, err := NewSyncer(source, target, state)
+	if err != nil {
+		return nil, err
+	}
+	_, syncs, err := syncer.Plan()
+	if err != nil {
+		return nil, err
+	}
+	usernames := map[string]string{}
+	for _, m := range target.afklMembers {
+		if m.GroupSAMLIdentity != nil {
+			usernames[m.GroupSAMLIdentity.ExternUID] = m.Username
+		}
+	}
+
+	groups := make([]DesiredGroup, 0, len(syncs))
+	for _, gs := range syncs {
+		g := DesiredGroup{OktaID: gs.Group.ID, OktaName: gs.Group.OktaName, Name: gitlabGroupLabel(gs), GitlabID: gs.GitlabID}
+		for _, m := range gs.Members {
+			if m.User.State == gitlabMemberAwaiting || set.Contains(gs.Plan.Remove, m.SAMLID) {
+				continue
+			}
+			level := memberAccessLevel(gs, m.SAMLID)
+			if m.Protected {
+				level = m.User.AccessLevel
+			}
+			g.Members = append(g.Members, DesiredMember{OktaID: m.SAMLID, Username: m.User.Username, AccessLevel: accessLevelName(level)})
+		}
+		for _, u := range gs.Plan.Add {
+			g.Members = append(g.Members, DesiredMember{OktaID: u, Username: usernames[u], AccessLevel: accessLevelName(memberAccessLevel(gs, u))})
+		}
+		sort.Slice(g.Members, func(i, j int) bool { return g.Members[i].Username < g.Members[j].Username })
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups, nil
+}
+
+// exportWriters write the desired memberships, by --format
+var exportWriters = map[string]func(w io.Writer, groups []DesiredGroup) error{
+	"ldif": writeLDIF,
+	"scim": writeSCIMGroups,
+}
+
+// writeLDIF writes the groups as LDIF groupOfNames entries under --base-dn.
+func writeLDIF(w io.Writer, groups []DesiredGroup) error {
+	var b strings.Builder
+	b.WriteString("version: 1\n")
+	for _, g := range groups {
+		dn := "cn=" + ldapEscapeDN(g.Name) + ",ou=groups," + exportBaseDN
+		b.WriteString("\n")
+		ldifLine(&b, "dn", dn)
+		ldifLine(&b, "objectClass", "top")
+		ldifLine(&b, "objectClass", "groupOfNames")
+		ldifLine(&b, "cn", g.Name)
+		ldifLine(&b, "description", fmt.Sprintf("Gitlab group %d, synced from the Okta group %s (%s)", g.GitlabID, g.OktaName, g.OktaID))
+		if len(g.Members) == 0 {
+			ldifLine(&b, "member", dn)
+		}
+		for _, m := range g.Members {
+			ldifLine(&b, "member", "uid="+ldapEscapeDN(m.Username)+",ou=people,"+exportBaseDN)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// ldifLine writes an attribute, base64 encoded when the value isn't a safe string of RFC 2849.
+func ldifLine(b *strings.Builder, attr, value string) {
+	safe := value == "" || !strings.ContainsAny(value[:1], " :<")
+	for _, c := range value {
+		if c > 127 || c == '\n' || c == '\r' || c == 0 {
+			safe = false
+			break
+		}
+	}
+	if safe && !strings.HasSuffix(value, " ") {
+		fmt.Fprintf(b, "%s: %s\n", attr, value)
+		return
+	}
+	fmt.Fprintf(b, "%s:: %s\n", attr, base64.StdEncoding.EncodeToString([]byte(value)))
+}
+
+// ldapEscapeDN escapes an attribute value of a DN, see RFC 4514.
+func ldapEscapeDN(value string) string {
+	var b strings.Builder
+	for i, c := range value {
+		switch {
+		case strings.ContainsRune(`,+"\<>;=`, c),
+			i == 0 && (c == '#' || c == ' '),
+			i == len(value)-1 && c == ' ':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// scimGitlabExtension is the schema of the Gitlab details of the exported SCIM groups
+const scimGitlabExtension = "urn:psync:scim:schemas:extension:gitlab:2.0:Group"
+
+// writeSCIMGroups writes the groups as a SCIM 2.0 ListResponse of Group resources.
+func writeSCIMGroups(w io.Writer, groups []DesiredGroup) error {
+	type scimMember struct {
+		Value   string `json:"value"`
+		Display string `json:"display,omitempty"`
+		Type    string `json:"type"`
+	}
+	resources := make([]map[string]interface{}, 0, len(groups))
+	for _, g := range groups {
+		members := make([]scimMember, 0, len(g.Members))
+		levels := make(map[string]string, len(g.Members))
+		for _, m := range g.Members {
+			members = append(members, scimMember{Value: m.OktaID, Display: m.Username, Type: "User"})
+			levels[m.OktaID] = m.AccessLevel
+		}
+		resources = append(resources, map[string]interface{}{
+			"schemas":     []string{"urn:ietf:params:scim:schemas:core:2.0:Group", scimGitlabExtension},
+			"externalId":  g.OktaID,
+			"displayName": g.Name,
+			"members":     members,
+			"meta":        map[string]string{"resourceType": "Group"},
+			scimGitlabExtension: map[string]interface{}{
+				"gitlabId":      g.GitlabID,
+				"oktaGroupName": g.OktaName,
+				"accessLevels":  levels,
+			},
+		})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(map[string]interface{}{
+		"schemas":      []string{"urn:ietf:params:scim:api:messages:2.0:ListResponse"},
+		"totalResults": len(resources),
+		"itemsPerPage": len(resources),
+		"startIndex":   1,
+		"Resources":    resources,
+	})
+}
+
+func init() {
+	exportCmd.Flags().StringVar(&exportFormat, "format", "scim", "format of the export: ldif or scim")
+	exportCmd.Flags().StringVar(&exportBaseDN, "base-dn", "dc=example,dc=com", "base DN of the LDIF entries")
+	rootCmd.AddCommand(exportCmd)
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import "psync/internal/set"
+
+// reportExternalDrift compares the members of the Gitlab groups with their members after the last run, and reports
+// the members added or removed in Gitlab outside of psync since then, apart from the changes driven by Okta.
+// Each change tells whether the plan reverts it. Groups the state has no members of are new and not compared.
+func reportExternalDrift(run *Run, syncs []GroupSync) {
+	seen := map[int]bool{}
+	reverted := 0
+	for _, gs := range syncs {
+		if gs.GitlabID == 0 || seen[gs.GitlabID] {
+			continue
+		}
+		seen[gs.GitlabID] = true
+		recorded, ok := run.State.Membership(gs.GitlabID)
+		if !ok {
+			continue
+		}
+		// Several Okta groups can share the Gitlab group, any of them may revert the change
+		var adds, removes []string
+		for _, other := range syncs {
+			if other.GitlabID == gs.GitlabID {
+				adds = set.Union(adds, other.Plan.Add)
+				removes = set.Union(removes, other.Plan.Remove)
+			}
+		}
+		usernames := make(map[string]string, len(gs.Members))
+		current := make([]string, 0, len(gs.Members))
+		for _, m := range gs.Members {
+			usernames[m.SAMLID] = m.User.Username
+			current = append(current, m.SAMLID)
+		}
+		label := gitlabGroupLabel(gs)
+		for _, id := range set.Difference(current, recorded) {
+			revert := set.Contains(removes, id)
+			logger.Warn("Member added outside of psync", "group", gs.Group.Name, "gitlab_group", label, "user", id,
+				"username", usernames[id], "reverted", revert)
+			run.report(&run.ExternalDrift, "%s added to %s outside of psync%s", usernames[id], label, revertedSuffix(revert))
+			if revert {
+				reverted++
+			}
+		}
+		for _, id := range set.Difference(recorded, current) {
+			revert := set.Contains(adds, id)
+			logger.Warn("Member removed outside of psync", "group", gs.Group.Name, "gitlab_group", label, "user", id, "reverted", revert)
+			run.report(&run.ExternalDrift, "Okta user %s removed from %s outside of psync%s", id, label, revertedSuffix(revert))
+			if revert {
+				reverted++
+			}
+		}
+	}
+	run.Summary.ExternalDrift = len(run.ExternalDrift)
+	if len(run.ExternalDrift) > 0 {
+		logger.Info("Found changes made outside of psync", "changes", len(run.ExternalDrift), "reverted", reverted)
+	}
+}
+
+// revertedSuffix notes in the report of an external change that the run reverts it.
+func revertedSuffix(reverted bool) string {
+	if reverted {
+		return ", reverted"
+	}
+	return ""
+}
+
+// recordMemberships records in the state the members of the Gitlab groups after the changes the run applied,
+// for the next run to tell the changes made outside of psync. The changes that failed are left out.
+func recordMemberships(run *Run, syncs []GroupSync) {
+	run.mu.Lock()
+	added, removed := map[int][]string{}, map[int][]string{}
+	for _, r := range run.Audit {
+		switch r.Action {
+		case "added":
+			added[r.GitlabID] = append(added[r.GitlabID], r.UserID)
+		case "removed":
+			removed[r.GitlabID] = append(removed[r.GitlabID], r.UserID)
+		}
+	}
+	run.mu.Unlock()
+	seen := map[int]bool{}
+	for _, gs := range syncs {
+		if gs.GitlabID == 0 || seen[gs.GitlabID] {
+			continue
+		}
+		seen[gs.GitlabID] = true
+		members := make([]string, 0, len(gs.Members))
+		for _, m := range gs.Members {
+			members = append(members, m.SAMLID)
+		}
+		members = set.Difference(set.Union(members, added[gs.GitlabID]), removed[gs.GitlabID])
+		run.State.SetMembership(gs.GitlabID, members)
+	}
+}
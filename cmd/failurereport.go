@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/spf13/viper"
+)
+
+// FailureReport is the full account of a failed run, uploaded to FAILURE_REPORT_LOCATION
+// because the logs of Cloud Functions truncate long outputs.
+type FailureReport struct {
+	RunID   string    `json:"run_id"`
+	Version string    `json:"version"`
+	Started time.Time `json:"started"`
+	Failed  time.Time `json:"failed"`
+	Error   string    `json:"error"`
+	// Causes are the wrapped errors, outermost first
+	Causes []string `json:"causes,omitempty"`
+	Stack  string   `json:"stack,omitempty"`
+	// Plan is the plan of the run, partial if planning failed
+	Plan      []FailureReportGroup `json:"plan,omitempty"`
+	Changes   []string             `json:"changes,omitempty"`
+	Audit     []AuditRecord        `json:"audit,omitempty"`
+	Skipped   []string             `json:"skipped,omitempty"`
+	Conflicts []string             `json:"conflicts,omitempty"`
+	Warnings  []string             `json:"warnings,omitempty"`
+	Alerts    []string             `json:"alerts,omitempty"`
+}
+
+// FailureReportGroup is the plan of one group in a failure report.
+type FailureReportGroup struct {
+	OktaGroup string   `json:"okta_group"`
+	GitlabID  int      `json:"gitlab_id"`
+	Tier      string   `json:"tier,omitempty"`
+	Add       []string `json:"add,omitempty"`
+	Remove    []string `json:"remove,omitempty"`
+	Pending   []string `json:"pending,omitempty"`
+}
+
+// NewFailureReport collects the report of the run failed with the error.
+func NewFailureReport(run *Run, syncs []GroupSync, err error, stack []byte) *FailureReport {
+	r := &FailureReport{RunID: run.ID, Version: Version, Failed: clock.Now(), Error: err.Error(), Stack: string(stack)}
+	if run.Summary != nil {
+		r.Started = run.Summary.Started
+	}
+	for cause := errors.Unwrap(err); cause != nil; cause = errors.Unwrap(cause) {
+		r.Causes = append(r.Causes, cause.Error())
+	}
+	for _, gs := range syncs {
+		r.Plan = append(r.Plan, FailureReportGroup{OktaGroup: gs.Group.Name, GitlabID: gs.GitlabID, Tier: gs.Tier,
+			Add: gs.Plan.Add, Remove: gs.Plan.Remove, Pending: gs.Plan.Pending})
+	}
+	run.mu.Lock()
+	r.Changes = append(r.Changes, run.Changes...)
+	r.Audit = append(r.Audit, run.Audit...)
+	r.Skipped = append(r.Skipped, run.Skipped...)
+	r.Conflicts = append(r.Conflicts, run.Conflicts...)
+	run.mu.Unlock()
+	runMu.Lock()
+	r.Warnings = append(r.Warnings, dataWarnings...)
+	r.Alerts = append(r.Alerts, alerts...)
+	runMu.Unlock()
+	return r
+}
+
+// uploadFailureReport uploads the failure report of the run to FAILURE_REPORT_LOCATION, a gs://bucket/prefix,
+// as <prefix>/<date>/<run ID>.json, and returns its location. Does nothing without a FAILURE_REPORT_LOCATION.
+func uploadFailureReport(report *FailureReport) (string, error) {
+	location := viper.GetString("FAILURE_REPORT_LOCATION")
+	if location == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(location, "gs://") {
+		return "", fmt.Errorf("invalid FAILURE_REPORT_LOCATION %s, expected gs://bucket/prefix", location)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(location, "gs://"), "/", 2)
+	prefix := ""
+	if len(parts) == 2 {
+		prefix = parts[1]
+	}
+	object := path.Join(prefix, report.Failed.UTC().Format("2006-01-02"), report.RunID+".json")
+
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx, gcpOptions()...)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+	w := client.Bucket(parts[0]).Object(object).NewWriter(ctx)
+	w.ContentType = "application/json"
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		_ = w.Close()
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("gs://%s/%s", parts[0], object), nil
+}
+
+// reportFailure uploads the failure report of the run and logs where it is, so a failure
+// never fails to be reported because of the report.
+func reportFailure(run *Run, syncs []GroupSync, err error, stack []byte) {
+	location, uploadErr := uploadFailureReport(NewFailureReport(run, syncs, err, stack))
+	switch {
+	case uploadErr != nil:
+		logger.Warn("Could not upload the failure report", "error", uploadErr)
+	case location != "":
+		logger.Error("Uploaded the failure report", "location", location)
+	}
+}
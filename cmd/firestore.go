@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"golang.org/x/oauth2/google"
+)
+
+// firestoreMaxWrites is the number of documents a Firestore commit writes at most
+const firestoreMaxWrites = 500
+
+// FirestoreStateStore keeps the state in a Firestore document, for deployments without a persistent disk that query
+// the state, e.g. from a dashboard. The members of each Gitlab group after the last run are documents of their own
+// in the memberships collection of the document, with the Gitlab group ID and the Okta user IDs, as all of them
+// would not fit the size limit of a document. The state is read and written through the Firestore REST API,
+// or the emulator at FIRESTORE_EMULATOR_HOST.
+type FirestoreStateStore struct {
+	Project    string
+	Collection string
+	Document   string
+}
+
+// firestoreDocument is a Firestore document as the REST API encodes it.
+type firestoreDocument struct {
+	Name   string                    `json:"name,omitempty"`
+	Fields map[string]firestoreValue `json:"fields"`
+}
+
+// firestoreValue is a typed Firestore value, of the types the state uses.
+type firestoreValue struct {
+	StringValue    *string              `json:"stringValue,omitempty"`
+	IntegerValue   *string              `json:"integerValue,omitempty"`
+	TimestampValue *string              `json:"timestampValue,omitempty"`
+	ArrayValue     *firestoreArrayValue `json:"arrayValue,omitempty"`
+}
+
+type firestoreArrayValue struct {
+	Values []firestoreValue `json:"values,omitempty"`
+}
+
+// Load reads the state document and the memberships. A missing document results in an empty state.
+func (f *FirestoreStateStore) Load() (*State, error) {
+	var doc firestoreDocument
+	found, err := f.do(http.MethodGet, f.path(), nil, &doc)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return &State{Groups: map[string]GroupMapping{}, Frozen: map[string]Freeze{}, Secrets: map[string]string{}}, nil
+	}
+	data := doc.Fields["state"].StringValue
+	if data == nil {
+		return nil, fmt.Errorf("firestore document %s has no state field", f.path())
+	}
+	state, err := DecodeState(bytes.NewReader([]byte(*data)))
+	if err != nil {
+		return nil, err
+	}
+	for page := ""; ; {
+		var list struct {
+			Documents     []firestoreDocument `json:"documents"`
+			NextPageToken string              `json:"nextPageToken"`
+		}
+		if _, err := f.do(http.MethodGet, f.path()+"/memberships?pageSize=300&pageToken="+url.QueryEscape(page), nil, &list); err != nil {
+			return nil, err
+		}
+		for _, d := range list.Documents {
+			id := d.Fields["gitlab_id"].IntegerValue
+			if id == nil {
+				continue
+			}
+			gitlabID, err := strconv.Atoi(*id)
+			if err != nil {
+				return nil, fmt.Errorf("firestore document %s: %w", d.Name, err)
+			}
+			members := make([]string, 0)
+			if users := d.Fields["users"].ArrayValue; users != nil {
+				for _, v := range users.Values {
+					if v.StringValue != nil {
+						members = append(members, *v.StringValue)
+					}
+				}
+			}
+			state.SetMembership(gitlabID, members)
+		}
+		if page = list.NextPageToken; page == "" {
+			return state, nil
+		}
+	}
+}
+
+// Save writes the memberships, then the state document.
+func (f *FirestoreStateStore) Save(state *State) error {
+	var buf bytes.Buffer
+	if err := EncodeState(&buf, state); err != nil {
+		return err
+	}
+	// The memberships are documents of their own
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(buf.Bytes(), &fields); err != nil {
+		return err
+	}
+	delete(fields, "memberships")
+	data, err := json.MarshalIndent(fields, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	now := clock.Now().UTC().Format(time.RFC3339Nano)
+	state.mu.Lock()
+	ids := make([]int, 0, len(state.Memberships))
+	for id := range state.Memberships {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	writes := make([]interface{}, 0, len(ids)+1)
+	for _, id := range ids {
+		users := make([]firestoreValue, 0, len(state.Memberships[id]))
+		for _, u := range state.Memberships[id] {
+			users = append(users, firestoreString(u))
+		}
+		gitlabID := strconv.Itoa(id)
+		writes = append(writes, map[string]interface{}{"update": firestoreDocument{
+			Name: f.name() + "/memberships/" + gitlabID,
+			Fields: map[string]firestoreValue{
+				"gitlab_id": {IntegerValue: &gitlabID},
+				"users":     {ArrayValue: &firestoreArrayValue{Values: users}},
+				"updated":   {TimestampValue: &now},
+			},
+		}})
+	}
+	state.mu.Unlock()
+	writes = append(writes, map[string]interface{}{"update": firestoreDocument{
+		Name: f.name(),
+		Fields: map[string]firestoreValue{
+			"state":   firestoreString(string(data)),
+			"updated": {TimestampValue: &now},
+		},
+	}})
+	for len(writes) > 0 {
+		n := firestoreMaxWrites
+		if n > len(writes) {
+			n = len(writes)
+		}
+		body, err := json.Marshal(map[string]interface{}{"writes": writes[:n]})
+		if err != nil {
+			return err
+		}
+		if _, err := f.do(http.MethodPost, "documents:commit", body, nil); err != nil {
+			return err
+		}
+		writes = writes[n:]
+	}
+	return nil
+}
+
+// firestoreString returns the string as a Firestore value.
+func firestoreString(s string) firestoreValue {
+	return firestoreValue{StringValue: &s}
+}
+
+// name returns the resource name of the state document.
+func (f *FirestoreStateStore) name() string {
+	return fmt.Sprintf("projects/%s/databases/(default)/documents/%s/%s", f.Project, f.Collection, f.Document)
+}
+
+// path returns the path of the state document, relative to the database.
+func (f *FirestoreStateStore) path() string {
+	return "documents/" + f.Collection + "/" + f.Document
+}
+
+// do sends a request about the path of the database and decodes the response into out, if not nil.
+// It returns false when the document is not found.
+func (f *FirestoreStateStore) do(method, path string, body []byte, out interface{}) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	base, clt := "https://firestore.googleapis.com", http.DefaultClient
+	if host := os.Getenv("FIRESTORE_EMULATOR_HOST"); host != "" {
+		base = "http://" + host
+	} else {
+		var err error
+		if clt, err = google.DefaultClient(ctx, "https://www.googleapis.com/auth/datastore"); err != nil {
+			return false, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("%s/v1/projects/%s/databases/(default)/%s", base, f.Project, path), bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent())
+	resp, err := clt.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return false, fmt.Errorf("firestore %s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(msg))
+	}
+	if out == nil {
+		return true, nil
+	}
+	return true, json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	freezeRemovals bool
+	freezeReason   string
+)
+
+// Freeze stops the changes to a group, e.g. during the containment of a security incident.
+type Freeze struct {
+	Since  time.Time `json:"since"`
+	Reason string    `json:"reason,omitempty"`
+	// Removals are frozen too when set, otherwise members can still be removed
+	Removals bool `json:"removals,omitempty"`
+}
+
+// freezeCmd freezes the access to a group
+var freezeCmd = &cobra.Command{
+	Use:   "freeze <okta group>",
+	Short: "Stop adding members to a group",
+	Long: `Freeze a mapped Okta group: until it is unfrozen, no members are added to its Gitlab group
+and pending access requests are not approved. The skipped changes are reported as alerts.
+Members are still removed, unless --removals is given.`,
+	Example: `  psync freeze payments --reason "INC-1234 containment"`,
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := NewStateStore()
+		cobra.CheckErr(err)
+		state, err := store.Load()
+		cobra.CheckErr(err)
+		id, err := state.OktaGroupID(args[0])
+		cobra.CheckErr(err)
+		state.Frozen[id] = Freeze{Since: clock.Now(), Reason: freezeReason, Removals: freezeRemovals}
+		cobra.CheckErr(store.Save(state))
+		fmt.Printf("Froze %s\n", args[0])
+	},
+}
+
+// unfreezeCmd lifts the freeze of a group
+var unfreezeCmd = &cobra.Command{
+	Use:     "unfreeze <okta group>",
+	Short:   "Lift the freeze of a group",
+	Example: `  psync unfreeze payments`,
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := NewStateStore()
+		cobra.CheckErr(err)
+		state, err := store.Load()
+		cobra.CheckErr(err)
+		id, err := state.OktaGroupID(args[0])
+		cobra.CheckErr(err)
+		if _, ok := state.Frozen[id]; !ok {
+			cobra.CheckErr(fmt.Errorf("%s is not frozen", args[0]))
+		}
+		delete(state.Frozen, id)
+		cobra.CheckErr(store.Save(state))
+		fmt.Printf("Unfroze %s\n", args[0])
+	},
+}
+
+// freezePolicy drops the additions to frozen groups, and their removals when those are frozen too.
+func freezePolicy(run *Run, syncs []GroupSync) ([]GroupSync, error) {
+	for i := range syncs {
+		gs := &syncs[i]
+		f, ok := run.State.Frozen[gs.Group.ID]
+		if !ok {
+			continue
+		}
+		blocked := len(gs.Plan.Add) + len(gs.Plan.Approve)
+		gs.Plan.Add, gs.Plan.Approve = nil, nil
+		if f.Removals {
+			blocked += len(gs.Plan.Remove)
+			gs.Plan.Remove = nil
+		}
+		if blocked > 0 {
+			raiseAlert("%s is frozen since %s, skipped %d membership changes", gs.Group.Name, f.Since.Format(time.RFC3339), blocked)
+		}
+	}
+	return syncs, nil
+}
+
+func init() {
+	freezeCmd.Flags().BoolVar(&freezeRemovals, "removals", false, "freeze the removals of members too")
+	freezeCmd.Flags().StringVar(&freezeReason, "reason", "", "reason of the freeze, e.g. the incident ID")
+	freezeCmd.ValidArgsFunction = completeMappedGroups
+	unfreezeCmd.ValidArgsFunction = completeMappedGroups
+	rootCmd.AddCommand(freezeCmd, unfreezeCmd)
+}
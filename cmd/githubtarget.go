@@ -0,0 +1,249 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/spf13/viper"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+
+	"psync/internal/set"
+)
+
+// oktaGithubLogins collects the GitHub logins, from the GITHUB_LOGIN_ATTRIBUTE profile attribute,
+// of the Okta users listed during the run, by user ID
+var oktaGithubLogins = map[string]string{}
+
+// recordGithubLogin keeps the GitHub login in the profile of the Okta user, if any.
+func recordGithubLogin(id string, profile map[string]interface{}) {
+	if login, ok := profile[viper.GetString("GITHUB_LOGIN_ATTRIBUTE")].(string); ok && login != "" {
+		runMu.Lock()
+		oktaGithubLogins[id] = strings.ToLower(login)
+		runMu.Unlock()
+	}
+}
+
+// GithubTarget syncs the Okta groups to the teams of the GITHUB_ORG GitHub organization.
+// Teams are matched to Okta groups by their slug, and GitHub users to Okta users by the login
+// in their GITHUB_LOGIN_ATTRIBUTE Okta profile attribute. Users with maintainer access or above
+// become maintainers of the team.
+type GithubTarget struct {
+	URL    string
+	Org    string
+	Token  string
+	Client *http.Client
+
+	// teams maps the Okta group IDs to the slugs of their teams
+	teams map[string]string
+}
+
+type githubTeam struct {
+	Slug string `json:"slug"`
+}
+
+type githubUser struct {
+	Login string `json:"login"`
+}
+
+// NewGithubTarget connects to the GitHub API with the token stored in the GITHUB_TOKEN_SECRET secret,
+// which needs the admin:org scope.
+func NewGithubTarget() (*GithubTarget, error) {
+	org := viper.GetString("GITHUB_ORG")
+	if org == "" {
+		return nil, fmt.Errorf("the github target requires GITHUB_ORG")
+	}
+	token, err := credential(nil, "GITHUB_TOKEN_SECRET")
+	if err != nil {
+		return nil, err
+	}
+	transport, err := NewTransport("GITHUB")
+	if err != nil {
+		return nil, err
+	}
+	return &GithubTarget{
+		URL:   strings.TrimRight(viper.GetString("GITHUB_URL"), "/"),
+		Org:   org,
+		Token: strings.TrimSpace(string(token)),
+		Client: &http.Client{
+			Transport: transportHook("GITHUB", &TracingTransport{Provider: "github", Next: &MetricsTransport{Provider: "github", Next: NewCircuitBreaker("GITHUB", transport)}}),
+			Timeout:   viper.GetDuration("GITHUB_TIMEOUT"),
+		},
+	}, nil
+}
+
+// Name returns the name of the target with the organization.
+func (t *GithubTarget) Name() string {
+	return "github " + t.Org
+}
+
+// Supports reports the capabilities of the target. Access requests and expiring memberships are Gitlab features.
+func (t *GithubTarget) Supports(c Capability) bool {
+	return false
+}
+
+// Plan matches the Okta groups to the teams of the organization and computes their membership changes.
+// Okta groups without a team are skipped, and Okta users without a GitHub login are reported.
+func (t *GithubTarget) Plan(run *Run, groups []OktaGroup) ([]GroupSync, error) {
+	runMu.Lock()
+	logins := make(map[string]string, len(oktaGithubLogins))
+	for id, login := range oktaGithubLogins {
+		logins[login] = id
+	}
+	runMu.Unlock()
+	t.teams = make(map[string]string, len(groups))
+
+	syncs := make([]GroupSync, 0, len(groups))
+	for _, g := range groups {
+		slug := githubSlug(g.Name)
+		found, err := t.teamExists(slug)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			run.report(&run.Skipped, "%s: no GitHub team %s in %s", g.Name, slug, t.Org)
+			continue
+		}
+		t.teams[g.ID] = slug
+		users, err := t.listMembers(slug)
+		if err != nil {
+			return nil, err
+		}
+		// Team members are matched back to Okta users through their login; members no Okta user claims are left alone
+		members := make([]GitlabMember, 0, len(users))
+		present := make([]string, 0, len(users))
+		for _, u := range users {
+			if id, ok := logins[strings.ToLower(u.Login)]; ok {
+				members = append(members, GitlabMember{User: &gitlab.GroupMember{Username: u.Login}, SAMLID: id})
+				present = append(present, id)
+			}
+		}
+		known := make([]string, 0, len(g.Users))
+		for _, id := range g.Users {
+			if _, ok := oktaGithubLogin(id); ok {
+				known = append(known, id)
+			} else {
+				warnDataQuality("Okta user %s of %s has no GitHub login in %s", id, g.Name, viper.GetString("GITHUB_LOGIN_ATTRIBUTE"))
+			}
+		}
+		syncs = append(syncs, GroupSync{
+			Group:   g,
+			Members: members,
+			Plan: GroupPlan{
+				Add:    set.Difference(known, present),
+				Remove: set.Intersection(g.Deprovisioned, present),
+			},
+		})
+	}
+	return syncs, nil
+}
+
+// Apply changes the team memberships, removals first. Removals that fail raise a high-severity alert,
+// the run fails if any addition fails.
+func (t *GithubTarget) Apply(run *Run, syncs []GroupSync) error {
+	errs := make([]string, 0)
+	for _, gs := range syncs {
+		g, slug := gs.Group, t.teams[gs.Group.ID]
+		for _, id := range gs.Plan.Remove {
+			login, _ := oktaGithubLogin(id)
+			if err := t.membership(http.MethodDelete, slug, login, nil); err != nil {
+				run.emit(EventFailed, g.Name, id, err)
+				raiseHighAlert("could not remove %s from the GitHub team %s, they keep their access: %v", login, slug, err)
+				continue
+			}
+			run.Summary.Removed++
+			run.emit(EventRemoved, g.Name, id, nil)
+			run.reportChange(g.Name, "Removed %s from the GitHub team %s: deprovisioned or suspended in Okta", login, slug)
+			run.audit("removed", id, login, g.Name, 0, 0, 0)
+		}
+		for _, id := range gs.Plan.Add {
+			login, _ := oktaGithubLogin(id)
+			level := memberAccessLevel(gs, id)
+			role := "member"
+			if level >= gitlab.MaintainerPermissions {
+				role = "maintainer"
+			}
+			if err := t.membership(http.MethodPut, slug, login, map[string]string{"role": role}); err != nil {
+				run.emit(EventFailed, g.Name, id, err)
+				errs = append(errs, fmt.Sprintf("%s in %s: %v", login, slug, err))
+				continue
+			}
+			run.Summary.Added++
+			run.emit(EventAdded, g.Name, id, nil)
+			run.reportChange(g.Name, "Added %s to the GitHub team %s as %s: active member of the Okta group", login, slug, role)
+			run.audit("added", id, login, g.Name, 0, 0, level)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d changes failed:\n  %s", len(errs), strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+// oktaGithubLogin returns the GitHub login of the Okta user.
+func oktaGithubLogin(id string) (string, bool) {
+	runMu.Lock()
+	defer runMu.Unlock()
+	login, ok := oktaGithubLogins[id]
+	return login, ok
+}
+
+// githubSlug returns the slug GitHub gives a team with the name.
+func githubSlug(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteRune('-')
+			dash = true
+		}
+	}
+	return strings.TrimRight(b.String(), "-")
+}
+
+// teamExists reports whether the organization has the team.
+func (t *GithubTarget) teamExists(slug string) (bool, error) {
+	resp, err := t.request(http.MethodGet, fmt.Sprintf("/orgs/%s/teams/%s", url.PathEscape(t.Org), url.PathEscape(slug)), nil, &githubTeam{})
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// listMembers lists the direct members of the team.
+func (t *GithubTarget) listMembers(slug string) ([]githubUser, error) {
+	users := []githubUser{}
+	for page := 1; ; page++ {
+		var batch []githubUser
+		path := fmt.Sprintf("/orgs/%s/teams/%s/members?per_page=100&page=%d", url.PathEscape(t.Org), url.PathEscape(slug), page)
+		if _, err := t.request(http.MethodGet, path, nil, &batch); err != nil {
+			return nil, err
+		}
+		users = append(users, batch...)
+		if len(batch) < 100 {
+			return users, nil
+		}
+	}
+}
+
+// membership adds or removes the user from the team, retrying with backoff.
+func (t *GithubTarget) membership(method, slug, login string, body interface{}) error {
+	path := fmt.Sprintf("/orgs/%s/teams/%s/memberships/%s", url.PathEscape(t.Org), url.PathEscape(slug), url.PathEscape(login))
+	return retryRequest(func() (*http.Response, error) {
+		return t.request(method, path, body, nil)
+	})
+}
+
+// request sends a request to the GitHub REST API with the token and decodes the response into v, if not nil.
+func (t *GithubTarget) request(method, path string, body, v interface{}) (*http.Response, error) {
+	header := http.Header{
+		"Authorization": {"Bearer " + t.Token},
+		"Accept":        {"application/vnd.github+json"},
+		"Content-Type":  {"application/json"},
+	}
+	return restRequest(t.Client, header, method, t.URL, path, body, v)
+}
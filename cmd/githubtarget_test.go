@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestGithubTargetMembershipTransportError(t *testing.T) {
+	viper.Set("RETRY_ATTEMPTS", 2)
+	viper.Set("RETRY_WAIT", time.Second)
+	viper.Set("RETRY_BACKOFF", "fixed")
+	t.Cleanup(viper.Reset)
+	saved := clock
+	clock = &ManualClock{T: time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)}
+	t.Cleanup(func() { clock = saved })
+
+	transport := &failingTransport{}
+	target := &GithubTarget{URL: "https://api.github.example.com", Org: "acme", Client: &http.Client{Transport: transport}}
+	if err := target.membership(http.MethodDelete, "payments", "octocat", nil); err == nil {
+		t.Fatalf("membership() succeeded through a failing transport")
+	}
+	if transport.requests != 2 {
+		t.Errorf("%d requests, want the transport error retried up to 2 attempts", transport.requests)
+	}
+}
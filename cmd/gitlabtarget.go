@@ -0,0 +1,449 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Plan resolves the Gitlab group of each Okta group and computes its membership changes.
+func (t *GitlabTarget) Plan(run *Run, groups []OktaGroup) ([]GroupSync, error) {
+	gitlabClt := t.Client
+	if _, err := protectedAccessLevel(); err != nil {
+		return nil, err
+	}
+	// Fetch the members of the parent groups, the protected ones included
+	afklMembers, _, err := ParentGroupMembers(gitlabClt)
+	if err != nil {
+		return nil, err
+	}
+	t.afklMembers = afklMembers
+	afklIdentities := GitlabIdentities(afklMembers)
+	// Parse out afkl-mcp group members identities
+	afklUids := make([]string, len(afklMembers))
+	for i, m := range afklMembers {
+		// Protected parent members, owners by default, are managed by hand and never added to the groups
+		if isProtectedMember(m) {
+			continue
+		}
+		if m.GroupSAMLIdentity != nil {
+			afklUids[i] = m.GroupSAMLIdentity.ExternUID
+		} else {
+			warnDataQuality("parent group member %s has no SAML identity and cannot be matched with Okta", m.Username)
+		}
+	}
+
+	seats, err := NewSeatPlanner(gitlabClt, afklMembers)
+	if err != nil {
+		return nil, err
+	}
+	reconcile, err := reconcileMode()
+	if err != nil {
+		return nil, err
+	}
+	if t.Marker == nil {
+		if t.Marker, err = NewMemberMarker(gitlabClt, run.State); err != nil {
+			return nil, err
+		}
+	}
+
+	logger.Info("Planning the Okta groups", "groups", len(groups))
+
+	tiers := map[string][]TierTarget{}
+	if err := viper.UnmarshalKey("GROUP_TARGETS", &tiers); err != nil {
+		return nil, fmt.Errorf("GROUP_TARGETS: %w", err)
+	}
+	// Resolve the Gitlab groups of the tiers once, by full path or by name
+	tierIDs := map[string]int{}
+	resolveTier := func(name string) (int, error) {
+		if _, ok := tierIDs[name]; !ok {
+			id, err := FindGitlabGroupID(gitlabClt, name)
+			if err != nil {
+				return 0, err
+			}
+			tierIDs[name] = id
+		}
+		return tierIDs[name], nil
+	}
+	// skip leaves a group out of the sync, the other groups are still synced
+	skip := func(name, reason string) {
+		logger.Info("Skipping the group", "group", name, "reason", reason)
+		run.report(&run.Skipped, "%s (%s)", name, reason)
+		run.emit(EventSkipped, name, "", nil)
+	}
+
+	// Compute the changes of all groups before applying any of them
+	syncs := make([]GroupSync, 0, len(groups))
+	// Users already planned to be added to a Gitlab group, which several Okta groups may share
+	adding := map[int]map[string]bool{}
+	for _, g := range groups {
+		// Resolve the Gitlab group by name only once, afterwards it is tracked by ID so renames don't orphan it
+		grID, known := run.State.GitlabGroupID(g.ID)
+		var level gitlab.AccessLevelValue
+		if g.Mapping != nil {
+			// Mapped groups follow the mappings file, which may move them to another Gitlab group
+			id, err := resolveTier(g.Mapping.GitlabGroup)
+			if err != nil {
+				skip(g.Name, err.Error())
+				continue
+			}
+			if known && id != grID {
+				logger.Info("Remapped the group", "group", g.Name, "from_gitlab_id", grID, "gitlab_id", id)
+			}
+			grID, level = id, accessLevels[strings.ToLower(g.Mapping.AccessLevel)]
+			run.State.SetGroupMapping(g.ID, g.Name, grID)
+		} else if !known {
+			id, err := FindGitlabGroupID(gitlabClt, g.Name)
+			if err != nil {
+				skip(g.Name, err.Error())
+				continue
+			}
+			grID = id
+			run.State.SetGroupMapping(g.ID, g.Name, grID)
+		}
+		if g.Mapping == nil {
+			if level, err = groupAccessLevel(g); err != nil {
+				return nil, fmt.Errorf("GROUP_ACCESS_LEVELS: %w", err)
+			}
+		}
+		// Users of routed types go to their own groups instead of the mapped group
+		routes, err := userTypeRoutes(g.Name)
+		if err != nil {
+			return nil, fmt.Errorf("USER_TYPE_ROUTES: %w", err)
+		}
+		rest := g
+		routed := 0
+		targets := make([]GroupSync, 0, 1+len(routes))
+		userTypes := make([]string, 0, len(routes))
+		for userType := range routes {
+			userTypes = append(userTypes, userType)
+		}
+		sort.Strings(userTypes)
+		for _, userType := range userTypes {
+			route := routes[userType]
+			var typed OktaGroup
+			rest, typed = splitByUserType(rest, userType)
+			level, err := tierAccessLevel(route)
+			if err != nil {
+				return nil, fmt.Errorf("USER_TYPE_ROUTES of %s: %w", g.Name, err)
+			}
+			id, err := resolveTier(route.Group)
+			if err != nil {
+				skip(fmt.Sprintf("%s (%s)", g.Name, route.Group), err.Error())
+				continue
+			}
+			routed += len(typed.Users)
+			targets = append(targets, GroupSync{Group: typed, GitlabID: id, Tier: route.Group, AccessLevel: level})
+		}
+		targets = append([]GroupSync{{Group: rest, GitlabID: grID, Adopted: !known, AccessLevel: level, Routed: routed}}, targets...)
+		for _, tier := range tiers[strings.ToLower(g.Name)] {
+			level, err := tierAccessLevel(tier)
+			if err != nil {
+				return nil, fmt.Errorf("GROUP_TARGETS of %s: %w", g.Name, err)
+			}
+			id, err := resolveTier(tier.Group)
+			if err != nil {
+				skip(fmt.Sprintf("%s (%s)", g.Name, tier.Group), err.Error())
+				continue
+			}
+			targets = append(targets, GroupSync{Group: g, GitlabID: id, Tier: tier.Group, AccessLevel: level})
+		}
+
+		for _, gs := range targets {
+			name := g.Name
+			if gs.Tier != "" {
+				name = fmt.Sprintf("%s (%s)", g.Name, gs.Tier)
+			}
+			// Fetch Gitlab dev group members, find each member in afkl-mcp group and extract their identity
+			glabgroup, err := ListGitlabGroupMembers(gitlabClt, gs.GitlabID)
+			if err != nil {
+				skip(name, err.Error())
+				continue
+			}
+			// Archived groups and groups pending deletion reject membership changes, so skip them
+			reason, err := GetGitlabGroupSkipReason(gitlabClt, gs.GitlabID)
+			if err != nil {
+				reason = err.Error()
+			}
+			if reason != "" {
+				skip(name, reason)
+				continue
+			}
+			gs.Members = MatchGitlabMembers(glabgroup, afklMembers)
+			if err := unprotectManaged(gs.GitlabID, gs.Members, t.Marker); err != nil {
+				skip(name, err.Error())
+				continue
+			}
+			// The mapped group and the routes plan the users left to them, the tiers all the users of the Okta group
+			gs.Plan = PlanGroup(gs.Group, afklUids, gs.Members)
+			// Members of other tiers may come from other Okta groups, only the mapped group is reconciled
+			if gs.Tier == "" {
+				if err := planReconcile(&gs, reconcile, t.Marker); err != nil {
+					skip(name, err.Error())
+					continue
+				}
+			}
+			// Bridge the native Gitlab access request flow with the Okta group membership.
+			// Requests to the groups of other tiers are left alone, as other Okta groups may grant access to them.
+			if viper.GetBool("ACCESS_REQUESTS") && gs.Tier == "" {
+				requests, _, err := gitlabClt.AccessRequests.ListGroupAccessRequests(gs.GitlabID, &gitlab.ListAccessRequestsOptions{PerPage: 100})
+				if err != nil {
+					skip(name, fmt.Sprintf("listing the access requests: %v", err))
+					continue
+				}
+				PlanAccessRequests(&gs.Plan, gs.Group, requests, afklIdentities)
+			}
+			if adding[gs.GitlabID] == nil {
+				adding[gs.GitlabID] = map[string]bool{}
+			}
+			add := make([]string, 0, len(gs.Plan.Add))
+			for _, u := range gs.Plan.Add {
+				if !adding[gs.GitlabID][u] {
+					adding[gs.GitlabID][u] = true
+					add = append(add, u)
+				}
+			}
+			gs.Plan.Add = add
+			seats.Plan(&gs)
+			syncs = append(syncs, gs)
+		}
+	}
+	return syncs, nil
+}
+
+// Change priorities of the retries, lower first. Removals of deprovisioned users close security gaps,
+// so they are retried before anything else.
+const (
+	priorityRemove = iota
+	priorityAccessRequest
+	priorityAdd
+)
+
+// failedChange is a membership change to retry after all others were applied.
+type failedChange struct {
+	priority int
+	group    string
+	user     string
+	apply    func() (*gitlab.Response, error)
+	err      error
+}
+
+// Apply makes the planned membership changes in Gitlab. Changes that fail don't stop the others,
+// they are retried with backoff at the end, removals first. Removals that still fail raise a high-severity alert.
+// A change Gitlab forbids stops all further changes with a TokenScopeError.
+func (t *GitlabTarget) Apply(run *Run, syncs []GroupSync) error {
+	gitlabClt, afklMembers, users := t.Client, t.afklMembers, t.Notifier
+	failed := make([]failedChange, 0)
+	var stop *TokenScopeError
+	// try applies a change, queueing it for a retry when it fails
+	try := func(priority int, group, user string, apply func() (*gitlab.Response, error)) {
+		if stop != nil {
+			return
+		}
+		span := startSpan("psync.member.change", attribute.String("psync.group", group), attribute.String("psync.user", user))
+		_, err := apply()
+		span.end(err)
+		switch {
+		case forbidden(err):
+			stop = &TokenScopeError{Group: group, User: user, Err: err}
+		case err != nil:
+			logger.Warn("Failed to change the member, will retry", "group", group, "user", user, "error", err)
+			failed = append(failed, failedChange{priority: priority, group: group, user: user, apply: apply, err: err})
+		}
+	}
+	for _, gs := range syncs {
+		if stop != nil {
+			break
+		}
+		gs := gs
+		g, grID, plan, glabgroupMembers := gs.Group, gs.GitlabID, gs.Plan, gs.Members
+		span := startSpan("psync.group.reconcile", attribute.String("psync.group", g.Name), attribute.Int("psync.gitlab_id", grID),
+			attribute.Int("psync.add", len(plan.Add)), attribute.Int("psync.remove", len(plan.Remove)))
+		// Seed groups adopted for the first time with the standard team setup
+		if gs.Adopted {
+			if err := OnboardGitlabGroup(gitlabClt, g, grID); err != nil {
+				span.end(err)
+				run.emit(EventFailed, g.Name, "", err)
+				return err
+			}
+		}
+		if len(plan.Pending) > 0 {
+			logger.Info("Members are awaiting approval", "group", g.Name, "pending", len(plan.Pending))
+		}
+		// Blocked Gitlab users that are active in Okta need a human decision, report them
+		for _, member := range plan.Conflicts {
+			conflict := fmt.Sprintf("%s: %s is active in Okta but blocked in Gitlab", g.Name, member.User.Username)
+			logger.Warn("Member blocked in Gitlab but active in Okta", "group", g.Name, "user", member.SAMLID, "username", member.User.Username)
+			run.report(&run.Conflicts, "%s", conflict)
+		}
+		for _, r := range plan.Approve {
+			r := r
+			level := gitlab.DeveloperPermissions
+			if gs.AccessLevel != 0 {
+				level = gs.AccessLevel
+			}
+			try(priorityAccessRequest, g.Name, r.Username, func() (*gitlab.Response, error) {
+				_, resp, err := gitlabClt.AccessRequests.ApproveGroupAccessRequest(grID, r.ID, &gitlab.ApproveAccessRequestOptions{
+					AccessLevel: gitlab.AccessLevel(level),
+				})
+				if err != nil {
+					return resp, err
+				}
+				logger.Info("Approved the access request", "group", g.Name, "username", r.Username)
+				run.emit(EventApproved, g.Name, r.Username, nil)
+				run.reportChange(g.Name, "Approved the access request of %s to %s: member of the Okta group", r.Username, g.Name)
+				run.audit("approved", plan.Requesters[r.ID], r.Username, g.Name, grID, 0, level)
+				return resp, nil
+			})
+		}
+		for _, r := range plan.Deny {
+			r := r
+			try(priorityAccessRequest, g.Name, r.Username, func() (*gitlab.Response, error) {
+				resp, err := gitlabClt.AccessRequests.DenyGroupAccessRequest(grID, r.ID)
+				if err != nil {
+					return resp, err
+				}
+				logger.Info("Denied the access request", "group", g.Name, "username", r.Username)
+				run.emit(EventDenied, g.Name, r.Username, nil)
+				run.reportChange(g.Name, "Denied the access request of %s to %s: not an active member of the Okta group", r.Username, g.Name)
+				run.audit("denied", "", r.Username, g.Name, grID, 0, 0)
+				return resp, nil
+			})
+		}
+		usersToAdd := plan.Add
+		logger.Debug("Adding members", "group", g.Name, "gitlab_id", grID, "count", len(usersToAdd))
+		// Assign the users to the Gitlab dev group with developer permissions level
+		for _, x := range usersToAdd {
+			x := x
+			var perm = memberAccessLevel(gs, x)
+			for _, y := range afklMembers {
+				if y.GroupSAMLIdentity != nil && x == y.GroupSAMLIdentity.ExternUID {
+					y := y
+					opt := &gitlab.AddGroupMemberOptions{
+						UserID:      &y.ID,
+						AccessLevel: &perm,
+					}
+					// Gitlab expires memberships by day only, so its expiry backs up the sweep of the grant
+					if !gs.Expires.IsZero() {
+						opt.ExpiresAt = gitlab.String(gs.Expires.AddDate(0, 0, 1).Format("2006-01-02"))
+					}
+					try(priorityAdd, g.Name, x, func() (*gitlab.Response, error) {
+						mem, resp, err := gitlabClt.GroupMembers.AddGroupMember(grID, opt)
+						if err != nil {
+							return resp, err
+						}
+						logger.Info("Added the member", "group", g.Name, "gitlab_group", gitlabGroupLabel(gs), "user", x,
+							"username", mem.Username, "access_level", accessLevelName(mem.AccessLevel))
+						if !gs.Expires.IsZero() {
+							run.State.AddGrant(g.ID, grID, x, gs.Expires)
+						}
+						if err := t.Marker.Mark(grID, y.ID, x); err != nil {
+							logger.Warn("Could not mark the member as managed", "group", g.Name, "user", x, "username", y.Username, "error", err)
+						}
+						run.Summary.Added++
+						run.emit(EventAdded, g.Name, x, nil)
+						run.reportChange(g.Name, "Added %s to %s as %s: active member of the Okta group", y.Username, gitlabGroupLabel(gs), accessLevelName(perm))
+						run.audit("added", x, y.Username, gitlabGroupLabel(gs), grID, 0, perm)
+						users.Notify("added", x, g)
+						return resp, nil
+					})
+				}
+			}
+		}
+		usersToRemove := plan.Remove
+		logger.Debug("Removing members", "group", g.Name, "gitlab_id", grID, "count", len(usersToRemove))
+		// Remove deprovisioned or suspended users from the gitlab dev group
+		for _, id := range usersToRemove {
+			id := id
+			for _, member := range glabgroupMembers {
+				// Protected members are never planned for removal, this guards against a policy slipping one in
+				if id == member.SAMLID && !member.Protected {
+					member := member
+					try(priorityRemove, g.Name, id, func() (*gitlab.Response, error) {
+						resp, err := gitlabClt.GroupMembers.RemoveGroupMember(grID, member.User.ID, nil)
+						if err != nil {
+							return resp, err
+						}
+						logger.Info("Removed the member", "group", g.Name, "gitlab_group", gitlabGroupLabel(gs), "user", id,
+							"username", member.User.Username)
+						if err := t.Marker.Unmark(grID, member); err != nil {
+							logger.Warn("Could not unmark the member", "group", g.Name, "user", id, "username", member.User.Username, "error", err)
+						}
+						run.Summary.Removed++
+						run.emit(EventRemoved, g.Name, id, nil)
+						run.reportChange(g.Name, "Removed %s from %s: deprovisioned or suspended in Okta, grant lapsed, or reconciled", member.User.Username, gitlabGroupLabel(gs))
+						run.audit("removed", id, member.User.Username, gitlabGroupLabel(gs), grID, member.User.AccessLevel, 0)
+						users.Notify("removed", id, g)
+						return resp, nil
+					})
+				}
+			}
+		}
+		span.end(nil)
+	}
+	if stop != nil {
+		run.emit(EventFailed, stop.Group, stop.User, stop)
+		raiseHighAlert("%v, the remaining changes were not applied", stop)
+		return stop
+	}
+	if err := retryFailedChanges(run, failed); err != nil {
+		return err
+	}
+	if err := t.applyProtections(run, syncs); err != nil {
+		return err
+	}
+	return t.handleEmptyGroups(run, syncs)
+}
+
+// retryFailedChanges retries the failed changes by priority and returns an error if any of them still fails.
+func retryFailedChanges(run *Run, failed []failedChange) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	sort.SliceStable(failed, func(i, j int) bool { return failed[i].priority < failed[j].priority })
+	logger.Info("Retrying the failed changes", "count", len(failed))
+	errs := make([]string, 0)
+	for _, c := range failed {
+		err := retryWithBackoff(viper.GetInt("RETRY_ATTEMPTS"), viper.GetDuration("RETRY_WAIT"), c.apply)
+		if err == nil {
+			continue
+		}
+		if forbidden(err) {
+			stop := &TokenScopeError{Group: c.group, User: c.user, Err: err}
+			run.emit(EventFailed, c.group, c.user, stop)
+			raiseHighAlert("%v, the remaining retries were not attempted", stop)
+			return stop
+		}
+		run.emit(EventFailed, c.group, c.user, err)
+		if c.priority == priorityRemove {
+			raiseHighAlert("could not remove %s from %s, they keep their access: %v", c.user, c.group, err)
+			continue
+		}
+		errs = append(errs, fmt.Sprintf("%s in %s: %v", c.user, c.group, err))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d changes failed:\n  %s", len(errs), strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+// gitlabGroupLabel names the Gitlab group of the sync for reports.
+func gitlabGroupLabel(gs GroupSync) string {
+	if gs.Tier != "" {
+		return gs.Tier
+	}
+	return gs.Group.Name
+}
+
+// tierAccessLevel returns the access level of the tier.
+func tierAccessLevel(tier TierTarget) (gitlab.AccessLevelValue, error) {
+	level, ok := accessLevels[strings.ToLower(tier.AccessLevel)]
+	if !ok {
+		return 0, fmt.Errorf("unknown access level %q of %s", tier.AccessLevel, tier.Group)
+	}
+	return level, nil
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/okta/okta-sdk-golang/v2/okta/query"
+	"github.com/spf13/viper"
+)
+
+// OktaGroupNamer selects the Okta groups to sync by their name and derives the names they are synced under.
+// A group is synced if its name starts with OKTA_GROUP_PREFIX and matches OKTA_GROUP_PATTERN, when set.
+// Okta groups must also have the OKTA_GROUP_ATTRIBUTE of their profile set to true, when set.
+// Its name is then rendered with the OKTA_GROUP_NAME_TEMPLATE Go template.
+type OktaGroupNamer struct {
+	Prefix   string
+	Pattern  *regexp.Regexp
+	Template *template.Template
+}
+
+// GroupName is the data of the OKTA_GROUP_NAME_TEMPLATE.
+type GroupName struct {
+	// Name is the full name of the Okta group
+	Name string
+	// Suffix is the name without the prefix
+	Suffix string
+	// Match holds the text matched by OKTA_GROUP_PATTERN and its subexpressions, empty without a pattern
+	Match []string
+}
+
+// groupNameFuncs are the functions available in the OKTA_GROUP_NAME_TEMPLATE
+var groupNameFuncs = template.FuncMap{
+	"lower":   strings.ToLower,
+	"upper":   strings.ToUpper,
+	"trim":    strings.TrimSpace,
+	"replace": strings.ReplaceAll,
+}
+
+// NewOktaGroupNamer compiles the configured pattern and name template.
+func NewOktaGroupNamer() (*OktaGroupNamer, error) {
+	n := &OktaGroupNamer{Prefix: viper.GetString("OKTA_GROUP_PREFIX")}
+	if pattern := viper.GetString("OKTA_GROUP_PATTERN"); pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("OKTA_GROUP_PATTERN: %w", err)
+		}
+		n.Pattern = re
+	}
+	t, err := template.New("name").Funcs(groupNameFuncs).Option("missingkey=error").Parse(viper.GetString("OKTA_GROUP_NAME_TEMPLATE"))
+	if err != nil {
+		return nil, fmt.Errorf("OKTA_GROUP_NAME_TEMPLATE: %w", err)
+	}
+	n.Template = t
+	return n, nil
+}
+
+// oktaGroupAttributeName is the syntax of the names of the custom attributes of the Okta profiles
+var oktaGroupAttributeName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// oktaGroupParams returns the query listing the Okta groups to sync: the groups whose name starts with
+// the prefix, with the OKTA_GROUP_ATTRIBUTE set to true if configured.
+func oktaGroupParams(prefix string) (*query.Params, error) {
+	attribute := viper.GetString("OKTA_GROUP_ATTRIBUTE")
+	if attribute == "" {
+		return &query.Params{Q: prefix}, nil
+	}
+	search, err := oktaGroupSearch(attribute, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return &query.Params{Search: search}, nil
+}
+
+// oktaGroupSearch returns the Okta search expression of the groups whose name starts with the prefix, if any, and
+// that have the boolean custom attribute of their profile set to true, e.g. psyncManaged, so that the groups are
+// synced on purpose rather than because their name happens to match.
+func oktaGroupSearch(attribute, prefix string) (string, error) {
+	if !oktaGroupAttributeName.MatchString(attribute) {
+		return "", fmt.Errorf("OKTA_GROUP_ATTRIBUTE %q is not an attribute name", attribute)
+	}
+	search := fmt.Sprintf("profile.%s eq true", attribute)
+	if prefix != "" {
+		search += fmt.Sprintf(` and profile.name sw "%s"`, strings.ReplaceAll(prefix, `"`, `\"`))
+	}
+	return search, nil
+}
+
+// Name returns the name the Okta group is synced under, and false if the group is not synced.
+func (n *OktaGroupNamer) Name(oktaName string) (string, bool, error) {
+	if !strings.HasPrefix(oktaName, n.Prefix) {
+		return "", false, nil
+	}
+	data := GroupName{Name: oktaName, Suffix: strings.TrimPrefix(oktaName, n.Prefix), Match: []string{}}
+	if n.Pattern != nil {
+		data.Match = n.Pattern.FindStringSubmatch(oktaName)
+		if data.Match == nil {
+			return "", false, nil
+		}
+	}
+	var buf bytes.Buffer
+	if err := n.Template.Execute(&buf, data); err != nil {
+		return "", false, fmt.Errorf("OKTA_GROUP_NAME_TEMPLATE of %s: %w", oktaName, err)
+	}
+	name := strings.TrimSpace(buf.String())
+	if name == "" {
+		return "", false, fmt.Errorf("OKTA_GROUP_NAME_TEMPLATE of %s: empty name", oktaName)
+	}
+	return name, true, nil
+}
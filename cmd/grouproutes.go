@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// GroupNotification is the channel the membership changes of an Okta group are announced to,
+// configured per group under GROUP_NOTIFICATIONS.
+type GroupNotification struct {
+	// Notifier is the backend, slack, teams or email
+	Notifier string
+	// Recipient is a Slack channel or user, a Teams incoming webhook URL or the secret holding it,
+	// or an email address or list
+	Recipient string
+}
+
+// reportChange records a membership change applied to the Gitlab groups of the Okta group,
+// for the run report and the announcement to the group's channel.
+func (run *Run) reportChange(group, format string, a ...interface{}) {
+	line := fmt.Sprintf(format, a...)
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	run.Changes = append(run.Changes, line)
+	if run.groupChanges == nil {
+		run.groupChanges = map[string][]string{}
+	}
+	run.groupChanges[group] = append(run.groupChanges[group], line)
+}
+
+// announceGroupChanges sends the changes applied to each Okta group to the channel configured for it
+// in GROUP_NOTIFICATIONS, so that teams see the changes to their groups. The global ALERT_RECIPIENT
+// only receives the alerts of the run. Groups without a channel aren't announced.
+func announceGroupChanges(run *Run) error {
+	routes := map[string]GroupNotification{}
+	if err := viper.UnmarshalKey("GROUP_NOTIFICATIONS", &routes); err != nil {
+		return fmt.Errorf("GROUP_NOTIFICATIONS: %w", err)
+	}
+	if len(routes) == 0 {
+		return nil
+	}
+	run.mu.Lock()
+	groups := make([]string, 0, len(run.groupChanges))
+	for g := range run.groupChanges {
+		groups = append(groups, g)
+	}
+	run.mu.Unlock()
+	sort.Strings(groups)
+
+	notifiers := map[string]Notifier{}
+	failed := make([]string, 0)
+	for _, g := range groups {
+		// Viper lower-cases the keys of config maps
+		route, ok := routes[strings.ToLower(g)]
+		if !ok {
+			continue
+		}
+		n, ok := notifiers[route.Notifier]
+		if !ok {
+			var err error
+			if n, err = NewNotifier(route.Notifier); err != nil {
+				return fmt.Errorf("GROUP_NOTIFICATIONS of %s: %w", g, err)
+			}
+			notifiers[route.Notifier] = n
+		}
+		changes := run.groupChanges[g]
+		err := n.Send(route.Recipient, Notification{
+			Subject: fmt.Sprintf("psync run %s made %d changes to the Gitlab groups of %s", run.ID, len(changes), g),
+			Text:    "- " + strings.Join(changes, "\n- "),
+		})
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", g, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("could not announce the changes of %d groups:\n  %s", len(failed), strings.Join(failed, "\n  "))
+	}
+	return nil
+}
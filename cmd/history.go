@@ -0,0 +1,254 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// AuditRecord is one membership change made by psync, as kept in the audit trail.
+type AuditRecord struct {
+	Time     time.Time `json:"time"`
+	RunID    string    `json:"run_id"`
+	Action   string    `json:"action"`
+	UserID   string    `json:"user_id"`
+	Username string    `json:"username"`
+	Group    string    `json:"group"`
+	GitlabID int       `json:"gitlab_id"`
+	// Before and After are the access levels around the change, empty for no membership
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// AuditTrail appends the membership changes to the AUDIT_FILE JSON lines file, one record per line.
+// The file is only ever appended to, so that it can be kept on write-once storage.
+// With ENCRYPTION_KEY_SECRET, each line is encrypted on its own.
+type AuditTrail struct {
+	Path string
+}
+
+// NewAuditTrail returns the audit trail configured with AUDIT_FILE.
+func NewAuditTrail() *AuditTrail {
+	return &AuditTrail{Path: viper.GetString("AUDIT_FILE")}
+}
+
+// Append writes the records at the end of the audit trail.
+func (a *AuditTrail) Append(records []AuditRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	c, err := LocalCipher()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for _, r := range records {
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		if data, err = sealLine(c, data); err != nil {
+			return err
+		}
+		buf.Write(append(data, '\n'))
+	}
+	f, err := os.OpenFile(a.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := buf.WriteTo(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Read returns the records of the audit trail in the order they were appended. A missing file has no records.
+func (a *AuditTrail) Read() ([]AuditRecord, error) {
+	f, err := os.Open(a.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c, err := LocalCipher()
+	if err != nil {
+		return nil, err
+	}
+	records := make([]AuditRecord, 0)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		data, err := openLine(c, scanner.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", a.Path, line, err)
+		}
+		var r AuditRecord
+		if err := json.Unmarshal(data, &r); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", a.Path, line, err)
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
+
+// audit records a membership change applied by the run, to be appended to the audit trail when the run ends.
+func (run *Run) audit(action, userID, username, group string, gitlabID int, before, after gitlab.AccessLevelValue) {
+	r := AuditRecord{Time: clock.Now(), RunID: run.ID, Action: action, UserID: userID, Username: username, Group: group, GitlabID: gitlabID}
+	if before != 0 {
+		r.Before = accessLevelName(before)
+	}
+	if after != 0 {
+		r.After = accessLevelName(after)
+	}
+	if auditLog {
+		// An entry of its own for each change, to build log-based alerts on, e.g. on the removals from a group
+		logger.LogAttrs(context.Background(), levelNotice, "Membership change",
+			slog.String("action", action), slog.String("group", group), slog.String("user", userID),
+			slog.String("username", username), slog.Int("gitlab_id", gitlabID),
+			slog.String("before", r.Before), slog.String("after", r.After))
+	}
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	run.Audit = append(run.Audit, r)
+}
+
+var historyLimit int
+
+// historyCmd lists the runs recorded in the SQL state, and groups the commands that look up the audit trail
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Look up the runs and the membership changes made by psync",
+	Long: `List the last runs recorded in the SQL state, with a STATE_FILE such as sqlite:.psync.db or postgres://host/psync,
+the most recent first:
+their result and the number of changes planned and made. See psync history show for the details of a run.`,
+	Example: `  psync history --limit 5
+  psync history show 20240131T101500Z-1a2b3c4d`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := historyStore()
+		cobra.CheckErr(err)
+		runs, err := store.Runs(historyLimit)
+		cobra.CheckErr(err)
+		if len(runs) == 0 {
+			fmt.Printf("No runs recorded in %s\n", store)
+			return
+		}
+		fmt.Printf("%-27s %-20s %8s %-8s %5s %5s %7s\n", "RUN", "STARTED", "DURATION", "RESULT", "DRIFT", "ADDED", "REMOVED")
+		for _, s := range runs {
+			fmt.Printf("%-27s %-20s %8s %-8s %5d %5d %7d\n", s.ID, s.Started.UTC().Format(time.RFC3339),
+				s.Finished.Sub(s.Started).Round(time.Second), s.Result, s.Drift, s.Added, s.Removed)
+		}
+	},
+}
+
+// historyShowCmd prints a run recorded in the SQL state
+var historyShowCmd = &cobra.Command{
+	Use:   "show <run id>",
+	Short: "Show a run: its summary, the changes it made and the memberships after it",
+	Long: `Print a run recorded in the SQL state: its summary, the membership changes it made, and the Okta users
+the Gitlab groups had as members after it.`,
+	Example: `  psync history show 20240131T101500Z-1a2b3c4d`,
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := historyStore()
+		cobra.CheckErr(err)
+		h, err := store.Run(args[0])
+		cobra.CheckErr(err)
+		s := h.Summary
+		fmt.Printf("Run %s, %s to %s: %s\n", s.ID, s.Started.UTC().Format(time.RFC3339), s.Finished.UTC().Format(time.RFC3339), s.Result)
+		if h.Failure != "" {
+			fmt.Printf("Failed: %s\n", h.Failure)
+		}
+		fmt.Printf("Drift %d, external drift %d, added %d, removed %d, skipped %d groups, %d conflicts, %d warnings, %d alerts\n",
+			s.Drift, s.ExternalDrift, s.Added, s.Removed, s.Skipped, s.Conflicts, s.Warnings, s.Alerts)
+		if s.Quota != nil {
+			fmt.Printf("Quota: %s\n", s.Quota)
+		}
+		fmt.Printf("\nChanges:\n")
+		if len(h.Actions) == 0 {
+			fmt.Println("  none")
+		}
+		for _, r := range h.Actions {
+			fmt.Printf("  %s  %-8s %s (%s) in %s (%d): %s -> %s\n", r.Time.UTC().Format(time.RFC3339), r.Action,
+				r.Username, r.UserID, r.Group, r.GitlabID, levelOrNone(r.Before), levelOrNone(r.After))
+		}
+		fmt.Printf("\nMemberships after the run:\n")
+		if len(h.Memberships) == 0 {
+			fmt.Println("  none recorded")
+		}
+		for _, id := range sortedGitlabIDs(h.Memberships) {
+			fmt.Printf("  Gitlab group %d: %s\n", id, strings.Join(h.Memberships[id], ", "))
+		}
+	},
+}
+
+// historyStore returns the configured state store if it keeps the history of the runs.
+func historyStore() (*SQLStateStore, error) {
+	configured, err := NewStateStore()
+	if err != nil {
+		return nil, err
+	}
+	store, ok := configured.(*SQLStateStore)
+	if !ok {
+		return nil, errors.New("the history of the runs is kept in a SQL state, set STATE_FILE to sqlite:PATH or a postgres:// URL")
+	}
+	return store, nil
+}
+
+// historyUserCmd prints the timeline of the changes affecting a user
+var historyUserCmd = &cobra.Command{
+	Use:   "user <okta user id | gitlab username>",
+	Short: "Show the membership changes made to a user",
+	Long: `Print the timeline of all membership changes psync made to the user across groups, from the audit trail
+kept in AUDIT_FILE: when, in which run, the group, and the access level before and after each change.
+Intended for HR and security investigations.`,
+	Example: `  psync history user 00u1abcd2EFGH3ijk4l5
+  psync history user jane.doe`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		records, err := NewAuditTrail().Read()
+		cobra.CheckErr(err)
+		found := 0
+		for _, r := range records {
+			if r.UserID != args[0] && !strings.EqualFold(r.Username, args[0]) {
+				continue
+			}
+			if found == 0 {
+				fmt.Printf("Changes made to %s:\n", args[0])
+			}
+			found++
+			fmt.Printf("  %s  run %s  %-8s %s (%d): %s -> %s\n", r.Time.UTC().Format(time.RFC3339), r.RunID,
+				r.Action, r.Group, r.GitlabID, levelOrNone(r.Before), levelOrNone(r.After))
+		}
+		if found == 0 {
+			fmt.Printf("No changes made to %s in %s\n", args[0], viper.GetString("AUDIT_FILE"))
+		}
+	},
+}
+
+// levelOrNone names an empty access level for the timeline.
+func levelOrNone(level string) string {
+	if level == "" {
+		return "none"
+	}
+	return level
+}
+
+func init() {
+	historyCmd.Flags().IntVar(&historyLimit, "limit", 20, "number of runs to list")
+	historyCmd.AddCommand(historyUserCmd, historyShowCmd)
+	rootCmd.AddCommand(historyCmd)
+}
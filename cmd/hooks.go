@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Stages of the run hooks
+const (
+	// HookPre runs after planning and before any change, with the plan
+	HookPre = "pre"
+	// HookPost runs at the end of the run, with its report, also when it failed
+	HookPost = "post"
+)
+
+// RunHook is a site-specific command or webhook run before or after the changes of the runs,
+// e.g. to check the VPN, purge a cache or trigger a downstream job, configured under HOOKS.
+// The hook receives a HookPayload as JSON.
+type RunHook struct {
+	// Name identifies the hook in the logs, the command or the host of the URL by default
+	Name string
+	// Stage is pre or post
+	Stage string
+	// Command is run with sh -c, with the payload on stdin and in the file at PSYNC_HOOK_FILE
+	Command string
+	// URL receives the payload in a POST request
+	URL string
+	// Token is the name of the secret holding the bearer token of the URL, if it needs one
+	Token string
+	// Timeout of the hook, one minute by default
+	Timeout time.Duration
+	// Optional pre hooks that fail don't stop the run. The failures of post hooks never fail the run.
+	Optional bool
+}
+
+// HookPayload is what the hooks receive.
+type HookPayload struct {
+	Stage string `json:"stage"`
+	RunID string `json:"run_id"`
+	// Plan are the changes the run is about to apply, for the pre hooks
+	Plan []OutputRecord `json:"plan,omitempty"`
+	// Summary and Changes report the run, for the post hooks
+	Summary *RunSummary `json:"summary,omitempty"`
+	Changes []string    `json:"changes,omitempty"`
+	// Error is why the run failed, for the post hooks
+	Error string `json:"error,omitempty"`
+}
+
+// runHooks runs the HOOKS of the stage, in order. A failing pre hook that isn't optional returns its error,
+// for the run to stop before any change, the other failures are logged.
+func runHooks(stage string, payload HookPayload) error {
+	var hooks []RunHook
+	if err := viper.UnmarshalKey("HOOKS", &hooks); err != nil {
+		return fmt.Errorf("HOOKS: %w", err)
+	}
+	payload.Stage = stage
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	for _, h := range hooks {
+		if h.Stage != stage {
+			continue
+		}
+		if h.Name == "" {
+			h.Name = h.Command
+			// The URL may carry a secret, only its host is logged
+			if u, err := url.Parse(h.URL); err == nil && h.URL != "" {
+				h.Name = u.Host
+			}
+		}
+		start := clock.Now()
+		err := h.run(payload.RunID, data)
+		if err == nil {
+			logger.Info("Ran the hook", "hook", h.Name, "stage", stage, "duration", clock.Now().Sub(start).String())
+			continue
+		}
+		if stage == HookPre && !h.Optional {
+			return fmt.Errorf("%s hook %s: %w", stage, h.Name, err)
+		}
+		logger.Warn("The hook failed", "hook", h.Name, "stage", stage, "error", err)
+	}
+	return nil
+}
+
+// run runs the command or calls the URL of the hook with the payload.
+func (h RunHook) run(runID string, payload []byte) error {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	switch {
+	case h.Command != "" && h.URL != "":
+		return errors.New("has both a command and a URL")
+	case h.Command != "":
+		return h.runCommand(ctx, runID, payload)
+	case h.URL != "":
+		return h.callURL(ctx, payload)
+	}
+	return errors.New("has neither a command nor a URL")
+}
+
+// runCommand runs the command of the hook. Its output is logged at debug level, and its end in the error when it fails.
+func (h RunHook) runCommand(ctx context.Context, runID string, payload []byte) error {
+	f, err := ioutil.TempFile("", "psync-hook-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(payload); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", h.Command)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = append(os.Environ(), "PSYNC_HOOK_FILE="+f.Name(), "PSYNC_HOOK_STAGE="+h.Stage, "PSYNC_RUN_ID="+runID)
+	out, err := cmd.CombinedOutput()
+	logger.Debug("Hook output", "hook", h.Name, "output", string(out))
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		if tail := strings.TrimSpace(string(out)); tail != "" {
+			if len(tail) > 500 {
+				tail = "..." + tail[len(tail)-500:]
+			}
+			return fmt.Errorf("%w: %s", err, tail)
+		}
+		return err
+	}
+	return nil
+}
+
+// callURL posts the payload to the URL of the hook.
+func (h RunHook) callURL(ctx context.Context, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent())
+	if h.Token != "" {
+		token, err := AccessSecret(h.Token)
+		if err != nil {
+			return fmt.Errorf("reading the token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// The error holds the URL, which may carry a secret
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// IdentityProvider is the identity side of the sync: it is the source of the groups and tells the users' emails.
+// The users of the groups are identified by the ID the Gitlab SAML identities of the users carry.
+type IdentityProvider interface {
+	Source
+	// UserEmail returns the email of the user, for the user notifications
+	UserEmail(userID string) (string, error)
+}
+
+// NewIdentityProvider returns the IDENTITY_PROVIDER the groups are read from: okta, azure for Entra ID,
+// or ldap for an LDAP directory such as Active Directory.
+func NewIdentityProvider(state *State) (IdentityProvider, error) {
+	switch kind := viper.GetString("IDENTITY_PROVIDER"); kind {
+	case "okta":
+		ctx, client, err := NewOktaClient(state)
+		if err != nil {
+			return nil, err
+		}
+		return NewOktaSource(ctx, client)
+	case "azure":
+		return NewAzureSource(state)
+	case "ldap":
+		return NewLdapSource(state)
+	default:
+		return nil, fmt.Errorf("unknown IDENTITY_PROVIDER %q, expected okta, azure or ldap", kind)
+	}
+}
@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/okta/okta-sdk-golang/v2/okta"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// impactCmd compares the plans of the current and a proposed config
+var impactCmd = &cobra.Command{
+	Use:   "impact <proposed config>",
+	Short: "Show the membership changes a config change would make",
+	Long: `Plan the sync with the current config and with the proposed config against the same snapshot of Okta and Gitlab,
+and print the membership changes that only one of the two plans makes. Nothing is changed in either provider,
+and the state is not saved. Use it to review mapping and policy changes before merging them.`,
+	Example: `  psync impact new.yaml
+  psync impact --config prod.yaml prod-proposed.yaml`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		transportHook = func(provider string, rt http.RoundTripper) http.RoundTripper {
+			return NewSnapshot(rt)
+		}
+		ctx, client, gitlabClt, err := NewClients()
+		cobra.CheckErr(err)
+		state, err := LoadState()
+		cobra.CheckErr(err)
+		var saved bytes.Buffer
+		cobra.CheckErr(EncodeState(&saved, state))
+
+		current := planOnly(ctx, client, gitlabClt, saved.Bytes())
+
+		fmt.Printf("Planning with %s\n", args[0])
+		cobra.CheckErr(loadConfig(args[0]))
+		proposed := planOnly(ctx, client, gitlabClt, saved.Bytes())
+
+		diff := func(a, b map[string]bool) []string {
+			only := make([]string, 0)
+			for c := range a {
+				if !b[c] {
+					only = append(only, c)
+				}
+			}
+			sort.Strings(only)
+			return only
+		}
+		added, dropped := diff(proposed, current), diff(current, proposed)
+		fmt.Printf("\nImpact of %s: %d changes added, %d changes dropped\n", args[0], len(added), len(dropped))
+		for _, c := range added {
+			fmt.Printf("+ %s\n", c)
+		}
+		for _, c := range dropped {
+			fmt.Printf("- %s\n", c)
+		}
+	},
+}
+
+// planOnly plans a run with the current config, starting from the encoded state, and describes its changes.
+func planOnly(ctx context.Context, client *okta.Client, gitlabClt *gitlab.Client, state []byte) map[string]bool {
+	run, syncs, err := planRun(ctx, client, gitlabClt, state)
+	if err != nil {
+		return map[string]bool{fmt.Sprintf("plan fails: %v", err): true}
+	}
+	return planChanges(run, syncs)
+}
+
+// planRun plans a run with the current config, starting from the encoded state, without applying it.
+func planRun(ctx context.Context, client *okta.Client, gitlabClt *gitlab.Client, state []byte) (*Run, []GroupSync, error) {
+	decoded, err := DecodeState(bytes.NewReader(state))
+	if err != nil {
+		return nil, nil, err
+	}
+	target, err := NewGitlabTarget(gitlabClt)
+	if err != nil {
+		return nil, nil, err
+	}
+	source, err := NewOktaSource(ctx, client)
+	if err != nil {
+		return nil, nil, err
+	}
+	syncer, err := NewSyncer(source, target, decoded)
+	if err != nil {
+		return nil, nil, err
+	}
+	return syncer.Plan()
+}
+
+// planChanges describes the planned membership changes, one per line.
+func planChanges(run *Run, syncs []GroupSync) map[string]bool {
+	changes := map[string]bool{}
+	for _, s := range run.Skipped {
+		changes[fmt.Sprintf("skip %s", s)] = true
+	}
+	for _, gs := range syncs {
+		label := gitlabGroupLabel(gs)
+		for _, u := range gs.Plan.Add {
+			change := fmt.Sprintf("add %s to %s as %s", u, label, accessLevelName(memberAccessLevel(gs, u)))
+			if seat := gs.Plan.Seats[u]; seat != "" {
+				change += fmt.Sprintf(" (%s)", seat)
+			}
+			changes[change] = true
+		}
+		for _, u := range gs.Plan.Remove {
+			changes[fmt.Sprintf("remove %s from %s", u, label)] = true
+		}
+		for _, r := range gs.Plan.Approve {
+			changes[fmt.Sprintf("approve the access request of %s to %s", r.Username, label)] = true
+		}
+		for _, r := range gs.Plan.Deny {
+			changes[fmt.Sprintf("deny the access request of %s to %s", r.Username, label)] = true
+		}
+	}
+	return changes
+}
+
+// loadConfig replaces the config with the config file and the defaults.
+func loadConfig(file string) error {
+	viper.Reset()
+	viper.SetConfigFile(file)
+	viper.AutomaticEnv()
+	setConfigDefaults()
+	if err := viper.BindPFlag("STRICT", rootCmd.PersistentFlags().Lookup("strict")); err != nil {
+		return err
+	}
+	if err := viper.BindPFlag("ENVIRONMENT", rootCmd.PersistentFlags().Lookup("env")); err != nil {
+		return err
+	}
+	if err := viper.BindPFlag("TARGET", rootCmd.PersistentFlags().Lookup("target")); err != nil {
+		return err
+	}
+	if err := viper.ReadInConfig(); err != nil {
+		return err
+	}
+	if err := mergeConfigFile(file, 0); err != nil {
+		return err
+	}
+	return applyEnvironment(viper.GetString("ENVIRONMENT"))
+}
+
+func init() {
+	impactCmd.ValidArgsFunction = completeConfigFiles
+	rootCmd.AddCommand(impactCmd)
+}
@@ -0,0 +1,207 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+var initOutput string
+
+// secretVersionPattern matches the GCP Secret Manager secret version names
+var secretVersionPattern = regexp.MustCompile(`^projects/[^/]+/secrets/[^/]+/versions/[^/]+$`)
+
+// initCmd walks a new operator through the configuration
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Create a config file interactively",
+	Long: `Ask for the GCP project holding the API tokens, the Okta org, the Gitlab instance and the parent group,
+check that the tokens work, list the Okta dev_ groups with the Gitlab groups they would be mapped to,
+and write the validated config file. Finishes with a dry run: the sync is planned against a read-only
+snapshot of Okta and Gitlab, and the changes it would make are printed. Nothing is changed in either provider.`,
+	Example: `  # Create .env.yaml in the current directory
+  psync init
+
+  # Create another config file
+  psync init --output prod.yaml`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		in := bufio.NewReader(os.Stdin)
+		if _, err := os.Stat(initOutput); err == nil {
+			overwrite, err := prompt(in, fmt.Sprintf("%s exists, overwrite it? (yes/no)", initOutput), "no", nil)
+			cobra.CheckErr(err)
+			if overwrite != "yes" {
+				return
+			}
+		}
+
+		fmt.Println("Secrets backend: the API tokens are read from GCP Secret Manager.")
+		project, err := prompt(in, "GCP project", "", nil)
+		cobra.CheckErr(err)
+		oktaSecret, err := prompt(in, "Secret version of the Okta API token",
+			fmt.Sprintf("projects/%s/secrets/okta-token/versions/latest", project), validateSecretVersion)
+		cobra.CheckErr(err)
+		gitlabSecret, err := prompt(in, "Secret version of the Gitlab API token",
+			fmt.Sprintf("projects/%s/secrets/gitlab-token/versions/latest", project), validateSecretVersion)
+		cobra.CheckErr(err)
+
+		oktaOrg, err := prompt(in, "Okta org URL", "", validateHTTPS)
+		cobra.CheckErr(err)
+		gitlabURL, err := prompt(in, "Gitlab URL", viper.GetString("GITLAB_URL"), validateHTTPS)
+		cobra.CheckErr(err)
+		parent, err := prompt(in, "Gitlab parent group of all developers", viper.GetString("GITLAB_PARENT_GROUP"), nil)
+		cobra.CheckErr(err)
+
+		var config bytes.Buffer
+		fmt.Fprintf(&config, "OKTA_SECRET: %q\n", oktaSecret)
+		fmt.Fprintf(&config, "OKTA_ORG_URL: %q\n", oktaOrg)
+		fmt.Fprintf(&config, "GITLAB_SECRET: %q\n", gitlabSecret)
+		fmt.Fprintf(&config, "GITLAB_URL: %q\n", gitlabURL)
+		fmt.Fprintf(&config, "GITLAB_PARENT_GROUP: %q\n", parent)
+		cobra.CheckErr(os.WriteFile(initOutput, config.Bytes(), 0o600))
+		cobra.CheckErr(loadConfig(initOutput))
+		fmt.Printf("Wrote %s\n", initOutput)
+
+		fmt.Println("\nChecking the API tokens")
+		checks := map[string]func(token string) error{
+			"OKTA":   checkOktaToken,
+			"GITLAB": checkGitlabToken,
+		}
+		for _, provider := range []string{"OKTA", "GITLAB"} {
+			token, err := apiToken(&State{}, provider)
+			if err == nil {
+				err = checks[provider](strings.TrimSpace(token))
+			}
+			if err != nil {
+				cobra.CheckErr(fmt.Errorf("%s token: %w, fix the config in %s and run psync init again", provider, err, initOutput))
+			}
+			fmt.Printf("  %s token: ok\n", provider)
+		}
+
+		// Replay a read-only snapshot of the providers, so the discovery and the dry run change nothing
+		transportHook = func(provider string, rt http.RoundTripper) http.RoundTripper {
+			return NewSnapshot(rt)
+		}
+		ctx, client, gitlabClt, err := NewClients()
+		cobra.CheckErr(err)
+
+		fmt.Println("\nDiscovering the group mappings")
+		namer, err := NewOktaGroupNamer()
+		cobra.CheckErr(err)
+		params, err := oktaGroupParams(namer.Prefix)
+		cobra.CheckErr(err)
+		oktaGroups, err := listOktaGroups(ctx, client, params)
+		cobra.CheckErr(err)
+		for _, g := range oktaGroups {
+			name, ok, err := namer.Name(g.Profile.Name)
+			cobra.CheckErr(err)
+			if !ok {
+				continue
+			}
+			fmt.Printf("  %s -> %s\n", g.Profile.Name, discoverGitlabGroup(gitlabClt, name))
+		}
+
+		fmt.Println("\nDry run")
+		state, err := LoadState()
+		cobra.CheckErr(err)
+		var saved bytes.Buffer
+		cobra.CheckErr(EncodeState(&saved, state))
+		changes := planOnly(ctx, client, gitlabClt, saved.Bytes())
+		lines := make([]string, 0, len(changes))
+		for c := range changes {
+			lines = append(lines, c)
+		}
+		sort.Strings(lines)
+		fmt.Printf("The first sync would make %d changes:\n", len(lines))
+		for _, c := range lines {
+			fmt.Printf("  %s\n", c)
+		}
+		fmt.Printf("\nRun psync --config %s to sync.\n", initOutput)
+	},
+}
+
+// prompt asks the question and reads the answer, using the default for an empty answer.
+// Invalid answers are asked again.
+func prompt(in *bufio.Reader, question, def string, validate func(string) error) (string, error) {
+	for {
+		if def != "" {
+			fmt.Printf("%s [%s]: ", question, def)
+		} else {
+			fmt.Printf("%s: ", question)
+		}
+		answer, err := in.ReadString('\n')
+		if err != nil && !(errors.Is(err, io.EOF) && answer != "") {
+			return "", err
+		}
+		answer = strings.TrimSpace(answer)
+		if answer == "" {
+			answer = def
+		}
+		if answer == "" {
+			fmt.Println("  an answer is required")
+			continue
+		}
+		if validate != nil {
+			if err := validate(answer); err != nil {
+				fmt.Printf("  %v\n", err)
+				continue
+			}
+		}
+		return answer, nil
+	}
+}
+
+// validateSecretVersion checks that the answer is a GCP Secret Manager secret version name.
+func validateSecretVersion(name string) error {
+	if !secretVersionPattern.MatchString(name) {
+		return errors.New("expected projects/<project>/secrets/<secret>/versions/<version>")
+	}
+	return nil
+}
+
+// validateHTTPS checks that the answer is an https URL.
+func validateHTTPS(answer string) error {
+	u, err := url.Parse(answer)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return errors.New("expected an https URL")
+	}
+	return nil
+}
+
+// discoverGitlabGroup describes the Gitlab group a sync would map the Okta group name to, without failing.
+func discoverGitlabGroup(clt *gitlab.Client, name string) string {
+	found, _, err := clt.Groups.ListGroups(&gitlab.ListGroupsOptions{Search: &name})
+	if err != nil {
+		return fmt.Sprintf("search failed: %v", err)
+	}
+	paths := make([]string, 0, len(found))
+	for _, g := range found {
+		if inGitlabNamespaces(g.FullPath) {
+			paths = append(paths, g.FullPath)
+		}
+	}
+	switch len(paths) {
+	case 0:
+		return "no matching Gitlab group, link one with psync remap"
+	case 1:
+		return paths[0]
+	}
+	return fmt.Sprintf("%s (%d groups match, use psync remap to pick another)", paths[0], len(paths))
+}
+
+func init() {
+	initCmd.Flags().StringVarP(&initOutput, "output", "o", ".env.yaml", "config file to write")
+	rootCmd.AddCommand(initCmd)
+}
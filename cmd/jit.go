@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"psync/internal/set"
+)
+
+// Grant is a temporary Gitlab membership given through a just-in-time Okta group.
+type Grant struct {
+	OktaGroupID string    `json:"okta_group_id"`
+	GitlabID    int       `json:"gitlab_id"`
+	UserID      string    `json:"user_id"`
+	Expires     time.Time `json:"expires"`
+	// Swept is set once the sweeper removed the lapsed membership
+	Swept bool `json:"swept,omitempty"`
+}
+
+// isJITGroup reports whether the Okta group is listed in JIT_GROUPS, by its name in Okta or its ID.
+// The name it is synced under may be rewritten by the transforms, and is shared by the groups of its routes and tiers.
+func isJITGroup(g OktaGroup) bool {
+	for _, n := range viper.GetStringSlice("JIT_GROUPS") {
+		if n == g.OktaName || n == g.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// jitPolicy gives the members of just-in-time groups a Gitlab membership that expires after JIT_DURATION,
+// and removes the lapsed grants the sweeper left. A lapsed grant is kept while the user is still in the Okta group,
+// so they are not granted access again until they leave and re-join it.
+// Targets without expiring memberships get permanent memberships, see checkCapabilities.
+func jitPolicy(run *Run, syncs []GroupSync) ([]GroupSync, error) {
+	if run.Target != nil && !run.Target.Supports(CapabilityExpiration) {
+		return syncs, nil
+	}
+	now := clock.Now()
+	for i := range syncs {
+		gs := &syncs[i]
+		if !isJITGroup(gs.Group) {
+			continue
+		}
+		gs.Expires = now.Add(viper.GetDuration("JIT_DURATION"))
+		active := make(map[string]bool, len(gs.Group.Users))
+		for _, u := range gs.Group.Users {
+			active[u] = true
+		}
+		members := make(map[string]bool, len(gs.Members))
+		for _, m := range gs.Members {
+			members[m.SAMLID] = true
+		}
+		lapsed := make([]string, 0)
+		grants := make([]Grant, 0, len(run.State.Grants))
+		for _, gr := range run.State.Grants {
+			if gr.OktaGroupID != gs.Group.ID || gr.GitlabID != gs.GitlabID || now.Before(gr.Expires) {
+				grants = append(grants, gr)
+				continue
+			}
+			lapsed = append(lapsed, gr.UserID)
+			if members[gr.UserID] {
+				logger.Info("The grant lapsed", "group", gs.Group.Name, "user", gr.UserID, "expired", gr.Expires.Format(time.RFC3339))
+			}
+			if active[gr.UserID] {
+				grants = append(grants, gr)
+			}
+		}
+		run.State.Grants = grants
+		gs.Plan.Add = set.Difference(gs.Plan.Add, lapsed)
+		gs.Plan.Remove = set.Union(gs.Plan.Remove, set.Intersection(lapsed, managedMemberIDs(gs.Members)))
+	}
+	return syncs, nil
+}
+
+// sweepGrants removes the memberships of the grants that lapsed at the time with remove, and marks them swept.
+// The lapsed grants stay in the state until the next sync, which drops them once the users left the Okta group,
+// so that the users are not granted access again before they re-join it. It returns the grants it swept.
+func sweepGrants(state *State, now time.Time, remove func(gr Grant) error) ([]Grant, error) {
+	swept := make([]Grant, 0)
+	errs := make([]error, 0)
+	for i := range state.Grants {
+		gr := &state.Grants[i]
+		if gr.Swept || now.Before(gr.Expires) {
+			continue
+		}
+		if err := remove(*gr); err != nil {
+			errs = append(errs, fmt.Errorf("removing %s from Gitlab group %d: %w", gr.UserID, gr.GitlabID, err))
+			continue
+		}
+		gr.Swept = true
+		swept = append(swept, *gr)
+	}
+	return swept, errors.Join(errs...)
+}
+
+// SweepGrants removes the members whose just-in-time grants lapsed from their Gitlab groups, between the syncs,
+// and records the removals in the audit trail. Members are matched through their SAML identity in the parent groups.
+func SweepGrants() error {
+	store, err := NewStateStore()
+	if err != nil {
+		return err
+	}
+	unlock, err := lockRun(store)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	state, err := store.Load()
+	if err != nil {
+		return err
+	}
+	clt, err := NewGitlabClient(state)
+	if err != nil {
+		return err
+	}
+	parentMembers, _, err := ParentGroupMembers(clt)
+	if err != nil {
+		return err
+	}
+	marker, err := NewMemberMarker(clt, state)
+	if err != nil {
+		return err
+	}
+	members := map[int][]GitlabMember{}
+	records := make([]AuditRecord, 0)
+	runID := ids.NewID()
+	setCurrentRun(runID)
+	swept, sweepErr := sweepGrants(state, clock.Now(), func(gr Grant) error {
+		if _, ok := members[gr.GitlabID]; !ok {
+			groupMembers, err := ListGitlabGroupMembers(clt, gr.GitlabID)
+			if err != nil {
+				return err
+			}
+			matched := MatchGitlabMembers(groupMembers, parentMembers)
+			if err := unprotectManaged(gr.GitlabID, matched, marker); err != nil {
+				return err
+			}
+			members[gr.GitlabID] = matched
+		}
+		for _, m := range members[gr.GitlabID] {
+			// Protected members psync did not add are managed by hand
+			if m.SAMLID != gr.UserID || m.Protected {
+				continue
+			}
+			resp, err := clt.GroupMembers.RemoveGroupMember(gr.GitlabID, m.User.ID, nil)
+			if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+				return err
+			}
+			if err := marker.Unmark(gr.GitlabID, m); err != nil {
+				logger.Warn("Could not unmark the member", "gitlab_id", gr.GitlabID, "user", gr.UserID, "username", m.User.Username, "error", err)
+			}
+			logger.Info("Removed the member whose grant lapsed", "gitlab_id", gr.GitlabID, "user", gr.UserID, "username", m.User.Username)
+			records = append(records, AuditRecord{Time: clock.Now(), RunID: runID, Action: "expired", UserID: gr.UserID,
+				Username: m.User.Username, Group: state.Groups[gr.OktaGroupID].OktaName, GitlabID: gr.GitlabID,
+				Before: accessLevelName(m.User.AccessLevel)})
+		}
+		return nil
+	})
+	if len(swept) > 0 {
+		logger.Info("Swept the lapsed grants", "swept", len(swept), "removed", len(records))
+	} else {
+		logger.Debug("No grant lapsed since the last sweep")
+	}
+	if err := store.Save(state); err != nil {
+		return err
+	}
+	if err := NewAuditTrail().Append(records); err != nil {
+		return err
+	}
+	return sweepErr
+}
+
+// jitCmd groups the commands of the just-in-time groups
+var jitCmd = &cobra.Command{
+	Use:   "jit",
+	Short: "Manage the memberships granted through just-in-time groups",
+}
+
+var jitSweepCmd = &cobra.Command{
+	Use:   "sweep",
+	Short: "Remove the members whose just-in-time grants lapsed",
+	Long: `Remove the Gitlab members whose grants through the JIT_GROUPS lapsed, without waiting for the next sync.
+Gitlab only expires memberships by the day, the sweeper removes them once their JIT_DURATION is over.
+psync serve sweeps every JIT_SWEEP_INTERVAL between its syncs. The removals are recorded in the audit trail.`,
+	Example: `  # Sweep every 10 minutes from a scheduled job
+  psync jit sweep`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(SweepGrants())
+	},
+}
+
+func init() {
+	jitCmd.AddCommand(jitSweepCmd)
+	rootCmd.AddCommand(jitCmd)
+}
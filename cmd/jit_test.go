@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// fakeTarget is a target with the capabilities listed.
+type fakeTarget []Capability
+
+func (t fakeTarget) Name() string { return "fake" }
+
+func (t fakeTarget) Supports(c Capability) bool {
+	for _, supported := range t {
+		if supported == c {
+			return true
+		}
+	}
+	return false
+}
+
+func TestJITGrantLifecycle(t *testing.T) {
+	quietLogger(t)
+	viper.Set("JIT_GROUPS", []string{"dev_oncall"})
+	viper.Set("JIT_DURATION", 8*time.Hour)
+	t.Cleanup(viper.Reset)
+	start := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	manual := &ManualClock{T: start}
+	saved := clock
+	clock = manual
+	t.Cleanup(func() { clock = saved })
+
+	state := &State{Groups: map[string]GroupMapping{}}
+	run := &Run{State: state, Target: fakeTarget{CapabilityExpiration}}
+	// The group is synced under another name than its name in Okta
+	group := OktaGroup{ID: "00goncall", Name: "oncall", OktaName: "dev_oncall"}
+	member := []GitlabMember{{User: &gitlab.GroupMember{ID: 7, Username: "anna"}, SAMLID: "00uanna"}}
+	// plan runs the policy on the group with the Okta users and the Gitlab members, adding the users not members yet
+	plan := func(users []string, members []GitlabMember) GroupSync {
+		t.Helper()
+		g := group
+		g.Users = users
+		gs := GroupSync{Group: g, GitlabID: 101, Members: members, Plan: PlanGroup(g, users, members)}
+		syncs, err := jitPolicy(run, []GroupSync{gs})
+		if err != nil {
+			t.Fatalf("jitPolicy() error = %v", err)
+		}
+		return syncs[0]
+	}
+
+	// anna joins the Okta group and is added until the grant lapses
+	gs := plan([]string{"00uanna"}, nil)
+	if !reflect.DeepEqual(gs.Plan.Add, []string{"00uanna"}) || !gs.Expires.Equal(start.Add(8*time.Hour)) {
+		t.Fatalf("joining: add %v expiring %s, want 00uanna expiring after 8h", gs.Plan.Add, gs.Expires)
+	}
+	state.AddGrant(group.ID, gs.GitlabID, "00uanna", gs.Expires)
+
+	// Before the grant lapses, the membership is kept
+	manual.Sleep(4 * time.Hour)
+	if gs = plan([]string{"00uanna"}, member); len(gs.Plan.Add)+len(gs.Plan.Remove) != 0 {
+		t.Errorf("before the lapse: add %v and remove %v, want no change", gs.Plan.Add, gs.Plan.Remove)
+	}
+	if swept, err := sweepGrants(state, clock.Now(), nil); err != nil || len(swept) != 0 {
+		t.Errorf("sweep before the lapse swept %v, %v, want nothing", swept, err)
+	}
+
+	// Once it lapsed, the sweeper removes the member between the syncs, once
+	manual.Sleep(5 * time.Hour)
+	removed := make([]Grant, 0)
+	remove := func(gr Grant) error {
+		removed = append(removed, gr)
+		return nil
+	}
+	if _, err := sweepGrants(state, clock.Now(), remove); err != nil {
+		t.Fatalf("sweepGrants() error = %v", err)
+	}
+	if _, err := sweepGrants(state, clock.Now(), remove); err != nil {
+		t.Fatalf("sweepGrants() error = %v", err)
+	}
+	if len(removed) != 1 || removed[0].UserID != "00uanna" || removed[0].GitlabID != 101 {
+		t.Fatalf("swept %v, want the grant of 00uanna in 101 once", removed)
+	}
+
+	// While anna stays in the Okta group, the next syncs don't add her again
+	if gs = plan([]string{"00uanna"}, nil); len(gs.Plan.Add) != 0 {
+		t.Errorf("after the lapse: add %v, want anna not added again", gs.Plan.Add)
+	}
+	if len(state.Grants) != 1 {
+		t.Fatalf("after the lapse: %d grants, want the lapsed grant kept", len(state.Grants))
+	}
+
+	// Once she left the Okta group the lapsed grant is dropped, and re-joining grants access again
+	plan(nil, nil)
+	if len(state.Grants) != 0 {
+		t.Errorf("after leaving: grants %v, want none", state.Grants)
+	}
+	gs = plan([]string{"00uanna"}, nil)
+	if !reflect.DeepEqual(gs.Plan.Add, []string{"00uanna"}) || !gs.Expires.Equal(clock.Now().Add(8*time.Hour)) {
+		t.Errorf("re-joining: add %v expiring %s, want 00uanna expiring after 8h", gs.Plan.Add, gs.Expires)
+	}
+}
+
+func TestJITPolicyRemovesUnsweptLapsedMembers(t *testing.T) {
+	quietLogger(t)
+	viper.Set("JIT_GROUPS", []string{"00goncall"})
+	t.Cleanup(viper.Reset)
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	saved := clock
+	clock = &ManualClock{T: now}
+	t.Cleanup(func() { clock = saved })
+
+	state := &State{Grants: []Grant{{OktaGroupID: "00goncall", GitlabID: 101, UserID: "00uanna", Expires: now.Add(-time.Minute)}}}
+	run := &Run{State: state, Target: fakeTarget{CapabilityExpiration}}
+	gs := GroupSync{Group: OktaGroup{ID: "00goncall", Name: "oncall", Users: []string{"00uanna"}}, GitlabID: 101,
+		Members: []GitlabMember{{User: &gitlab.GroupMember{ID: 7, Username: "anna"}, SAMLID: "00uanna"}}}
+	syncs, err := jitPolicy(run, []GroupSync{gs})
+	if err != nil {
+		t.Fatalf("jitPolicy() error = %v", err)
+	}
+	if !reflect.DeepEqual(syncs[0].Plan.Remove, []string{"00uanna"}) {
+		t.Errorf("remove %v, want the member whose grant lapsed", syncs[0].Plan.Remove)
+	}
+}
+
+func TestJITPolicyWithoutExpiration(t *testing.T) {
+	viper.Set("JIT_GROUPS", []string{"dev_oncall"})
+	t.Cleanup(viper.Reset)
+	run := &Run{State: &State{}, Target: fakeTarget{}}
+	gs := GroupSync{Group: OktaGroup{ID: "00goncall", OktaName: "dev_oncall", Users: []string{"00uanna"}},
+		Plan: GroupPlan{Add: []string{"00uanna"}}}
+	syncs, err := jitPolicy(run, []GroupSync{gs})
+	if err != nil {
+		t.Fatalf("jitPolicy() error = %v", err)
+	}
+	if !syncs[0].Expires.IsZero() || !reflect.DeepEqual(syncs[0].Plan.Add, []string{"00uanna"}) {
+		t.Errorf("expires %s and add %v, want a permanent membership", syncs[0].Expires, syncs[0].Plan.Add)
+	}
+}
+
+func TestSweepGrantsKeepsFailedRemovals(t *testing.T) {
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	state := &State{Grants: []Grant{
+		{OktaGroupID: "00goncall", GitlabID: 101, UserID: "00uanna", Expires: now.Add(-time.Hour)},
+		{OktaGroupID: "00goncall", GitlabID: 101, UserID: "00ubert", Expires: now.Add(-time.Hour)},
+	}}
+	swept, err := sweepGrants(state, now, func(gr Grant) error {
+		if gr.UserID == "00ubert" {
+			return errors.New("gitlab unavailable")
+		}
+		return nil
+	})
+	if err == nil {
+		t.Errorf("sweepGrants() succeeded, want the failed removal reported")
+	}
+	if len(swept) != 1 || swept[0].UserID != "00uanna" || state.Grants[1].Swept {
+		t.Errorf("swept %v, want only anna, bert left to the next sweep", swept)
+	}
+}
@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/spf13/viper"
+)
+
+// adAccountDisabled is the ACCOUNTDISABLE flag of the userAccountControl attribute of Active Directory users
+const adAccountDisabled = 0x2
+
+// LdapSource reads the groups from an LDAP directory such as on-prem Active Directory, over LDAPS at LDAP_URL.
+// It binds as LDAP_BIND_DN with the password stored in the LDAP_BIND_PASSWORD secret, and searches LDAP_BASE_DN
+// for the groups matching LDAP_GROUP_FILTER, named by their LDAP_GROUP_NAME_ATTRIBUTE. Groups are then selected and
+// named like Okta groups, with OKTA_GROUP_PREFIX, OKTA_GROUP_PATTERN and OKTA_GROUP_NAME_TEMPLATE.
+// The members of a group are the users matching LDAP_MEMBER_FILTER, which by default resolves nested Active
+// Directory groups. Users are identified by their LDAP_USER_ID_ATTRIBUTE, which must match the SAML identities
+// in Gitlab, and disabled Active Directory accounts are removed like deprovisioned Okta users.
+type LdapSource struct {
+	URL      string
+	BindDN   string
+	Password string
+}
+
+// NewLdapSource reads the bind password stored in the LDAP_BIND_PASSWORD secret, or its version active in the state.
+func NewLdapSource(state *State) (*LdapSource, error) {
+	u := viper.GetString("LDAP_URL")
+	if !strings.HasPrefix(u, "ldaps://") {
+		return nil, fmt.Errorf("the ldap identity provider requires an ldaps:// LDAP_URL, got %q", u)
+	}
+	password, err := credential(state, "LDAP_BIND_PASSWORD")
+	if err != nil {
+		return nil, err
+	}
+	return &LdapSource{URL: u, BindDN: viper.GetString("LDAP_BIND_DN"), Password: strings.TrimSpace(string(password))}, nil
+}
+
+// connect dials the directory and binds.
+func (s *LdapSource) connect() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(s.URL)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", s.URL, err)
+	}
+	conn.SetTimeout(viper.GetDuration("LDAP_TIMEOUT"))
+	if err := conn.Bind(s.BindDN, s.Password); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("binding as %s: %w", s.BindDN, err)
+	}
+	return conn, nil
+}
+
+// search runs a paged subtree search of the base DN.
+func (s *LdapSource) search(conn *ldap.Conn, filter string, attributes ...string) ([]*ldap.Entry, error) {
+	req := ldap.NewSearchRequest(viper.GetString("LDAP_BASE_DN"), ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		0, 0, false, filter, attributes, nil)
+	res, err := conn.SearchWithPaging(req, 500)
+	if err != nil {
+		return nil, fmt.Errorf("searching %s: %w", filter, err)
+	}
+	return res.Entries, nil
+}
+
+// Groups fetches the members of the directory groups selected by their name.
+// Groups whose members cannot be listed are left out with a data-quality warning, so the others are still synced.
+func (s *LdapSource) Groups(run *Run) ([]OktaGroup, error) {
+	namer, err := NewOktaGroupNamer()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	nameAttr, idAttr := viper.GetString("LDAP_GROUP_NAME_ATTRIBUTE"), viper.GetString("LDAP_USER_ID_ATTRIBUTE")
+	found, err := s.search(conn, viper.GetString("LDAP_GROUP_FILTER"), nameAttr, "objectGUID")
+	if err != nil {
+		return nil, fmt.Errorf("listing the LDAP groups: %w", err)
+	}
+	groups := make([]OktaGroup, 0, len(found))
+	for _, g := range found {
+		name, ok, err := namer.Name(g.GetAttributeValue(nameAttr))
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		// The objectGUID of Active Directory groups survives renames and moves, unlike their DN
+		id := g.DN
+		if guid := g.GetRawAttributeValue("objectGUID"); len(guid) > 0 {
+			id = hex.EncodeToString(guid)
+		}
+		gr := OktaGroup{ID: id, Name: name, OktaName: g.GetAttributeValue(nameAttr), Users: []string{}, Deprovisioned: []string{}}
+		filter := strings.ReplaceAll(viper.GetString("LDAP_MEMBER_FILTER"), "{dn}", ldap.EscapeFilter(g.DN))
+		users, err := s.search(conn, filter, idAttr, "userAccountControl")
+		if err != nil {
+			warnDataQuality("LDAP group %s is not synced: %v", g.DN, err)
+			continue
+		}
+		for _, u := range users {
+			id := u.GetAttributeValue(idAttr)
+			if id == "" {
+				warnDataQuality("LDAP user %s has no %s and cannot be matched with Gitlab", u.DN, idAttr)
+				continue
+			}
+			flags, _ := strconv.Atoi(u.GetAttributeValue("userAccountControl"))
+			if flags&adAccountDisabled != 0 {
+				gr.Deprovisioned = append(gr.Deprovisioned, id)
+			} else {
+				gr.Users = append(gr.Users, id)
+			}
+		}
+		groups = append(groups, gr)
+	}
+	return groups, nil
+}
+
+// UserEmail returns the mail attribute of the directory user.
+func (s *LdapSource) UserEmail(userID string) (string, error) {
+	conn, err := s.connect()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	filter := fmt.Sprintf("(%s=%s)", ldap.EscapeFilter(viper.GetString("LDAP_USER_ID_ATTRIBUTE")), ldap.EscapeFilter(userID))
+	users, err := s.search(conn, filter, "mail")
+	if err != nil {
+		return "", err
+	}
+	if len(users) == 0 {
+		return "", fmt.Errorf("no LDAP user with %s", filter)
+	}
+	return users[0].GetAttributeValue("mail"), nil
+}
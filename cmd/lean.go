@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"net/http"
+	"strings"
+)
+
+// oktaLeanResponse asks Okta to leave the credentials and their links out of the user objects, which psync never reads
+const oktaLeanResponse = "application/json; okta-response=omitCredentials,omitCredentialsLinks,omitTransitioningToStatus"
+
+// LeanOktaTransport is an HTTP transport trimming the user objects Okta returns, to reduce the memory
+// and bandwidth used for very large groups. Enabled with LEAN_API.
+type LeanOktaTransport struct {
+	Next http.RoundTripper
+}
+
+// RoundTrip sends the request, asking for lean user objects when listing users.
+func (t *LeanOktaTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodGet && strings.HasPrefix(req.URL.Path, "/api/v1/") &&
+		(strings.HasSuffix(req.URL.Path, "/users") || strings.HasPrefix(req.URL.Path, "/api/v1/users")) {
+		req = req.Clone(req.Context())
+		req.Header.Set("Content-Type", oktaLeanResponse)
+	}
+	return t.Next.RoundTrip(req)
+}
+
+// gitlabGroupOptions are the options of the Gitlab get group API.
+// Without projects the response of large groups shrinks from megabytes to a few hundred bytes.
+type gitlabGroupOptions struct {
+	WithProjects *bool `url:"with_projects,omitempty"`
+}
@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/okta/okta-sdk-golang/v2/okta/query"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	lintFile string
+	lintLive bool
+)
+
+// lintCmd checks the mapping file
+var lintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Check the group mapping file",
+	Long: `Check the mapping file, MAPPINGS_FILE unless --file is given, for mistakes the sync would trip over:
+missing fields, unknown access levels, Okta groups mapped more than once, whose later entries are unreachable,
+Gitlab groups several Okta groups map to, and those of them with conflicting access levels.
+With --live, the Okta groups and Gitlab group paths are also looked up, to find unknown ones.
+Prints one finding per line and fails if there is any, so it can run in the CI of the config repository.`,
+	Example: `  # Check the mapping file in CI, without credentials
+  psync lint --file mappings.yaml
+
+  # Also check that the groups exist
+  psync lint --live`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		path := lintFile
+		if path == "" {
+			path = viper.GetString("MAPPINGS_FILE")
+		}
+		if path == "" {
+			cobra.CheckErr("no mapping file, set MAPPINGS_FILE or --file")
+		}
+		rules, err := readMappingRules(path)
+		cobra.CheckErr(err)
+		findings := lintMappingRules(rules)
+		if lintLive {
+			live, err := lintMappingRulesLive(rules)
+			cobra.CheckErr(err)
+			findings = append(findings, live...)
+		}
+		for _, f := range findings {
+			fmt.Printf("%s: %s\n", path, f)
+		}
+		if len(findings) > 0 {
+			cobra.CheckErr(fmt.Errorf("%d findings in %s", len(findings), path))
+		}
+		fmt.Printf("%s: %d mappings, no findings\n", path, len(rules))
+	},
+}
+
+// lintMappingRules checks the mapping rules without calling any API.
+func lintMappingRules(rules []MappingRule) []string {
+	findings := make([]string, 0)
+	if len(rules) == 0 {
+		return append(findings, "no mappings")
+	}
+	// First entry of each Okta group, and entries of each Gitlab group
+	first := map[string]int{}
+	targets := map[string][]int{}
+	targetOrder := make([]string, 0)
+	for i, r := range rules {
+		entry := fmt.Sprintf("entry %d (%s)", i+1, r.OktaGroup)
+		if r.OktaGroup == "" {
+			findings = append(findings, fmt.Sprintf("%s has no okta_group", entry))
+		}
+		if r.GitlabGroup == "" {
+			findings = append(findings, fmt.Sprintf("%s has no gitlab_group", entry))
+		}
+		if _, ok := accessLevels[strings.ToLower(r.AccessLevel)]; !ok {
+			findings = append(findings, fmt.Sprintf("%s has unknown access level %q", entry, r.AccessLevel))
+		}
+		if r.OktaGroup != "" {
+			key := strings.ToLower(r.OktaGroup)
+			if j, ok := first[key]; ok {
+				findings = append(findings, fmt.Sprintf("%s is unreachable, entry %d already maps %s", entry, j+1, rules[j].OktaGroup))
+				continue
+			}
+			first[key] = i
+		}
+		if r.GitlabGroup != "" {
+			key := strings.ToLower(strings.Trim(r.GitlabGroup, "/"))
+			if _, ok := targets[key]; !ok {
+				targetOrder = append(targetOrder, key)
+			}
+			targets[key] = append(targets[key], i)
+		}
+	}
+	for _, target := range targetOrder {
+		entries := targets[target]
+		if len(entries) < 2 {
+			continue
+		}
+		groups := make([]string, 0, len(entries))
+		levels := map[string]bool{}
+		for _, i := range entries {
+			groups = append(groups, fmt.Sprintf("%s (entry %d, %s)", rules[i].OktaGroup, i+1, strings.ToLower(rules[i].AccessLevel)))
+			levels[strings.ToLower(rules[i].AccessLevel)] = true
+		}
+		if len(levels) > 1 {
+			findings = append(findings, fmt.Sprintf("conflicting access levels in %s: %s", target, strings.Join(groups, ", ")))
+		} else {
+			findings = append(findings, fmt.Sprintf("duplicate target %s of %s", target, strings.Join(groups, ", ")))
+		}
+	}
+	return findings
+}
+
+// lintMappingRulesLive looks up the Okta groups and Gitlab group paths of the mapping rules.
+func lintMappingRulesLive(rules []MappingRule) ([]string, error) {
+	ctx, client, gitlabClt, err := NewClients()
+	if err != nil {
+		return nil, err
+	}
+	findings := make([]string, 0)
+	for i, r := range rules {
+		entry := fmt.Sprintf("entry %d (%s)", i+1, r.OktaGroup)
+		if r.OktaGroup != "" {
+			found, err := listOktaGroups(ctx, client, &query.Params{Q: r.OktaGroup})
+			if err != nil {
+				findings = append(findings, fmt.Sprintf("%s: could not look up the Okta group: %v", entry, err))
+			} else {
+				known := false
+				for _, g := range found {
+					known = known || (g.Profile != nil && strings.EqualFold(g.Profile.Name, r.OktaGroup))
+				}
+				if !known {
+					findings = append(findings, fmt.Sprintf("%s: unknown Okta group %s", entry, r.OktaGroup))
+				}
+			}
+		}
+		if r.GitlabGroup != "" {
+			_, resp, err := gitlabClt.Groups.GetGroup(r.GitlabGroup, nil)
+			switch {
+			case resp != nil && resp.StatusCode == http.StatusNotFound:
+				findings = append(findings, fmt.Sprintf("%s: unknown Gitlab group %s", entry, r.GitlabGroup))
+			case err != nil:
+				findings = append(findings, fmt.Sprintf("%s: could not look up the Gitlab group %s: %v", entry, r.GitlabGroup, err))
+			}
+		}
+	}
+	return findings, nil
+}
+
+func init() {
+	lintCmd.Flags().StringVarP(&lintFile, "file", "f", "", "mapping file to check, MAPPINGS_FILE by default")
+	lintCmd.Flags().BoolVar(&lintLive, "live", false, "look up the Okta groups and Gitlab groups")
+	rootCmd.AddCommand(lintCmd)
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// logger writes the logs of the runs, at the LOG_LEVEL and in the LOG_FORMAT.
+// The changes of memberships carry the group and user fields, so the logs can be filtered by group or user:
+// group is the Okta group, gitlab_group the Gitlab group or tier, user the Okta user ID and username the Gitlab username.
+var logger = slog.New(&runLogHandler{slog.NewTextHandler(os.Stdout, nil)})
+
+// auditLog tells whether the changes of memberships are logged as audit entries, with the gcp format
+var auditLog bool
+
+// setupLogging configures the logger with the level, debug, info, warn or error, and the format, text, json, gcp,
+// or auto for gcp on Cloud Functions and Cloud Run and text elsewhere.
+// The logs are written to stdout, or to stderr with the JSON output.
+func setupLogging(level, format string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("LOG_LEVEL: %w", err)
+	}
+	opts := &slog.HandlerOptions{Level: l}
+	format = strings.ToLower(format)
+	if format == "auto" {
+		// Cloud Functions and Cloud Run set K_SERVICE
+		format = "text"
+		if os.Getenv("K_SERVICE") != "" {
+			format = "gcp"
+		}
+	}
+	var h slog.Handler
+	switch format {
+	case "text":
+		h = slog.NewTextHandler(os.Stdout, opts)
+	case "json":
+		h = slog.NewJSONHandler(os.Stdout, opts)
+	case "gcp":
+		opts.ReplaceAttr = gcpLogAttr
+		h = &gcpLogHandler{slog.NewJSONHandler(os.Stdout, opts)}
+	default:
+		return fmt.Errorf("unknown LOG_FORMAT %q, expected text, json, gcp or auto", format)
+	}
+	auditLog = format == "gcp"
+	logger = slog.New(&runLogHandler{h})
+	return nil
+}
+
+// levelNotice is the level of the audit entries, the NOTICE severity of Cloud Logging
+const levelNotice = slog.Level(2)
+
+// gcpLabels are the fields also set as labels of the Cloud Logging entries, to build log-based alerts and metrics on
+var gcpLabels = []string{"run_id", "action", "group", "user"}
+
+// gcpLogAttr names the fields of the entries as the Cloud Logging agents of Cloud Functions and Cloud Run expect them.
+func gcpLogAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) > 0 {
+		return a
+	}
+	switch a.Key {
+	case slog.LevelKey:
+		severity := "DEBUG"
+		switch l := a.Value.Any().(slog.Level); {
+		case l >= slog.LevelError:
+			severity = "ERROR"
+		case l >= slog.LevelWarn:
+			severity = "WARNING"
+		case l >= levelNotice:
+			severity = "NOTICE"
+		case l >= slog.LevelInfo:
+			severity = "INFO"
+		}
+		return slog.String("severity", severity)
+	case slog.MessageKey:
+		return slog.Attr{Key: "message", Value: a.Value}
+	}
+	return a
+}
+
+// gcpLogHandler writes the logs as structured Cloud Logging entries, with the gcpLabels fields as labels.
+type gcpLogHandler struct {
+	slog.Handler
+}
+
+// Handle writes the record with the labels of its fields.
+func (h *gcpLogHandler) Handle(ctx context.Context, r slog.Record) error {
+	var labels []interface{}
+	r.Attrs(func(a slog.Attr) bool {
+		for _, key := range gcpLabels {
+			if a.Key == key {
+				labels = append(labels, slog.String(key, a.Value.String()))
+			}
+		}
+		return true
+	})
+	if len(labels) > 0 {
+		r = r.Clone()
+		r.AddAttrs(slog.Group("logging.googleapis.com/labels", labels...))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+// WithAttrs returns the handler labelling the records after the attributes.
+func (h *gcpLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &gcpLogHandler{h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup returns the handler labelling the records in the group.
+func (h *gcpLogHandler) WithGroup(name string) slog.Handler {
+	return &gcpLogHandler{h.Handler.WithGroup(name)}
+}
+
+// runLogHandler adds the ID of the run in progress to the log lines.
+type runLogHandler struct {
+	slog.Handler
+}
+
+// Handle writes the record with the run_id field, if a run is in progress.
+func (h *runLogHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := runID(); id != "" {
+		r.AddAttrs(slog.String("run_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+// WithAttrs returns the handler adding the run ID after the attributes.
+func (h *runLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &runLogHandler{h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup returns the handler adding the run ID in the group.
+func (h *runLogHandler) WithGroup(name string) slog.Handler {
+	return &runLogHandler{h.Handler.WithGroup(name)}
+}
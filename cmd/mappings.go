@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/okta/okta-sdk-golang/v2/okta"
+	"github.com/okta/okta-sdk-golang/v2/okta/query"
+	"github.com/spf13/viper"
+)
+
+// MappingRule links an Okta group to a Gitlab group, as declared in the MAPPINGS_FILE.
+type MappingRule struct {
+	// OktaGroup is the full name of the Okta group
+	OktaGroup string `mapstructure:"okta_group"`
+	// GitlabGroup is the full path of the Gitlab group
+	GitlabGroup string `mapstructure:"gitlab_group"`
+	// AccessLevel is the access level the members are added with: guest, reporter, developer or maintainer
+	AccessLevel string `mapstructure:"access_level"`
+}
+
+// LoadMappingRules reads and validates the mapping rules of the MAPPINGS_FILE, a YAML file with a mappings list.
+// Returns no mappings if MAPPINGS_FILE is not set, then the dev_ groups are synced by name.
+func LoadMappingRules() ([]MappingRule, error) {
+	path := viper.GetString("MAPPINGS_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	mappings, err := readMappingRules(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(mappings) == 0 {
+		return nil, fmt.Errorf("%s: no mappings", path)
+	}
+	seen := map[string]bool{}
+	for i, m := range mappings {
+		switch {
+		case m.OktaGroup == "":
+			return nil, fmt.Errorf("%s: mapping %d has no okta_group", path, i+1)
+		case m.GitlabGroup == "":
+			return nil, fmt.Errorf("%s: mapping of %s has no gitlab_group", path, m.OktaGroup)
+		case seen[strings.ToLower(m.OktaGroup)]:
+			return nil, fmt.Errorf("%s: %s is mapped more than once", path, m.OktaGroup)
+		}
+		if _, ok := accessLevels[strings.ToLower(m.AccessLevel)]; !ok {
+			return nil, fmt.Errorf("%s: unknown access level %q of %s", path, m.AccessLevel, m.OktaGroup)
+		}
+		seen[strings.ToLower(m.OktaGroup)] = true
+	}
+	return mappings, nil
+}
+
+// readMappingRules reads the mapping rules of the mappings file, without validating them.
+func readMappingRules(path string) ([]MappingRule, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("reading the mappings file %s: %w", path, err)
+	}
+	mappings := []MappingRule{}
+	if err := v.UnmarshalKey("mappings", &mappings); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return mappings, nil
+}
+
+// GetOktaMappedGroups fetches the group members of the Okta groups of the mappings.
+// Mapped groups that don't exist in Okta are reported and left out.
+func GetOktaMappedGroups(ctx context.Context, ctl *okta.Client, mappings []MappingRule) (groups []OktaGroup, err error) {
+	membership := NewOktaMembership(ctx, ctl, viper.GetString("OKTA_MEMBERSHIP"))
+	for i := range mappings {
+		m := &mappings[i]
+		found, err := listOktaGroups(ctx, ctl, &query.Params{Q: m.OktaGroup})
+		if err != nil {
+			return nil, fmt.Errorf("finding the Okta group %s: %w", m.OktaGroup, err)
+		}
+		var group *okta.Group
+		for _, g := range found {
+			if g.Profile != nil && strings.EqualFold(g.Profile.Name, m.OktaGroup) {
+				group = g
+				break
+			}
+		}
+		if group == nil {
+			warnDataQuality("mapped Okta group %s does not exist", m.OktaGroup)
+			continue
+		}
+		gr := OktaGroup{ID: group.Id, Name: m.OktaGroup, OktaName: m.OktaGroup, Mapping: m}
+		gr.Users, gr.Deprovisioned, err = oktaMembers.listGroupUsers(ctx, ctl, group)
+		if err == nil {
+			gr.Users, gr.Deprovisioned, err = membership.Resolve(group.Id, gr.Users, gr.Deprovisioned)
+		}
+		if err != nil {
+			warnDataQuality("Okta group %s is not synced: %v", gr.Name, err)
+			continue
+		}
+		groups = append(groups, gr)
+	}
+	return groups, nil
+}
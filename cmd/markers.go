@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/spf13/viper"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+
+	"psync/internal/set"
+)
+
+// Reconcile modes, set with RECONCILE, for the Gitlab group members that are not members of the Okta group
+const (
+	// ReconcileOff leaves them alone, only deprovisioned and suspended Okta users are removed
+	ReconcileOff = ""
+	// ReconcileManaged removes them if psync added them
+	ReconcileManaged = "managed"
+	// ReconcileStrict removes them all, also the collaborators added by hand
+	ReconcileStrict = "strict"
+)
+
+// MemberMarker records which Gitlab group memberships psync made, so that reconciling
+// only removes those and leaves the collaborators added by hand.
+type MemberMarker interface {
+	Marked(gitlabID int, m GitlabMember) (bool, error)
+	Mark(gitlabID int, userID int, oktaID string) error
+	Unmark(gitlabID int, m GitlabMember) error
+}
+
+// NewMemberMarker returns the MANAGED_MARKER marker: state, recording the memberships in the state store,
+// or custom_attribute, setting a custom attribute on the Gitlab users, which requires an admin token on self-managed Gitlab.
+func NewMemberMarker(clt *gitlab.Client, state *State) (MemberMarker, error) {
+	switch kind := viper.GetString("MANAGED_MARKER"); kind {
+	case "state":
+		return &stateMarker{state: state}, nil
+	case "custom_attribute":
+		return &attributeMarker{client: clt}, nil
+	default:
+		return nil, fmt.Errorf("unknown MANAGED_MARKER %q, expected state or custom_attribute", kind)
+	}
+}
+
+// stateMarker records the managed memberships in the state.
+type stateMarker struct {
+	state *State
+}
+
+func (s *stateMarker) Marked(gitlabID int, m GitlabMember) (bool, error) {
+	return s.state.IsManaged(gitlabID, m.SAMLID), nil
+}
+
+func (s *stateMarker) Mark(gitlabID int, userID int, oktaID string) error {
+	s.state.SetManaged(gitlabID, oktaID, true)
+	return nil
+}
+
+func (s *stateMarker) Unmark(gitlabID int, m GitlabMember) error {
+	s.state.SetManaged(gitlabID, m.SAMLID, false)
+	return nil
+}
+
+// attributeMarker sets the psync_managed_<group ID> custom attribute on the Gitlab users psync added to the group.
+type attributeMarker struct {
+	client *gitlab.Client
+}
+
+func managedAttribute(gitlabID int) string {
+	return fmt.Sprintf("psync_managed_%d", gitlabID)
+}
+
+func (a *attributeMarker) Marked(gitlabID int, m GitlabMember) (bool, error) {
+	_, resp, err := a.client.CustomAttribute.GetCustomUserAttribute(m.User.ID, managedAttribute(gitlabID))
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (a *attributeMarker) Mark(gitlabID int, userID int, oktaID string) error {
+	_, _, err := a.client.CustomAttribute.SetCustomUserAttribute(userID, gitlab.CustomAttribute{Key: managedAttribute(gitlabID), Value: oktaID})
+	return err
+}
+
+func (a *attributeMarker) Unmark(gitlabID int, m GitlabMember) error {
+	resp, err := a.client.CustomAttribute.DeleteCustomUserAttribute(m.User.ID, managedAttribute(gitlabID))
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// reconcileMode returns the RECONCILE mode.
+func reconcileMode() (string, error) {
+	switch mode := viper.GetString("RECONCILE"); mode {
+	case ReconcileOff, ReconcileManaged, ReconcileStrict:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown RECONCILE %q, expected managed or strict", mode)
+	}
+}
+
+// planReconcile adds to the removals of the group its members that are not members of the Okta group,
+// all of them in strict mode, and only those psync marked as managed otherwise. Protected members are kept.
+func planReconcile(gs *GroupSync, mode string, marker MemberMarker) error {
+	if mode == ReconcileOff {
+		return nil
+	}
+	for _, m := range gs.Members {
+		if m.SAMLID == "" || m.Protected || set.Contains(gs.Group.Users, m.SAMLID) || set.Contains(gs.Plan.Remove, m.SAMLID) {
+			continue
+		}
+		if mode == ReconcileManaged {
+			managed, err := marker.Marked(gs.GitlabID, m)
+			if err != nil {
+				return fmt.Errorf("checking whether psync manages %s: %w", m.User.Username, err)
+			}
+			if !managed {
+				continue
+			}
+		}
+		gs.Plan.Remove = append(gs.Plan.Remove, m.SAMLID)
+	}
+	return nil
+}
+
+// unprotectManaged lifts the protection of the members psync marked as managed in the group. psync may grant
+// access levels at or above PROTECTED_ACCESS_LEVEL itself, e.g. owner through GROUP_ACCESS_LEVELS, and removes
+// those members like the others; only the members added by hand stay protected.
+func unprotectManaged(gitlabID int, members []GitlabMember, marker MemberMarker) error {
+	for i, m := range members {
+		if !m.Protected {
+			continue
+		}
+		managed, err := marker.Marked(gitlabID, m)
+		if err != nil {
+			return fmt.Errorf("checking whether psync manages %s: %w", m.User.Username, err)
+		}
+		members[i].Protected = !managed
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+func TestPlanRemovesOwnersPsyncAdded(t *testing.T) {
+	viper.Set("PROTECTED_ACCESS_LEVEL", "owner")
+	t.Cleanup(viper.Reset)
+
+	identity := func(id string) *gitlab.GroupMemberSAMLIdentity { return &gitlab.GroupMemberSAMLIdentity{ExternUID: id} }
+	parent := []*gitlab.GroupMember{
+		{ID: 1, Username: "anna", GroupSAMLIdentity: identity("00uanna")},
+		{ID: 2, Username: "ben", GroupSAMLIdentity: identity("00uben")},
+		{ID: 3, Username: "carl", GroupSAMLIdentity: identity("00ucarl")},
+	}
+	group := []*gitlab.GroupMember{
+		// anna was made owner by psync through GROUP_ACCESS_LEVELS, ben by hand
+		{ID: 1, Username: "anna", AccessLevel: gitlab.OwnerPermissions},
+		{ID: 2, Username: "ben", AccessLevel: gitlab.OwnerPermissions},
+		{ID: 3, Username: "carl", AccessLevel: gitlab.DeveloperPermissions},
+	}
+	state := &State{}
+	state.SetManaged(201, "00uanna", true)
+
+	members := MatchGitlabMembers(group, parent)
+	if err := unprotectManaged(201, members, &stateMarker{state: state}); err != nil {
+		t.Fatalf("unprotectManaged() error = %v", err)
+	}
+	protected := map[string]bool{}
+	for _, m := range members {
+		protected[m.User.Username] = m.Protected
+	}
+	if want := map[string]bool{"anna": false, "ben": true, "carl": false}; !reflect.DeepEqual(protected, want) {
+		t.Errorf("protected %v, want %v", protected, want)
+	}
+
+	// All three left Okta, the owner added by hand stays
+	g := OktaGroup{ID: "00g1", Name: "payments", Deprovisioned: []string{"00uanna", "00uben", "00ucarl"}}
+	plan := PlanGroup(g, []string{"00uanna", "00uben", "00ucarl"}, members)
+	if want := []string{"00uanna", "00ucarl"}; !reflect.DeepEqual(plan.Remove, want) {
+		t.Errorf("remove %v, want %v", plan.Remove, want)
+	}
+}
@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/okta/okta-sdk-golang/v2/okta"
+
+	"psync/internal/set"
+)
+
+// Okta membership modes
+const (
+	// MembershipOkta takes the group members as Okta reports them, including the users assigned by group rules
+	MembershipOkta = "okta"
+	// MembershipDirect excludes the users that are members of a group rule source group, and removes them from the Gitlab group.
+	// Okta doesn't expose how a user was assigned, so users that are also assigned directly are excluded too.
+	MembershipDirect = "direct"
+	// MembershipNested adds the members of the group rule source groups transitively.
+	// Okta doesn't chain group rules, so members of nested groups are otherwise missing.
+	MembershipNested = "nested"
+)
+
+// Patterns extracting the group IDs from the isMemberOfGroup and isMemberOfAnyGroup rule expressions
+var (
+	ruleFuncPattern  = regexp.MustCompile(`isMemberOf(?:Any)?Group\(([^)]*)\)`)
+	ruleGroupPattern = regexp.MustCompile(`"(00g[0-9A-Za-z]+)"`)
+)
+
+// OktaMembership resolves group memberships derived from Okta group rules.
+type OktaMembership struct {
+	ctx  context.Context
+	ctl  *okta.Client
+	mode string
+	// sources maps the group IDs to the groups whose members group rules assign to them
+	sources map[string][]string
+	// users caches the members of the source groups
+	users map[string]groupUsers
+}
+
+// groupUsers are the active and deprovisioned members of a group
+type groupUsers struct {
+	active, deprovisioned []string
+}
+
+// NewOktaMembership returns the resolver for the membership mode.
+func NewOktaMembership(ctx context.Context, ctl *okta.Client, mode string) *OktaMembership {
+	return &OktaMembership{ctx: ctx, ctl: ctl, mode: mode, users: map[string]groupUsers{}}
+}
+
+// Resolve applies the membership mode to the active and deprovisioned members of the group.
+func (m *OktaMembership) Resolve(id string, active, deprovisioned []string) ([]string, []string, error) {
+	switch m.mode {
+	case MembershipOkta, "":
+		return active, deprovisioned, nil
+	case MembershipDirect:
+		sources, err := m.sourceGroups(id, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, src := range sources {
+			srcActive, _, err := m.groupUsers(src)
+			if err != nil {
+				return nil, nil, err
+			}
+			// The rule-derived users are removed like deprovisioned users, so that existing Gitlab members lose access
+			deprovisioned = set.Union(deprovisioned, set.Intersection(active, srcActive))
+			active = set.Difference(active, srcActive)
+		}
+		return active, deprovisioned, nil
+	case MembershipNested:
+		sources, err := m.sourceGroups(id, true)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, src := range sources {
+			srcActive, srcDeprovisioned, err := m.groupUsers(src)
+			if err != nil {
+				return nil, nil, err
+			}
+			active = set.Union(active, srcActive)
+			deprovisioned = set.Union(deprovisioned, srcDeprovisioned)
+		}
+		return active, deprovisioned, nil
+	}
+	return nil, nil, fmt.Errorf("unknown Okta membership mode %q, expected %s, %s or %s", m.mode, MembershipOkta, MembershipDirect, MembershipNested)
+}
+
+// sourceGroups returns the groups whose members are assigned to the group by active group rules,
+// following the rules of the source groups as well when transitive is set.
+func (m *OktaMembership) sourceGroups(id string, transitive bool) ([]string, error) {
+	if m.sources == nil {
+		if err := m.loadRules(); err != nil {
+			return nil, err
+		}
+	}
+	var result []string
+	seen := map[string]bool{id: true}
+	queue := []string{id}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, src := range m.sources[current] {
+			if seen[src] {
+				continue
+			}
+			seen[src] = true
+			result = append(result, src)
+			if transitive {
+				queue = append(queue, src)
+			}
+		}
+	}
+	return result, nil
+}
+
+// loadRules fetches the active group rules and indexes their source groups by target group.
+func (m *OktaMembership) loadRules() error {
+	rules, err := listOktaGroupRules(m.ctx, m.ctl)
+	if err != nil {
+		return fmt.Errorf("listing the Okta group rules: %w", err)
+	}
+	m.sources = map[string][]string{}
+	for _, r := range rules {
+		if r.Status != "ACTIVE" || r.Actions == nil || r.Actions.AssignUserToGroups == nil {
+			continue
+		}
+		sources := ruleSourceGroups(r)
+		for _, target := range r.Actions.AssignUserToGroups.GroupIds {
+			m.sources[target] = append(m.sources[target], sources...)
+		}
+	}
+	return nil
+}
+
+// ruleSourceGroups returns the groups a group rule takes its members from,
+// both from the people condition and the group functions of the expression.
+func ruleSourceGroups(r *okta.GroupRule) (groups []string) {
+	if r.Conditions == nil {
+		return
+	}
+	if r.Conditions.People != nil && r.Conditions.People.Groups != nil {
+		groups = append(groups, r.Conditions.People.Groups.Include...)
+	}
+	if r.Conditions.Expression != nil {
+		for _, call := range ruleFuncPattern.FindAllStringSubmatch(r.Conditions.Expression.Value, -1) {
+			for _, g := range ruleGroupPattern.FindAllStringSubmatch(call[1], -1) {
+				groups = append(groups, g[1])
+			}
+		}
+	}
+	return
+}
+
+// groupUsers lists the members of a source group once.
+func (m *OktaMembership) groupUsers(id string) ([]string, []string, error) {
+	if u, ok := m.users[id]; ok {
+		return u.active, u.deprovisioned, nil
+	}
+	active, deprovisioned, err := ListOktaGroupUsers(m.ctx, m.ctl, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	m.users[id] = groupUsers{active: active, deprovisioned: deprovisioned}
+	return active, deprovisioned, nil
+}
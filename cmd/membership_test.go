@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/okta/okta-sdk-golang/v2/okta"
+)
+
+func TestOktaMembershipResolve(t *testing.T) {
+	// 00gsales is assigned the members of 00gemea by a group rule, and 00gemea those of 00gparis
+	sources := map[string][]string{"00gsales": {"00gemea"}, "00gemea": {"00gparis"}}
+	users := map[string]groupUsers{
+		"00gemea":  {active: []string{"anna", "bert"}, deprovisioned: []string{"carl"}},
+		"00gparis": {active: []string{"dana"}, deprovisioned: []string{"emil"}},
+	}
+	tests := []struct {
+		name                          string
+		mode                          string
+		group                         string
+		active, deprovisioned         []string
+		wantActive, wantDeprovisioned []string
+		wantErr                       bool
+	}{
+		{
+			name: "okta mode keeps the members", mode: MembershipOkta, group: "00gsales",
+			active: []string{"anna", "zoe"}, deprovisioned: []string{"yann"},
+			wantActive: []string{"anna", "zoe"}, wantDeprovisioned: []string{"yann"},
+		},
+		{
+			name: "default mode is okta", mode: "", group: "00gsales",
+			active: []string{"anna"}, deprovisioned: nil,
+			wantActive: []string{"anna"}, wantDeprovisioned: nil,
+		},
+		{
+			name: "direct mode removes the rule-derived users", mode: MembershipDirect, group: "00gsales",
+			active: []string{"anna", "bert", "zoe"}, deprovisioned: []string{"yann"},
+			wantActive: []string{"zoe"}, wantDeprovisioned: []string{"yann", "anna", "bert"},
+		},
+		{
+			name: "direct mode only removes the source members of the group", mode: MembershipDirect, group: "00gsales",
+			active: []string{"bert"}, deprovisioned: nil,
+			wantActive: nil, wantDeprovisioned: []string{"bert"},
+		},
+		{
+			name: "direct mode without rules", mode: MembershipDirect, group: "00gparis",
+			active: []string{"dana"}, deprovisioned: []string{"emil"},
+			wantActive: []string{"dana"}, wantDeprovisioned: []string{"emil"},
+		},
+		{
+			name: "nested mode adds the source members transitively", mode: MembershipNested, group: "00gsales",
+			active: []string{"zoe"}, deprovisioned: []string{"yann"},
+			wantActive: []string{"zoe", "anna", "bert", "dana"}, wantDeprovisioned: []string{"yann", "carl", "emil"},
+		},
+		{
+			name: "unknown mode", mode: "rules", group: "00gsales", wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &OktaMembership{mode: tt.mode, sources: sources, users: users}
+			active, deprovisioned, err := m.Resolve(tt.group, tt.active, tt.deprovisioned)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(active, tt.wantActive) {
+				t.Errorf("Resolve() active = %v, want %v", active, tt.wantActive)
+			}
+			if !reflect.DeepEqual(deprovisioned, tt.wantDeprovisioned) {
+				t.Errorf("Resolve() deprovisioned = %v, want %v", deprovisioned, tt.wantDeprovisioned)
+			}
+		})
+	}
+}
+
+func TestRuleSourceGroups(t *testing.T) {
+	tests := []struct {
+		name string
+		rule *okta.GroupRule
+		want []string
+	}{
+		{
+			name: "no conditions",
+			rule: &okta.GroupRule{},
+			want: nil,
+		},
+		{
+			name: "people condition",
+			rule: &okta.GroupRule{Conditions: &okta.GroupRuleConditions{
+				People: &okta.GroupRulePeopleCondition{Groups: &okta.GroupRuleGroupCondition{Include: []string{"00ga", "00gb"}}},
+			}},
+			want: []string{"00ga", "00gb"},
+		},
+		{
+			name: "isMemberOfGroup expression",
+			rule: &okta.GroupRule{Conditions: &okta.GroupRuleConditions{
+				Expression: &okta.GroupRuleExpression{Value: `isMemberOfGroup("00ga") AND user.department == "Sales"`},
+			}},
+			want: []string{"00ga"},
+		},
+		{
+			name: "isMemberOfAnyGroup expression",
+			rule: &okta.GroupRule{Conditions: &okta.GroupRuleConditions{
+				Expression: &okta.GroupRuleExpression{Value: `isMemberOfAnyGroup("00ga", "00gb") OR isMemberOfGroup("00gc")`},
+			}},
+			want: []string{"00ga", "00gb", "00gc"},
+		},
+		{
+			name: "quoted IDs outside group functions",
+			rule: &okta.GroupRule{Conditions: &okta.GroupRuleConditions{
+				Expression: &okta.GroupRuleExpression{Value: `user.costCenter == "00gnotagroup"`},
+			}},
+			want: nil,
+		},
+		{
+			name: "people condition and expression",
+			rule: &okta.GroupRule{Conditions: &okta.GroupRuleConditions{
+				People:     &okta.GroupRulePeopleCondition{Groups: &okta.GroupRuleGroupCondition{Include: []string{"00ga"}}},
+				Expression: &okta.GroupRuleExpression{Value: `isMemberOfGroup("00gb")`},
+			}},
+			want: []string{"00ga", "00gb"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ruleSourceGroups(tt.rule); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ruleSourceGroups() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metric types
+const (
+	MetricCounter   = "counter"
+	MetricGauge     = "gauge"
+	MetricHistogram = "histogram"
+)
+
+// Metric is a Prometheus metric psync exposes at /metrics in serve mode.
+type Metric struct {
+	Name   string
+	Help   string
+	Type   string
+	Labels []string
+	// Buckets are the upper bounds of the buckets of a histogram
+	Buckets []float64
+
+	mu     sync.Mutex
+	values map[string]float64
+	// counts are the observations of a histogram per bucket, the last one counting all of them
+	counts map[string][]uint64
+}
+
+// The metrics registry. The Grafana dashboard is generated from it, so it always matches the exposed metrics.
+var (
+	metricRuns            = &Metric{Name: "psync_runs_total", Help: "Sync runs by result", Type: MetricCounter, Labels: []string{"result"}}
+	metricLastRun         = &Metric{Name: "psync_last_run_timestamp_seconds", Help: "Time the last run finished", Type: MetricGauge}
+	metricRunDuration     = &Metric{Name: "psync_last_run_duration_seconds", Help: "Duration of the last run", Type: MetricGauge}
+	metricDrift           = &Metric{Name: "psync_drift", Help: "Membership changes found necessary by the last run", Type: MetricGauge}
+	metricExternalDrift   = &Metric{Name: "psync_external_drift", Help: "Membership changes made in Gitlab outside of psync, found by the last run", Type: MetricGauge}
+	metricAdded           = &Metric{Name: "psync_members_added_total", Help: "Members added to Gitlab groups", Type: MetricCounter}
+	metricRemoved         = &Metric{Name: "psync_members_removed_total", Help: "Members removed from Gitlab groups", Type: MetricCounter}
+	metricSkipped         = &Metric{Name: "psync_skipped_groups", Help: "Groups skipped by the last run", Type: MetricGauge}
+	metricConflicts       = &Metric{Name: "psync_conflicts", Help: "Membership conflicts found by the last run", Type: MetricGauge}
+	metricWarnings        = &Metric{Name: "psync_data_warnings", Help: "Data-quality warnings raised by the last run", Type: MetricGauge}
+	metricAlerts          = &Metric{Name: "psync_alerts", Help: "Alerts raised by the last run", Type: MetricGauge}
+	metricOktaRequests    = &Metric{Name: "psync_okta_requests_total", Help: "Okta API requests", Type: MetricCounter}
+	metricOktaThrottled   = &Metric{Name: "psync_okta_throttled_seconds_total", Help: "Time spent waiting for the Okta rate limit", Type: MetricCounter}
+	metricOktaRemaining   = &Metric{Name: "psync_okta_rate_limit_remaining", Help: "Okta requests remaining in the rate limit window at the end of the last run", Type: MetricGauge}
+	metricGitlabCacheHits = &Metric{Name: "psync_gitlab_cache_hits_total", Help: "Gitlab responses served from the cache", Type: MetricCounter}
+	metricTokenExpiry     = &Metric{Name: "psync_gitlab_token_expiry_timestamp_seconds", Help: "Time the Gitlab token expires", Type: MetricGauge}
+	metricRunDurations    = &Metric{Name: "psync_run_duration_seconds", Help: "Duration of the runs", Type: MetricHistogram,
+		Buckets: []float64{10, 30, 60, 120, 300, 600, 1200, 1800, 3600}}
+	metricAPIRequests = &Metric{Name: "psync_api_requests_total", Help: "API requests by provider and response status", Type: MetricCounter,
+		Labels: []string{"provider", "code"}}
+	metricErrors      = &Metric{Name: "psync_errors_total", Help: "Membership changes that failed", Type: MetricCounter}
+	metricRunRequests = &Metric{Name: "psync_last_run_api_requests", Help: "API requests sent by the last run, by provider", Type: MetricGauge,
+		Labels: []string{"provider"}}
+	metricRateLimitConsumed = &Metric{Name: "psync_last_run_rate_limit_consumed_ratio", Help: "Share of the rate limit window used at the lowest headroom of the last run, by provider",
+		Type: MetricGauge, Labels: []string{"provider"}}
+	metricSecretAccesses = &Metric{Name: "psync_secret_accesses_total", Help: "Secrets read, by secret manager", Type: MetricCounter,
+		Labels: []string{"manager"}}
+
+	metrics = []*Metric{
+		metricRuns, metricLastRun, metricRunDuration, metricDrift, metricExternalDrift, metricAdded, metricRemoved,
+		metricSkipped, metricConflicts, metricWarnings, metricAlerts,
+		metricOktaRequests, metricOktaThrottled, metricOktaRemaining, metricGitlabCacheHits, metricTokenExpiry,
+		metricRunDurations, metricAPIRequests, metricErrors, metricRunRequests, metricRateLimitConsumed, metricSecretAccesses,
+	}
+)
+
+// Set sets the value of the metric with the label values.
+func (m *Metric) Set(v float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.values == nil {
+		m.values = map[string]float64{}
+	}
+	m.values[strings.Join(labels, "\x00")] = v
+}
+
+// Add adds to the value of the metric with the label values.
+func (m *Metric) Add(v float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.values == nil {
+		m.values = map[string]float64{}
+	}
+	m.values[strings.Join(labels, "\x00")] += v
+}
+
+// Observe adds an observation to the histogram with the label values.
+func (m *Metric) Observe(v float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.values == nil {
+		m.values, m.counts = map[string]float64{}, map[string][]uint64{}
+	}
+	key := strings.Join(labels, "\x00")
+	if m.counts[key] == nil {
+		m.counts[key] = make([]uint64, len(m.Buckets)+1)
+	}
+	for i, le := range m.Buckets {
+		if v <= le {
+			m.counts[key][i]++
+		}
+	}
+	m.counts[key][len(m.Buckets)]++
+	m.values[key] += v
+}
+
+// labelPairs formats the label values of the key as Prometheus label pairs.
+func (m *Metric) labelPairs(key string) []string {
+	pairs := make([]string, 0, len(m.Labels))
+	if len(m.Labels) > 0 {
+		for i, v := range strings.Split(key, "\x00") {
+			pairs = append(pairs, fmt.Sprintf("%s=%q", m.Labels[i], v))
+		}
+	}
+	return pairs
+}
+
+// writeHistogram writes the buckets, sum and count of a histogram, m.mu being held.
+func (m *Metric) writeHistogram(w *strings.Builder, keys []string) {
+	for _, k := range keys {
+		pairs := m.labelPairs(k)
+		counts := m.counts[k]
+		for i, le := range append(append([]float64{}, m.Buckets...), math.Inf(1)) {
+			bound := strconv.FormatFloat(le, 'f', -1, 64)
+			if math.IsInf(le, 1) {
+				bound = "+Inf"
+			}
+			labels := strings.Join(append(append([]string{}, pairs...), fmt.Sprintf("le=%q", bound)), ",")
+			fmt.Fprintf(w, "%s_bucket{%s} %d\n", m.Name, labels, counts[i])
+		}
+		labels := ""
+		if len(pairs) > 0 {
+			labels = "{" + strings.Join(pairs, ",") + "}"
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.Name, labels, strconv.FormatFloat(m.values[k], 'f', -1, 64))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.Name, labels, counts[len(m.Buckets)])
+	}
+}
+
+// write writes the metric in the Prometheus text format.
+func (m *Metric) write(w *strings.Builder) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s.\n# TYPE %s %s\n", m.Name, m.Help, m.Name, m.Type)
+	keys := make([]string, 0, len(m.values))
+	for k := range m.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if m.Type == MetricHistogram {
+		m.writeHistogram(w, keys)
+		return
+	}
+	for _, k := range keys {
+		labels := ""
+		if len(m.Labels) > 0 {
+			labels = "{" + strings.Join(m.labelPairs(k), ",") + "}"
+		}
+		fmt.Fprintf(w, "%s%s %s\n", m.Name, labels, strconv.FormatFloat(m.values[k], 'f', -1, 64))
+	}
+}
+
+// ServeMetrics serves the metrics in the Prometheus text format.
+func ServeMetrics(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	for _, m := range metrics {
+		m.write(&b)
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = fmt.Fprint(w, b.String())
+}
+
+// recordRunMetrics updates the metrics with the outcome of a run.
+func recordRunMetrics(s *RunSummary) {
+	metricRuns.Add(1, s.Result)
+	metricLastRun.Set(float64(s.Finished.Unix()))
+	metricRunDuration.Set(s.Finished.Sub(s.Started).Seconds())
+	metricRunDurations.Observe(s.Finished.Sub(s.Started).Seconds())
+	metricDrift.Set(float64(s.Drift))
+	metricExternalDrift.Set(float64(s.ExternalDrift))
+	metricAdded.Add(float64(s.Added))
+	metricRemoved.Add(float64(s.Removed))
+	metricSkipped.Set(float64(s.Skipped))
+	metricConflicts.Set(float64(s.Conflicts))
+	metricWarnings.Set(float64(s.Warnings))
+	metricAlerts.Set(float64(s.Alerts))
+	metricOktaRequests.Add(float64(oktaRateLimit.Requests))
+	metricOktaThrottled.Add(oktaRateLimit.Throttled.Seconds())
+	metricOktaRemaining.Set(float64(oktaRateLimit.Remaining))
+	if s.Quota != nil {
+		for provider, n := range s.Quota.Requests {
+			metricRunRequests.Set(float64(n), provider)
+		}
+		for provider, rl := range s.Quota.RateLimits {
+			metricRateLimitConsumed.Set(float64(rl.Consumed)/100, provider)
+		}
+		for manager, n := range s.Quota.SecretAccesses {
+			metricSecretAccesses.Add(float64(n), manager)
+		}
+	}
+	if gitlabCache != nil {
+		metricGitlabCacheHits.Add(float64(gitlabCache.Hits))
+	}
+	if s.GitlabTokenExpires != nil {
+		metricTokenExpiry.Set(float64(s.GitlabTokenExpires.Unix()))
+	}
+}
+
+// MetricsTransport counts the API requests of a provider by response status in psync_api_requests_total,
+// "error" counting the requests that got no response.
+type MetricsTransport struct {
+	Provider string
+	Next     http.RoundTripper
+}
+
+// RoundTrip sends the request and counts it.
+func (t *MetricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.Next.RoundTrip(req)
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	metricAPIRequests.Add(1, t.Provider, code)
+	runQuota.observe(t.Provider, resp)
+	return resp, err
+}
@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	mockListen   string
+	mockFixtures string
+)
+
+// mockCmd groups the commands of the local mock providers
+var mockCmd = &cobra.Command{
+	Use:   "mock",
+	Short: "Run psync locally against mock Okta and Gitlab APIs",
+}
+
+// mockServeCmd serves the mock Okta and Gitlab APIs
+var mockServeCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve mock Okta and Gitlab APIs from a fixture pack",
+	Long: `Serve the subset of the Okta and Gitlab APIs psync uses, from the JSON files of a fixture pack, so the
+whole sync can run locally without an Okta org, a Gitlab instance or GCP credentials.
+The Okta API is served under /api/v1 and the Gitlab API under /api/v4, on the same address. Any token is accepted.
+
+The fixture pack is a directory with:
+  okta.json    the Okta users, with their status and profile, and the Okta groups with the IDs of their users
+               and the custom attributes of their profile
+  gitlab.json  the Gitlab version, the users with the Okta user ID of their SAML identity, and the groups
+               with their parent and direct members
+  psync.yaml   a config pointing psync at the mock, to use with --config
+
+The membership changes are applied to the mock, so a second run finds nothing left to change.
+They are lost when the mock stops. Requests to endpoints the mock does not emulate are logged and get a 404.`,
+	Example: `  # Serve the fixture pack shipped with psync, then sync against it from another terminal
+  psync mock serve
+  psync --config fixtures/mock/psync.yaml plan
+  psync --config fixtures/mock/psync.yaml`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		mock, err := LoadMockServer(mockFixtures)
+		cobra.CheckErr(err)
+		logger.Info("Serving the mock Okta and Gitlab APIs", "listen", mockListen, "fixtures", mockFixtures,
+			"okta_url", "http://"+mockListen, "gitlab_url", "http://"+mockListen+"/api/v4")
+		cobra.CheckErr(http.ListenAndServe(mockListen, mock))
+	},
+}
+
+// MockOkta is the okta.json of a fixture pack.
+type MockOkta struct {
+	Users  []MockOktaUser  `json:"users"`
+	Groups []MockOktaGroup `json:"groups"`
+}
+
+// MockOktaUser is an Okta user of the mock. The profile is served as is, so it can carry the custom attributes
+// the sync reads, e.g. the OKTA_ACCESS_LEVEL_ATTRIBUTE.
+type MockOktaUser struct {
+	ID      string                 `json:"id"`
+	Status  string                 `json:"status"`
+	Profile map[string]interface{} `json:"profile"`
+	// Admin marks the user the API token belongs to
+	Admin bool `json:"admin,omitempty"`
+}
+
+// MockOktaGroup is an Okta group of the mock.
+type MockOktaGroup struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Users       []string `json:"users"`
+	// Attributes are the custom attributes of the group profile, e.g. the OKTA_GROUP_ATTRIBUTE
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// MockGitlab is the gitlab.json of a fixture pack.
+type MockGitlab struct {
+	Version string            `json:"version"`
+	Users   []MockGitlabUser  `json:"users"`
+	Groups  []MockGitlabGroup `json:"groups"`
+}
+
+// MockGitlabUser is a Gitlab user of the mock.
+type MockGitlabUser struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	Name     string `json:"name"`
+	State    string `json:"state"`
+	// SAMLExternUID is the Okta user ID of the SAML identity of the user, empty for users without one
+	SAMLExternUID string `json:"saml_extern_uid,omitempty"`
+}
+
+// MockGitlabGroup is a Gitlab group of the mock, with its direct members.
+type MockGitlabGroup struct {
+	ID       int                `json:"id"`
+	Path     string             `json:"path"`
+	Name     string             `json:"name"`
+	ParentID int                `json:"parent_id,omitempty"`
+	Archived bool               `json:"archived,omitempty"`
+	Members  []MockGitlabMember `json:"members"`
+}
+
+// MockGitlabMember is a direct member of a Gitlab group of the mock.
+type MockGitlabMember struct {
+	UserID      int    `json:"user_id"`
+	AccessLevel int    `json:"access_level"`
+	ExpiresAt   string `json:"expires_at,omitempty"`
+}
+
+// MockServer serves the mock Okta and Gitlab APIs. The membership changes are applied to its fixtures.
+type MockServer struct {
+	okta    MockOkta
+	gitlab  MockGitlab
+	started time.Time
+	mux     *http.ServeMux
+
+	// mu guards the fixtures
+	mu sync.Mutex
+}
+
+// LoadMockServer reads the okta.json and gitlab.json of the fixture pack in the directory.
+func LoadMockServer(dir string) (*MockServer, error) {
+	m := &MockServer{started: clock.Now().UTC(), mux: http.NewServeMux()}
+	for name, v := range map[string]interface{}{"okta.json": &m.okta, "gitlab.json": &m.gitlab} {
+		path := filepath.Join(dir, name)
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading the fixture pack: %w", err)
+		}
+		if err := json.Unmarshal(data, v); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	m.routeOkta()
+	m.routeGitlab()
+	m.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		logger.Warn("Endpoint not emulated by the mock", "method", r.Method, "path", r.URL.Path)
+		mockError(w, http.StatusNotFound, "not emulated by the psync mock")
+	})
+	return m, nil
+}
+
+// ServeHTTP serves a request to the mock Okta or Gitlab API.
+func (m *MockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logger.Debug("Mock request", "method", r.Method, "path", r.URL.Path, "query", r.URL.RawQuery)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mux.ServeHTTP(w, r)
+}
+
+// mockJSON writes the response body as JSON.
+func mockJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// mockError writes an error the way both APIs do, with the message under errorSummary for Okta and message for Gitlab.
+func mockError(w http.ResponseWriter, status int, message string) {
+	mockJSON(w, status, map[string]string{"errorSummary": message, "message": message})
+}
+
+// isLoopbackURL tells whether the URL is a plain HTTP URL of this machine, like the URL of psync mock serve.
+func isLoopbackURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "http" {
+		return false
+	}
+	if u.Hostname() == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(u.Hostname())
+	return ip != nil && ip.IsLoopback()
+}
+
+func init() {
+	mockServeCmd.Flags().StringVar(&mockListen, "listen", "127.0.0.1:8089", "address of the mock APIs")
+	mockServeCmd.Flags().StringVar(&mockFixtures, "fixtures", "fixtures/mock", "directory of the fixture pack")
+	mockCmd.AddCommand(mockServeCmd)
+	rootCmd.AddCommand(mockCmd)
+}
@@ -0,0 +1,283 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// routeGitlab routes the Gitlab endpoints psync uses: the version, groups and their members, access requests,
+// projects and billable members, and users.
+func (m *MockServer) routeGitlab() {
+	m.mux.HandleFunc("GET /api/v4/version", func(w http.ResponseWriter, r *http.Request) {
+		mockJSON(w, http.StatusOK, map[string]string{"version": m.gitlab.Version, "revision": "mock"})
+	})
+	m.mux.HandleFunc("GET /api/v4/personal_access_tokens/self", func(w http.ResponseWriter, r *http.Request) {
+		mockJSON(w, http.StatusOK, map[string]interface{}{
+			"id": 1, "name": "psync", "user_id": 1, "active": true, "revoked": false, "scopes": []string{"api"},
+			"created_at": m.started, "expires_at": m.started.AddDate(1, 0, 0).Format("2006-01-02"),
+		})
+	})
+	m.mux.HandleFunc("GET /api/v4/groups", func(w http.ResponseWriter, r *http.Request) {
+		search := strings.ToLower(r.URL.Query().Get("search"))
+		topLevel := r.URL.Query().Get("top_level_only") == "true"
+		groups := make([]map[string]interface{}, 0, len(m.gitlab.Groups))
+		for _, g := range m.gitlab.Groups {
+			if topLevel && g.ParentID != 0 {
+				continue
+			}
+			if strings.Contains(strings.ToLower(g.Path), search) || strings.Contains(strings.ToLower(g.Name), search) {
+				groups = append(groups, m.gitlabGroupJSON(g))
+			}
+		}
+		mockJSON(w, http.StatusOK, groups)
+	})
+	m.mux.HandleFunc("GET /api/v4/groups/{id}", m.gitlabGroupHandler(func(w http.ResponseWriter, r *http.Request, g *MockGitlabGroup) {
+		mockJSON(w, http.StatusOK, m.gitlabGroupJSON(*g))
+	}))
+	m.mux.HandleFunc("POST /api/v4/groups/{id}/archive", m.gitlabGroupHandler(func(w http.ResponseWriter, r *http.Request, g *MockGitlabGroup) {
+		g.Archived = true
+		mockJSON(w, http.StatusOK, m.gitlabGroupJSON(*g))
+	}))
+	m.mux.HandleFunc("GET /api/v4/groups/{id}/subgroups", m.gitlabGroupHandler(func(w http.ResponseWriter, r *http.Request, g *MockGitlabGroup) {
+		groups := make([]map[string]interface{}, 0)
+		for _, sub := range m.gitlab.Groups {
+			if sub.ParentID == g.ID {
+				groups = append(groups, m.gitlabGroupJSON(sub))
+			}
+		}
+		mockJSON(w, http.StatusOK, groups)
+	}))
+	m.mux.HandleFunc("GET /api/v4/groups/{id}/projects", m.gitlabGroupHandler(func(w http.ResponseWriter, r *http.Request, g *MockGitlabGroup) {
+		mockJSON(w, http.StatusOK, []interface{}{})
+	}))
+	m.mux.HandleFunc("GET /api/v4/groups/{id}/access_requests", m.gitlabGroupHandler(func(w http.ResponseWriter, r *http.Request, g *MockGitlabGroup) {
+		mockJSON(w, http.StatusOK, []interface{}{})
+	}))
+	m.mux.HandleFunc("GET /api/v4/groups/{id}/members", m.gitlabGroupHandler(func(w http.ResponseWriter, r *http.Request, g *MockGitlabGroup) {
+		mockJSON(w, http.StatusOK, m.gitlabMembersJSON(g.Members))
+	}))
+	m.mux.HandleFunc("GET /api/v4/groups/{id}/members/all", m.gitlabGroupHandler(func(w http.ResponseWriter, r *http.Request, g *MockGitlabGroup) {
+		// Like Gitlab, the members of the ancestors are inherited, with the highest of their access levels
+		levels := map[int]int{}
+		order := make([]int, 0)
+		for group := g; group != nil; group = m.gitlabGroupByID(group.ParentID) {
+			for _, member := range group.Members {
+				if _, ok := levels[member.UserID]; !ok {
+					order = append(order, member.UserID)
+				}
+				if member.AccessLevel > levels[member.UserID] {
+					levels[member.UserID] = member.AccessLevel
+				}
+			}
+		}
+		members := make([]MockGitlabMember, 0, len(order))
+		for _, id := range order {
+			members = append(members, MockGitlabMember{UserID: id, AccessLevel: levels[id]})
+		}
+		mockJSON(w, http.StatusOK, m.gitlabMembersJSON(members))
+	}))
+	m.mux.HandleFunc("GET /api/v4/groups/{id}/billable_members", m.gitlabGroupHandler(func(w http.ResponseWriter, r *http.Request, g *MockGitlabGroup) {
+		// Guests are not billable, as on the Ultimate plan
+		billable := map[int]bool{}
+		users := make([]map[string]interface{}, 0)
+		for _, group := range m.gitlab.Groups {
+			if !m.gitlabInGroup(&group, g.ID) {
+				continue
+			}
+			for _, member := range group.Members {
+				if u := m.gitlabUser(member.UserID); u != nil && member.AccessLevel > 10 && !billable[u.ID] {
+					billable[u.ID] = true
+					users = append(users, map[string]interface{}{"id": u.ID, "username": u.Username, "name": u.Name, "state": u.State})
+				}
+			}
+		}
+		mockJSON(w, http.StatusOK, users)
+	}))
+	m.mux.HandleFunc("POST /api/v4/groups/{id}/members", m.gitlabGroupHandler(func(w http.ResponseWriter, r *http.Request, g *MockGitlabGroup) {
+		var member MockGitlabMember
+		if err := json.NewDecoder(r.Body).Decode(&member); err != nil {
+			mockError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if m.gitlabUser(member.UserID) == nil {
+			mockError(w, http.StatusNotFound, "404 User Not Found")
+			return
+		}
+		if m.gitlabMember(g, member.UserID) != nil {
+			mockError(w, http.StatusConflict, "Member already exists")
+			return
+		}
+		g.Members = append(g.Members, member)
+		logger.Info("Mock Gitlab member added", "gitlab_group", m.gitlabFullPath(g), "user_id", member.UserID, "access_level", member.AccessLevel)
+		mockJSON(w, http.StatusCreated, m.gitlabMembersJSON([]MockGitlabMember{member})[0])
+	}))
+	m.mux.HandleFunc("PUT /api/v4/groups/{id}/members/{user}", m.gitlabGroupHandler(func(w http.ResponseWriter, r *http.Request, g *MockGitlabGroup) {
+		member := m.gitlabMember(g, mockAtoi(r.PathValue("user")))
+		if member == nil {
+			mockError(w, http.StatusNotFound, "404 Member Not Found")
+			return
+		}
+		var update MockGitlabMember
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			mockError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if update.AccessLevel != 0 {
+			member.AccessLevel = update.AccessLevel
+		}
+		if update.ExpiresAt != "" {
+			member.ExpiresAt = update.ExpiresAt
+		}
+		mockJSON(w, http.StatusOK, m.gitlabMembersJSON([]MockGitlabMember{*member})[0])
+	}))
+	m.mux.HandleFunc("DELETE /api/v4/groups/{id}/members/{user}", m.gitlabGroupHandler(func(w http.ResponseWriter, r *http.Request, g *MockGitlabGroup) {
+		id := mockAtoi(r.PathValue("user"))
+		for i, member := range g.Members {
+			if member.UserID == id {
+				g.Members = append(g.Members[:i], g.Members[i+1:]...)
+				logger.Info("Mock Gitlab member removed", "gitlab_group", m.gitlabFullPath(g), "user_id", id)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		mockError(w, http.StatusNotFound, "404 Member Not Found")
+	}))
+	m.mux.HandleFunc("GET /api/v4/users", func(w http.ResponseWriter, r *http.Request) {
+		username := r.URL.Query().Get("username")
+		users := make([]map[string]interface{}, 0, len(m.gitlab.Users))
+		for _, u := range m.gitlab.Users {
+			if username == "" || strings.EqualFold(u.Username, username) {
+				users = append(users, map[string]interface{}{"id": u.ID, "username": u.Username, "name": u.Name, "state": u.State})
+			}
+		}
+		mockJSON(w, http.StatusOK, users)
+	})
+	m.mux.HandleFunc("GET /api/v4/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		u := m.gitlabUser(mockAtoi(r.PathValue("id")))
+		if u == nil {
+			mockError(w, http.StatusNotFound, "404 User Not Found")
+			return
+		}
+		mockJSON(w, http.StatusOK, map[string]interface{}{"id": u.ID, "username": u.Username, "name": u.Name, "state": u.State})
+	})
+}
+
+// gitlabGroupHandler serves the requests about the group with the ID or full path of the request, 404 if unknown.
+func (m *MockServer) gitlabGroupHandler(serve func(w http.ResponseWriter, r *http.Request, g *MockGitlabGroup)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		g := m.gitlabGroup(r.PathValue("id"))
+		if g == nil {
+			mockError(w, http.StatusNotFound, "404 Group Not Found")
+			return
+		}
+		serve(w, r, g)
+	}
+}
+
+// gitlabGroup finds the Gitlab group by ID or full path.
+func (m *MockServer) gitlabGroup(idOrPath string) *MockGitlabGroup {
+	if id, err := strconv.Atoi(idOrPath); err == nil {
+		return m.gitlabGroupByID(id)
+	}
+	for i := range m.gitlab.Groups {
+		if g := &m.gitlab.Groups[i]; strings.EqualFold(m.gitlabFullPath(g), idOrPath) {
+			return g
+		}
+	}
+	return nil
+}
+
+// gitlabGroupByID finds the Gitlab group by ID, nil for 0, the parent of the top-level groups.
+func (m *MockServer) gitlabGroupByID(id int) *MockGitlabGroup {
+	for i := range m.gitlab.Groups {
+		if id != 0 && m.gitlab.Groups[i].ID == id {
+			return &m.gitlab.Groups[i]
+		}
+	}
+	return nil
+}
+
+// gitlabFullPath returns the path of the group under its ancestors.
+func (m *MockServer) gitlabFullPath(g *MockGitlabGroup) string {
+	if parent := m.gitlabGroupByID(g.ParentID); parent != nil {
+		return m.gitlabFullPath(parent) + "/" + g.Path
+	}
+	return g.Path
+}
+
+// gitlabInGroup tells whether the group is the group with the ID or one of its descendants.
+func (m *MockServer) gitlabInGroup(g *MockGitlabGroup, id int) bool {
+	for ; g != nil; g = m.gitlabGroupByID(g.ParentID) {
+		if g.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MockServer) gitlabUser(id int) *MockGitlabUser {
+	for i := range m.gitlab.Users {
+		if m.gitlab.Users[i].ID == id {
+			return &m.gitlab.Users[i]
+		}
+	}
+	return nil
+}
+
+func (m *MockServer) gitlabMember(g *MockGitlabGroup, userID int) *MockGitlabMember {
+	for i := range g.Members {
+		if g.Members[i].UserID == userID {
+			return &g.Members[i]
+		}
+	}
+	return nil
+}
+
+func (m *MockServer) gitlabGroupJSON(g MockGitlabGroup) map[string]interface{} {
+	group := map[string]interface{}{
+		"id":        g.ID,
+		"name":      g.Name,
+		"path":      g.Path,
+		"full_path": m.gitlabFullPath(&g),
+		"archived":  g.Archived,
+		"web_url":   "http://mock/groups/" + m.gitlabFullPath(&g),
+	}
+	if g.ParentID != 0 {
+		group["parent_id"] = g.ParentID
+	}
+	return group
+}
+
+// gitlabMembersJSON describes the members with their user, and the SAML identity linking them to their Okta user.
+func (m *MockServer) gitlabMembersJSON(members []MockGitlabMember) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(members))
+	for _, member := range members {
+		u := m.gitlabUser(member.UserID)
+		if u == nil {
+			continue
+		}
+		j := map[string]interface{}{
+			"id":           u.ID,
+			"username":     u.Username,
+			"name":         u.Name,
+			"state":        u.State,
+			"access_level": member.AccessLevel,
+		}
+		if member.ExpiresAt != "" {
+			j["expires_at"] = member.ExpiresAt
+		}
+		if u.SAMLExternUID != "" {
+			j["group_saml_identity"] = map[string]interface{}{"extern_uid": u.SAMLExternUID, "provider": "group_saml", "saml_provider_id": 1}
+		}
+		result = append(result, j)
+	}
+	return result
+}
+
+// mockAtoi parses the ID of a path, 0 if it is not a number.
+func mockAtoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"psync/internal/set"
+)
+
+// mockOktaUpdatedFilter is the filter psync lists the recently updated Okta users with
+var mockOktaUpdatedFilter = regexp.MustCompile(`lastUpdated gt "([^"]+)"`)
+
+// mockOktaGroupSearch is a condition of the search psync lists the Okta groups with, see oktaGroupSearch
+var mockOktaGroupSearch = regexp.MustCompile(`profile\.(\w+) (eq|sw) ("[^"]*"|true|false)`)
+
+// routeOkta routes the Okta endpoints psync uses: groups and their users, users and their groups, group rules,
+// and the user and roles of the API token.
+func (m *MockServer) routeOkta() {
+	m.mux.HandleFunc("GET /api/v1/groups", func(w http.ResponseWriter, r *http.Request) {
+		q := strings.ToLower(r.URL.Query().Get("q"))
+		search := mockOktaGroupSearch.FindAllStringSubmatch(r.URL.Query().Get("search"), -1)
+		groups := make([]map[string]interface{}, 0, len(m.okta.Groups))
+	next:
+		for _, g := range m.okta.Groups {
+			if !strings.HasPrefix(strings.ToLower(g.Name), q) {
+				continue
+			}
+			profile := m.oktaGroupJSON(g)["profile"].(map[string]interface{})
+			for _, c := range search {
+				value := fmt.Sprint(profile[c[1]])
+				want := strings.Trim(c[3], `"`)
+				if c[2] == "eq" && value != want || c[2] == "sw" && !strings.HasPrefix(value, want) {
+					continue next
+				}
+			}
+			groups = append(groups, m.oktaGroupJSON(g))
+		}
+		mockJSON(w, http.StatusOK, groups)
+	})
+	m.mux.HandleFunc("GET /api/v1/groups/rules", func(w http.ResponseWriter, r *http.Request) {
+		mockJSON(w, http.StatusOK, []interface{}{})
+	})
+	m.mux.HandleFunc("GET /api/v1/groups/{id}", func(w http.ResponseWriter, r *http.Request) {
+		g := m.oktaGroup(r.PathValue("id"))
+		if g == nil {
+			mockError(w, http.StatusNotFound, "Not found: Resource not found: "+r.PathValue("id")+" (UserGroup)")
+			return
+		}
+		mockJSON(w, http.StatusOK, m.oktaGroupJSON(*g))
+	})
+	m.mux.HandleFunc("GET /api/v1/groups/{id}/users", func(w http.ResponseWriter, r *http.Request) {
+		g := m.oktaGroup(r.PathValue("id"))
+		if g == nil {
+			mockError(w, http.StatusNotFound, "Not found: Resource not found: "+r.PathValue("id")+" (UserGroup)")
+			return
+		}
+		users := make([]map[string]interface{}, 0, len(g.Users))
+		for _, id := range g.Users {
+			if u := m.oktaUser(id); u != nil {
+				users = append(users, m.oktaUserJSON(*u))
+			}
+		}
+		mockJSON(w, http.StatusOK, users)
+	})
+	m.mux.HandleFunc("GET /api/v1/users", func(w http.ResponseWriter, r *http.Request) {
+		// Only the users updated after the filter time are listed, none after the start of the mock
+		if match := mockOktaUpdatedFilter.FindStringSubmatch(r.URL.Query().Get("filter")); match != nil {
+			since, err := time.Parse(time.RFC3339, match[1])
+			if err == nil && !since.Before(m.started) {
+				mockJSON(w, http.StatusOK, []interface{}{})
+				return
+			}
+		}
+		q := strings.ToLower(r.URL.Query().Get("q"))
+		users := make([]map[string]interface{}, 0, len(m.okta.Users))
+		for _, u := range m.okta.Users {
+			for _, attr := range []string{"login", "email", "firstName", "lastName"} {
+				if v, _ := u.Profile[attr].(string); strings.HasPrefix(strings.ToLower(v), q) {
+					users = append(users, m.oktaUserJSON(u))
+					break
+				}
+			}
+		}
+		mockJSON(w, http.StatusOK, users)
+	})
+	m.mux.HandleFunc("GET /api/v1/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		u := m.oktaUser(r.PathValue("id"))
+		if u == nil {
+			mockError(w, http.StatusNotFound, "Not found: Resource not found: "+r.PathValue("id")+" (User)")
+			return
+		}
+		mockJSON(w, http.StatusOK, m.oktaUserJSON(*u))
+	})
+	m.mux.HandleFunc("GET /api/v1/users/{id}/groups", func(w http.ResponseWriter, r *http.Request) {
+		u := m.oktaUser(r.PathValue("id"))
+		if u == nil {
+			mockError(w, http.StatusNotFound, "Not found: Resource not found: "+r.PathValue("id")+" (User)")
+			return
+		}
+		groups := make([]map[string]interface{}, 0)
+		for _, g := range m.okta.Groups {
+			if set.Contains(g.Users, u.ID) {
+				groups = append(groups, m.oktaGroupJSON(g))
+			}
+		}
+		mockJSON(w, http.StatusOK, groups)
+	})
+	m.mux.HandleFunc("GET /api/v1/users/{id}/roles", func(w http.ResponseWriter, r *http.Request) {
+		u := m.oktaUser(r.PathValue("id"))
+		if u == nil || !u.Admin {
+			mockJSON(w, http.StatusOK, []interface{}{})
+			return
+		}
+		mockJSON(w, http.StatusOK, []map[string]string{{"id": "ra1mock", "type": "READ_ONLY_ADMIN", "status": "ACTIVE"}})
+	})
+}
+
+// oktaUser finds the Okta user by ID or login, "me" being the admin user.
+func (m *MockServer) oktaUser(idOrLogin string) *MockOktaUser {
+	for i := range m.okta.Users {
+		u := &m.okta.Users[i]
+		login, _ := u.Profile["login"].(string)
+		if u.ID == idOrLogin || strings.EqualFold(login, idOrLogin) || (idOrLogin == "me" && u.Admin) {
+			return u
+		}
+	}
+	return nil
+}
+
+// oktaGroup finds the Okta group by ID.
+func (m *MockServer) oktaGroup(id string) *MockOktaGroup {
+	for i := range m.okta.Groups {
+		if m.okta.Groups[i].ID == id {
+			return &m.okta.Groups[i]
+		}
+	}
+	return nil
+}
+
+func (m *MockServer) oktaUserJSON(u MockOktaUser) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          u.ID,
+		"status":      u.Status,
+		"created":     m.started,
+		"lastUpdated": m.started,
+		"profile":     u.Profile,
+	}
+}
+
+func (m *MockServer) oktaGroupJSON(g MockOktaGroup) map[string]interface{} {
+	profile := map[string]interface{}{"name": g.Name, "description": g.Description}
+	for k, v := range g.Attributes {
+		profile[k] = v
+	}
+	return map[string]interface{}{
+		"id":                    g.ID,
+		"type":                  "OKTA_GROUP",
+		"created":               m.started,
+		"lastUpdated":           m.started,
+		"lastMembershipUpdated": m.started,
+		"profile":               profile,
+	}
+}
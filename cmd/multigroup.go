@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"sort"
+	"strings"
+)
+
+// Actions for users in more mapped Okta groups than the threshold
+const (
+	MultiGroupFlag  = "flag"
+	MultiGroupBlock = "block"
+)
+
+// FindMultiGroupUsers returns the active users that belong to more than threshold of the groups,
+// with the names of their groups. Belonging to many groups is often a data hygiene problem.
+func FindMultiGroupUsers(groups []OktaGroup, threshold int, reviewed []string) map[string][]string {
+	memberships := make(map[string][]string)
+	for _, g := range groups {
+		for _, u := range g.Users {
+			memberships[u] = append(memberships[u], g.Name)
+		}
+	}
+	exempt := make(map[string]bool, len(reviewed))
+	for _, u := range reviewed {
+		exempt[u] = true
+	}
+	for u, names := range memberships {
+		if len(names) <= threshold || exempt[u] {
+			delete(memberships, u)
+		}
+	}
+	return memberships
+}
+
+// printMultiGroupUsers prints the report section of the users in too many groups.
+func printMultiGroupUsers(users map[string][]string, threshold int, action string) {
+	if len(users) == 0 {
+		return
+	}
+	ids := make([]string, 0, len(users))
+	for u := range users {
+		ids = append(ids, u)
+	}
+	sort.Strings(ids)
+	logger.Warn("Found users in too many groups", "users", len(users), "threshold", threshold, "action", action)
+	for _, u := range ids {
+		logger.Warn("User in too many groups", "user", u, "groups", strings.Join(users[u], ", "))
+	}
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/spf13/viper"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// ParentGroups returns the Gitlab parent groups by ID, whose members carry the SAML identities linking them to Okta users.
+// That is the GITLAB_PARENT_GROUP group, or on Gitlab.com, where each top-level group has its own SAML identities,
+// the top-level groups the token can see matching GITLAB_NAMESPACES and not GITLAB_NAMESPACES_EXCLUDE.
+func ParentGroups(clt *gitlab.Client) (map[int]string, error) {
+	if len(viper.GetStringSlice("GITLAB_NAMESPACES")) == 0 {
+		parent := viper.GetString("GITLAB_PARENT_GROUP")
+		id, err := FindGitlabGroupID(clt, parent)
+		if err != nil {
+			return nil, err
+		}
+		return map[int]string{id: parent}, nil
+	}
+	parents := map[int]string{}
+	opt := &gitlab.ListGroupsOptions{
+		ListOptions:  gitlab.ListOptions{PerPage: 100},
+		TopLevelOnly: gitlab.Bool(true),
+	}
+	for {
+		groups, resp, err := clt.Groups.ListGroups(opt)
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range groups {
+			if inGitlabNamespaces(g.FullPath) {
+				parents[g.ID] = g.FullPath
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opt.Page = resp.NextPage
+	}
+	if len(parents) == 0 {
+		return nil, fmt.Errorf("no top-level Gitlab group matches GITLAB_NAMESPACES %v", viper.GetStringSlice("GITLAB_NAMESPACES"))
+	}
+	return parents, nil
+}
+
+// ParentGroupMembers returns the members of all parent groups, once per user, and the parent groups.
+func ParentGroupMembers(clt *gitlab.Client) ([]*gitlab.GroupMember, map[int]string, error) {
+	parents, err := ParentGroups(clt)
+	if err != nil {
+		return nil, nil, err
+	}
+	members := make([]*gitlab.GroupMember, 0)
+	seen := map[int]bool{}
+	for id := range parents {
+		parentMembers, err := ListGitlabGroupMembers(clt, id)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, m := range parentMembers {
+			if !seen[m.ID] {
+				seen[m.ID] = true
+				members = append(members, m)
+			}
+		}
+	}
+	return members, parents, nil
+}
+
+// inGitlabNamespaces reports whether the group lives in one of the top-level groups matching the
+// GITLAB_NAMESPACES patterns and none of the GITLAB_NAMESPACES_EXCLUDE patterns. Always true when no patterns are set.
+func inGitlabNamespaces(fullPath string) bool {
+	include := viper.GetStringSlice("GITLAB_NAMESPACES")
+	if len(include) == 0 {
+		return true
+	}
+	top := strings.SplitN(fullPath, "/", 2)[0]
+	for _, pattern := range viper.GetStringSlice("GITLAB_NAMESPACES_EXCLUDE") {
+		if ok, _ := path.Match(pattern, top); ok {
+			return false
+		}
+	}
+	for _, pattern := range include {
+		if ok, _ := path.Match(pattern, top); ok {
+			return true
+		}
+	}
+	return false
+}
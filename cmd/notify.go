@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Notification is a message sent to a person or a channel.
+type Notification struct {
+	Subject string
+	Text    string
+	// HTML is an optional rich version of the text, sent along with it by email and ignored by the other notifiers
+	HTML string
+}
+
+// Notifier delivers notifications. The recipient format depends on the implementation.
+type Notifier interface {
+	Send(recipient string, n Notification) error
+}
+
+// NewNotifier returns the notifier of the given kind, "slack", "teams" or "email".
+func NewNotifier(kind string) (Notifier, error) {
+	switch kind {
+	case "slack":
+		token, err := credential(nil, "SLACK_SECRET")
+		if err != nil {
+			return nil, err
+		}
+		return &SlackNotifier{Token: string(token), Client: http.DefaultClient}, nil
+	case "teams":
+		return &TeamsNotifier{Client: http.DefaultClient}, nil
+	case "email":
+		n := &EmailNotifier{
+			Host:     viper.GetString("SMTP_HOST"),
+			Port:     viper.GetInt("SMTP_PORT"),
+			From:     viper.GetString("SMTP_FROM"),
+			Username: viper.GetString("SMTP_USERNAME"),
+		}
+		if hasCredential("SMTP_PASSWORD_SECRET") {
+			password, err := credential(nil, "SMTP_PASSWORD_SECRET")
+			if err != nil {
+				return nil, err
+			}
+			n.Password = string(password)
+		}
+		return n, nil
+	}
+	return nil, fmt.Errorf("unknown notifier %q", kind)
+}
+
+// SlackNotifier posts Slack messages with a bot token.
+// Recipients starting with # are channels, any other recipient is the email of a user who gets a direct message.
+type SlackNotifier struct {
+	Token  string
+	Client *http.Client
+}
+
+// slackResponse holds the fields of the Slack API responses psync needs
+type slackResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error"`
+	User  struct {
+		ID string `json:"id"`
+	} `json:"user"`
+}
+
+// Send posts the notification to the channel or as a direct message to the user.
+func (s *SlackNotifier) Send(recipient string, n Notification) error {
+	channel := recipient
+	if !strings.HasPrefix(recipient, "#") {
+		user, err := s.call(http.MethodGet, "users.lookupByEmail?email="+url.QueryEscape(recipient), nil)
+		if err != nil {
+			return err
+		}
+		channel = user.User.ID
+	}
+	text := n.Text
+	if n.Subject != "" {
+		text = fmt.Sprintf("*%s*\n%s", n.Subject, n.Text)
+	}
+	_, err := s.call(http.MethodPost, "chat.postMessage", map[string]string{"channel": channel, "text": text})
+	return err
+}
+
+// call invokes a Slack Web API method.
+func (s *SlackNotifier) call(method, path string, body interface{}) (*slackResponse, error) {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequest(method, "https://slack.com/api/"+path, &buf)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.Token)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	res := new(slackResponse)
+	if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+		return nil, err
+	}
+	if !res.OK {
+		return nil, fmt.Errorf("slack %s: %s", strings.SplitN(path, "?", 2)[0], res.Error)
+	}
+	return res, nil
+}
+
+// EmailNotifier sends plain text emails through an SMTP server.
+type EmailNotifier struct {
+	Host     string
+	Port     int
+	From     string
+	Username string
+	Password string
+}
+
+// Send emails the notification to the recipient address, or to each address of a comma-separated list.
+// Notifications with HTML are sent as multipart/alternative, so that mail clients pick the version they show.
+func (e *EmailNotifier) Send(recipient string, n Notification) error {
+	to := make([]string, 0, 1)
+	for _, addr := range strings.Split(recipient, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			to = append(to, addr)
+		}
+	}
+	if len(to) == 0 {
+		return errors.New("no email recipient")
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\n",
+		e.From, strings.Join(to, ", "), mime.QEncoding.Encode("utf-8", n.Subject))
+	if n.HTML == "" {
+		fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n", n.Text)
+	} else {
+		parts := multipart.NewWriter(&msg)
+		fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", parts.Boundary())
+		for _, part := range []struct{ contentType, body string }{{"text/plain", n.Text}, {"text/html", n.HTML}} {
+			w, err := parts.CreatePart(textproto.MIMEHeader{
+				"Content-Type":              {part.contentType + "; charset=utf-8"},
+				"Content-Transfer-Encoding": {"quoted-printable"},
+			})
+			if err != nil {
+				return err
+			}
+			qp := quotedprintable.NewWriter(w)
+			if _, err := qp.Write([]byte(part.body)); err != nil {
+				return err
+			}
+			if err := qp.Close(); err != nil {
+				return err
+			}
+		}
+		if err := parts.Close(); err != nil {
+			return err
+		}
+	}
+	var auth smtp.Auth
+	if e.Username != "" {
+		auth = smtp.PlainAuth("", e.Username, e.Password, e.Host)
+	}
+	return smtp.SendMail(fmt.Sprintf("%s:%d", e.Host, e.Port), auth, e.From, to, msg.Bytes())
+}
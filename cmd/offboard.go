@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+var (
+	offboardParent bool
+	offboardPace   time.Duration
+)
+
+// offboardCmd removes one user from all managed Gitlab groups
+var offboardCmd = &cobra.Command{
+	Use:   "offboard <okta user id | email>",
+	Short: "Remove a user from all managed Gitlab groups",
+	Long: `Remove the user from every Gitlab group mapped to an Okta group, and from the parent groups with --parent:
+the GITLAB_PARENT_GROUP group, or the top-level groups matching GITLAB_NAMESPACES.
+Removals are paced and retried with backoff. Prints an offboarding report and fails if any removal failed.
+The removals are recorded in the audit trail, see psync history.
+Intended for urgent terminations, without waiting for the user to be deprovisioned in Okta.`,
+	Args: cobra.ExactArgs(1),
+	Example: `  # Remove a user from all mapped groups
+  psync offboard jane.doe@example.com
+
+  # Remove them from the parent group too, one removal every 5 seconds
+  psync offboard 00u1abcd2EFGH3ijk4l5 --parent --pace 5s`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, client, gitlabClt, err := NewClients()
+		cobra.CheckErr(err)
+
+		oktaUser, resp, err := client.User.GetUser(ctx, args[0])
+		oktaRateLimit.Observe(resp)
+		cobra.CheckErr(err)
+
+		// Find the Gitlab user through their SAML identity in the parent groups
+		parentMembers, parents, err := ParentGroupMembers(gitlabClt)
+		cobra.CheckErr(err)
+		var user *gitlab.GroupMember
+		for _, m := range parentMembers {
+			if m.GroupSAMLIdentity != nil && m.GroupSAMLIdentity.ExternUID == oktaUser.Id {
+				user = m
+			}
+		}
+		if user == nil {
+			cobra.CheckErr(fmt.Errorf("no parent group member with the SAML identity of Okta user %s", oktaUser.Id))
+		}
+		if isProtectedMember(user) {
+			cobra.CheckErr(fmt.Errorf("%s is %s of a parent group, at or above PROTECTED_ACCESS_LEVEL, offboard them by hand",
+				user.Username, accessLevelName(user.AccessLevel)))
+		}
+
+		state, err := LoadState()
+		cobra.CheckErr(err)
+		if len(state.Groups) == 0 {
+			cobra.CheckErr(errors.New("no managed groups in the state, run a sync first"))
+		}
+		groups := make(map[int]string, len(state.Groups)+1)
+		for _, m := range state.Groups {
+			groups[m.GitlabID] = m.OktaName
+		}
+		if offboardParent {
+			for id, name := range parents {
+				groups[id] = name
+			}
+		}
+		gids := make([]int, 0, len(groups))
+		for id := range groups {
+			gids = append(gids, id)
+		}
+		sort.Ints(gids)
+
+		fmt.Printf("Offboarding %s (Okta %s, Gitlab %d) from %d groups\n", user.Username, oktaUser.Id, user.ID, len(gids))
+		report := make([]string, 0, len(gids))
+		records := make([]AuditRecord, 0, len(gids))
+		runID := ids.NewID()
+		failed := 0
+		for _, gid := range gids {
+			result := offboardGroup(gitlabClt, gid, user.ID)
+			switch result {
+			case "removed":
+				records = append(records, AuditRecord{Time: clock.Now(), RunID: runID, Action: "offboarded",
+					UserID: oktaUser.Id, Username: user.Username, Group: groups[gid], GitlabID: gid})
+			case "not a member":
+			default:
+				failed++
+			}
+			report = append(report, fmt.Sprintf("%s (%d): %s", groups[gid], gid, result))
+			clock.Sleep(offboardPace)
+		}
+
+		cobra.CheckErr(NewAuditTrail().Append(records))
+		fmt.Println("Offboarding report:")
+		for _, line := range report {
+			fmt.Printf("  %s\n", line)
+		}
+		if failed > 0 {
+			cobra.CheckErr(fmt.Errorf("%d removals failed", failed))
+		}
+	},
+}
+
+// offboardGroup removes the user from the Gitlab group and describes the outcome.
+func offboardGroup(clt *gitlab.Client, gid, uid int) string {
+	var notMember bool
+	err := retryWithBackoff(5, time.Second, func() (*gitlab.Response, error) {
+		resp, err := clt.GroupMembers.RemoveGroupMember(gid, uid, nil)
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			notMember = true
+			return resp, nil
+		}
+		return resp, err
+	})
+	switch {
+	case err != nil:
+		return "failed: " + err.Error()
+	case notMember:
+		return "not a member"
+	}
+	return "removed"
+}
+
+func init() {
+	offboardCmd.Flags().BoolVar(&offboardParent, "parent", false, "remove the user from the parent groups too")
+	offboardCmd.Flags().DurationVar(&offboardPace, "pace", time.Second, "pause between two removals")
+	rootCmd.AddCommand(offboardCmd)
+}
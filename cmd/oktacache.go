@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/okta/okta-sdk-golang/v2/okta"
+	"github.com/okta/okta-sdk-golang/v2/okta/query"
+	"github.com/spf13/viper"
+)
+
+// OktaMembersCache keeps the users of the Okta groups listed by the previous runs, in the state.
+type OktaMembersCache struct {
+	// Checked is when the Okta users were last checked for changes
+	Checked time.Time `json:"checked"`
+	// Groups are the cached members by Okta group ID
+	Groups map[string]CachedOktaMembers `json:"groups,omitempty"`
+}
+
+// CachedOktaMembers are the users of an Okta group when its membership was last updated.
+type CachedOktaMembers struct {
+	MembershipUpdated time.Time `json:"membership_updated"`
+	Listed            time.Time `json:"listed"`
+	Users             []string  `json:"users"`
+	Deprovisioned     []string  `json:"deprovisioned"`
+	// Profiles are the profile attributes of the users psync reads, see oktaProfileAttributes
+	Profiles map[string]map[string]interface{} `json:"profiles,omitempty"`
+}
+
+// oktaMembers serves the users of the Okta groups from the state during a run, nil when OKTA_MEMBERSHIP_CACHE is off
+var oktaMembers *oktaMembersRun
+
+// oktaMembersRun is the membership cache of a run.
+type oktaMembersRun struct {
+	state *State
+	// changed are the users updated since the cache was last checked, nil on the first run
+	changed map[string]bool
+	// profiles are the profile attributes of the users listed during the run
+	profiles map[string]map[string]interface{}
+}
+
+// startOktaMembersCache sets up the membership cache of the run when OKTA_MEMBERSHIP_CACHE is set.
+// The lastMembershipUpdated of a group doesn't change when one of its users is deactivated or their profile
+// changes, so the users updated since the previous run are listed in one call, and the groups they are
+// members of are listed again. The groups are also listed again after OKTA_MEMBERSHIP_CACHE_MAX_AGE.
+func startOktaMembersCache(ctx context.Context, ctl *okta.Client, state *State) error {
+	oktaMembers = nil
+	if !viper.GetBool("OKTA_MEMBERSHIP_CACHE") {
+		return nil
+	}
+	now := clock.Now()
+	state.mu.Lock()
+	if state.OktaMembers == nil {
+		state.OktaMembers = &OktaMembersCache{}
+	}
+	cache := state.OktaMembers
+	// Groups gone from Okta, or not synced anymore, are dropped once they would be listed again anyway
+	for id, cached := range cache.Groups {
+		if now.Sub(cached.Listed) > viper.GetDuration("OKTA_MEMBERSHIP_CACHE_MAX_AGE") {
+			delete(cache.Groups, id)
+		}
+	}
+	state.mu.Unlock()
+	run := &oktaMembersRun{state: state, profiles: map[string]map[string]interface{}{}}
+	if !cache.Checked.IsZero() {
+		users, err := listOktaUsers(ctx, ctl, &query.Params{
+			Filter: fmt.Sprintf("lastUpdated gt %q", cache.Checked.UTC().Format("2006-01-02T15:04:05.000Z")),
+			Limit:  200,
+		})
+		if err != nil {
+			return fmt.Errorf("listing the Okta users updated since %s: %w", cache.Checked.Format(time.RFC3339), err)
+		}
+		run.changed = make(map[string]bool, len(users))
+		for _, u := range users {
+			run.changed[u.Id] = true
+		}
+	}
+	state.mu.Lock()
+	cache.Checked = now
+	state.mu.Unlock()
+	oktaMembers = run
+	return nil
+}
+
+// oktaProfileAttributes are the profile attributes psync reads, kept with the cached members.
+func oktaProfileAttributes() []string {
+	attrs := make([]string, 0, 4)
+	for _, key := range []string{"ACCESS_LEVEL_ATTRIBUTE", "USER_TYPE_ATTRIBUTE", "READ_ONLY_ATTRIBUTE", "GITHUB_LOGIN_ATTRIBUTE"} {
+		if attr := viper.GetString(key); attr != "" {
+			attrs = append(attrs, attr)
+		}
+	}
+	return attrs
+}
+
+// observe keeps the profile attributes of a listed user, to cache them with the members of the group.
+func (c *oktaMembersRun) observe(id string, profile map[string]interface{}) {
+	if c == nil {
+		return
+	}
+	kept := map[string]interface{}{}
+	for _, attr := range oktaProfileAttributes() {
+		if v, ok := profile[attr]; ok {
+			kept[attr] = v
+		}
+	}
+	runMu.Lock()
+	c.profiles[id] = kept
+	runMu.Unlock()
+}
+
+// listGroupUsers returns the users of the Okta group from the cache if its membership wasn't updated since,
+// none of its users changed, and the cache isn't older than OKTA_MEMBERSHIP_CACHE_MAX_AGE. It lists them otherwise.
+func (c *oktaMembersRun) listGroupUsers(ctx context.Context, ctl *okta.Client, g *okta.Group) (active, deprovisioned []string, err error) {
+	if c == nil || g.LastMembershipUpdated == nil {
+		return ListOktaGroupUsers(ctx, ctl, g.Id)
+	}
+	c.state.mu.Lock()
+	cached, ok := c.state.OktaMembers.Groups[g.Id]
+	c.state.mu.Unlock()
+	if ok && c.fresh(cached, *g.LastMembershipUpdated) {
+		for id, profile := range cached.Profiles {
+			recordOktaProfile(id, profile)
+		}
+		return append([]string{}, cached.Users...), append([]string{}, cached.Deprovisioned...), nil
+	}
+
+	if active, deprovisioned, err = ListOktaGroupUsers(ctx, ctl, g.Id); err != nil {
+		return nil, nil, err
+	}
+	cached = CachedOktaMembers{MembershipUpdated: *g.LastMembershipUpdated, Listed: clock.Now(), Users: active, Deprovisioned: deprovisioned,
+		Profiles: map[string]map[string]interface{}{}}
+	runMu.Lock()
+	for _, ids := range [][]string{active, deprovisioned} {
+		for _, id := range ids {
+			if p := c.profiles[id]; len(p) > 0 {
+				cached.Profiles[id] = p
+			}
+		}
+	}
+	runMu.Unlock()
+	c.state.mu.Lock()
+	if c.state.OktaMembers.Groups == nil {
+		c.state.OktaMembers.Groups = map[string]CachedOktaMembers{}
+	}
+	c.state.OktaMembers.Groups[g.Id] = cached
+	c.state.mu.Unlock()
+	return active, deprovisioned, nil
+}
+
+// fresh tells whether the cached members of a group can be used.
+func (c *oktaMembersRun) fresh(cached CachedOktaMembers, membershipUpdated time.Time) bool {
+	if c.changed == nil || !cached.MembershipUpdated.Equal(membershipUpdated) ||
+		clock.Now().Sub(cached.Listed) > viper.GetDuration("OKTA_MEMBERSHIP_CACHE_MAX_AGE") {
+		return false
+	}
+	for _, ids := range [][]string{cached.Users, cached.Deprovisioned} {
+		for _, id := range ids {
+			if c.changed[id] {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/okta/okta-sdk-golang/v2/okta"
+	"github.com/spf13/viper"
+)
+
+// checkOktaHealth verifies that the Okta token works and that its admin has a role, before anything else
+// is read from Okta, so that a bad token fails fast with an actionable message. Reports the admin and the roles.
+func checkOktaHealth(ctx context.Context, client *okta.Client) error {
+	me, resp, err := client.User.GetUser(ctx, "me")
+	oktaRateLimit.Observe(resp)
+	if err != nil {
+		switch {
+		case resp != nil && resp.StatusCode == http.StatusUnauthorized:
+			return fmt.Errorf("Okta rejected the OKTA_SECRET token at %s, it is invalid, expired or revoked: "+
+				"add a new API token as a new secret version and run psync rotate-check: %w", viper.GetString("OKTA_ORG_URL"), err)
+		case resp != nil && resp.StatusCode == http.StatusForbidden:
+			return fmt.Errorf("the OKTA_SECRET token may not read its own user, check that it is an API token of an admin: %w", err)
+		}
+		return fmt.Errorf("checking the OKTA_SECRET token: %w", err)
+	}
+	login := me.Id
+	if me.Profile != nil {
+		if l, ok := (*me.Profile)["login"].(string); ok {
+			login = l
+		}
+	}
+
+	roles, resp, err := client.User.ListAssignedRolesForUser(ctx, me.Id, nil)
+	oktaRateLimit.Observe(resp)
+	if resp != nil && resp.StatusCode == http.StatusForbidden {
+		// Only super admins may list roles, the token works and the sync finds out about missing rights itself
+		logger.Info("Okta token roles not readable", "login", login)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("listing the roles of the OKTA_SECRET token: %w", err)
+	}
+	if len(roles) == 0 {
+		return errors.New("the admin of the OKTA_SECRET token " + login + " has no admin role, " +
+			"psync needs to read the groups and users, e.g. with the READ_ONLY_ADMIN role")
+	}
+	names := make([]string, 0, len(roles))
+	for _, r := range roles {
+		names = append(names, r.Type)
+	}
+	logger.Info("Okta token roles", "login", login, "roles", strings.Join(names, ", "))
+	return nil
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/okta/okta-sdk-golang/v2/okta"
+	"github.com/okta/okta-sdk-golang/v2/okta/query"
+)
+
+// The Okta list calls return one page of results, with the link to the next page in the response.
+// These helpers follow the links until the last page, slowing down when the shared rate limit runs low.
+
+// listOktaGroups lists all the Okta groups matching the query.
+func listOktaGroups(ctx context.Context, ctl *okta.Client, qp *query.Params) ([]*okta.Group, error) {
+	oktaRateLimit.Throttle()
+	groups, resp, err := ctl.Group.ListGroups(ctx, qp)
+	oktaRateLimit.Observe(resp)
+	for err == nil && resp.HasNextPage() {
+		var page []*okta.Group
+		oktaRateLimit.Throttle()
+		resp, err = resp.Next(ctx, &page)
+		oktaRateLimit.Observe(resp)
+		groups = append(groups, page...)
+	}
+	return groups, err
+}
+
+// listOktaGroupUsers lists all the users of the Okta group.
+func listOktaGroupUsers(ctx context.Context, ctl *okta.Client, id string) ([]*okta.User, error) {
+	oktaRateLimit.Throttle()
+	users, resp, err := ctl.Group.ListGroupUsers(ctx, id, nil)
+	oktaRateLimit.Observe(resp)
+	for err == nil && resp.HasNextPage() {
+		var page []*okta.User
+		oktaRateLimit.Throttle()
+		resp, err = resp.Next(ctx, &page)
+		oktaRateLimit.Observe(resp)
+		users = append(users, page...)
+	}
+	return users, err
+}
+
+// listOktaUsers lists all the Okta users matching the query.
+func listOktaUsers(ctx context.Context, ctl *okta.Client, qp *query.Params) ([]*okta.User, error) {
+	oktaRateLimit.Throttle()
+	users, resp, err := ctl.User.ListUsers(ctx, qp)
+	oktaRateLimit.Observe(resp)
+	for err == nil && resp.HasNextPage() {
+		var page []*okta.User
+		oktaRateLimit.Throttle()
+		resp, err = resp.Next(ctx, &page)
+		oktaRateLimit.Observe(resp)
+		users = append(users, page...)
+	}
+	return users, err
+}
+
+// listOktaGroupRules lists all the Okta group rules.
+func listOktaGroupRules(ctx context.Context, ctl *okta.Client) ([]*okta.GroupRule, error) {
+	oktaRateLimit.Throttle()
+	rules, resp, err := ctl.Group.ListGroupRules(ctx, nil)
+	oktaRateLimit.Observe(resp)
+	for err == nil && resp.HasNextPage() {
+		var page []*okta.GroupRule
+		oktaRateLimit.Throttle()
+		resp, err = resp.Next(ctx, &page)
+		oktaRateLimit.Observe(resp)
+		rules = append(rules, page...)
+	}
+	return rules, err
+}
+
+// listOktaUserGroups lists all the Okta groups of the user.
+func listOktaUserGroups(ctx context.Context, ctl *okta.Client, userID string) ([]*okta.Group, error) {
+	oktaRateLimit.Throttle()
+	groups, resp, err := ctl.User.ListUserGroups(ctx, userID)
+	oktaRateLimit.Observe(resp)
+	for err == nil && resp.HasNextPage() {
+		var page []*okta.Group
+		oktaRateLimit.Throttle()
+		resp, err = resp.Next(ctx, &page)
+		oktaRateLimit.Observe(resp)
+		groups = append(groups, page...)
+	}
+	return groups, err
+}
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"text/template"
+
+	"github.com/spf13/viper"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// OnboardingConfig describes how a Gitlab group is seeded when psync adopts it for a new Okta team.
+// All string values are Go templates executed with OnboardingData.
+type OnboardingConfig struct {
+	Labels []struct {
+		Name        string
+		Color       string
+		Description string
+	}
+	// ReadmeProject is the name of the project created in the group with a README
+	ReadmeProject string `mapstructure:"readme_project"`
+	Readme        string
+	// Settings are passed to the Gitlab update group API as they are
+	Settings map[string]interface{}
+}
+
+// OnboardingData is the data available to the onboarding templates.
+type OnboardingData struct {
+	Team          string
+	OktaGroupID   string
+	GitlabGroupID int
+}
+
+// OnboardGitlabGroup runs the onboarding hook configured under ONBOARDING for the Gitlab group.
+// Does nothing if onboarding is not configured. Every step can be rerun safely.
+func OnboardGitlabGroup(clt *gitlab.Client, g OktaGroup, id int) error {
+	if !viper.IsSet("ONBOARDING") {
+		return nil
+	}
+	var cfg OnboardingConfig
+	if err := viper.UnmarshalKey("ONBOARDING", &cfg); err != nil {
+		return err
+	}
+	data := OnboardingData{Team: g.Name, OktaGroupID: g.ID, GitlabGroupID: id}
+
+	for _, l := range cfg.Labels {
+		var rendered [3]string
+		for i, tpl := range []string{l.Name, l.Color, l.Description} {
+			v, err := executeTemplate(tpl, data)
+			if err != nil {
+				return err
+			}
+			rendered[i] = v
+		}
+		opts := &gitlab.CreateGroupLabelOptions{Name: &rendered[0], Color: &rendered[1], Description: &rendered[2]}
+		_, resp, err := clt.GroupLabels.CreateGroupLabel(id, opts)
+		// The label exists already if onboarding was interrupted before
+		if err != nil && (resp == nil || resp.StatusCode != http.StatusConflict) {
+			return fmt.Errorf("creating label %s: %w", *opts.Name, err)
+		}
+	}
+
+	if cfg.ReadmeProject != "" {
+		if err := createReadmeProject(clt, id, cfg, data); err != nil {
+			return err
+		}
+	}
+
+	if len(cfg.Settings) > 0 {
+		settings := make(map[string]interface{}, len(cfg.Settings))
+		for k, v := range cfg.Settings {
+			if tpl, ok := v.(string); ok {
+				s, err := executeTemplate(tpl, data)
+				if err != nil {
+					return err
+				}
+				v = s
+			}
+			settings[k] = v
+		}
+		req, err := clt.NewRequest(http.MethodPut, fmt.Sprintf("groups/%d", id), settings, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := clt.Do(req, nil); err != nil {
+			return fmt.Errorf("updating group settings: %w", err)
+		}
+	}
+	logger.Info("Onboarded the Gitlab group", "group", g.Name, "gitlab_id", id)
+	return nil
+}
+
+// createReadmeProject creates the README project in the group, unless it exists already.
+func createReadmeProject(clt *gitlab.Client, id int, cfg OnboardingConfig, data OnboardingData) error {
+	name, err := executeTemplate(cfg.ReadmeProject, data)
+	if err != nil {
+		return err
+	}
+	projects, _, err := clt.Groups.ListGroupProjects(id, &gitlab.ListGroupProjectsOptions{Search: &name})
+	if err != nil {
+		return err
+	}
+	for _, p := range projects {
+		if p.Name == name {
+			return nil
+		}
+	}
+	project, _, err := clt.Projects.CreateProject(&gitlab.CreateProjectOptions{
+		Name:                 &name,
+		NamespaceID:          &id,
+		InitializeWithReadme: gitlab.Bool(cfg.Readme == ""),
+	})
+	if err != nil {
+		return fmt.Errorf("creating project %s: %w", name, err)
+	}
+	if cfg.Readme == "" {
+		return nil
+	}
+	readme, err := executeTemplate(cfg.Readme, data)
+	if err != nil {
+		return err
+	}
+	_, _, err = clt.RepositoryFiles.CreateFile(project.ID, "README.md", &gitlab.CreateFileOptions{
+		Branch:        gitlab.String("main"),
+		Content:       &readme,
+		CommitMessage: gitlab.String("Add README"),
+	})
+	return err
+}
+
+// executeTemplate renders the template string with the given data.
+func executeTemplate(tpl string, data interface{}) (string, error) {
+	t, err := template.New("").Parse(tpl)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
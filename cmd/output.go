@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Output formats
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+)
+
+// OutputRecord is one action or outcome of a command in the JSON output: the events of a run (added, removed,
+// skipped, failed...), its warnings and alerts and its summary, or the changes of a plan (add, remove, skip, conflict).
+type OutputRecord struct {
+	Type        string      `json:"type"`
+	Time        time.Time   `json:"time"`
+	RunID       string      `json:"run_id,omitempty"`
+	Group       string      `json:"group,omitempty"`
+	User        string      `json:"user,omitempty"`
+	AccessLevel string      `json:"access_level,omitempty"`
+	Message     string      `json:"message,omitempty"`
+	Error       string      `json:"error,omitempty"`
+	Summary     *RunSummary `json:"summary,omitempty"`
+}
+
+var (
+	// outputMu serializes the records, written from the concurrent changes of a run
+	outputMu sync.Mutex
+	// outputRecords writes the JSON records, nil with the text output
+	outputRecords *json.Encoder
+)
+
+// setupOutput configures the OUTPUT format. With json, stdout only receives one JSON record per line,
+// and the messages meant for people go to stderr.
+func setupOutput(format string) error {
+	switch format {
+	case OutputText:
+		return nil
+	case OutputJSON:
+		if outputRecords != nil {
+			return nil
+		}
+		outputRecords = json.NewEncoder(os.Stdout)
+		os.Stdout = os.Stderr
+		return nil
+	default:
+		return fmt.Errorf("unknown output format %q, expected text or json", format)
+	}
+}
+
+// jsonOutput tells whether the records are written as JSON.
+func jsonOutput() bool {
+	return outputRecords != nil
+}
+
+// writeRecord writes the record with the JSON output, and does nothing with the text output.
+func writeRecord(r OutputRecord) {
+	if outputRecords == nil {
+		return
+	}
+	r.Time = clock.Now()
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	if err := outputRecords.Encode(r); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not write the output record: %v\n", err)
+	}
+}
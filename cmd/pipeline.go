@@ -0,0 +1,256 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/okta/okta-sdk-golang/v2/okta"
+	"github.com/spf13/viper"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+
+	"psync/internal/engine"
+)
+
+// Run holds what the stages of one sync share and report.
+type Run struct {
+	ID      string
+	State   *State
+	Summary *RunSummary
+	// Target is the target of the run, whose capabilities the policies check
+	Target Target
+	// Groups are the Okta groups after the transforms
+	Groups []OktaGroup
+	// Skipped are the groups that cannot be modified
+	Skipped []string
+	// Conflicts are membership conflicts that need to be resolved by an administrator
+	Conflicts []string
+	// Changes describe the membership changes applied
+	Changes []string
+	// ExternalDrift describe the membership changes made in Gitlab outside of psync since the last run
+	ExternalDrift []string
+	// Audit are the records of the changes applied, for the audit trail
+	Audit []AuditRecord
+	// groupChanges are the Changes by Okta group, announced to the channels in GROUP_NOTIFICATIONS
+	groupChanges map[string][]string
+
+	// mu guards the reports of the run
+	mu sync.Mutex
+}
+
+// report appends a line to one of the reports of the run.
+func (run *Run) report(lines *[]string, format string, a ...interface{}) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	*lines = append(*lines, fmt.Sprintf(format, a...))
+}
+
+// Source produces the Okta groups and their members.
+type Source = engine.Source[*Run, OktaGroup]
+
+// Transform rewrites the groups produced by the source, e.g. to normalize names or enrich them.
+type Transform = engine.Transform[*Run, OktaGroup]
+
+// SyncTarget plans the membership changes of the groups and applies them.
+type SyncTarget interface {
+	Target
+	Plan(run *Run, groups []OktaGroup) ([]GroupSync, error)
+	Apply(run *Run, syncs []GroupSync) error
+}
+
+// Policy filters the planned changes before they are applied. Returning an error aborts the run.
+type Policy = engine.Policy[*Run, GroupSync]
+
+// Transforms and policies that can be enabled, in order, with PIPELINE_TRANSFORMS and PIPELINE_POLICIES
+var (
+	transforms = map[string]Transform{
+		"normalize_names": normalizeNames,
+	}
+	policies = map[string]Policy{
+		"jit":         jitPolicy,
+		"freeze":      freezePolicy,
+		"multi_group": multiGroupPolicy,
+		"empty_group": emptyGroupPolicy,
+		"strict":      strictPolicy,
+		"recert":      recertPolicy,
+	}
+)
+
+// configuredStages returns the transforms and policies configured in PIPELINE_TRANSFORMS and PIPELINE_POLICIES.
+func configuredStages() ([]Transform, []Policy, error) {
+	ts, err := namedTransforms(viper.GetStringSlice("PIPELINE_TRANSFORMS"))
+	if err != nil {
+		return nil, nil, err
+	}
+	ps, err := namedPolicies(viper.GetStringSlice("PIPELINE_POLICIES"))
+	return ts, ps, err
+}
+
+// namedTransforms returns the transforms of the names.
+func namedTransforms(names []string) ([]Transform, error) {
+	ts := make([]Transform, 0, len(names))
+	for _, name := range names {
+		t, ok := transforms[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown transform %q", name)
+		}
+		ts = append(ts, t)
+	}
+	return ts, nil
+}
+
+// namedPolicies returns the policies of the names.
+func namedPolicies(names []string) ([]Policy, error) {
+	ps := make([]Policy, 0, len(names))
+	for _, name := range names {
+		policy, ok := policies[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown policy %q", name)
+		}
+		ps = append(ps, policy)
+	}
+	return ps, nil
+}
+
+// emit publishes a progress event of the run.
+func (run *Run) emit(typ, group, user string, err error) {
+	e := ProgressEvent{
+		RunID: run.ID,
+		Type:  typ,
+		Group: group,
+		User:  user,
+		Done:  run.Summary.Added + run.Summary.Removed,
+		Total: run.Summary.Drift,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if typ == EventFailed {
+		metricErrors.Add(1)
+	}
+	progress.Publish(e)
+	writeRecord(OutputRecord{Type: typ, RunID: run.ID, Group: group, User: user, Error: e.Error})
+}
+
+// OktaSource reads the dev_ groups from Okta, or the groups of the MAPPINGS_FILE.
+type OktaSource struct {
+	Ctx    context.Context
+	Client *okta.Client
+	// Mappings are the groups to sync, nil to sync the dev_ groups
+	Mappings []MappingRule
+}
+
+// NewOktaSource reads the groups of the client, loading and validating the MAPPINGS_FILE if set.
+func NewOktaSource(ctx context.Context, client *okta.Client) (*OktaSource, error) {
+	mappings, err := LoadMappingRules()
+	if err != nil {
+		return nil, err
+	}
+	return &OktaSource{Ctx: ctx, Client: client, Mappings: mappings}, nil
+}
+
+// Groups fetches the group members of the mapped Okta groups, or of the Okta groups that start with dev_.
+func (s *OktaSource) Groups(run *Run) ([]OktaGroup, error) {
+	oktaRateLimit.Threshold = viper.GetInt("OKTA_RATE_LIMIT_THRESHOLD")
+	if err := startOktaMembersCache(s.Ctx, s.Client, run.State); err != nil {
+		return nil, err
+	}
+	var groups []OktaGroup
+	var err error
+	if s.Mappings != nil {
+		groups, err = GetOktaMappedGroups(s.Ctx, s.Client, s.Mappings)
+	} else {
+		groups, err = GetOktaDevGroups(s.Ctx, s.Client)
+	}
+	logger.Info("Okta rate limit", "rate_limit", oktaRateLimit.String())
+	return groups, err
+}
+
+// UserEmail returns the email in the Okta profile of the user.
+func (s *OktaSource) UserEmail(userID string) (string, error) {
+	user, resp, err := s.Client.User.GetUser(s.Ctx, userID)
+	oktaRateLimit.Observe(resp)
+	if err != nil {
+		return "", err
+	}
+	email, _ := (*user.Profile)["email"].(string)
+	return email, nil
+}
+
+// normalizeNames lowercases the group names and replaces spaces, so they match Gitlab group paths.
+func normalizeNames(run *Run, groups []OktaGroup) ([]OktaGroup, error) {
+	for i := range groups {
+		groups[i].Name = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(groups[i].Name)), " ", "-")
+	}
+	return groups, nil
+}
+
+// multiGroupPolicy reports users in many groups, and doesn't add them anywhere or approve their access requests
+// until they are reviewed when MULTI_GROUP_ACTION is block.
+func multiGroupPolicy(run *Run, syncs []GroupSync) ([]GroupSync, error) {
+	threshold := viper.GetInt("MULTI_GROUP_THRESHOLD")
+	action := viper.GetString("MULTI_GROUP_ACTION")
+	if action != MultiGroupFlag && action != MultiGroupBlock {
+		return nil, fmt.Errorf("unknown MULTI_GROUP_ACTION %q, expected %s or %s", action, MultiGroupFlag, MultiGroupBlock)
+	}
+	users := FindMultiGroupUsers(run.Groups, threshold, viper.GetStringSlice("MULTI_GROUP_REVIEWED"))
+	printMultiGroupUsers(users, threshold, action)
+	if action != MultiGroupBlock || len(users) == 0 {
+		return syncs, nil
+	}
+	for i := range syncs {
+		plan := &syncs[i].Plan
+		add := make([]string, 0, len(plan.Add))
+		for _, u := range plan.Add {
+			if _, ok := users[u]; ok {
+				logger.Info("Not adding the user until their group memberships are reviewed", "group", syncs[i].Group.Name, "user", u)
+				continue
+			}
+			add = append(add, u)
+		}
+		plan.Add = add
+		// The access requests of the users stay pending until the review too
+		approve := make([]*gitlab.AccessRequest, 0, len(plan.Approve))
+		for _, r := range plan.Approve {
+			if _, ok := users[plan.Requesters[r.ID]]; ok {
+				logger.Info("Not approving the access request until the group memberships are reviewed", "group", syncs[i].Group.Name, "username", r.Username)
+				continue
+			}
+			approve = append(approve, r)
+		}
+		plan.Approve = approve
+	}
+	return syncs, nil
+}
+
+// emptyGroupPolicy raises an alert for mapped Okta groups without active members and skips their removals,
+// unless EMPTY_GROUP_REMOVALS is set. An empty Okta group is often a deleted and re-created group
+// or a broken rule rather than a dissolved team. The Gitlab groups emptied by the removals get the EMPTY_GROUP_ACTION.
+// Just-in-time groups are empty between incidents, their removals always go through.
+func emptyGroupPolicy(run *Run, syncs []GroupSync) ([]GroupSync, error) {
+	for i := range syncs {
+		gs := &syncs[i]
+		// A group whose users are all routed to other groups by type still has active members
+		if gs.Adopted || len(gs.Group.Users) > 0 || gs.Routed > 0 || isJITGroup(gs.Group) {
+			continue
+		}
+		if gs.Tier == "" {
+			raiseAlert("Okta group %s has no active members", gs.Group.Name)
+		}
+		if !viper.GetBool("EMPTY_GROUP_REMOVALS") && len(gs.Plan.Remove) > 0 {
+			logger.Warn("Skipping the removals because the Okta group is empty", "group", gs.Group.Name, "removals", len(gs.Plan.Remove))
+			gs.Plan.Remove = nil
+		}
+	}
+	return syncs, nil
+}
+
+// strictPolicy aborts the run before any change when data-quality warnings were raised and STRICT is set.
+// In strict mode no sync is preferred over a sync based on possibly incomplete data.
+func strictPolicy(run *Run, syncs []GroupSync) ([]GroupSync, error) {
+	if viper.GetBool("STRICT") && len(dataWarnings) > 0 {
+		return nil, fmt.Errorf("strict mode: aborting before any change because of %d data-quality warnings", len(dataWarnings))
+	}
+	return syncs, nil
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+func TestMultiGroupPolicyBlock(t *testing.T) {
+	viper.Set("MULTI_GROUP_THRESHOLD", 1)
+	viper.Set("MULTI_GROUP_ACTION", MultiGroupBlock)
+	t.Cleanup(viper.Reset)
+
+	// anna is in both groups, bert in one
+	run := &Run{Groups: []OktaGroup{
+		{ID: "00ga", Name: "a", Users: []string{"anna", "bert"}},
+		{ID: "00gb", Name: "b", Users: []string{"anna"}},
+	}}
+	annaRequest := &gitlab.AccessRequest{ID: 1, Username: "anna"}
+	bertRequest := &gitlab.AccessRequest{ID: 2, Username: "bert"}
+	syncs := []GroupSync{
+		{Group: run.Groups[0], Plan: GroupPlan{
+			Approve:    []*gitlab.AccessRequest{annaRequest, bertRequest},
+			Requesters: map[int]string{1: "anna", 2: "bert"},
+		}},
+		{Group: run.Groups[1], Plan: GroupPlan{Add: []string{"anna"}}},
+	}
+	syncs, err := multiGroupPolicy(run, syncs)
+	if err != nil {
+		t.Fatalf("multiGroupPolicy() error = %v", err)
+	}
+	if want := []*gitlab.AccessRequest{bertRequest}; !reflect.DeepEqual(syncs[0].Plan.Approve, want) {
+		t.Errorf("approved %v, want only the request of bert", syncs[0].Plan.Approve)
+	}
+	if len(syncs[1].Plan.Add) != 0 {
+		t.Errorf("added %v, want no additions", syncs[1].Plan.Add)
+	}
+}
+
+func TestEmptyGroupPolicyKeepsLapsedJITRemovals(t *testing.T) {
+	quietLogger(t)
+	viper.Set("JIT_GROUPS", []string{"dev_oncall"})
+	t.Cleanup(viper.Reset)
+	now := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	saved := clock
+	clock = &ManualClock{T: now}
+	t.Cleanup(func() { clock = saved })
+
+	// anna left Okta after her grant lapsed, so the JIT group has no active members left
+	state := &State{Grants: []Grant{{OktaGroupID: "00goncall", GitlabID: 101, UserID: "00uanna", Expires: now.Add(-time.Minute)}}}
+	run := &Run{State: state, Target: fakeTarget{CapabilityExpiration}}
+	g := OktaGroup{ID: "00goncall", Name: "oncall", OktaName: "dev_oncall", Deprovisioned: []string{"00uanna"}}
+	members := []GitlabMember{{User: &gitlab.GroupMember{ID: 7, Username: "anna"}, SAMLID: "00uanna"}}
+	syncs := []GroupSync{{Group: g, GitlabID: 101, Members: members, Plan: PlanGroup(g, []string{"00uanna"}, members)}}
+	syncs, err := jitPolicy(run, syncs)
+	if err != nil {
+		t.Fatalf("jitPolicy() error = %v", err)
+	}
+	syncs, err = emptyGroupPolicy(run, syncs)
+	if err != nil {
+		t.Fatalf("emptyGroupPolicy() error = %v", err)
+	}
+	if !reflect.DeepEqual(syncs[0].Plan.Remove, []string{"00uanna"}) {
+		t.Errorf("remove %v, want the member whose grant lapsed", syncs[0].Plan.Remove)
+	}
+}
@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"time"
+
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+
+	"psync/internal/set"
+)
+
+// GroupPlan holds the membership changes computed for one Okta group and its Gitlab counterpart.
+// Users are identified by their SAML identity, which is the Okta user ID.
+type GroupPlan struct {
+	Add     []string
+	Remove  []string
+	Pending []string
+	// Conflicts are Gitlab members that are blocked in Gitlab while their Okta account is active
+	Conflicts []GitlabMember
+	// Pending Gitlab access requests to approve or deny
+	Approve []*gitlab.AccessRequest
+	Deny    []*gitlab.AccessRequest
+	// Requesters are the Okta user IDs of the access requests to approve, by Gitlab user ID
+	Requesters map[int]string
+	// Seats tells the seat impact of each addition when SEAT_IMPACT is set, by Okta user ID
+	Seats map[string]string
+}
+
+// GroupSync is the plan of one Okta group together with the Gitlab group it applies to.
+type GroupSync struct {
+	Group    OktaGroup
+	GitlabID int
+	// Adopted is set when the Gitlab group was mapped to the Okta group for the first time in this run
+	Adopted bool
+	Members []GitlabMember
+	Plan    GroupPlan
+	// Expires is when the memberships added to a just-in-time group lapse, zero for permanent memberships
+	Expires time.Time
+	// Tier is the Gitlab group of an additional GROUP_TARGETS tier, empty for the group the Okta group is mapped to
+	Tier string
+	// AccessLevel is the access level of the tier or of the mapping, zero to add members with their own access level
+	AccessLevel gitlab.AccessLevelValue
+	// Routed is the number of active users of the Okta group routed to other Gitlab groups by USER_TYPE_ROUTES
+	Routed int
+}
+
+// TierTarget is a Gitlab group the members of an Okta group are added to at a fixed access level,
+// e.g. as reporters of a shared infrastructure group, or the group contractors are routed to.
+// Group is a group name, or a full path such as payments/external.
+type TierTarget struct {
+	Group       string
+	AccessLevel string `mapstructure:"access_level"`
+}
+
+// GitlabIdentities maps the Gitlab user IDs of the afkl-mcp group members to their SAML identity.
+func GitlabIdentities(afklMembers []*gitlab.GroupMember) map[int]string {
+	identities := make(map[int]string, len(afklMembers))
+	for _, v := range afklMembers {
+		// Check if SAML identity is not nil. If it is, something went wrong when user was added to AFKL group
+		// Users without a SAML identity cannot be matched with Okta users (!)
+		if v.GroupSAMLIdentity != nil {
+			identities[v.ID] = v.GroupSAMLIdentity.ExternUID
+		}
+	}
+	return identities
+}
+
+// MatchGitlabMembers finds each Gitlab group member in the afkl-mcp group and extracts their identity.
+func MatchGitlabMembers(group, afklMembers []*gitlab.GroupMember) []GitlabMember {
+	identities := GitlabIdentities(afklMembers)
+	members := make([]GitlabMember, 0, len(group))
+	for _, glm := range group {
+		if uid, ok := identities[glm.ID]; ok {
+			members = append(members, GitlabMember{User: glm, SAMLID: uid, Protected: isProtectedMember(glm)})
+		}
+	}
+	return members
+}
+
+// PlanGroup computes the membership changes of the Gitlab group from the Okta group.
+// afklUids are the identities of the afkl-mcp members, members the matched members of the Gitlab group.
+func PlanGroup(g OktaGroup, afklUids []string, members []GitlabMember) (plan GroupPlan) {
+	present := make([]string, 0, len(members))
+	// Members awaiting approval are not present yet, but must not be invited again either
+	pending := make([]string, 0)
+	blocked := make(map[string]GitlabMember)
+	for _, m := range members {
+		switch m.User.State {
+		case gitlabMemberAwaiting:
+			pending = append(pending, m.SAMLID)
+			continue
+		case gitlabMemberBlocked:
+			blocked[m.SAMLID] = m
+		}
+		present = append(present, m.SAMLID)
+	}
+	plan.Pending = pending
+
+	// Blocked Gitlab users that are active in Okta need a human decision
+	for _, id := range g.Users {
+		if m, ok := blocked[id]; ok {
+			plan.Conflicts = append(plan.Conflicts, m)
+		}
+	}
+	// Identify Okta group members that are part of AFKL-MCP Gitlab group
+	oktaUsersInGitlab := set.Intersection(g.Users, afklUids)
+	// Find the members who are not assigned to the Gitlab developer group yet
+	plan.Add = set.Difference(set.Difference(oktaUsersInGitlab, present), pending)
+	// Find deprovisioned or suspended Okta group users who still have access to the Gitlab group
+	plan.Remove = set.Intersection(g.Deprovisioned, managedMemberIDs(members))
+	return
+}
+
+// managedMemberIDs returns the identities of the members psync may change, leaving out the protected ones.
+func managedMemberIDs(members []GitlabMember) []string {
+	ids := make([]string, 0, len(members))
+	for _, m := range members {
+		if !m.Protected {
+			ids = append(ids, m.SAMLID)
+		}
+	}
+	return ids
+}
+
+// PlanAccessRequests decides on the pending Gitlab access requests of the group.
+// Requests of active Okta group members are approved, which also makes adding them unnecessary;
+// all other requests are denied.
+func PlanAccessRequests(plan *GroupPlan, g OktaGroup, requests []*gitlab.AccessRequest, identities map[int]string) {
+	active := make(map[string]bool, len(g.Users))
+	for _, u := range g.Users {
+		active[u] = true
+	}
+	approved := make([]string, 0, len(requests))
+	for _, r := range requests {
+		if uid, ok := identities[r.ID]; ok && active[uid] {
+			if plan.Requesters == nil {
+				plan.Requesters = map[int]string{}
+			}
+			plan.Approve = append(plan.Approve, r)
+			plan.Requesters[r.ID] = uid
+			approved = append(approved, uid)
+		} else {
+			plan.Deny = append(plan.Deny, r)
+		}
+	}
+	plan.Add = set.Difference(plan.Add, approved)
+}
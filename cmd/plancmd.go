@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// planCmd prints the changes a sync would make
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Print the membership changes a sync would make, without making them",
+	Long: `Compute the additions and removals of all Okta dev_ groups, with the configured policies, and print them
+without changing any Gitlab membership. Okta and Gitlab are read through a read-only snapshot and the state is not saved.
+Same as psync --dry-run.
+The plan is labeled with the Okta org and Gitlab instance it reads, production or sandbox (OKTA_SANDBOX, GITLAB_SANDBOX).
+Planning a sandbox Okta org against production Gitlab shows the Gitlab impact of an Okta group restructure before
+its rollout, such runs are plan-only and psync refuses to sync them.`,
+	Example: `  # Preview the blast radius of the production config
+  psync plan --config prod.yaml
+
+  # Evaluate the Okta groups of the preview org, with ENVIRONMENTS.okta-preview setting
+  # OKTA_ORG_URL, OKTA_SECRET and STATE_FILE
+  psync plan --env okta-preview`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(Plan())
+	},
+}
+
+// Plan plans one sync of the Okta dev_ groups to Gitlab and prints the changes, without applying them.
+func Plan() error {
+	// Any request that could change something fails instead of reaching the providers
+	transportHook = func(provider string, rt http.RoundTripper) http.RoundTripper {
+		return NewSnapshot(rt)
+	}
+	ctx, client, gitlabClt, err := NewClients()
+	if err != nil {
+		return err
+	}
+	state, err := LoadState()
+	if err != nil {
+		return err
+	}
+	var saved bytes.Buffer
+	if err := EncodeState(&saved, state); err != nil {
+		return err
+	}
+	run, syncs, err := planRun(ctx, client, gitlabClt, saved.Bytes())
+	if err != nil {
+		return err
+	}
+
+	changes := planChanges(run, syncs)
+	lines := make([]string, 0, len(changes))
+	for c := range changes {
+		lines = append(lines, c)
+	}
+	sort.Strings(lines)
+	add, remove, seats := 0, 0, 0
+	for _, gs := range syncs {
+		add += len(gs.Plan.Add)
+		remove += len(gs.Plan.Remove)
+		for _, u := range gs.Plan.Add {
+			if gs.Plan.Seats[u] == SeatBillable {
+				seats++
+			}
+		}
+	}
+	fmt.Printf("Reading %s\n", orgCombination())
+	if mixedOrgs() {
+		fmt.Println("Mixing a sandbox with production, this plan cannot be synced")
+	}
+	fmt.Printf("Plan %s: %d additions, %d removals in %d groups\n", run.ID, add, remove, len(syncs))
+	if viper.GetBool("SEAT_IMPACT") {
+		fmt.Printf("The additions take %d new billable seats\n", seats)
+	}
+	for _, c := range lines {
+		fmt.Printf("  %s\n", c)
+	}
+	// Conflicts are left alone by the sync, list them so the plan shows everything needing a decision
+	for _, gs := range syncs {
+		for _, m := range gs.Plan.Conflicts {
+			fmt.Printf("  conflict: %s is active in Okta but blocked in %s\n", m.User.Username, gitlabGroupLabel(gs))
+		}
+	}
+	writePlanRecords(run, syncs)
+	return nil
+}
+
+// writePlanRecords writes the planned changes as JSON records, with --output json.
+func writePlanRecords(run *Run, syncs []GroupSync) {
+	for _, r := range planRecords(run, syncs) {
+		writeRecord(r)
+	}
+}
+
+// planRecords returns the planned changes as records: the skipped groups, additions, removals and conflicts.
+func planRecords(run *Run, syncs []GroupSync) []OutputRecord {
+	records := make([]OutputRecord, 0)
+	for _, s := range run.Skipped {
+		records = append(records, OutputRecord{Type: "skip", RunID: run.ID, Message: s})
+	}
+	for _, gs := range syncs {
+		label := gitlabGroupLabel(gs)
+		for _, u := range gs.Plan.Add {
+			records = append(records, OutputRecord{Type: "add", RunID: run.ID, Group: label, User: u, AccessLevel: accessLevelName(memberAccessLevel(gs, u))})
+		}
+		for _, u := range gs.Plan.Remove {
+			records = append(records, OutputRecord{Type: "remove", RunID: run.ID, Group: label, User: u})
+		}
+		for _, m := range gs.Plan.Conflicts {
+			records = append(records, OutputRecord{Type: "conflict", RunID: run.ID, Group: label, User: m.SAMLID,
+				Message: fmt.Sprintf("%s is active in Okta but blocked in Gitlab", m.User.Username)})
+		}
+	}
+	now := clock.Now()
+	for i := range records {
+		records[i].Time = now
+	}
+	return records
+}
+
+func init() {
+	rootCmd.AddCommand(planCmd)
+}
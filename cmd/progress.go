@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Progress event types
+const (
+	EventPlanned  = "planned"
+	EventAdded    = "added"
+	EventRemoved  = "removed"
+	EventApproved = "approved"
+	EventDenied   = "denied"
+	EventSkipped  = "skipped"
+	EventFailed   = "failed"
+	EventFinished = "finished"
+)
+
+// ProgressEvent reports one step of a run. Done and Total count the applied and planned membership changes,
+// so callers can show the progress of long runs.
+type ProgressEvent struct {
+	RunID string    `json:"run_id"`
+	Type  string    `json:"type"`
+	Time  time.Time `json:"time"`
+	Group string    `json:"group,omitempty"`
+	User  string    `json:"user,omitempty"`
+	Error string    `json:"error,omitempty"`
+	Done  int       `json:"done"`
+	Total int       `json:"total"`
+}
+
+// ProgressBroker fans the progress events out to the subscribed clients.
+// Events are dropped for clients that don't keep up, so a slow client never holds up the sync.
+type ProgressBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan ProgressEvent]bool
+}
+
+// progress publishes the progress of the runs
+var progress = &ProgressBroker{subscribers: map[chan ProgressEvent]bool{}}
+
+// Subscribe returns a channel receiving the events published from now on.
+func (b *ProgressBroker) Subscribe() chan ProgressEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch := make(chan ProgressEvent, 64)
+	b.subscribers[ch] = true
+	return ch
+}
+
+// Unsubscribe stops sending events to the channel and closes it.
+func (b *ProgressBroker) Unsubscribe(ch chan ProgressEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subscribers, ch)
+	close(ch)
+}
+
+// Publish sends the event to all subscribers.
+func (b *ProgressBroker) Publish(e ProgressEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e.Time = clock.Now()
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// ServeEvents streams the progress events as server-sent events until the client disconnects.
+func (b *ProgressBroker) ServeEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	flusher.Flush()
+
+	ch := b.Subscribe()
+	defer b.Unsubscribe(ch)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-ch:
+			data, err := json.Marshal(e)
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+
+	"psync/internal/set"
+)
+
+// ProtectionTarget keeps the reviewers of a key Gitlab project aligned with the members of an Okta group:
+// the users of a project approval rule, or the users allowed to merge to a protected branch.
+// Configured per Okta group under PROTECTION_TARGETS, and applied after the memberships.
+type ProtectionTarget struct {
+	// Project is the full path of the project
+	Project string
+	// Rule is the name of the project approval rule, created if missing
+	Rule string
+	// ApprovalsRequired of the rule, kept as is when 0 and 1 for new rules
+	ApprovalsRequired int `mapstructure:"approvals_required"`
+	// Branch is the name of the protected branch
+	Branch string
+	// Push also allows the users to push to the branch
+	Push bool
+}
+
+// applyProtections sets the users of the PROTECTION_TARGETS of the synced Okta groups to their active members
+// with a Gitlab identity. Users added to the rules or branches by hand are removed, roles and groups are left alone.
+// A target that cannot be updated raises an alert without failing the run, the memberships being applied already.
+func (t *GitlabTarget) applyProtections(run *Run, syncs []GroupSync) error {
+	targets := map[string][]ProtectionTarget{}
+	if err := viper.UnmarshalKey("PROTECTION_TARGETS", &targets); err != nil {
+		return fmt.Errorf("PROTECTION_TARGETS: %w", err)
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+	gitlabIDs := map[string]int{}
+	for id, oktaID := range GitlabIdentities(t.afklMembers) {
+		gitlabIDs[oktaID] = id
+	}
+	for _, gs := range syncs {
+		// Viper lower-cases the keys of config maps
+		if gs.Tier != "" || len(targets[strings.ToLower(gs.Group.Name)]) == 0 {
+			continue
+		}
+		users := make([]int, 0, len(gs.Group.Users))
+		for _, oktaID := range gs.Group.Users {
+			if id, ok := gitlabIDs[oktaID]; ok {
+				users = append(users, id)
+			}
+		}
+		sort.Ints(users)
+		for _, target := range targets[strings.ToLower(gs.Group.Name)] {
+			var err error
+			switch {
+			case target.Rule != "":
+				err = t.syncApprovalRule(run, gs.Group.Name, target, users)
+			case target.Branch != "":
+				err = t.syncProtectedBranch(run, gs.Group.Name, target, users)
+			default:
+				err = fmt.Errorf("%s has neither a rule nor a branch", target.Project)
+			}
+			if err != nil {
+				raiseAlert("PROTECTION_TARGETS of %s: %v", gs.Group.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// syncApprovalRule sets the users of the approval rule of the project, creating the rule if needed.
+func (t *GitlabTarget) syncApprovalRule(run *Run, group string, target ProtectionTarget, users []int) error {
+	rules, _, err := t.Client.Projects.GetProjectApprovalRules(target.Project, &gitlab.GetProjectApprovalRulesListsOptions{PerPage: 100})
+	if err != nil {
+		return fmt.Errorf("listing the approval rules of %s: %w", target.Project, err)
+	}
+	label := fmt.Sprintf("the %s approval rule of %s", target.Rule, target.Project)
+	for _, r := range rules {
+		if r.Name != target.Rule {
+			continue
+		}
+		current := make([]int, 0, len(r.Users))
+		for _, u := range r.Users {
+			current = append(current, u.ID)
+		}
+		approvals := r.ApprovalsRequired
+		if target.ApprovalsRequired != 0 {
+			approvals = target.ApprovalsRequired
+		}
+		added, removed := set.Difference(users, current), set.Difference(current, users)
+		if len(added)+len(removed) == 0 && approvals == r.ApprovalsRequired {
+			return nil
+		}
+		if _, _, err := t.Client.Projects.UpdateProjectApprovalRule(target.Project, r.ID, &gitlab.UpdateProjectLevelRuleOptions{
+			ApprovalsRequired: &approvals,
+			UserIDs:           &users,
+		}); err != nil {
+			return fmt.Errorf("updating %s: %w", label, err)
+		}
+		logger.Info("Updated the approval rule", "group", group, "project", target.Project, "rule", target.Rule, "added", len(added), "removed", len(removed))
+		run.reportChange(group, "Set %s to the %d members of the Okta group, %d added and %d removed", label, len(users), len(added), len(removed))
+		return nil
+	}
+
+	approvals := target.ApprovalsRequired
+	if approvals == 0 {
+		approvals = 1
+	}
+	if _, _, err := t.Client.Projects.CreateProjectApprovalRule(target.Project, &gitlab.CreateProjectLevelRuleOptions{
+		Name:              &target.Rule,
+		ApprovalsRequired: &approvals,
+		UserIDs:           &users,
+	}); err != nil {
+		return fmt.Errorf("creating %s: %w", label, err)
+	}
+	logger.Info("Created the approval rule", "group", group, "project", target.Project, "rule", target.Rule, "users", len(users))
+	run.reportChange(group, "Created %s with the %d members of the Okta group", label, len(users))
+	return nil
+}
+
+// syncProtectedBranch sets the users allowed to merge, and to push if configured, to the protected branch of the project.
+func (t *GitlabTarget) syncProtectedBranch(run *Run, group string, target ProtectionTarget, users []int) error {
+	branch, _, err := t.Client.ProtectedBranches.GetProtectedBranch(target.Project, target.Branch)
+	if err != nil {
+		return fmt.Errorf("getting the protected branch %s of %s: %w", target.Branch, target.Project, err)
+	}
+	label := fmt.Sprintf("the %s branch of %s", target.Branch, target.Project)
+	opt := &gitlab.UpdateProtectedBranchOptions{}
+	changed := false
+	if perms := branchUserPermissions(branch.MergeAccessLevels, users); len(perms) > 0 {
+		opt.AllowedToMerge, changed = &perms, true
+	}
+	if target.Push {
+		if perms := branchUserPermissions(branch.PushAccessLevels, users); len(perms) > 0 {
+			opt.AllowedToPush, changed = &perms, true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	if _, _, err := t.Client.ProtectedBranches.UpdateProtectedBranch(target.Project, target.Branch, opt); err != nil {
+		return fmt.Errorf("updating %s: %w", label, err)
+	}
+	logger.Info("Updated the users allowed to merge", "group", group, "project", target.Project, "branch", target.Branch, "users", len(users))
+	run.reportChange(group, "Allowed the %d members of the Okta group to merge to %s", len(users), label)
+	return nil
+}
+
+// branchUserPermissions returns the changes turning the users of the access levels of a protected branch into the users.
+func branchUserPermissions(levels []*gitlab.BranchAccessDescription, users []int) []*gitlab.BranchPermissionOptions {
+	perms := make([]*gitlab.BranchPermissionOptions, 0)
+	current := make([]int, 0, len(levels))
+	for _, l := range levels {
+		if l.UserID == 0 {
+			continue
+		}
+		current = append(current, l.UserID)
+		if !set.Contains(users, l.UserID) {
+			perms = append(perms, &gitlab.BranchPermissionOptions{ID: gitlab.Int(l.ID), Destroy: gitlab.Bool(true)})
+		}
+	}
+	for _, id := range set.Difference(users, current) {
+		perms = append(perms, &gitlab.BranchPermissionOptions{UserID: gitlab.Int(id)})
+	}
+	return perms
+}
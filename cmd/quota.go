@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// QuotaUsage is the API quota a run consumed, to tell the impact of running the syncs more often.
+type QuotaUsage struct {
+	// Requests are the API requests sent, by provider
+	Requests map[string]int `json:"requests"`
+	// RateLimits are the lowest rate limit headroom the providers reported, by provider
+	RateLimits map[string]RateLimitUsage `json:"rate_limits,omitempty"`
+	// SecretAccesses are the secrets read, by secret manager: gcp, aws or vault
+	SecretAccesses map[string]int `json:"secret_accesses,omitempty"`
+
+	mu sync.Mutex
+}
+
+// RateLimitUsage is the rate limit window of a provider with the fewest requests remaining during the run.
+type RateLimitUsage struct {
+	Limit     int `json:"limit"`
+	Remaining int `json:"remaining"`
+	// Consumed is the percentage of the window used when the fewest requests remained
+	Consumed int `json:"consumed_percent"`
+}
+
+// runQuota collects the quota usage of the current run
+var runQuota = &QuotaUsage{}
+
+// rateLimitHeaders are the headers of the rate limit and the requests remaining, by convention:
+// Okta, Gitlab and the IETF draft, then GitHub
+var rateLimitHeaders = [][2]string{
+	{"X-Rate-Limit-Limit", "X-Rate-Limit-Remaining"},
+	{"RateLimit-Limit", "RateLimit-Remaining"},
+	{"X-RateLimit-Limit", "X-RateLimit-Remaining"},
+}
+
+// observe counts an API request of the provider and the rate limit headroom its response reports, if any.
+func (q *QuotaUsage) observe(provider string, resp *http.Response) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.Requests == nil {
+		q.Requests = map[string]int{}
+	}
+	q.Requests[provider]++
+	if resp == nil {
+		return
+	}
+	for _, h := range rateLimitHeaders {
+		limit, err := strconv.Atoi(resp.Header.Get(h[0]))
+		if err != nil || limit <= 0 {
+			continue
+		}
+		remaining, err := strconv.Atoi(resp.Header.Get(h[1]))
+		if err != nil {
+			continue
+		}
+		usage := RateLimitUsage{Limit: limit, Remaining: remaining, Consumed: 100 - remaining*100/limit}
+		if q.RateLimits == nil {
+			q.RateLimits = map[string]RateLimitUsage{}
+		}
+		// The endpoints of a provider have windows of their own, the most used one tells the headroom
+		if last, ok := q.RateLimits[provider]; !ok || usage.Consumed > last.Consumed {
+			q.RateLimits[provider] = usage
+		}
+		return
+	}
+}
+
+// secretAccessed counts a secret read from the secret manager.
+func (q *QuotaUsage) secretAccessed(manager string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.SecretAccesses == nil {
+		q.SecretAccesses = map[string]int{}
+	}
+	q.SecretAccesses[manager]++
+}
+
+// Snapshot returns a copy of the usage collected so far.
+func (q *QuotaUsage) Snapshot() *QuotaUsage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	s := &QuotaUsage{Requests: map[string]int{}, RateLimits: map[string]RateLimitUsage{}, SecretAccesses: map[string]int{}}
+	for k, v := range q.Requests {
+		s.Requests[k] = v
+	}
+	for k, v := range q.RateLimits {
+		s.RateLimits[k] = v
+	}
+	for k, v := range q.SecretAccesses {
+		s.SecretAccesses[k] = v
+	}
+	return s
+}
+
+// String summarizes the usage, e.g. "okta 12 requests (34% of the rate limit), gitlab 40 requests, 2 gcp secret accesses".
+func (q *QuotaUsage) String() string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	parts := make([]string, 0, len(q.Requests)+len(q.SecretAccesses))
+	for _, p := range sortedKeys(q.Requests) {
+		part := fmt.Sprintf("%s %d requests", p, q.Requests[p])
+		if rl, ok := q.RateLimits[p]; ok {
+			part += fmt.Sprintf(" (%d%% of the rate limit)", rl.Consumed)
+		}
+		parts = append(parts, part)
+	}
+	for _, m := range sortedKeys(q.SecretAccesses) {
+		parts = append(parts, fmt.Sprintf("%d %s secret accesses", q.SecretAccesses[m], m))
+	}
+	if len(parts) == 0 {
+		return "no API requests"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// sortedKeys returns the keys of the counts, sorted.
+func sortedKeys(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/okta/okta-sdk-golang/v2/okta"
+)
+
+// OktaRateLimit keeps track of the Okta rate limit headers returned by the API.
+// The Okta org token bucket is shared with other integrations, so psync slows down before it gets exhausted.
+type OktaRateLimit struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time
+	// Threshold is the percentage of the limit left at which requests start being spread over the reset window
+	Threshold int
+	Requests  int
+	Throttled time.Duration
+
+	mu sync.Mutex
+}
+
+// oktaRateLimit collects the rate limit telemetry of the current run
+var oktaRateLimit = &OktaRateLimit{}
+
+// Observe records the rate limit headers of an Okta API response.
+func (r *OktaRateLimit) Observe(resp *okta.Response) {
+	if resp == nil || resp.Response == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Requests++
+	h := resp.Header
+	if v, err := strconv.Atoi(h.Get("X-Rate-Limit-Limit")); err == nil {
+		r.Limit = v
+	}
+	if v, err := strconv.Atoi(h.Get("X-Rate-Limit-Remaining")); err == nil {
+		r.Remaining = v
+	}
+	if v, err := strconv.ParseInt(h.Get("X-Rate-Limit-Reset"), 10, 64); err == nil {
+		r.Reset = time.Unix(v, 0)
+	}
+}
+
+// Delay returns how long to wait before the next request.
+// Once the remaining requests drop below the threshold, they are spread evenly until the window resets.
+func (r *OktaRateLimit) Delay() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.delay()
+}
+
+// delay computes the delay, with the lock held.
+func (r *OktaRateLimit) delay() time.Duration {
+	if r.Limit == 0 || r.Remaining*100 > r.Limit*r.Threshold {
+		return 0
+	}
+	until := r.Reset.Sub(clock.Now())
+	if until <= 0 {
+		return 0
+	}
+	return until / time.Duration(r.Remaining+1)
+}
+
+// Throttle sleeps for the delay computed from the last observed response.
+func (r *OktaRateLimit) Throttle() {
+	r.mu.Lock()
+	d := r.delay()
+	r.Throttled += d
+	r.mu.Unlock()
+	if d > 0 {
+		clock.Sleep(d)
+	}
+}
+
+// String summarizes the rate limit usage of the run.
+func (r *OktaRateLimit) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return fmt.Sprintf("%d requests, %d/%d remaining until %s, throttled for %s",
+		r.Requests, r.Remaining, r.Limit, r.Reset.Format(time.RFC3339), r.Throttled)
+}
@@ -0,0 +1,277 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+
+	"psync/internal/set"
+)
+
+var (
+	recertGroups   []string
+	recertDeadline time.Duration
+)
+
+// RecertCampaign is an access recertification: the owners of each Gitlab group review its members
+// in an issue of RECERT_PROJECT, and the members they don't confirm by the deadline are revoked.
+type RecertCampaign struct {
+	ID       string         `json:"id"`
+	Started  time.Time      `json:"started"`
+	Deadline time.Time      `json:"deadline"`
+	Project  string         `json:"project"`
+	Reviews  []RecertReview `json:"reviews"`
+}
+
+// RecertReview is the review of the members of one group.
+type RecertReview struct {
+	OktaGroupID string `json:"okta_group_id"`
+	Group       string `json:"group"`
+	GitlabID    int    `json:"gitlab_id"`
+	IssueIID    int    `json:"issue_iid"`
+	// Members are the Okta user IDs of the members under review, by Gitlab username
+	Members  map[string]string `json:"members"`
+	Enforced bool              `json:"enforced,omitempty"`
+}
+
+// recertTask matches the task of a member in the review issue, checked when the owner confirms the member
+var recertTask = regexp.MustCompile(`(?m)^- \[([ xX])\] @(\S+)`)
+
+// recertCmd groups the access recertification commands
+var recertCmd = &cobra.Command{
+	Use:   "recert",
+	Short: "Run access recertification campaigns",
+	Long: `Ask the owners of the Gitlab groups to recertify the access of their members, and revoke the access they don't confirm.
+psync recert start opens one issue per group in RECERT_PROJECT, assigned to the owners of the group and listing its members
+as tasks. Owners confirm members by checking their task, or all of them with the RECERT_CONFIRMED_LABEL label or a thumbs up.
+Once the deadline has passed, the sync revokes the members left unconfirmed, with the recert policy in PIPELINE_POLICIES:
+they are removed from the Gitlab group and not added back while they stay in the Okta group.`,
+	Example: `  # Review the members of two groups within two weeks
+  psync recert start --groups payments,platform --deadline 336h
+
+  # Follow the reviews
+  psync recert status`,
+}
+
+// recertStartCmd opens the reviews of a campaign
+var recertStartCmd = &cobra.Command{
+	Use:   "start",
+	Short: "Open the review issues of a recertification campaign",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(recertGroups) == 0 {
+			cobra.CheckErr("--groups is required")
+		}
+		if !set.Contains(viper.GetStringSlice("PIPELINE_POLICIES"), "recert") {
+			cobra.CheckErr("the recert policy is not in PIPELINE_POLICIES, unconfirmed members would never be revoked")
+		}
+		project := viper.GetString("RECERT_PROJECT")
+		if project == "" {
+			cobra.CheckErr("RECERT_PROJECT is not set")
+		}
+		store, err := NewStateStore()
+		cobra.CheckErr(err)
+		state, err := store.Load()
+		cobra.CheckErr(err)
+		clt, err := NewGitlabClient(state)
+		cobra.CheckErr(err)
+		parent, _, err := ParentGroupMembers(clt)
+		cobra.CheckErr(err)
+
+		campaign := RecertCampaign{ID: ids.NewID(), Started: clock.Now(), Project: project}
+		campaign.Deadline = campaign.Started.Add(recertDeadline)
+		for _, name := range recertGroups {
+			oktaID, err := state.OktaGroupID(name)
+			cobra.CheckErr(err)
+			gitlabID, _ := state.GitlabGroupID(oktaID)
+			review, url, err := openRecertReview(clt, campaign, parent, name, gitlabID)
+			cobra.CheckErr(err)
+			review.OktaGroupID = oktaID
+			campaign.Reviews = append(campaign.Reviews, review)
+			fmt.Printf("%s: %d members to review in %s\n", name, len(review.Members), url)
+		}
+		state.Recerts = append(state.Recerts, campaign)
+		cobra.CheckErr(store.Save(state))
+		fmt.Printf("Started campaign %s, unconfirmed members are revoked after %s\n", campaign.ID, campaign.Deadline.Format(time.RFC3339))
+	},
+}
+
+// openRecertReview opens the review issue of the members of the Gitlab group, assigned to its owners.
+func openRecertReview(clt *gitlab.Client, campaign RecertCampaign, parent []*gitlab.GroupMember, name string, gitlabID int) (RecertReview, string, error) {
+	review := RecertReview{Group: name, GitlabID: gitlabID, Members: map[string]string{}}
+	all, _, err := clt.Groups.ListAllGroupMembers(gitlabID, &gitlab.ListGroupMembersOptions{ListOptions: gitlab.ListOptions{PerPage: 100}})
+	if err != nil {
+		return review, "", fmt.Errorf("listing the members of %s: %w", name, err)
+	}
+	owners := make([]int, 0)
+	for _, m := range all {
+		if m.AccessLevel >= gitlab.OwnerPermissions {
+			owners = append(owners, m.ID)
+		}
+	}
+	if len(owners) == 0 {
+		return review, "", fmt.Errorf("%s has no owner to review its members", name)
+	}
+	members, err := ListGitlabGroupMembers(clt, gitlabID)
+	if err != nil {
+		return review, "", err
+	}
+	var tasks strings.Builder
+	// Protected members are never removed, so there is nothing to review about them
+	for _, m := range MatchGitlabMembers(members, parent) {
+		if m.SAMLID == "" || m.Protected {
+			continue
+		}
+		review.Members[m.User.Username] = m.SAMLID
+		fmt.Fprintf(&tasks, "- [ ] @%s (%s)\n", m.User.Username, accessLevelName(m.User.AccessLevel))
+	}
+	description := fmt.Sprintf("Please confirm the members of %s who still need their access, by checking their task.\n"+
+		"Apply the ~%q label or give this issue a :thumbsup: to confirm them all.\n"+
+		"The members left unchecked on %s lose their access to the group.\n\n%s",
+		name, viper.GetString("RECERT_CONFIRMED_LABEL"), campaign.Deadline.Format("2006-01-02"), tasks.String())
+	due := gitlab.ISOTime(campaign.Deadline)
+	issue, _, err := clt.Issues.CreateIssue(campaign.Project, &gitlab.CreateIssueOptions{
+		Title:       gitlab.String(fmt.Sprintf("Access recertification of %s (%s)", name, campaign.ID)),
+		Description: &description,
+		AssigneeIDs: &owners,
+		Labels:      &gitlab.LabelOptions{"recert"},
+		DueDate:     &due,
+	})
+	if err != nil {
+		return review, "", fmt.Errorf("opening the review of %s: %w", name, err)
+	}
+	review.IssueIID = issue.IID
+	return review, issue.WebURL, nil
+}
+
+// confirmedMembers returns the Okta user IDs of the members the owners confirmed in the review issue.
+func confirmedMembers(clt *gitlab.Client, project string, review RecertReview) ([]string, error) {
+	issue, _, err := clt.Issues.GetIssue(project, review.IssueIID)
+	if err != nil {
+		return nil, fmt.Errorf("getting the review issue of %s: %w", review.Group, err)
+	}
+	all := make([]string, 0, len(review.Members))
+	for _, id := range review.Members {
+		all = append(all, id)
+	}
+	if set.Contains(issue.Labels, viper.GetString("RECERT_CONFIRMED_LABEL")) {
+		return all, nil
+	}
+	emojis, _, err := clt.AwardEmoji.ListIssueAwardEmoji(project, review.IssueIID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("getting the reactions to the review of %s: %w", review.Group, err)
+	}
+	for _, e := range emojis {
+		for _, a := range issue.Assignees {
+			if e.Name == "thumbsup" && e.User.ID == a.ID {
+				return all, nil
+			}
+		}
+	}
+	confirmed := make([]string, 0)
+	for _, task := range recertTask.FindAllStringSubmatch(issue.Description, -1) {
+		if id, ok := review.Members[task[2]]; ok && task[1] != " " {
+			confirmed = append(confirmed, id)
+		}
+	}
+	return confirmed, nil
+}
+
+// enforceRecerts revokes the unconfirmed members of the reviews past their deadline, for the recert policy to remove them,
+// and closes their issues with the list of the revoked members.
+func enforceRecerts(clt *gitlab.Client, state *State) error {
+	now := clock.Now()
+	for i := range state.Recerts {
+		c := &state.Recerts[i]
+		if now.Before(c.Deadline) {
+			continue
+		}
+		for j := range c.Reviews {
+			r := &c.Reviews[j]
+			if r.Enforced {
+				continue
+			}
+			confirmed, err := confirmedMembers(clt, c.Project, *r)
+			if err != nil {
+				return err
+			}
+			revoked := make([]string, 0)
+			for username, id := range r.Members {
+				if !set.Contains(confirmed, id) {
+					state.Revoke(r.OktaGroupID, id)
+					revoked = append(revoked, "@"+username)
+				}
+			}
+			body := fmt.Sprintf("The deadline has passed, all %d members were confirmed.", len(r.Members))
+			if len(revoked) > 0 {
+				body = fmt.Sprintf("The deadline has passed, the access of %d unconfirmed members is revoked: %s", len(revoked), strings.Join(revoked, ", "))
+			}
+			if _, _, err := clt.Notes.CreateIssueNote(c.Project, r.IssueIID, &gitlab.CreateIssueNoteOptions{Body: &body}); err != nil {
+				return fmt.Errorf("commenting on the review of %s: %w", r.Group, err)
+			}
+			if _, _, err := clt.Issues.UpdateIssue(c.Project, r.IssueIID, &gitlab.UpdateIssueOptions{StateEvent: gitlab.String("close")}); err != nil {
+				return fmt.Errorf("closing the review of %s: %w", r.Group, err)
+			}
+			r.Enforced = true
+			logger.Info("Enforced the recertification", "group", r.Group, "revoked", len(revoked), "members", len(r.Members))
+		}
+	}
+	return nil
+}
+
+// recertPolicy removes the members revoked by a recertification and doesn't add them back.
+// Revocations end when the user leaves the Okta group.
+func recertPolicy(run *Run, syncs []GroupSync) ([]GroupSync, error) {
+	for i := range syncs {
+		gs := &syncs[i]
+		revoked := run.State.Revoked(gs.Group.ID)
+		if len(revoked) == 0 {
+			continue
+		}
+		gs.Plan.Add = set.Difference(gs.Plan.Add, revoked)
+		gs.Plan.Remove = set.Union(gs.Plan.Remove, set.Intersection(managedMemberIDs(gs.Members), revoked))
+		if gs.Tier == "" {
+			for _, id := range set.Difference(revoked, append(append([]string{}, gs.Group.Users...), gs.Group.Deprovisioned...)) {
+				run.State.Unrevoke(gs.Group.ID, id)
+			}
+		}
+	}
+	return syncs, nil
+}
+
+// recertStatusCmd prints the progress of the reviews
+var recertStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Print the progress of the recertification reviews",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		state, err := LoadState()
+		cobra.CheckErr(err)
+		clt, err := NewGitlabClient(state)
+		cobra.CheckErr(err)
+		for _, c := range state.Recerts {
+			fmt.Printf("Campaign %s, deadline %s:\n", c.ID, c.Deadline.Format(time.RFC3339))
+			for _, r := range c.Reviews {
+				if r.Enforced {
+					fmt.Printf("  %s: enforced\n", r.Group)
+					continue
+				}
+				confirmed, err := confirmedMembers(clt, c.Project, r)
+				cobra.CheckErr(err)
+				fmt.Printf("  %s: %d of %d members confirmed\n", r.Group, len(confirmed), len(r.Members))
+			}
+		}
+	},
+}
+
+func init() {
+	recertStartCmd.Flags().StringSliceVar(&recertGroups, "groups", nil, "Okta groups whose Gitlab group members to review")
+	recertStartCmd.Flags().DurationVar(&recertDeadline, "deadline", 14*24*time.Hour, "time the owners have to review the members")
+	recertCmd.AddCommand(recertStartCmd, recertStatusCmd)
+	rootCmd.AddCommand(recertCmd)
+}
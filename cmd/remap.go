@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// remapCmd re-links the Okta groups to Gitlab groups stored in the state
+var remapCmd = &cobra.Command{
+	Use:   "remap [okta group] [gitlab group]",
+	Short: "Re-link Okta groups to Gitlab groups",
+	Long: `Resolve the Gitlab groups of all Okta dev_ groups by name again and update the stored mappings.
+Use it when the group naming conventions change. Given an Okta group name and a Gitlab group name,
+only that Okta group is linked to the given Gitlab group.`,
+	Args: cobra.RangeArgs(0, 2),
+	Example: `  # Resolve all Gitlab groups by name again
+  psync remap
+
+  # Link the Okta group dev_payments to the Gitlab group payments-team
+  psync remap payments payments-team`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 1 {
+			cobra.CheckErr("both the Okta group and the Gitlab group are required")
+		}
+		ctx, client, gitlabClt, err := NewClients()
+		cobra.CheckErr(err)
+
+		store, err := NewStateStore()
+		cobra.CheckErr(err)
+		state, err := store.Load()
+		cobra.CheckErr(err)
+
+		oktaGroups, err := GetOktaDevGroups(ctx, client)
+		cobra.CheckErr(err)
+
+		for _, g := range oktaGroups {
+			gitlabName := g.Name
+			if len(args) == 2 {
+				if g.Name != args[0] {
+					continue
+				}
+				gitlabName = args[1]
+			}
+			newID, err := FindGitlabGroupID(gitlabClt, gitlabName)
+			cobra.CheckErr(err)
+			if oldID, ok := state.GitlabGroupID(g.ID); ok && oldID != newID {
+				fmt.Printf("Remapped %s: Gitlab group %d -> %d\n", g.Name, oldID, newID)
+			} else if !ok {
+				fmt.Printf("Mapped %s: Gitlab group %d\n", g.Name, newID)
+			}
+			state.SetGroupMapping(g.ID, g.Name, newID)
+		}
+		cobra.CheckErr(store.Save(state))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(remapCmd)
+}
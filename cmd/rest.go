@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/viper"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// restRequest sends a request with the JSON body to the REST API at base and decodes the JSON response into v, if not nil.
+// The Content-Type header is only sent with a body. Returns the response also on error responses, for the retries,
+// and no response on transport errors.
+func restRequest(client *http.Client, header http.Header, method, base, path string, body, v interface{}) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, base+path, r)
+	if err != nil {
+		return nil, err
+	}
+	for k, values := range header {
+		if k == "Content-Type" && body == nil {
+			continue
+		}
+		req.Header[k] = values
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return resp, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	if v == nil || len(data) == 0 {
+		return resp, nil
+	}
+	return resp, json.Unmarshal(data, v)
+}
+
+// retryRequest retries the REST request with backoff, like the Gitlab API calls, on rate limits,
+// server errors and transport errors.
+func retryRequest(send func() (*http.Response, error)) error {
+	return retryWithBackoff(viper.GetInt("RETRY_ATTEMPTS"), viper.GetDuration("RETRY_WAIT"), func() (*gitlab.Response, error) {
+		resp, err := send()
+		if resp == nil {
+			// Network errors and an open circuit breaker leave no response
+			return nil, err
+		}
+		// retryWithBackoff decides on the status code of the response, whichever API it comes from
+		return &gitlab.Response{Response: resp}, err
+	})
+}
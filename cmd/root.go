@@ -1,230 +1,460 @@
 package cmd
 
 import (
-	secretmanager "cloud.google.com/go/secretmanager/apiv1"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/okta/okta-sdk-golang/v2/okta"
-	"github.com/okta/okta-sdk-golang/v2/okta/query"
 	"github.com/spf13/cobra"
-	"github.com/xanzy/go-gitlab"
-	secretmanagerpb "google.golang.org/genproto/googleapis/cloud/secretmanager/v1"
-	"log"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+	"net/http"
 	"os"
+	"runtime/debug"
 	"strings"
+	"time"
 
 	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-var cfgFile string
+var (
+	cfgFile string
+	dryRun  bool
+)
+
+// Version of psync, set at build time
+var Version = "dev"
 
 type OktaGroup struct {
-	ID            string
-	Name          string
+	ID   string
+	Name string
+	// OktaName is the full name of the group in Okta, Name is the name it is synced under
+	OktaName      string
 	Users         []string
 	Deprovisioned []string
+	// Mapping declares the Gitlab group and access level of the group, nil for dev_ groups synced by name
+	Mapping *MappingRule
 }
 
 type GitlabMember struct {
-	User *gitlab.GroupMember
+	User   *gitlab.GroupMember
 	SAMLID string
+	// Protected members count as present but are never changed: members at or above PROTECTED_ACCESS_LEVEL that psync did not add
+	Protected bool
+}
+
+// GitlabGroupStatus holds the group lifecycle attributes that are not exposed by the gitlab.Group type.
+type GitlabGroupStatus struct {
+	Archived            bool            `json:"archived"`
+	MarkedForDeletionOn *gitlab.ISOTime `json:"marked_for_deletion_on"`
 }
 
+// afklGroup is the default Gitlab parent group all developers are members of, with their SAML identity
+const afklGroup = "AFKL-MCP"
+
+// Gitlab member states that need special handling
+const (
+	gitlabMemberAwaiting = "awaiting"
+	gitlabMemberBlocked  = "blocked"
+)
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
-	Use:   "psync",
-	Short: "Sync Okta groups permissions",
-	Long:  `Automatically assign new groupMembers Gitlab groups permissions based on their Okta profile`,
+	Use:     "psync",
+	Short:   "Sync Okta groups permissions",
+	Long:    `Automatically assign new groupMembers Gitlab groups permissions based on their Okta profile`,
+	Version: Version,
+	Example: `  # Sync all Okta dev_ groups once
+  psync
+
+  # Use another config file and abort on any data-quality warning
+  psync --config prod.yaml --strict
+
+  # Print the changes without making them
+  psync --dry-run
+
+  # Record the actions of the sync for other tools, one JSON record per line
+  psync --output json > actions.jsonl`,
 	Run: func(cmd *cobra.Command, args []string) {
-		// Create the GCP client
-		gcpCtx := context.Background()
-		gcpClient, err := secretmanager.NewClient(gcpCtx)
-		if err != nil {
-			log.Fatal(err)
+		if dryRun {
+			cobra.CheckErr(Plan())
+			return
 		}
-		req := &secretmanagerpb.AccessSecretVersionRequest{Name: viper.GetString("OKTA_SECRET")}
-		oktaToken, err := gcpClient.AccessSecretVersion(gcpCtx, req)
-		if err != nil {
-			log.Fatal(err)
-		}
-		// Initialize Okta Client
-		ctx, client, err := okta.NewClient(context.Background(),
-			okta.WithOrgUrl(viper.GetString("OKTA_ORG_URL")),
-			okta.WithToken(string(oktaToken.Payload.Data)),
-			okta.WithRequestTimeout(45),
-			okta.WithRateLimitMaxRetries(3))
+		_, err := Sync()
 		cobra.CheckErr(err)
+	},
+}
 
-		req = &secretmanagerpb.AccessSecretVersionRequest{Name: viper.GetString("GITLAB_SECRET")}
-		gitlabToken, err := gcpClient.AccessSecretVersion(gcpCtx, req)
-		if err != nil {
-			log.Fatal(err)
+// Sync runs one sync of the Okta dev_ groups to Gitlab, with the options of the Syncer.
+// A run that fails after planning returns its summary with the error, once its state, audit records and alerts are kept.
+func Sync(opts ...SyncerOption) (*RunSummary, error) {
+	if err := checkApplyOrgs(); err != nil {
+		return nil, err
+	}
+	// The quota of the run includes the secrets and the state read before planning
+	runQuota = &QuotaUsage{}
+	span := startSpan("psync.sync")
+	// abort ends the run before it started
+	abort := func(err error) (*RunSummary, error) {
+		span.end(err)
+		flushTraces()
+		return nil, err
+	}
+	store, err := NewStateStore()
+	if err != nil {
+		return abort(err)
+	}
+	unlock, err := lockRun(store)
+	if err != nil {
+		return abort(err)
+	}
+	defer unlock()
+	// Load the Okta to Gitlab group mappings resolved in previous runs, and the active token versions
+	state, err := store.Load()
+	if err != nil {
+		return abort(err)
+	}
+
+	identity, err := NewIdentityProvider(state)
+	if err != nil {
+		return abort(err)
+	}
+	target, gitlabClt, err := NewSyncTarget(identity, state)
+	if err != nil {
+		return abort(err)
+	}
+	syncer, err := NewSyncer(identity, target, state, opts...)
+	if err != nil {
+		return abort(err)
+	}
+	annotator, err := NewGrafanaAnnotator()
+	if err != nil {
+		return abort(err)
+	}
+	// Revoke the members left unconfirmed by the recertifications past their deadline, for the recert policy to remove them
+	if gitlabClt != nil {
+		if err := enforceRecerts(gitlabClt, state); err != nil {
+			return abort(err)
 		}
+	}
 
-		// Initialize Gitlab Client
-		gitlabClt, err := gitlab.NewClient(string(gitlabToken.Payload.Data))
-		cobra.CheckErr(err)
+	run, syncs, err := syncer.Plan()
+	span.SetAttributes(attribute.String("psync.run_id", run.ID))
+	// Report the panics of the run too, then crash as before
+	defer func() {
+		if p := recover(); p != nil {
+			reportFailure(run, syncs, fmt.Errorf("panic: %v", p), debug.Stack())
+			panic(p)
+		}
+	}()
+	logger.Info("Planned the run", "target", DescribeCapabilities(target))
+	if gitlabClt != nil {
+		checkGitlabTokenExpiry(run, gitlabClt)
+	}
+	if err == nil {
+		err = runHooks(HookPre, HookPayload{RunID: run.ID, Plan: planRecords(run, syncs)})
+	}
+	if err == nil {
+		annotator.Start(run)
+		err = syncer.Apply(run, syncs)
+	}
+	if err != nil {
+		failedSummary(run.Summary, run.Summary.Started, err)
+		annotator.End(run, "failed")
+		reportFailure(run, syncs, err, debug.Stack())
+		var stop *TokenScopeError
+		if errors.As(err, &stop) {
+			printStopReport(run, stop)
+		}
+		// Keep the mappings, grants and audit records of the applied changes, and send the alerts before failing
+		if err := store.Save(run.State); err != nil {
+			logger.Error("Could not save the state", "error", err)
+		}
+		if err := NewAuditTrail().Append(run.Audit); err != nil {
+			logger.Error("Could not append the audit records", "error", err)
+		}
+		if err := recordRunHistory(store, run, err); err != nil {
+			logger.Warn("Could not record the run in the history", "error", err)
+		}
+		if err := announceGroupChanges(run); err != nil {
+			logger.Warn("Could not announce the group changes", "error", err)
+		}
+		if err := sendAlerts(run.ID); err != nil {
+			logger.Warn("Could not send the alerts", "error", err)
+		}
+		if err := emailRunSummary(run, err); err != nil {
+			logger.Warn("Could not email the run summary", "error", err)
+		}
+		if err := runHooks(HookPost, HookPayload{RunID: run.ID, Summary: run.Summary, Changes: run.Changes, Error: err.Error()}); err != nil {
+			logger.Warn("Could not run the hooks", "error", err)
+		}
+		run.emit(EventFailed, "", "", err)
+		span.end(err)
+		flushTraces()
+		return run.Summary, err
+	}
 
-		// Fetch the group members of the Okta groups that start with dev_
-		oktaGroups, err := GetOktaDevGroups(ctx, client)
-		cobra.CheckErr(err)
+	for _, s := range run.Skipped {
+		logger.Info("Skipped", "group", s)
+	}
+	for _, c := range run.Conflicts {
+		logger.Warn("Conflict", "conflict", c)
+	}
+	if gitlabCache != nil {
+		logger.Info("Gitlab cache", "stats", gitlabCache.String())
+	}
+	if err := digestRun(run); err != nil {
+		logger.Warn("Could not digest the run", "error", err)
+	}
+	if err := store.Save(run.State); err != nil {
+		span.end(err)
+		flushTraces()
+		err = fmt.Errorf("saving the state: %w", err)
+		return failedSummary(run.Summary, run.Summary.Started, err), err
+	}
+	if err := NewAuditTrail().Append(run.Audit); err != nil {
+		span.end(err)
+		flushTraces()
+		err = fmt.Errorf("appending the audit records: %w", err)
+		return failedSummary(run.Summary, run.Summary.Started, err), err
+	}
+	if err := announceGroupChanges(run); err != nil {
+		logger.Warn("Could not announce the group changes", "error", err)
+	}
+	if err := sendAlerts(run.ID); err != nil {
+		logger.Warn("Could not send the alerts", "error", err)
+	}
+	if err := emailRunSummary(run, nil); err != nil {
+		logger.Warn("Could not email the run summary", "error", err)
+	}
+	annotator.End(run, "completed")
+	logger.Info("Run completed successfully", "added", run.Summary.Added, "removed", run.Summary.Removed)
+	logger.Info("Quota usage", "usage", runQuota.String())
 
-		// Fetch Gitlab group AFKL-MCP members with access level < 50
-		afklMembers, _ := GetGitlabGroupMembers(gitlabClt, "AFKL-MCP")
-		// Parse out afkl-mcp group members identities
-		afklUids := make([]string, len(afklMembers))
-		for i, m := range afklMembers {
-			if m.GroupSAMLIdentity != nil {
-				afklUids[i] = m.GroupSAMLIdentity.ExternUID
-			}
+	summary := run.Summary
+	summary.Finished = clock.Now()
+	summary.Skipped = len(run.Skipped)
+	summary.Conflicts = len(run.Conflicts)
+	summary.Warnings = len(dataWarnings)
+	summary.Alerts = len(alerts)
+	summary.Quota = runQuota.Snapshot()
+	summary.Result = ResultSuccess
+	if summary.Conflicts+summary.Warnings+summary.Alerts > 0 {
+		summary.Result = ResultWarning
+	}
+	if err := recordRunHistory(store, run, nil); err != nil {
+		logger.Warn("Could not record the run in the history", "error", err)
+	}
+	if gitlabClt != nil {
+		if err := publishRunLog(gitlabClt, run); err != nil {
+			logger.Warn("Could not publish the run log", "error", err)
 		}
+	}
+	if err := runHooks(HookPost, HookPayload{RunID: run.ID, Summary: summary, Changes: run.Changes}); err != nil {
+		logger.Warn("Could not run the hooks", "error", err)
+	}
+	run.emit(EventFinished, "", "", nil)
+	writeRecord(OutputRecord{Type: "summary", RunID: run.ID, Summary: summary})
+	span.SetAttributes(attribute.Int("psync.added", summary.Added), attribute.Int("psync.removed", summary.Removed))
+	span.end(nil)
+	flushTraces()
+	return summary, nil
+}
 
-		fmt.Println("Syncing okta dev_ groups ...")
-
-		for _, g := range oktaGroups {
-			// Fetch Gitlab dev group members, find each member in afkl-mcp group and extract their identity
-			glabgroup, grID := GetGitlabGroupMembers(gitlabClt, g.Name)
-			glabgroupMembers := make([]GitlabMember, 0, len(glabgroup))
-			glabgroupUids := make([]string, 0, len(glabgroup))
-			for _, glm := range glabgroup {
-				for _, v := range afklMembers {
-					// Check if SAML identity is not nil. If it is, something went wrong when user was added to AFKL group
-					// Users without a SAML identity cannot be matched with Okta users (!)
-					if v.ID == glm.ID && v.GroupSAMLIdentity != nil {
-						glabgroupUids = append(glabgroupUids, v.GroupSAMLIdentity.ExternUID)
-						glabgroupMembers = append(glabgroupMembers, GitlabMember{
-							User:   glm,
-							SAMLID: v.GroupSAMLIdentity.ExternUID,
-						})
-					}
-				}
-			}
-			// Identify Okta group members that are part of AFKL-MCP Gitlab group
-			oktaUsersInGitlab := getSetIntersection(g.Users, afklUids)
-			// Find the members who are not assigned to the Gitlab developer group yet
-			usersToAdd := getSetDifference(oktaUsersInGitlab, glabgroupUids)
-			if len(usersToAdd) > 0 {
-				fmt.Printf("Adding %d members to %s:\n", len(usersToAdd), g.Name)
-			} else {
-				fmt.Printf("No members to add to %s.\n", g.Name)
-			}
-			// Assign the users to the Gitlab dev group with developer permissions level
-			for _, x := range usersToAdd {
-				var perm = gitlab.DeveloperPermissions
-				for _, y := range afklMembers {
-					if x == y.GroupSAMLIdentity.ExternUID {
-						mem, _, err := gitlabClt.GroupMembers.AddGroupMember(grID, &gitlab.AddGroupMemberOptions{
-							UserID:      &y.ID,
-							AccessLevel: &perm,
-						})
-						cobra.CheckErr(err)
-						fmt.Printf("Added %+v\n", mem)
-					}
-				}
-			}
-			// Find deprovisioned or suspended Okta group users who still have access to the Gitlab group
-			usersToRemove := getSetIntersection(g.Deprovisioned, glabgroupUids)
-			if len(usersToRemove) > 0 {
-				fmt.Printf("Removing %d members from %s:\n", len(usersToRemove), g.Name)
-			} else {
-				fmt.Printf("No members to remove from %s.\n", g.Name)
-			}
-			// Remove deprovisioned or suspended users from the gitlab dev group
-			for _, id := range usersToRemove {
-				for _, member := range glabgroupMembers {
-					if id == member.SAMLID {
-						_, err := gitlabClt.GroupMembers.RemoveGroupMember(grID, member.User.ID)
-						cobra.CheckErr(err)
-						fmt.Printf("Removed %+v\n", member.User)
-					}
-				}
-			}
-		}
-		fmt.Println("Sync completed successfully.")
-	},
+// resetRun clears the data collected by the previous run when several runs share the process.
+func resetRun() {
+	dataWarnings = nil
+	alerts = nil
+	oktaRateLimit = &OktaRateLimit{}
+	oktaAccessLevels = map[string]string{}
+	oktaUserTypes = map[string]string{}
+	oktaReadOnly = map[string]bool{}
+	oktaGithubLogins = map[string]string{}
+	oktaMembers = nil
 }
 
-// GetOktaDevGroups finds and returns only the okta groups with dev_ in the name
+// GetOktaDevGroups finds and returns only the okta groups named with the OKTA_GROUP_PREFIX, dev_ by default,
+// and with the OKTA_GROUP_ATTRIBUTE of their profile set to true, if set, under the names rendered by
+// the OKTA_GROUP_NAME_TEMPLATE.
+// Groups whose users cannot be listed are left out with a data-quality warning, so the others are still synced.
 func GetOktaDevGroups(ctx context.Context, ctl *okta.Client) (groups []OktaGroup, err error) {
-	oktaGroups, _, err := ctl.Group.ListGroups(ctx, &query.Params{
-		Q: "dev_",
-	})
-	cobra.CheckErr(err)
+	namer, err := NewOktaGroupNamer()
+	if err != nil {
+		return nil, err
+	}
+	params, err := oktaGroupParams(namer.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	oktaGroups, err := listOktaGroups(ctx, ctl, params)
+	if err != nil {
+		return nil, fmt.Errorf("listing the Okta %s groups: %w", namer.Prefix, err)
+	}
+	// Rule-derived memberships are only resolved when the membership mode needs them
+	membership := NewOktaMembership(ctx, ctl, viper.GetString("OKTA_MEMBERSHIP"))
 	for _, g := range oktaGroups {
-		gr := OktaGroup{ID: g.Id, Name: strings.Split(g.Profile.Name, "dev_")[1], Users: []string{}, Deprovisioned: []string{}}
+		name, ok, err := namer.Name(g.Profile.Name)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		gr := OktaGroup{ID: g.Id, Name: name, OktaName: g.Profile.Name, Users: []string{}, Deprovisioned: []string{}}
 		// Fetch and store the group users
-		users, _, err := ctl.Group.ListGroupUsers(ctx, g.Id, nil)
-		cobra.CheckErr(err)
-
-		for _, u := range users {
-			if u.Status == "DEPROVISIONED" || u.Status == "SUSPENDED" {
-				gr.Deprovisioned = append(gr.Deprovisioned, u.Id)
-			} else {
-				gr.Users = append(gr.Users, u.Id)
-			}
-
+		gr.Users, gr.Deprovisioned, err = oktaMembers.listGroupUsers(ctx, ctl, g)
+		if err == nil {
+			gr.Users, gr.Deprovisioned, err = membership.Resolve(g.Id, gr.Users, gr.Deprovisioned)
+		}
+		if err != nil {
+			warnDataQuality("Okta group %s is not synced: %v", gr.Name, err)
+			continue
 		}
 		groups = append(groups, gr)
 	}
-	return
+	return groups, nil
 }
 
-// GetGitlabGroupMembers given a (part of) group name finds the group in Gitlab.
-// Returns the group members and the group ID.
-func GetGitlabGroupMembers(clt *gitlab.Client, name string) (members []*gitlab.GroupMember, id int) {
-	groups, _, err := clt.Groups.ListGroups(&gitlab.ListGroupsOptions{
-		Search: &name,
-	})
-	cobra.CheckErr(err)
-	// Gitlab search returns a slice of len 1, so we take the ID of the 0 element
-	id = groups[0].ID
-	// List the group members
-	users, _, err := clt.Groups.ListAllGroupMembers(id, &gitlab.ListGroupMembersOptions{
-		ListOptions: gitlab.ListOptions{PerPage: 100},
-	})
-	cobra.CheckErr(err)
-	// Take only those with developer access level or less
+// ListOktaGroupUsers lists the users of the Okta group.
+// Returns the IDs of the active users and of the deprovisioned or suspended users.
+func ListOktaGroupUsers(ctx context.Context, ctl *okta.Client, id string) (active, deprovisioned []string, err error) {
+	active, deprovisioned = []string{}, []string{}
+	users, err := listOktaGroupUsers(ctx, ctl, id)
+	if err != nil {
+		return nil, nil, fmt.Errorf("listing the users of Okta group %s: %w", id, err)
+	}
+
 	for _, u := range users {
-		if u.AccessLevel < 50 {
-			members = append(members, u)
+		if u.Profile != nil {
+			recordOktaProfile(u.Id, *u.Profile)
+		}
+		if u.Status == "DEPROVISIONED" || u.Status == "SUSPENDED" {
+			deprovisioned = append(deprovisioned, u.Id)
+		} else {
+			active = append(active, u.Id)
 		}
 	}
 	return
 }
 
-// getSetIntersection returns the intersection of two sets.
-// Used to identify which group members exist both in the okta developers group and the afkl-mcp group.
-func getSetIntersection(a, b []string) (c []string) {
-	m := make(map[string]bool)
+// recordOktaProfile keeps the attributes of the profile of the Okta user the sync depends on.
+func recordOktaProfile(id string, profile map[string]interface{}) {
+	recordAccessLevel(id, profile)
+	recordUserType(id, profile)
+	recordReadOnly(id, profile)
+	recordGithubLogin(id, profile)
+	oktaMembers.observe(id, profile)
+}
 
-	for _, item := range a {
-		m[item] = true
+// GetGitlabGroupMembers given a (part of) group name finds the group in Gitlab.
+// Returns the group members and the group ID.
+func GetGitlabGroupMembers(clt *gitlab.Client, name string) (members []*gitlab.GroupMember, id int, err error) {
+	if id, err = FindGitlabGroupID(clt, name); err != nil {
+		return nil, 0, err
 	}
+	members, err = ListGitlabGroupMembers(clt, id)
+	return members, id, err
+}
 
-	for _, item := range b {
-		if _, ok := m[item]; ok {
-			c = append(c, item)
+// FindGitlabGroupID finds the Gitlab group with the full path, or else the name, and returns its ID.
+// Names containing a / are full paths, looked up exactly. Other names are searched, and the group whose
+// path or name is the name is preferred over the groups that only contain it. A name matching several groups
+// is an error listing their full paths, rather than a guess.
+func FindGitlabGroupID(clt *gitlab.Client, name string) (int, error) {
+	if strings.Contains(name, "/") {
+		return findGitlabGroupByPath(clt, name)
+	}
+	found, _, err := clt.Groups.ListGroups(&gitlab.ListGroupsOptions{
+		ListOptions: gitlab.ListOptions{PerPage: 100},
+		Search:      &name,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("searching the Gitlab group %s: %w", name, err)
+	}
+	// Only consider the groups in the configured top-level namespaces
+	groups := make([]*gitlab.Group, 0, len(found))
+	exact := make([]*gitlab.Group, 0, 1)
+	for _, g := range found {
+		if !inGitlabNamespaces(g.FullPath) {
+			continue
+		}
+		groups = append(groups, g)
+		if strings.EqualFold(g.Path, name) || strings.EqualFold(g.Name, name) {
+			exact = append(exact, g)
 		}
 	}
-	return
+	if len(exact) > 0 {
+		groups = exact
+	}
+	switch len(groups) {
+	case 0:
+		return 0, fmt.Errorf("no Gitlab group matches %s", name)
+	case 1:
+		if len(exact) == 1 && len(found) > 1 {
+			warnDataQuality("Gitlab search for %s matched %d groups, using %s whose name is %s", name, len(found), groups[0].FullPath, name)
+		}
+		return groups[0].ID, nil
+	}
+	paths := make([]string, 0, len(groups))
+	for _, g := range groups {
+		paths = append(paths, g.FullPath)
+	}
+	return 0, fmt.Errorf("%d Gitlab groups match %s: %s, use the full path of the group", len(groups), name, strings.Join(paths, ", "))
 }
 
-// getSetDifference returns the difference of two sets.
-// Used to identify which users in the afkl-mcp group haven't been granted access to the given dev group in Gitlab.
-func getSetDifference(a, b []string) (c []string) {
-	m := make(map[string]bool)
+// findGitlabGroupByPath looks up the Gitlab group with the full path, in the configured namespaces.
+func findGitlabGroupByPath(clt *gitlab.Client, fullPath string) (int, error) {
+	group, resp, err := clt.Groups.GetGroup(strings.Trim(fullPath, "/"), &gitlab.GetGroupOptions{WithProjects: gitlab.Bool(false)})
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return 0, fmt.Errorf("no Gitlab group %s", fullPath)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("getting the Gitlab group %s: %w", fullPath, err)
+	}
+	if !inGitlabNamespaces(group.FullPath) {
+		return 0, fmt.Errorf("the Gitlab group %s is outside of GITLAB_NAMESPACES", group.FullPath)
+	}
+	return group.ID, nil
+}
 
-	for _, item := range b {
-		m[item] = true
+// ListGitlabGroupMembers lists the members of the Gitlab group with the given ID.
+func ListGitlabGroupMembers(clt *gitlab.Client, id int) ([]*gitlab.GroupMember, error) {
+	users, resp, err := clt.Groups.ListAllGroupMembers(id, &gitlab.ListGroupMembersOptions{
+		ListOptions: gitlab.ListOptions{PerPage: 100},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing the members of Gitlab group %d: %w", id, err)
 	}
+	if resp.NextPage != 0 {
+		warnDataQuality("Gitlab group %d has more members than fit in one page, only the first page was read", id)
+	}
+	// Owners are listed too, the members at or above PROTECTED_ACCESS_LEVEL are marked protected when matched
+	return users, nil
+}
 
-	for _, item := range a {
-		if _, ok := m[item]; !ok {
-			c = append(c, item)
-		}
+// GetGitlabGroupSkipReason checks whether the Gitlab group is archived or marked for deletion.
+// Returns a human readable reason when the group must not be synced, an empty string otherwise.
+func GetGitlabGroupSkipReason(clt *gitlab.Client, id int) (string, error) {
+	var opt *gitlabGroupOptions
+	if viper.GetBool("LEAN_API") {
+		opt = &gitlabGroupOptions{WithProjects: gitlab.Bool(false)}
 	}
-	return
+	req, err := clt.NewRequest(http.MethodGet, fmt.Sprintf("groups/%d", id), opt, nil)
+	if err != nil {
+		return "", err
+	}
+	status := new(GitlabGroupStatus)
+	if _, err = clt.Do(req, status); err != nil {
+		return "", fmt.Errorf("getting Gitlab group %d: %w", id, err)
+	}
+	switch {
+	case status.Archived:
+		return "group is archived", nil
+	case status.MarkedForDeletionOn != nil:
+		return fmt.Sprintf("group is marked for deletion on %s", status.MarkedForDeletionOn), nil
+	}
+	return "", nil
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -236,10 +466,21 @@ func Execute() {
 func init() {
 	cobra.OnInitialize(initConfig)
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", ".env.yaml", "config file (default is $HOME/.psync.yaml)")
+	cobra.CheckErr(rootCmd.RegisterFlagCompletionFunc("config", completeConfigFiles))
+	rootCmd.PersistentFlags().Bool("strict", false, "fail the run on any data-quality warning")
+	cobra.CheckErr(viper.BindPFlag("STRICT", rootCmd.PersistentFlags().Lookup("strict")))
+	rootCmd.PersistentFlags().String("env", "", "named environment of the config to use, see ENVIRONMENTS")
+	cobra.CheckErr(viper.BindPFlag("ENVIRONMENT", rootCmd.PersistentFlags().Lookup("env")))
+	rootCmd.PersistentFlags().String("target", "", "target to sync to: gitlab, scim or github, see TARGET")
+	cobra.CheckErr(viper.BindPFlag("TARGET", rootCmd.PersistentFlags().Lookup("target")))
+	rootCmd.PersistentFlags().String("output", "", "output format: text, or json for one JSON record per action on stdout")
+	cobra.CheckErr(viper.BindPFlag("OUTPUT", rootCmd.PersistentFlags().Lookup("output")))
+	rootCmd.PersistentFlags().String("log-level", "", "level of the logs: debug, info, warn or error")
+	cobra.CheckErr(viper.BindPFlag("LOG_LEVEL", rootCmd.PersistentFlags().Lookup("log-level")))
+	rootCmd.PersistentFlags().String("log-format", "", "format of the logs: text, json, gcp for Cloud Logging, or auto")
+	cobra.CheckErr(viper.BindPFlag("LOG_FORMAT", rootCmd.PersistentFlags().Lookup("log-format")))
 
-	// Cobra also supports local flags, which will only run
-	// when this action is called directly.
-	// rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	rootCmd.Flags().BoolVar(&dryRun, "dry-run", false, "print the changes of the sync without making them, see psync plan")
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -259,8 +500,144 @@ func initConfig() {
 
 	viper.AutomaticEnv() // read in environment variables that match
 
+	setConfigDefaults()
+
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
 		_, _ = fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
+		// Layer the included files and interpolate environment variables
+		cobra.CheckErr(mergeConfigFile(viper.ConfigFileUsed(), 0))
+	}
+	cobra.CheckErr(applyEnvironment(viper.GetString("ENVIRONMENT")))
+	cobra.CheckErr(setupOutput(viper.GetString("OUTPUT")))
+	cobra.CheckErr(setupLogging(viper.GetString("LOG_LEVEL"), viper.GetString("LOG_FORMAT")))
+	cobra.CheckErr(setupTracing(viper.GetString("TRACING"), viper.GetString("TRACING_ENDPOINT")))
+}
+
+// setConfigDefaults sets the defaults of the config keys.
+func setConfigDefaults() {
+	// Percentage of the Okta rate limit left at which discovery starts slowing down
+	viper.SetDefault("OKTA_RATE_LIMIT_THRESHOLD", 20)
+	// Location of the state of the runs: a local file, sqlite:PATH with the history of the runs,
+	// postgres://user@host/database shared by several instances, gs://bucket/object or firestore://project/collection/document
+	viper.SetDefault("STATE_FILE", ".psync-state.json")
+	// How long a run waits for the run of another instance sharing a PostgreSQL state to end
+	viper.SetDefault("STATE_LOCK_TIMEOUT", "10m")
+	// Append-only file of the membership changes, see psync history
+	viper.SetDefault("AUDIT_FILE", ".psync-audit.jsonl")
+	// How memberships derived from Okta group rules are treated: okta, direct or nested
+	viper.SetDefault("OKTA_MEMBERSHIP", MembershipOkta)
+	// Sync the Okta groups named dev_<gitlab group>
+	viper.SetDefault("OKTA_GROUP_PREFIX", "dev_")
+	viper.SetDefault("OKTA_GROUP_NAME_TEMPLATE", "{{.Suffix}}")
+	// Boolean custom attribute of the Okta group profiles that must be true for the groups to be synced,
+	// e.g. psyncManaged, none to select the groups by name only
+	viper.SetDefault("OKTA_GROUP_ATTRIBUTE", "")
+	// Users in more groups than the threshold are flagged or blocked, unless listed in MULTI_GROUP_REVIEWED
+	viper.SetDefault("MULTI_GROUP_THRESHOLD", 5)
+	viper.SetDefault("MULTI_GROUP_ACTION", MultiGroupFlag)
+	// Stages of the sync pipeline, applied in order
+	viper.SetDefault("PIPELINE_TRANSFORMS", []string{})
+	viper.SetDefault("PIPELINE_POLICIES", []string{"jit", "freeze", "multi_group", "empty_group", "strict", "recert"})
+	// Okta groups, by name in Okta or ID, whose members get a Gitlab membership for JIT_DURATION only
+	viper.SetDefault("JIT_GROUPS", []string{})
+	viper.SetDefault("JIT_DURATION", 8*time.Hour)
+	// Time between two sweeps of the lapsed grants by psync serve
+	viper.SetDefault("JIT_SWEEP_INTERVAL", 5*time.Minute)
+	// Highest access level an Okta profile attribute can request, unless set per group in GROUP_ACCESS_LEVEL_CEILINGS
+	viper.SetDefault("ACCESS_LEVEL_CEILING", "developer")
+	// Verify the Okta token and its roles before reading from Okta
+	viper.SetDefault("OKTA_PRECHECK", true)
+	// Days before the Gitlab token expires from which runs warn about it
+	viper.SetDefault("GITLAB_TOKEN_EXPIRY_WARNING_DAYS", 14)
+	// Where psync records the Gitlab memberships it made, for RECONCILE: state, or custom_attribute on self-managed Gitlab
+	viper.SetDefault("MANAGED_MARKER", "state")
+	// OKTA_SANDBOX and GITLAB_SANDBOX label the instances as sandboxes, Okta preview orgs are by default.
+	// Runs mixing a sandbox with production are plan-only.
+	viper.SetDefault("GITLAB_SANDBOX", false)
+	// Time between two digests of the changes, sent to DIGEST_RECIPIENT when DIGEST_NOTIFIER is set
+	viper.SetDefault("DIGEST_INTERVAL", 24*time.Hour)
+	// Age after which the Okta group members cached with OKTA_MEMBERSHIP_CACHE are listed again
+	viper.SetDefault("OKTA_MEMBERSHIP_CACHE_MAX_AGE", 24*time.Hour)
+	// Project the recertification review issues are opened in, see psync recert
+	viper.SetDefault("RECERT_PROJECT", "")
+	// Label of the review issues confirming all the members under review
+	viper.SetDefault("RECERT_CONFIRMED_LABEL", "recert::confirmed")
+	// Read only the groups concerned by the webhook events, using the group mappings of the state,
+	// instead of discovering all the groups. Sources that can't read single groups always discover them.
+	viper.SetDefault("WEBHOOK_SPARSE", true)
+	// Appended to the psync version and run ID in the User-Agent of the Okta, Gitlab and GCP requests,
+	// e.g. the name of the deployment, so the providers can attribute the traffic
+	viper.SetDefault("USER_AGENT_SUFFIX", "")
+	// Output format: text, or json to write the actions of the runs and plans as JSON records on stdout,
+	// the messages then going to stderr
+	viper.SetDefault("OUTPUT", OutputText)
+	// Level of the logs of the runs: debug, info, warn or error
+	viper.SetDefault("LOG_LEVEL", "info")
+	// Format of the logs of the runs: text, json for one JSON object per line, gcp for structured Cloud Logging
+	// entries with severity and labels and an entry per change, or auto for gcp on Cloud Functions and Cloud Run
+	viper.SetDefault("LOG_FORMAT", "auto")
+	// Directory of the membership snapshots, see psync snapshot
+	viper.SetDefault("SNAPSHOT_DIR", ".psync-snapshots")
+	// Action on the Gitlab groups emptied because their Okta group has no members left, with EMPTY_GROUP_REMOVALS:
+	// alert, archive the group, or transfer it under the EMPTY_GROUP_HOLDING_GROUP
+	viper.SetDefault("EMPTY_GROUP_ACTION", EmptyGroupAlert)
+	// Full path of the Gitlab group the emptied groups are transferred to, e.g. archive/teams
+	viper.SetDefault("EMPTY_GROUP_HOLDING_GROUP", "")
+	// Export of the spans of the runs: none, or otlp over OTLP/HTTP
+	viper.SetDefault("TRACING", "none")
+	// OTLP/HTTP endpoint of the spans, e.g. http://localhost:4318/v1/traces; the OTEL_EXPORTER_OTLP_* variables apply if unset
+	viper.SetDefault("TRACING_ENDPOINT", "")
+	// Gitlab members with this access level or a higher one are read but never changed: reporter, developer, maintainer or owner
+	viper.SetDefault("PROTECTED_ACCESS_LEVEL", "owner")
+	// System the groups are read from: okta, azure for Azure AD (Entra ID), or ldap
+	viper.SetDefault("IDENTITY_PROVIDER", "okta")
+	// Azure AD user property matching the Gitlab SAML identities: id, the object ID, or userPrincipalName
+	viper.SetDefault("AZURE_USER_ID_ATTRIBUTE", "id")
+	// Directory search of the ldap identity provider, {dn} is replaced with the DN of the group.
+	// The member filter resolves nested Active Directory groups.
+	viper.SetDefault("LDAP_GROUP_FILTER", "(objectClass=group)")
+	viper.SetDefault("LDAP_GROUP_NAME_ATTRIBUTE", "cn")
+	viper.SetDefault("LDAP_MEMBER_FILTER", "(&(objectClass=user)(memberOf:1.2.840.113556.1.4.1941:={dn}))")
+	viper.SetDefault("LDAP_USER_ID_ATTRIBUTE", "userPrincipalName")
+	viper.SetDefault("LDAP_TIMEOUT", 45*time.Second)
+	// System the memberships are synced to: gitlab, scim for the SCIM_URL service provider, or github for the GITHUB_ORG teams
+	viper.SetDefault("TARGET", "gitlab")
+	// Gitlab instance and parent group, see ParentGroups
+	viper.SetDefault("GITLAB_URL", "https://gitlab.com")
+	viper.SetDefault("GITLAB_PARENT_GROUP", afklGroup)
+	// GitHub API of the github target, and the Okta profile attribute with the GitHub login of the users
+	viper.SetDefault("GITHUB_URL", "https://api.github.com")
+	viper.SetDefault("GITHUB_LOGIN_ATTRIBUTE", "githubUsername")
+	// Retries of the changes that failed, with a doubling wait unless RETRY_BACKOFF is fixed or decorrelated_jitter
+	viper.SetDefault("RETRY_ATTEMPTS", 4)
+	viper.SetDefault("RETRY_WAIT", 2*time.Second)
+	viper.SetDefault("RETRY_BACKOFF", "exponential")
+	viper.SetDefault("RETRY_MAX_WAIT", time.Minute)
+	// Skip fetching the fields psync doesn't use, such as the projects of groups and the credentials of users
+	viper.SetDefault("LEAN_API", true)
+	// Okta profile attribute whose value routes users with USER_TYPE_ROUTES
+	viper.SetDefault("USER_TYPE_ATTRIBUTE", "userType")
+	// Wiki page of RUN_LOG_PROJECT the run summaries are appended to
+	viper.SetDefault("RUN_LOG_PAGE", "psync-runs")
+	// Timeout of the Grafana annotations of the change windows, see GRAFANA_URL
+	viper.SetDefault("GRAFANA_TIMEOUT", 10*time.Second)
+	// Per provider request timeouts, and circuit breakers failing fast after consecutive failures
+	for _, provider := range []string{"OKTA", "AZURE", "GITLAB", "SCIM", "GITHUB"} {
+		viper.SetDefault(provider+"_TIMEOUT", 45*time.Second)
+		viper.SetDefault(provider+"_BREAKER_THRESHOLD", 5)
+		viper.SetDefault(provider+"_BREAKER_COOLDOWN", time.Minute)
 	}
+	// Send the summary email of the runs without changes to SUMMARY_EMAIL_RECIPIENTS too
+	viper.SetDefault("SUMMARY_EMAIL_UNCHANGED", false)
+	// Time each request of psync cloudrun works on the run, the groups it applies between two checkpoints,
+	// and the age after which a run left unfinished is dropped
+	viper.SetDefault("CLOUDRUN_SLICE", 4*time.Minute)
+	viper.SetDefault("CLOUDRUN_CHUNK_GROUPS", 5)
+	viper.SetDefault("CLOUDRUN_RESUME_MAX_AGE", 24*time.Hour)
+	// Messages sent to users added to or removed from a group when NOTIFY_USERS is set
+	viper.SetDefault("SMTP_PORT", 587)
+	viper.SetDefault("NOTIFY_SUBJECT_TEMPLATE", "Your access to the {{.Group}} Gitlab group was {{.Action}}")
+	viper.SetDefault("NOTIFY_TEMPLATE", `You were {{.Action}} {{if eq .Action "added"}}to{{else}}from{{end}} the {{.Group}} Gitlab group `+
+		`because of your membership of the {{.OktaGroup}} Okta group.{{if .Contact}} Questions? Contact {{.Contact}}.{{end}}`)
 }
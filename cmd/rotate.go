@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/okta/okta-sdk-golang/v2/okta"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// rotateCheckCmd verifies the latest versions of the API tokens and activates them
+var rotateCheckCmd = &cobra.Command{
+	Use:   "rotate-check",
+	Short: "Verify the latest API token versions and make them active",
+	Long: `Fetch the latest versions of the OKTA_SECRET and GITLAB_SECRET secrets and make an authenticated call with each.
+Only the versions that work are recorded as active in the state, and used by the next runs instead of the configured versions.
+Rotate a token by adding a new secret version, running rotate-check, and disabling the old version once it passes.
+Tokens given directly with OKTA_TOKEN, GITLAB_TOKEN or their _TOKEN_FILE take precedence over the secrets and aren't rotated.`,
+	Example: `  psync rotate-check`,
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := NewStateStore()
+		cobra.CheckErr(err)
+		state, err := store.Load()
+		cobra.CheckErr(err)
+
+		checks := map[string]func(token string) error{
+			"OKTA_SECRET":   checkOktaToken,
+			"GITLAB_SECRET": checkGitlabToken,
+		}
+		failed := 0
+		for _, key := range []string{"OKTA_SECRET", "GITLAB_SECRET"} {
+			version, token, err := AccessSecretVersion(latestSecretVersion(viper.GetString(key)))
+			if err == nil {
+				err = checks[key](string(token))
+			}
+			if err != nil {
+				fmt.Printf("%s: latest version not activated: %v\n", key, err)
+				failed++
+				continue
+			}
+			if state.Secrets[key] == version {
+				fmt.Printf("%s: %s is already active\n", key, version)
+				continue
+			}
+			state.Secrets[key] = version
+			fmt.Printf("%s: activated %s\n", key, version)
+		}
+		cobra.CheckErr(store.Save(state))
+		if failed > 0 {
+			cobra.CheckErr(fmt.Errorf("%d secrets failed the check, the previous versions stay active", failed))
+		}
+	},
+}
+
+// latestSecretVersion returns the name of the latest version of the secret of a secret version name.
+func latestSecretVersion(name string) string {
+	if i := strings.Index(name, "/versions/"); i >= 0 {
+		name = name[:i]
+	}
+	return name + "/versions/latest"
+}
+
+// activeSecret returns the secret version to read the secret of the config key from:
+// the version activated by rotate-check if any, the configured one otherwise.
+func activeSecret(state *State, key string) string {
+	if v, ok := state.Secrets[key]; ok {
+		return v
+	}
+	return viper.GetString(key)
+}
+
+// checkOktaToken makes an authenticated Okta call with the token.
+func checkOktaToken(token string) error {
+	ctx, client, err := okta.NewClient(context.Background(),
+		okta.WithOrgUrl(viper.GetString("OKTA_ORG_URL")),
+		okta.WithToken(token),
+		okta.WithRequestTimeout(int64(viper.GetDuration("OKTA_TIMEOUT").Seconds())),
+		okta.WithUserAgentExtra(userAgent()),
+		okta.WithCache(false))
+	if err != nil {
+		return err
+	}
+	return checkOktaHealth(ctx, client)
+}
+
+// checkGitlabToken makes an authenticated Gitlab call with the token.
+func checkGitlabToken(token string) error {
+	transport, err := NewTransport("GITLAB")
+	if err != nil {
+		return err
+	}
+	clt, err := gitlab.NewClient(token, gitlab.WithBaseURL(viper.GetString("GITLAB_URL")), gitlab.WithHTTPClient(&http.Client{
+		Transport: &UserAgentTransport{Next: transport},
+		Timeout:   viper.GetDuration("GITLAB_TIMEOUT"),
+	}))
+	if err != nil {
+		return err
+	}
+	_, _, err = clt.Users.CurrentUser()
+	return err
+}
+
+func init() {
+	rootCmd.AddCommand(rotateCheckCmd)
+}
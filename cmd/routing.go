@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// oktaUserTypes collects the user types, from the USER_TYPE_ATTRIBUTE profile attribute,
+// of the Okta users listed during the run, by user ID
+var oktaUserTypes = map[string]string{}
+
+// recordUserType keeps the type in the profile of the Okta user, e.g. employee or contractor.
+func recordUserType(id string, profile map[string]interface{}) {
+	if t, ok := profile[viper.GetString("USER_TYPE_ATTRIBUTE")].(string); ok && t != "" {
+		runMu.Lock()
+		oktaUserTypes[id] = strings.ToLower(t)
+		runMu.Unlock()
+	}
+}
+
+// userTypeRoutes returns the targets the users of the Okta group are routed to by user type,
+// configured in USER_TYPE_ROUTES, e.g. contractors to an external group as reporters.
+func userTypeRoutes(group string) (map[string]TierTarget, error) {
+	routes := map[string]map[string]TierTarget{}
+	if err := viper.UnmarshalKey("USER_TYPE_ROUTES", &routes); err != nil {
+		return nil, err
+	}
+	return routes[strings.ToLower(group)], nil
+}
+
+// splitByUserType returns the group without the users of the type, and the group of the users of the type only.
+func splitByUserType(g OktaGroup, userType string) (rest, typed OktaGroup) {
+	runMu.Lock()
+	defer runMu.Unlock()
+	rest, typed = g, g
+	rest.Users, typed.Users = []string{}, []string{}
+	rest.Deprovisioned, typed.Deprovisioned = []string{}, []string{}
+	for _, u := range g.Users {
+		if oktaUserTypes[u] == userType {
+			typed.Users = append(typed.Users, u)
+		} else {
+			rest.Users = append(rest.Users, u)
+		}
+	}
+	for _, u := range g.Deprovisioned {
+		if oktaUserTypes[u] == userType {
+			typed.Deprovisioned = append(typed.Deprovisioned, u)
+		} else {
+			rest.Deprovisioned = append(rest.Deprovisioned, u)
+		}
+	}
+	return
+}
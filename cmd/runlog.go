@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// runLogIntro opens the run log page, explaining why memberships change
+const runLogIntro = `# psync runs
+
+Members of the Okta dev_ groups are added to their Gitlab groups, and removed when they are deprovisioned
+or suspended in Okta or their just-in-time grant lapses. Each run appends its summary below.
+`
+
+// publishRunLog appends the summary of the run to the RUN_LOG_PAGE wiki page of the RUN_LOG_PROJECT project,
+// so that engineers without access to psync can see when and why memberships changed. Does nothing if not configured.
+func publishRunLog(clt *gitlab.Client, run *Run) error {
+	project := viper.GetString("RUN_LOG_PROJECT")
+	if project == "" {
+		return nil
+	}
+	page := viper.GetString("RUN_LOG_PAGE")
+	entry := runLogEntry(run)
+
+	wiki, resp, err := clt.Wikis.GetWikiPage(project, page, nil)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		_, _, err = clt.Wikis.CreateWikiPage(project, &gitlab.CreateWikiPageOptions{
+			Title:   gitlab.String(page),
+			Content: gitlab.String(runLogIntro + entry),
+		})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	_, _, err = clt.Wikis.EditWikiPage(project, page, &gitlab.EditWikiPageOptions{
+		Content: gitlab.String(strings.TrimRight(wiki.Content, "\n") + "\n" + entry),
+	})
+	return err
+}
+
+// runLogEntry formats the summary and the changes of the run as markdown.
+func runLogEntry(run *Run) string {
+	s := run.Summary
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n## %s run %s\n\n", s.Finished.UTC().Format(time.RFC3339), run.ID)
+	fmt.Fprintf(&b, "Result: %s, drift %d, external drift %d, added %d, removed %d, skipped %d groups, %d conflicts, %d warnings, %d alerts.\n",
+		s.Result, s.Drift, s.ExternalDrift, s.Added, s.Removed, s.Skipped, s.Conflicts, s.Warnings, s.Alerts)
+	if s.Quota != nil {
+		fmt.Fprintf(&b, "Quota: %s.\n", s.Quota)
+	}
+	sections := []struct {
+		title string
+		items []string
+	}{
+		{"Changes", run.Changes},
+		{"Changes made outside of psync", run.ExternalDrift},
+		{"Skipped groups", run.Skipped},
+		{"Conflicts", run.Conflicts},
+		{"Alerts", alerts},
+	}
+	for _, sec := range sections {
+		if len(sec.items) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n%s:\n\n", sec.title)
+		for _, item := range sec.items {
+			fmt.Fprintf(&b, "- %s\n", item)
+		}
+	}
+	return b.String()
+}
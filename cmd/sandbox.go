@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// oktaSandbox tells whether OKTA_ORG_URL is a sandbox org: OKTA_SANDBOX if set, otherwise whether it is an Okta preview org.
+func oktaSandbox() bool {
+	if viper.IsSet("OKTA_SANDBOX") {
+		return viper.GetBool("OKTA_SANDBOX")
+	}
+	u, err := url.Parse(viper.GetString("OKTA_ORG_URL"))
+	return err == nil && strings.HasSuffix(u.Hostname(), ".oktapreview.com")
+}
+
+// gitlabSandbox tells whether GITLAB_URL is a sandbox instance, set with GITLAB_SANDBOX.
+func gitlabSandbox() bool {
+	return viper.GetBool("GITLAB_SANDBOX")
+}
+
+func sandboxLabel(sandbox bool) string {
+	if sandbox {
+		return "sandbox"
+	}
+	return "production"
+}
+
+// orgCombination labels the Okta org and Gitlab instance the run reads, e.g.
+// "Okta sandbox https://acme.oktapreview.com, Gitlab production https://gitlab.com".
+func orgCombination() string {
+	return fmt.Sprintf("Okta %s %s, Gitlab %s %s", sandboxLabel(oktaSandbox()), viper.GetString("OKTA_ORG_URL"),
+		sandboxLabel(gitlabSandbox()), viper.GetString("GITLAB_URL"))
+}
+
+// mixedOrgs tells whether the run combines a sandbox with production, e.g. a sandbox Okta org to evaluate
+// an upcoming restructure of the Okta groups against the production Gitlab groups.
+// The group IDs of the sandbox don't exist in production, so such runs are only planned, never applied.
+func mixedOrgs() bool {
+	return oktaSandbox() != gitlabSandbox()
+}
+
+// checkApplyOrgs refuses to apply the changes of a run that mixes a sandbox with production.
+func checkApplyOrgs() error {
+	if mixedOrgs() {
+		return fmt.Errorf("refusing to sync %s, mixing a sandbox with production is plan-only, use psync plan", orgCombination())
+	}
+	return nil
+}
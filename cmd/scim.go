@@ -0,0 +1,252 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+
+	"psync/internal/set"
+)
+
+// scimContentType is the media type of SCIM 2.0 requests and responses
+const scimContentType = "application/scim+json"
+
+// ScimTarget pushes the memberships to the groups of a SCIM 2.0 service provider at SCIM_URL.
+// SCIM users are matched to Okta users by their externalId, and SCIM groups to Okta groups by their displayName.
+// Like in Gitlab, active Okta group members are added and deprovisioned or suspended ones removed.
+type ScimTarget struct {
+	URL    string
+	Token  string
+	Client *http.Client
+
+	// users maps the externalId of the SCIM users, the Okta user ID, to their SCIM ID
+	users map[string]scimUser
+	// groups maps the Okta group IDs to the IDs of their SCIM groups
+	groups map[string]string
+}
+
+type scimUser struct {
+	ID         string `json:"id"`
+	ExternalID string `json:"externalId"`
+	UserName   string `json:"userName"`
+}
+
+type scimGroup struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"displayName"`
+	Members     []struct {
+		Value string `json:"value"`
+	} `json:"members"`
+}
+
+type scimList struct {
+	TotalResults int               `json:"totalResults"`
+	Resources    []json.RawMessage `json:"Resources"`
+}
+
+// NewScimTarget connects to the SCIM service provider with the bearer token stored in the SCIM_TOKEN_SECRET secret.
+func NewScimTarget() (*ScimTarget, error) {
+	base := strings.TrimRight(viper.GetString("SCIM_URL"), "/")
+	if base == "" {
+		return nil, fmt.Errorf("the scim target requires SCIM_URL")
+	}
+	token, err := credential(nil, "SCIM_TOKEN_SECRET")
+	if err != nil {
+		return nil, err
+	}
+	transport, err := NewTransport("SCIM")
+	if err != nil {
+		return nil, err
+	}
+	return &ScimTarget{
+		URL:   base,
+		Token: strings.TrimSpace(string(token)),
+		Client: &http.Client{
+			Transport: transportHook("SCIM", &TracingTransport{Provider: "scim", Next: &MetricsTransport{Provider: "scim", Next: NewCircuitBreaker("SCIM", transport)}}),
+			Timeout:   viper.GetDuration("SCIM_TIMEOUT"),
+		},
+	}, nil
+}
+
+// Name returns the name of the target with the host of the service provider.
+func (t *ScimTarget) Name() string {
+	if u, err := url.Parse(t.URL); err == nil {
+		return "scim " + u.Host
+	}
+	return "scim"
+}
+
+// Supports reports the capabilities of the target. SCIM 2.0 defines none of them.
+func (t *ScimTarget) Supports(c Capability) bool {
+	return false
+}
+
+// Plan matches the Okta groups to the SCIM groups and computes their membership changes.
+// Okta groups without a SCIM group are skipped.
+func (t *ScimTarget) Plan(run *Run, groups []OktaGroup) ([]GroupSync, error) {
+	users, err := t.listUsers()
+	if err != nil {
+		return nil, err
+	}
+	t.users = users
+	t.groups = make(map[string]string, len(groups))
+	known := make([]string, 0, len(users))
+	byID := make(map[string]scimUser, len(users))
+	for externalID, u := range users {
+		known = append(known, externalID)
+		byID[u.ID] = u
+	}
+
+	syncs := make([]GroupSync, 0, len(groups))
+	for _, g := range groups {
+		group, err := t.findGroup(g.Name)
+		if err != nil {
+			return nil, err
+		}
+		if group == nil {
+			run.report(&run.Skipped, "%s: no SCIM group with this name", g.Name)
+			continue
+		}
+		t.groups[g.ID] = group.ID
+		// SCIM members are keyed by their externalId, which is the Okta user ID; members provisioned by other sources have none and are left alone
+		members := make([]GitlabMember, 0, len(group.Members))
+		present := make([]string, 0, len(group.Members))
+		for _, m := range group.Members {
+			if u, ok := byID[m.Value]; ok && u.ExternalID != "" {
+				members = append(members, GitlabMember{User: &gitlab.GroupMember{Username: u.UserName}, SAMLID: u.ExternalID})
+				present = append(present, u.ExternalID)
+			}
+		}
+		syncs = append(syncs, GroupSync{
+			Group:   g,
+			Members: members,
+			Plan: GroupPlan{
+				Add:    set.Difference(set.Intersection(g.Users, known), present),
+				Remove: set.Intersection(g.Deprovisioned, present),
+			},
+		})
+	}
+	return syncs, nil
+}
+
+// Apply patches the members of the SCIM groups, one user at a time, removals first.
+// Removals that fail raise a high-severity alert, the run fails if any addition fails.
+func (t *ScimTarget) Apply(run *Run, syncs []GroupSync) error {
+	errs := make([]string, 0)
+	for _, gs := range syncs {
+		g, groupID := gs.Group, t.groups[gs.Group.ID]
+		for _, id := range gs.Plan.Remove {
+			u := t.users[id]
+			op := map[string]interface{}{"op": "remove", "path": fmt.Sprintf("members[value eq %q]", u.ID)}
+			if err := t.patchGroup(groupID, op); err != nil {
+				run.emit(EventFailed, g.Name, id, err)
+				raiseHighAlert("could not remove %s from the SCIM group %s, they keep their access: %v", u.UserName, g.Name, err)
+				continue
+			}
+			run.Summary.Removed++
+			run.emit(EventRemoved, g.Name, id, nil)
+			run.reportChange(g.Name, "Removed %s from %s: deprovisioned or suspended in Okta", u.UserName, g.Name)
+			run.audit("removed", id, u.UserName, g.Name, 0, 0, 0)
+		}
+		for _, id := range gs.Plan.Add {
+			u := t.users[id]
+			op := map[string]interface{}{"op": "add", "path": "members", "value": []map[string]string{{"value": u.ID}}}
+			if err := t.patchGroup(groupID, op); err != nil {
+				run.emit(EventFailed, g.Name, id, err)
+				errs = append(errs, fmt.Sprintf("%s in %s: %v", u.UserName, g.Name, err))
+				continue
+			}
+			run.Summary.Added++
+			run.emit(EventAdded, g.Name, id, nil)
+			run.reportChange(g.Name, "Added %s to %s: active member of the Okta group", u.UserName, g.Name)
+			run.audit("added", id, u.UserName, g.Name, 0, 0, 0)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d changes failed:\n  %s", len(errs), strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+// listUsers lists all SCIM users with an externalId, by externalId.
+func (t *ScimTarget) listUsers() (map[string]scimUser, error) {
+	users := map[string]scimUser{}
+	for start := 1; ; {
+		var page scimList
+		q := url.Values{"startIndex": {strconv.Itoa(start)}, "count": {"100"}}
+		if err := t.do(http.MethodGet, "/Users?"+q.Encode(), nil, &page); err != nil {
+			return nil, err
+		}
+		for _, raw := range page.Resources {
+			var u scimUser
+			if err := json.Unmarshal(raw, &u); err != nil {
+				return nil, err
+			}
+			if u.ExternalID != "" {
+				users[u.ExternalID] = u
+			}
+		}
+		start += len(page.Resources)
+		if len(page.Resources) == 0 || start > page.TotalResults {
+			return users, nil
+		}
+	}
+}
+
+// findGroup returns the SCIM group with the display name, nil if there is none.
+func (t *ScimTarget) findGroup(name string) (*scimGroup, error) {
+	var page scimList
+	q := url.Values{"filter": {fmt.Sprintf("displayName eq %q", name)}}
+	if err := t.do(http.MethodGet, "/Groups?"+q.Encode(), nil, &page); err != nil {
+		return nil, err
+	}
+	if len(page.Resources) == 0 {
+		return nil, nil
+	}
+	if len(page.Resources) > 1 {
+		warnDataQuality("SCIM search for %s matched %d groups, using the first", name, len(page.Resources))
+	}
+	var summary scimGroup
+	if err := json.Unmarshal(page.Resources[0], &summary); err != nil {
+		return nil, err
+	}
+	// Service providers may leave the members out of search results
+	group := &scimGroup{}
+	if err := t.do(http.MethodGet, "/Groups/"+url.PathEscape(summary.ID), nil, group); err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+// patchGroup applies the PATCH operation to the SCIM group, retrying with backoff.
+func (t *ScimTarget) patchGroup(id string, op map[string]interface{}) error {
+	body := map[string]interface{}{
+		"schemas":    []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"},
+		"Operations": []interface{}{op},
+	}
+	return retryRequest(func() (*http.Response, error) {
+		return t.request(http.MethodPatch, "/Groups/"+url.PathEscape(id), body, nil)
+	})
+}
+
+// do sends a request to the service provider and decodes the response into v.
+func (t *ScimTarget) do(method, path string, body, v interface{}) error {
+	_, err := t.request(method, path, body, v)
+	return err
+}
+
+// request sends a SCIM request to the service provider, with the bearer token, and decodes the response into v, if not nil.
+func (t *ScimTarget) request(method, path string, body, v interface{}) (*http.Response, error) {
+	header := http.Header{
+		"Authorization": {"Bearer " + t.Token},
+		"Accept":        {scimContentType},
+		"Content-Type":  {scimContentType},
+	}
+	return restRequest(t.Client, header, method, t.URL, path, body, v)
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// failingTransport fails every request without a response, like a network error or an open circuit breaker.
+type failingTransport struct {
+	requests int
+}
+
+func (t *failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	t.requests++
+	return nil, errors.New("circuit breaker open")
+}
+
+func TestScimTargetPatchGroupTransportError(t *testing.T) {
+	viper.Set("RETRY_ATTEMPTS", 3)
+	viper.Set("RETRY_WAIT", time.Second)
+	viper.Set("RETRY_BACKOFF", "fixed")
+	t.Cleanup(viper.Reset)
+	saved := clock
+	clock = &ManualClock{T: time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)}
+	t.Cleanup(func() { clock = saved })
+
+	transport := &failingTransport{}
+	target := &ScimTarget{URL: "https://scim.example.com/v2", Client: &http.Client{Transport: transport}}
+	op := map[string]interface{}{"op": "remove", "path": `members[value eq "1"]`}
+	if err := target.patchGroup("g1", op); err == nil {
+		t.Fatalf("patchGroup() succeeded through a failing transport")
+	}
+	if transport.requests != 3 {
+		t.Errorf("%d requests, want the transport error retried up to 3 attempts", transport.requests)
+	}
+}
+
+func TestScimTargetRequestHeaders(t *testing.T) {
+	viper.Set("RETRY_ATTEMPTS", 1)
+	viper.Set("RETRY_BACKOFF", "fixed")
+	t.Cleanup(viper.Reset)
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Header().Set("Content-Type", scimContentType)
+		w.Write([]byte(`{"totalResults":0,"Resources":[]}`))
+	}))
+	defer srv.Close()
+
+	target := &ScimTarget{URL: srv.URL, Token: "secret", Client: srv.Client()}
+	var page scimList
+	if err := target.do(http.MethodGet, "/Groups", nil, &page); err != nil {
+		t.Fatalf("do() = %v", err)
+	}
+	if got.Get("Authorization") != "Bearer secret" || got.Get("Accept") != scimContentType {
+		t.Errorf("headers %v, want the bearer token and the SCIM media type", got)
+	}
+	if got.Get("Content-Type") != "" {
+		t.Errorf("Content-Type %q sent without a body", got.Get("Content-Type"))
+	}
+
+	if err := target.patchGroup("g1", map[string]interface{}{"op": "add"}); err != nil {
+		t.Fatalf("patchGroup() = %v", err)
+	}
+	if got.Get("Content-Type") != scimContentType {
+		t.Errorf("Content-Type %q, want %s", got.Get("Content-Type"), scimContentType)
+	}
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// TokenScopeError stops a run at the first membership change Gitlab forbids, e.g. after the token was rotated
+// to one without the api scope, or its user lost the owner role. The changes after it are not attempted.
+type TokenScopeError struct {
+	Group string
+	User  string
+	Err   error
+}
+
+func (e *TokenScopeError) Error() string {
+	return fmt.Sprintf("the Gitlab token lost the scope or role to change memberships, stopped at %s in %s: %v", e.User, e.Group, e.Err)
+}
+
+func (e *TokenScopeError) Unwrap() error {
+	return e.Err
+}
+
+// forbidden reports whether Gitlab refused the request with 403 Forbidden.
+func forbidden(err error) bool {
+	var resp *gitlab.ErrorResponse
+	return errors.As(err, &resp) && resp.Response != nil && resp.Response.StatusCode == http.StatusForbidden
+}
+
+// printStopReport lists the changes applied before the run stopped and the drift left, so the operator knows where it stopped.
+func printStopReport(run *Run, stop *TokenScopeError) {
+	logger.Error("Run stopped", "group", stop.Group, "user", stop.User, "applied", run.Summary.Added+run.Summary.Removed, "drift", run.Summary.Drift)
+	for _, c := range run.Changes {
+		logger.Info("Applied before the stop", "change", c)
+	}
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/viper"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// oktaReadOnly collects the Okta users flagged read-only with the READ_ONLY_ATTRIBUTE profile attribute,
+// during the run, by user ID
+var oktaReadOnly = map[string]bool{}
+
+// recordReadOnly keeps whether the Okta user is flagged read-only in their profile.
+func recordReadOnly(id string, profile map[string]interface{}) {
+	attr := viper.GetString("READ_ONLY_ATTRIBUTE")
+	if attr == "" {
+		return
+	}
+	readOnly := false
+	switch v := profile[attr].(type) {
+	case bool:
+		readOnly = v
+	case string:
+		readOnly, _ = strconv.ParseBool(v)
+	}
+	if readOnly {
+		runMu.Lock()
+		oktaReadOnly[id] = true
+		runMu.Unlock()
+	}
+}
+
+// memberAccessLevel returns the access level to add the Okta user to the Gitlab group of the plan with:
+// the level of the tier or mapping, or else the level of the user. Read-only users are added as guests
+// when READ_ONLY_GUEST is set, as guests take no seat on Gitlab.com Ultimate.
+func memberAccessLevel(gs GroupSync, userID string) gitlab.AccessLevelValue {
+	level := gs.AccessLevel
+	if level == 0 {
+		level = AccessLevelFor(gs.Group.Name, userID)
+	}
+	runMu.Lock()
+	readOnly := oktaReadOnly[userID]
+	runMu.Unlock()
+	if readOnly && viper.GetBool("READ_ONLY_GUEST") {
+		return gitlab.GuestPermissions
+	}
+	return level
+}
+
+// Seat impacts of an addition
+const (
+	SeatFreeGuest = "free guest"
+	SeatBillable  = "new billable seat"
+	SeatExisting  = "already billable"
+)
+
+// SeatPlanner tells the seat impact of the additions on Gitlab.com, where guests of Ultimate namespaces are free
+// and a user takes one seat however many groups of the namespace they are a member of.
+type SeatPlanner struct {
+	// billable are the SAML identities of the billable members of the namespace, and of those the plan makes billable
+	billable map[string]bool
+}
+
+// NewSeatPlanner lists the billable members of the parent group, matched to Okta users by their SAML identity.
+// Returns nil if SEAT_IMPACT is not set, listing billable members requires the Owner role.
+func NewSeatPlanner(clt *gitlab.Client, members []*gitlab.GroupMember) (*SeatPlanner, error) {
+	if !viper.GetBool("SEAT_IMPACT") {
+		return nil, nil
+	}
+	identities := map[string]string{}
+	for _, m := range members {
+		if m.GroupSAMLIdentity != nil {
+			identities[m.Username] = m.GroupSAMLIdentity.ExternUID
+		}
+	}
+	p := &SeatPlanner{billable: map[string]bool{}}
+	opt := &gitlab.ListBillableGroupMembersOptions{ListOptions: gitlab.ListOptions{PerPage: 100}}
+	for {
+		billable, resp, err := clt.Groups.ListBillableGroupMembers(viper.GetString("GITLAB_PARENT_GROUP"), opt)
+		if err != nil {
+			return nil, fmt.Errorf("listing the billable members: %w", err)
+		}
+		for _, m := range billable {
+			if uid, ok := identities[m.Username]; ok {
+				p.billable[uid] = true
+			}
+		}
+		if resp.NextPage == 0 {
+			return p, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+// Plan records the seat impact of the additions of the group in the plan.
+func (p *SeatPlanner) Plan(gs *GroupSync) {
+	if p == nil {
+		return
+	}
+	gs.Plan.Seats = make(map[string]string, len(gs.Plan.Add))
+	for _, u := range gs.Plan.Add {
+		switch {
+		case memberAccessLevel(*gs, u) <= gitlab.GuestPermissions:
+			gs.Plan.Seats[u] = SeatFreeGuest
+		case p.billable[u]:
+			gs.Plan.Seats[u] = SeatExisting
+		default:
+			gs.Plan.Seats[u] = SeatBillable
+			p.billable[u] = true
+		}
+	}
+}
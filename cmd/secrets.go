@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// errSecretUnset is the error of a secret source that has no value, e.g. an environment variable that isn't set
+var errSecretUnset = errors.New("not set")
+
+// secretSources returns the sources SECRET_SOURCES lists for the credential of the config key, in order.
+// A source is one of:
+//
+//	env:NAME          the environment variable NAME
+//	file:PATH         the content of the file, e.g. a mounted Kubernetes secret
+//	secret            the secret the config key names, in the version activated by rotate-check if any
+//	gcp:NAME          the GCP Secret Manager secret version NAME
+//	aws:NAME          the AWS Secrets Manager secret NAME, or its ARN
+//	vault:PATH#FIELD  the field of the Vault secret at PATH, read from VAULT_ADDR with VAULT_TOKEN
+func secretSources(key string) []string {
+	// Viper lower-cases the keys of config maps
+	return viper.GetStringMapStringSlice("SECRET_SOURCES")[strings.ToLower(key)]
+}
+
+// hasCredential tells whether the credential of the config key is configured, as a secret or as sources.
+func hasCredential(key string) bool {
+	return viper.IsSet(key) || len(secretSources(key)) > 0
+}
+
+// credential returns the credential of the config key, read from the first of its SECRET_SOURCES that has it,
+// or else from the secret the key names. The state gives the versions activated by rotate-check, it may be nil.
+// Sources that fail are skipped, so that the same config works on a laptop, in CI and in production.
+func credential(state *State, key string) ([]byte, error) {
+	sources := secretSources(key)
+	if len(sources) == 0 {
+		return readSecretSource(state, key, "secret")
+	}
+	failures := make([]string, 0, len(sources))
+	for _, source := range sources {
+		value, err := readSecretSource(state, key, source)
+		if err != nil {
+			logger.Debug("Skipped a secret source", "credential", key, "source", source, "error", err)
+			failures = append(failures, fmt.Sprintf("%s: %v", source, err))
+			continue
+		}
+		logger.Info("Read the credential", "credential", key, "source", source, "skipped", len(failures))
+		return value, nil
+	}
+	return nil, fmt.Errorf("no source of %s could be read: %s", key, strings.Join(failures, "; "))
+}
+
+// readSecretSource reads the credential of the config key from one source, see secretSources.
+func readSecretSource(state *State, key, source string) ([]byte, error) {
+	kind, ref := source, ""
+	if i := strings.Index(source, ":"); i >= 0 {
+		kind, ref = source[:i], source[i+1:]
+	}
+	switch kind {
+	case "env":
+		value := strings.TrimSpace(os.Getenv(ref))
+		if value == "" {
+			return nil, errSecretUnset
+		}
+		return []byte(value), nil
+	case "file":
+		value, err := ioutil.ReadFile(ref)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strings.TrimSpace(string(value))), nil
+	case "secret":
+		name := viper.GetString(key)
+		if state != nil {
+			name = activeSecret(state, key)
+		}
+		if name == "" {
+			return nil, errSecretUnset
+		}
+		return AccessSecret(name)
+	case "gcp":
+		return AccessSecret(ref)
+	case "aws", "arn":
+		return AccessSecret(source)
+	case "vault":
+		return accessVaultSecret(ref)
+	}
+	return nil, fmt.Errorf("unknown secret source %q, expected env, file, secret, gcp, aws or vault", kind)
+}
+
+// accessVaultSecret reads the field of a HashiCorp Vault secret, given as PATH#FIELD, e.g.
+// secret/data/psync#okta_token for a KV version 2 engine mounted at secret. The token is VAULT_TOKEN,
+// or else the one the vault CLI saved in ~/.vault-token on login.
+func accessVaultSecret(ref string) ([]byte, error) {
+	i := strings.LastIndex(ref, "#")
+	if i < 0 {
+		return nil, fmt.Errorf("vault secret %q has no #field", ref)
+	}
+	path, field := strings.Trim(ref[:i], "/"), ref[i+1:]
+	addr := strings.TrimRight(viper.GetString("VAULT_ADDR"), "/")
+	if addr == "" {
+		return nil, fmt.Errorf("VAULT_ADDR is %w", errSecretUnset)
+	}
+	token := viper.GetString("VAULT_TOKEN")
+	if token == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			saved, _ := ioutil.ReadFile(filepath.Join(home, ".vault-token"))
+			token = strings.TrimSpace(string(saved))
+		}
+	}
+	if token == "" {
+		return nil, fmt.Errorf("VAULT_TOKEN is %w", errSecretUnset)
+	}
+	req, err := http.NewRequest(http.MethodGet, addr+"/v1/"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	runQuota.secretAccessed("vault")
+	if ns := viper.GetString("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("reading the vault secret %s: %s", path, resp.Status)
+	}
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return nil, fmt.Errorf("reading the vault secret %s: %w", path, err)
+	}
+	// KV version 2 nests the fields of the secret under data.data
+	fields := secret.Data
+	if nested, ok := fields["data"].(map[string]interface{}); ok {
+		if _, top := fields[field]; !top {
+			fields = nested
+		}
+	}
+	value, ok := fields[field].(string)
+	if !ok {
+		return nil, fmt.Errorf("vault secret %s has no string field %s", path, field)
+	}
+	return []byte(value), nil
+}
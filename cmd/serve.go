@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	serveListen   string
+	serveInterval time.Duration
+	serveDebug    bool
+	serveWebhooks bool
+)
+
+// syncMu serializes the periodic syncs and the syncs of webhook events
+var syncMu sync.Mutex
+
+// serveCmd runs psync as a daemon
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run the sync periodically as a daemon",
+	Long: `Run the sync at a fixed interval and serve an HTTP endpoint for health checks.
+The result of the last run is served as /status.json and as a badge at /badge.svg.
+The progress of the runs is streamed as server-sent events from /events.
+Prometheus metrics are served at /metrics, see psync metrics dashboard.
+With --debug, pprof and runtime debug endpoints are served under /debug/, guarded by the bearer token
+stored in the DEBUG_TOKEN_SECRET secret.
+With --webhooks, Okta event hooks are received at /webhooks/okta and Gitlab system hooks at /webhooks/gitlab,
+guarded by the token stored in the WEBHOOK_TOKEN_SECRET secret. The groups their events concern are synced
+right away, reading only those groups from Okta unless WEBHOOK_SPARSE is false. Try payloads locally with psync webhook test.
+With API_TOKENS set, the API is served under /api/ to the holders of the tokens, by role: status reads
+/api/status, plan also POSTs to /api/plan to get the changes a sync would make, and apply also POSTs to
+/api/sync to trigger a sync. Each token is stored in a secret, and given as bearer token.
+With DIGEST_NOTIFIER and DIGEST_RECIPIENT set, the changes of all the runs are summarized in one digest
+every DIGEST_INTERVAL, a day by default, while the alerts are still sent by each run.
+With JIT_GROUPS set, the lapsed grants are swept every JIT_SWEEP_INTERVAL between the syncs, see psync jit sweep.`,
+	Example: `  # Sync every 15 minutes
+  psync serve --interval 15m
+
+  # Serve the debug endpoints on another port
+  psync serve --listen :9090 --debug
+
+  # Let the developer portal read the status, and the platform team trigger syncs, with API_TOKENS:
+  #   - {name: portal, secret: projects/p/secrets/psync-portal/versions/latest, role: status}
+  #   - {name: platform, secret: projects/p/secrets/psync-platform/versions/latest, role: apply}
+  curl -X POST -H "Authorization: Bearer $TOKEN" http://localhost:8080/api/sync`,
+	Run: func(cmd *cobra.Command, args []string) {
+		status := &serveStatus{}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprintln(w, "ok")
+		})
+		mux.HandleFunc("/status.json", status.ServeJSON)
+		mux.HandleFunc("/badge.svg", status.ServeBadge)
+		mux.HandleFunc("/events", progress.ServeEvents)
+		mux.HandleFunc("/metrics", ServeMetrics)
+		if serveDebug {
+			if !hasCredential("DEBUG_TOKEN_SECRET") {
+				cobra.CheckErr("--debug requires DEBUG_TOKEN_SECRET to guard the debug endpoints")
+			}
+			token, err := credential(nil, "DEBUG_TOKEN_SECRET")
+			cobra.CheckErr(err)
+			mux.Handle("/debug/", requireToken(strings.TrimSpace(string(token)), debugHandler()))
+		}
+		if viper.IsSet("API_TOKENS") {
+			tokens, err := LoadServeTokens()
+			cobra.CheckErr(err)
+			mux.Handle("/api/status", requireRole(tokens, RoleStatus, http.HandlerFunc(status.ServeJSON)))
+			mux.Handle("/api/plan", requireRole(tokens, RolePlan, http.HandlerFunc(servePlan)))
+			mux.Handle("/api/sync", requireRole(tokens, RoleApply, serveSync(status)))
+		}
+		if serveWebhooks {
+			if !hasCredential("WEBHOOK_TOKEN_SECRET") {
+				cobra.CheckErr("--webhooks requires WEBHOOK_TOKEN_SECRET to guard the webhook endpoints")
+			}
+			token, err := credential(nil, "WEBHOOK_TOKEN_SECRET")
+			cobra.CheckErr(err)
+			syncEvents := func(events []WebhookEvent) {
+				serveRun(status, WithEvents(events))
+			}
+			for _, provider := range []string{"okta", "gitlab"} {
+				mux.Handle("/webhooks/"+provider, &WebhookHandler{Provider: provider, Token: strings.TrimSpace(string(token)), Sync: syncEvents, mu: &syncMu})
+			}
+		}
+
+		go func() {
+			log.Fatal(http.ListenAndServe(serveListen, mux))
+		}()
+		logger.Info("Listening", "address", serveListen, "interval", serveInterval.String())
+
+		for {
+			syncMu.Lock()
+			serveRun(status)
+			syncMu.Unlock()
+			serveSweeps(clock.Now().Add(serveInterval))
+		}
+	},
+}
+
+// serveSweeps sweeps the lapsed grants every JIT_SWEEP_INTERVAL until the next sync, or only waits for it
+// without JIT_GROUPS. A failed sweep is logged, the next sweep or sync removes the members it left.
+func serveSweeps(next time.Time) {
+	interval := viper.GetDuration("JIT_SWEEP_INTERVAL")
+	if len(viper.GetStringSlice("JIT_GROUPS")) == 0 || interval <= 0 {
+		clock.Sleep(next.Sub(clock.Now()))
+		return
+	}
+	for {
+		wait := next.Sub(clock.Now())
+		if wait <= interval {
+			clock.Sleep(wait)
+			return
+		}
+		clock.Sleep(interval)
+		syncMu.Lock()
+		if err := SweepGrants(); err != nil {
+			logger.Error("The sweep of the lapsed grants failed", "error", err)
+		}
+		syncMu.Unlock()
+	}
+}
+
+// serveRun runs a sync of the daemon and records its metrics and status. A failed run is logged and the daemon
+// keeps running, the next run may well succeed: Okta and Gitlab errors are often transient.
+func serveRun(status *serveStatus, opts ...SyncerOption) {
+	started := clock.Now()
+	status.start()
+	summary, err := Sync(opts...)
+	if err != nil {
+		logger.Error("The run failed", "error", err)
+		summary = failedSummary(summary, started, err)
+	}
+	recordRunMetrics(summary)
+	status.finish(summary)
+}
+
+// debugHandler serves the pprof profiles and runtime statistics.
+func debugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/runtime", func(w http.ResponseWriter, r *http.Request) {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"goroutines": runtime.NumGoroutine(),
+			"heap_alloc": m.HeapAlloc,
+			"heap_inuse": m.HeapInuse,
+			"sys":        m.Sys,
+			"num_gc":     m.NumGC,
+		})
+	})
+	return mux
+}
+
+// requireToken only passes the requests that carry the bearer token.
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func init() {
+	serveCmd.Flags().StringVar(&serveListen, "listen", ":8080", "address of the HTTP endpoint")
+	serveCmd.Flags().DurationVar(&serveInterval, "interval", time.Hour, "time between two syncs")
+	serveCmd.Flags().BoolVar(&serveDebug, "debug", false, "serve the pprof and runtime debug endpoints")
+	serveCmd.Flags().BoolVar(&serveWebhooks, "webhooks", false, "sync the groups concerned by Okta event hooks and Gitlab system hooks")
+	rootCmd.AddCommand(serveCmd)
+}
@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Roles of the API tokens, each allowing what the previous ones do
+const (
+	// RoleStatus reads the status of the runs
+	RoleStatus = "status"
+	// RolePlan also plans runs, without changing any membership
+	RolePlan = "plan"
+	// RoleApply also triggers syncs
+	RoleApply = "apply"
+)
+
+var roleRanks = map[string]int{RoleStatus: 1, RolePlan: 2, RoleApply: 3}
+
+// ServeToken is an API token of psync serve, configured under API_TOKENS.
+type ServeToken struct {
+	// Name identifies the holder of the token in the logs
+	Name string
+	// Secret is the secret the token is stored in
+	Secret string
+	Role   string
+	token  string
+}
+
+// LoadServeTokens reads the API_TOKENS and their secrets.
+func LoadServeTokens() ([]ServeToken, error) {
+	tokens := make([]ServeToken, 0)
+	if err := viper.UnmarshalKey("API_TOKENS", &tokens); err != nil {
+		return nil, fmt.Errorf("API_TOKENS: %w", err)
+	}
+	for i := range tokens {
+		t := &tokens[i]
+		if _, ok := roleRanks[t.Role]; !ok {
+			return nil, fmt.Errorf("API_TOKENS: unknown role %q of %s, expected status, plan or apply", t.Role, t.Name)
+		}
+		token, err := AccessSecret(t.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("API_TOKENS: reading the token of %s: %w", t.Name, err)
+		}
+		if t.token = strings.TrimSpace(string(token)); t.token == "" {
+			return nil, fmt.Errorf("API_TOKENS: the token of %s is empty", t.Name)
+		}
+	}
+	return tokens, nil
+}
+
+// requireRole only passes the requests carrying the bearer token of a holder with the role, or a higher one.
+// Requests without a known token are unauthorized, those of holders with a lower role forbidden.
+func requireRole(tokens []ServeToken, role string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		for _, t := range tokens {
+			if subtle.ConstantTimeCompare([]byte(given), []byte(t.token)) != 1 {
+				continue
+			}
+			if roleRanks[t.Role] < roleRanks[role] {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			logger.Info("API call", "method", r.Method, "path", r.URL.Path, "holder", t.Name)
+			next.ServeHTTP(w, r)
+			return
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// servePlan plans a run through a read-only snapshot, like psync plan, and serves its changes.
+func servePlan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	syncMu.Lock()
+	defer syncMu.Unlock()
+	// Only the clients of this plan read through the snapshot, the syncs keep their transports
+	hook := transportHook
+	transportHook = func(provider string, rt http.RoundTripper) http.RoundTripper {
+		return NewSnapshot(hook(provider, rt))
+	}
+	ctx, client, gitlabClt, err := NewClients()
+	transportHook = hook
+	var state *State
+	if err == nil {
+		state, err = LoadState()
+	}
+	var saved bytes.Buffer
+	if err == nil {
+		err = EncodeState(&saved, state)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	run, syncs, err := planRun(ctx, client, gitlabClt, saved.Bytes())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	changes := make([]string, 0)
+	for c := range planChanges(run, syncs) {
+		changes = append(changes, c)
+	}
+	sort.Strings(changes)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		RunID   string   `json:"run_id"`
+		Groups  int      `json:"groups"`
+		Changes []string `json:"changes"`
+	}{run.ID, len(syncs), changes})
+}
+
+// serveSync triggers a sync, run in the background after the one in progress if any.
+func serveSync(status *serveStatus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		go func() {
+			syncMu.Lock()
+			defer syncMu.Unlock()
+			serveRun(status)
+		}()
+	}
+}
@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"time"
+
+	"github.com/spf13/cobra"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+var (
+	simulateUsers  int
+	simulateGroups int
+	simulateSeed   int64
+)
+
+// simulateCmd runs the diff engine against a synthetic dataset
+var simulateCmd = &cobra.Command{
+	Use:   "simulate",
+	Short: "Run the sync engine against synthetic Okta and Gitlab data",
+	Long: `Generate a synthetic dataset of Okta groups and Gitlab group members and compute the sync plan
+for it without calling any API. Prints timing and memory statistics, to validate the performance
+and the behaviour of the engine at scale. The diff and matching code paths are benchmarked on the same
+dataset by the Go benchmarks of the cmd package.`,
+	Example: `  # Simulate a large org
+  psync simulate --users 50000 --groups 1000
+
+  # Compare two datasets of the same size
+  psync simulate --seed 2`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if simulateUsers <= 0 || simulateGroups <= 0 {
+			cobra.CheckErr("--users and --groups must be positive")
+		}
+		rnd := rand.New(rand.NewSource(simulateSeed))
+		// Simulated runs are reproducible, including their IDs and timestamps
+		clock = &ManualClock{T: time.Unix(simulateSeed, 0)}
+		ids = &SequentialIDs{Prefix: "simulation-"}
+		fmt.Printf("Starting run %s\n", ids.NewID())
+
+		genStart := time.Now()
+		data := generateSimulation(rnd, simulateUsers, simulateGroups)
+		fmt.Printf("Generated %d users in %d groups in %s\n", simulateUsers, simulateGroups, time.Since(genStart))
+
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		start := time.Now()
+
+		var add, remove, pending, conflicts int
+		for i, g := range data.oktaGroups {
+			plan := PlanGroup(g, data.afklUids, MatchGitlabMembers(data.gitlabGroups[i], data.afklMembers))
+			add += len(plan.Add)
+			remove += len(plan.Remove)
+			pending += len(plan.Pending)
+			conflicts += len(plan.Conflicts)
+		}
+
+		elapsed := time.Since(start)
+		runtime.ReadMemStats(&after)
+
+		fmt.Printf("Planned %d additions, %d removals, %d pending, %d conflicts\n", add, remove, pending, conflicts)
+		fmt.Printf("Time: %s (%s per group)\n", elapsed, elapsed/time.Duration(simulateGroups))
+		fmt.Printf("Memory: %d allocations, %d KiB allocated, %d KiB heap in use\n",
+			after.Mallocs-before.Mallocs, (after.TotalAlloc-before.TotalAlloc)/1024, after.HeapInuse/1024)
+	},
+}
+
+// simulation is a synthetic dataset shaped like the data fetched from Okta and Gitlab
+type simulation struct {
+	oktaGroups   []OktaGroup
+	afklMembers  []*gitlab.GroupMember
+	afklUids     []string
+	gitlabGroups [][]*gitlab.GroupMember
+}
+
+// generateSimulation creates the dataset. Every user belongs to one to three groups,
+// most users have a SAML identity and most group members are already in sync.
+func generateSimulation(rnd *rand.Rand, users, groups int) *simulation {
+	s := &simulation{
+		oktaGroups:   make([]OktaGroup, groups),
+		gitlabGroups: make([][]*gitlab.GroupMember, groups),
+	}
+	for i := range s.oktaGroups {
+		s.oktaGroups[i] = OktaGroup{ID: fmt.Sprintf("00g%07d", i), Name: fmt.Sprintf("team-%d", i), OktaName: fmt.Sprintf("dev_team-%d", i)}
+	}
+
+	for u := 0; u < users; u++ {
+		uid := fmt.Sprintf("00u%07d", u)
+		deprovisioned := rnd.Intn(100) < 5
+		var member *gitlab.GroupMember
+		if rnd.Intn(100) < 90 {
+			member = &gitlab.GroupMember{
+				ID:                u + 1,
+				Username:          fmt.Sprintf("user%d", u),
+				State:             "active",
+				AccessLevel:       gitlab.DeveloperPermissions,
+				GroupSAMLIdentity: &gitlab.GroupMemberSAMLIdentity{ExternUID: uid},
+			}
+			s.afklMembers = append(s.afklMembers, member)
+			s.afklUids = append(s.afklUids, uid)
+		}
+
+		for n := 1 + rnd.Intn(3); n > 0; n-- {
+			i := rnd.Intn(groups)
+			g := &s.oktaGroups[i]
+			if deprovisioned {
+				g.Deprovisioned = append(g.Deprovisioned, uid)
+			} else {
+				g.Users = append(g.Users, uid)
+			}
+			if member == nil || rnd.Intn(100) >= 80 {
+				continue
+			}
+			m := *member
+			switch r := rnd.Intn(100); {
+			case r < 2:
+				m.State = gitlabMemberAwaiting
+			case r < 3:
+				m.State = gitlabMemberBlocked
+			}
+			s.gitlabGroups[i] = append(s.gitlabGroups[i], &m)
+		}
+	}
+	return s
+}
+
+func init() {
+	simulateCmd.Flags().IntVar(&simulateUsers, "users", 5000, "number of synthetic users")
+	simulateCmd.Flags().IntVar(&simulateGroups, "groups", 200, "number of synthetic groups")
+	simulateCmd.Flags().Int64Var(&simulateSeed, "seed", 1, "random seed of the dataset generator")
+	rootCmd.AddCommand(simulateCmd)
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+
+	"psync/internal/set"
+)
+
+// The benchmarks cover the diff and matching code paths the plan of every group goes through, at a representative
+// size. Compare them between releases with benchstat:
+//
+//	go test -run '^$' -bench . -benchmem -count 10 ./cmd > new.txt && benchstat old.txt new.txt
+const (
+	benchUsers  = 10000
+	benchGroups = 500
+)
+
+var (
+	benchDataOnce sync.Once
+	benchData     *simulation
+)
+
+// benchSimulation returns the dataset of the benchmarks, generated once with the seed of psync simulate.
+func benchSimulation(b *testing.B) *simulation {
+	b.Helper()
+	benchDataOnce.Do(func() {
+		benchData = generateSimulation(rand.New(rand.NewSource(1)), benchUsers, benchGroups)
+	})
+	b.ReportAllocs()
+	b.ResetTimer()
+	return benchData
+}
+
+func BenchmarkSetIntersection(b *testing.B) {
+	data := benchSimulation(b)
+	for i := 0; i < b.N; i++ {
+		for _, g := range data.oktaGroups {
+			set.Intersection(g.Users, data.afklUids)
+		}
+	}
+}
+
+func BenchmarkSetDifference(b *testing.B) {
+	data := benchSimulation(b)
+	for i := 0; i < b.N; i++ {
+		for _, g := range data.oktaGroups {
+			set.Difference(data.afklUids, g.Users)
+		}
+	}
+}
+
+func BenchmarkGitlabIdentities(b *testing.B) {
+	data := benchSimulation(b)
+	for i := 0; i < b.N; i++ {
+		GitlabIdentities(data.afklMembers)
+	}
+}
+
+func BenchmarkMatchGitlabMembers(b *testing.B) {
+	data := benchSimulation(b)
+	for i := 0; i < b.N; i++ {
+		for j := range data.oktaGroups {
+			MatchGitlabMembers(data.gitlabGroups[j], data.afklMembers)
+		}
+	}
+}
+
+func BenchmarkPlanGroup(b *testing.B) {
+	data := benchSimulation(b)
+	members := make([][]GitlabMember, len(data.oktaGroups))
+	for j := range data.oktaGroups {
+		members[j] = MatchGitlabMembers(data.gitlabGroups[j], data.afklMembers)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j, g := range data.oktaGroups {
+			PlanGroup(g, data.afklUids, members[j])
+		}
+	}
+}
+
+func BenchmarkPlanAccessRequests(b *testing.B) {
+	data := benchSimulation(b)
+	identities := GitlabIdentities(data.afklMembers)
+	requests := make([]*gitlab.AccessRequest, 0, 10)
+	for j := 0; j < 10 && j < len(data.afklMembers); j++ {
+		requests = append(requests, &gitlab.AccessRequest{ID: data.afklMembers[j].ID, Username: data.afklMembers[j].Username})
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, g := range data.oktaGroups {
+			PlanAccessRequests(&GroupPlan{}, g, requests, identities)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// Snapshot is an HTTP transport that records the responses to GET requests and replays them,
+// so that several plans are computed against the same state of a provider.
+// Any other request is refused, so nothing is changed through a snapshot.
+type Snapshot struct {
+	Next http.RoundTripper
+
+	mu        sync.Mutex
+	responses map[string]*cachedResponse
+	status    map[string]int
+}
+
+// NewSnapshot returns a snapshot recording the responses of the transport.
+func NewSnapshot(next http.RoundTripper) *Snapshot {
+	return &Snapshot{Next: next, responses: map[string]*cachedResponse{}, status: map[string]int{}}
+}
+
+// RoundTrip answers the request from the snapshot, recording the response the first time.
+func (s *Snapshot) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return nil, fmt.Errorf("refusing %s %s: provider snapshots are read-only", req.Method, req.URL.Path)
+	}
+	key := req.URL.String()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.responses[key]; !ok {
+		resp, err := s.Next.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		s.responses[key] = &cachedResponse{Header: resp.Header, Body: body}
+		s.status[key] = resp.StatusCode
+	}
+	r := s.responses[key]
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", s.status[key], http.StatusText(s.status[key])),
+		StatusCode:    s.status[key],
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        r.Header.Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(r.Body)),
+		ContentLength: int64(len(r.Body)),
+		Request:       req,
+	}, nil
+}
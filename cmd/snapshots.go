@@ -0,0 +1,246 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+
+	"psync/internal/set"
+)
+
+// MembershipSnapshot is the membership of the Gitlab groups psync manages at a point in time, kept in SNAPSHOT_DIR.
+// Unlike the audit trail it also shows the changes made outside of psync.
+type MembershipSnapshot struct {
+	ID     string          `json:"id"`
+	Taken  time.Time       `json:"taken"`
+	Groups []SnapshotGroup `json:"groups"`
+}
+
+// SnapshotGroup is the membership of one Gitlab group in a snapshot.
+type SnapshotGroup struct {
+	GitlabID int              `json:"gitlab_id"`
+	FullPath string           `json:"full_path"`
+	Members  []SnapshotMember `json:"members"`
+}
+
+// SnapshotMember is a direct member of a Gitlab group in a snapshot.
+type SnapshotMember struct {
+	UserID      int    `json:"user_id"`
+	Username    string `json:"username"`
+	AccessLevel string `json:"access_level"`
+	State       string `json:"state,omitempty"`
+}
+
+// snapshotCmd groups the membership snapshot commands
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Record and compare the memberships of the Gitlab groups",
+	Long: `Record the direct members of the Gitlab groups mapped in the state, and compare two records to see what changed
+in between, whoever changed it: psync, Gitlab admins or group owners. Snapshots are JSON files in SNAPSHOT_DIR.`,
+	Example: `  # Record the memberships every night, e.g. from a scheduled job
+  psync snapshot create
+
+  # Show what changed between two snapshots, by ID or file
+  psync snapshot diff 20261001T000000Z 20261017T000000Z`,
+}
+
+// snapshotCreateCmd records a snapshot
+var snapshotCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Record the members of the Gitlab groups mapped in the state",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		state, err := LoadState()
+		cobra.CheckErr(err)
+		clt, err := NewGitlabClient(state)
+		cobra.CheckErr(err)
+		snapshot, err := TakeMembershipSnapshot(clt, state)
+		cobra.CheckErr(err)
+		path, err := SaveMembershipSnapshot(snapshot)
+		cobra.CheckErr(err)
+		fmt.Printf("Recorded snapshot %s of %d groups in %s\n", snapshot.ID, len(snapshot.Groups), path)
+	},
+}
+
+// snapshotDiffCmd compares two snapshots
+var snapshotDiffCmd = &cobra.Command{
+	Use:   "diff <a> <b>",
+	Short: "Print the membership changes between two snapshots",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		a, err := LoadMembershipSnapshot(args[0])
+		cobra.CheckErr(err)
+		b, err := LoadMembershipSnapshot(args[1])
+		cobra.CheckErr(err)
+		fmt.Printf("Changes from %s (%s) to %s (%s):\n", a.ID, a.Taken.Format(time.RFC3339), b.ID, b.Taken.Format(time.RFC3339))
+		changes := DiffMembershipSnapshots(a, b)
+		for _, c := range changes {
+			fmt.Printf("  %s\n", c)
+		}
+		if len(changes) == 0 {
+			fmt.Println("  none")
+		}
+	},
+}
+
+// snapshotListCmd lists the snapshots
+var snapshotListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the snapshots in SNAPSHOT_DIR",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		paths, err := filepath.Glob(filepath.Join(viper.GetString("SNAPSHOT_DIR"), "*.json"))
+		cobra.CheckErr(err)
+		sort.Strings(paths)
+		for _, p := range paths {
+			fmt.Println(strings.TrimSuffix(filepath.Base(p), ".json"))
+		}
+	},
+}
+
+// TakeMembershipSnapshot lists the direct members of the Gitlab groups mapped in the state.
+func TakeMembershipSnapshot(clt *gitlab.Client, state *State) (*MembershipSnapshot, error) {
+	taken := clock.Now().UTC()
+	snapshot := &MembershipSnapshot{ID: taken.Format("20060102T150405Z"), Taken: taken}
+	ids := make([]int, 0, len(state.Groups))
+	for _, m := range state.Groups {
+		if !set.Contains(ids, m.GitlabID) {
+			ids = append(ids, m.GitlabID)
+		}
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		group, _, err := clt.Groups.GetGroup(id, &gitlab.GetGroupOptions{WithProjects: gitlab.Bool(false)})
+		if err != nil {
+			return nil, fmt.Errorf("getting Gitlab group %d: %w", id, err)
+		}
+		g := SnapshotGroup{GitlabID: id, FullPath: group.FullPath, Members: []SnapshotMember{}}
+		opt := &gitlab.ListGroupMembersOptions{ListOptions: gitlab.ListOptions{PerPage: 100}}
+		for {
+			members, resp, err := clt.Groups.ListGroupMembers(id, opt)
+			if err != nil {
+				return nil, fmt.Errorf("listing the members of Gitlab group %s: %w", group.FullPath, err)
+			}
+			for _, m := range members {
+				g.Members = append(g.Members, SnapshotMember{UserID: m.ID, Username: m.Username, AccessLevel: accessLevelName(m.AccessLevel), State: m.State})
+			}
+			if resp.NextPage == 0 {
+				break
+			}
+			opt.Page = resp.NextPage
+		}
+		sort.Slice(g.Members, func(i, j int) bool { return g.Members[i].Username < g.Members[j].Username })
+		snapshot.Groups = append(snapshot.Groups, g)
+	}
+	return snapshot, nil
+}
+
+// SaveMembershipSnapshot writes the snapshot to SNAPSHOT_DIR as <ID>.json, encrypted like the state, and returns its path.
+func SaveMembershipSnapshot(snapshot *MembershipSnapshot) (string, error) {
+	dir := viper.GetString("SNAPSHOT_DIR")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, snapshot.ID+".json")
+	return path, writeLocalFile(path, data, 0600)
+}
+
+// LoadMembershipSnapshot reads the snapshot with the ID from SNAPSHOT_DIR, or the snapshot file at the path.
+func LoadMembershipSnapshot(idOrPath string) (*MembershipSnapshot, error) {
+	path := idOrPath
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		path = filepath.Join(viper.GetString("SNAPSHOT_DIR"), idOrPath+".json")
+	}
+	data, err := readLocalFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading snapshot %s: %w", idOrPath, err)
+	}
+	snapshot := &MembershipSnapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return snapshot, nil
+}
+
+// DiffMembershipSnapshots describes the membership changes from snapshot a to snapshot b, one per line, by group.
+func DiffMembershipSnapshots(a, b *MembershipSnapshot) []string {
+	before, after := snapshotGroups(a), snapshotGroups(b)
+	ids := make([]int, 0, len(before)+len(after))
+	for id := range before {
+		ids = append(ids, id)
+	}
+	for id := range after {
+		if _, ok := before[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	changes := make([]string, 0)
+	for _, id := range ids {
+		gb, inBefore := before[id]
+		ga, inAfter := after[id]
+		switch {
+		case !inBefore:
+			changes = append(changes, fmt.Sprintf("%s: started being recorded", ga.FullPath))
+			continue
+		case !inAfter:
+			changes = append(changes, fmt.Sprintf("%s: stopped being recorded", gb.FullPath))
+			continue
+		case gb.FullPath != ga.FullPath:
+			changes = append(changes, fmt.Sprintf("%s: renamed from %s", ga.FullPath, gb.FullPath))
+		}
+		path := ga.FullPath
+		mb, ma := snapshotMembers(gb), snapshotMembers(ga)
+		for _, m := range ga.Members {
+			old, ok := mb[m.UserID]
+			switch {
+			case !ok:
+				changes = append(changes, fmt.Sprintf("%s: + %s as %s", path, m.Username, m.AccessLevel))
+			case old.AccessLevel != m.AccessLevel:
+				changes = append(changes, fmt.Sprintf("%s: ~ %s from %s to %s", path, m.Username, old.AccessLevel, m.AccessLevel))
+			case old.State != m.State:
+				changes = append(changes, fmt.Sprintf("%s: ~ %s state from %s to %s", path, m.Username, old.State, m.State))
+			}
+		}
+		for _, m := range gb.Members {
+			if _, ok := ma[m.UserID]; !ok {
+				changes = append(changes, fmt.Sprintf("%s: - %s, was %s", path, m.Username, m.AccessLevel))
+			}
+		}
+	}
+	return changes
+}
+
+func snapshotGroups(s *MembershipSnapshot) map[int]SnapshotGroup {
+	groups := make(map[int]SnapshotGroup, len(s.Groups))
+	for _, g := range s.Groups {
+		groups[g.GitlabID] = g
+	}
+	return groups
+}
+
+func snapshotMembers(g SnapshotGroup) map[int]SnapshotMember {
+	members := make(map[int]SnapshotMember, len(g.Members))
+	for _, m := range g.Members {
+		members[m.UserID] = m
+	}
+	return members
+}
+
+func init() {
+	snapshotCmd.AddCommand(snapshotCreateCmd, snapshotDiffCmd, snapshotListCmd)
+	rootCmd.AddCommand(snapshotCmd)
+}
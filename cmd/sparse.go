@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+
+	"psync/internal/set"
+)
+
+// GroupSource is a Source that can also read single groups, so that the syncs of webhook events
+// read only the groups the events concern instead of discovering all of them.
+type GroupSource interface {
+	Source
+	// GroupsByID reads the groups with the IDs, leaving out the groups that don't exist or are not synced
+	GroupsByID(run *Run, ids []string) ([]OktaGroup, error)
+	// UserGroupIDs returns the IDs of the groups of the user
+	UserGroupIDs(userID string) ([]string, error)
+}
+
+// sparseSource reads the groups the webhook events concern, found with the group mappings of the state.
+type sparseSource struct {
+	GroupSource
+	events []WebhookEvent
+}
+
+// withSparseSource reads only the groups the events concern when the source can read single groups
+// and WEBHOOK_SPARSE is set, otherwise the source discovers all the groups as usual.
+func withSparseSource(source Source, events []WebhookEvent) Source {
+	gs, ok := source.(GroupSource)
+	if !ok || !viper.GetBool("WEBHOOK_SPARSE") {
+		return source
+	}
+	return &sparseSource{GroupSource: gs, events: events}
+}
+
+// Groups reads the Okta groups the events name, the groups of the Okta users they name that are mapped already,
+// and the Okta groups mapped to the Gitlab groups they name.
+func (s *sparseSource) Groups(run *Run) ([]OktaGroup, error) {
+	ids, err := s.groupIDs(run)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Reading the Okta groups concerned by the events", "groups", len(ids), "events", len(s.events))
+	return s.GroupsByID(run, ids)
+}
+
+// groupIDs resolves the events to the IDs of the Okta groups they concern.
+func (s *sparseSource) groupIDs(run *Run) ([]string, error) {
+	ids := make([]string, 0)
+	for _, e := range s.events {
+		switch {
+		case e.Provider == "okta" && e.GroupID != "":
+			ids = set.Union(ids, []string{e.GroupID})
+		case e.Provider == "okta" && e.UserID != "":
+			groups, err := s.UserGroupIDs(e.UserID)
+			if err != nil {
+				return nil, fmt.Errorf("listing the groups of Okta user %s: %w", e.UserID, err)
+			}
+			// The user's groups that were never synced have no Gitlab group to update
+			for _, id := range groups {
+				if _, ok := run.State.GitlabGroupID(id); ok {
+					ids = set.Union(ids, []string{id})
+				}
+			}
+		case e.Provider == "gitlab":
+			if gitlabID, err := strconv.Atoi(e.GroupID); err == nil {
+				ids = set.Union(ids, run.State.OktaGroupIDs(gitlabID))
+			}
+		}
+	}
+	return ids, nil
+}
+
+// GroupsByID reads the Okta groups with the IDs that are mapped, or that are dev_ groups without MAPPINGS_FILE.
+func (s *OktaSource) GroupsByID(run *Run, ids []string) ([]OktaGroup, error) {
+	oktaRateLimit.Threshold = viper.GetInt("OKTA_RATE_LIMIT_THRESHOLD")
+	if err := startOktaMembersCache(s.Ctx, s.Client, run.State); err != nil {
+		return nil, err
+	}
+	namer, err := NewOktaGroupNamer()
+	if err != nil {
+		return nil, err
+	}
+	// The custom attributes of the group profiles aren't read with the groups, the groups having the attribute are listed
+	var selected []string
+	if s.Mappings == nil && viper.GetString("OKTA_GROUP_ATTRIBUTE") != "" {
+		params, err := oktaGroupParams(namer.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		oktaGroups, err := listOktaGroups(s.Ctx, s.Client, params)
+		if err != nil {
+			return nil, fmt.Errorf("listing the Okta groups with %s: %w", viper.GetString("OKTA_GROUP_ATTRIBUTE"), err)
+		}
+		selected = make([]string, 0, len(oktaGroups))
+		for _, g := range oktaGroups {
+			selected = append(selected, g.Id)
+		}
+	}
+	membership := NewOktaMembership(s.Ctx, s.Client, viper.GetString("OKTA_MEMBERSHIP"))
+	groups := make([]OktaGroup, 0, len(ids))
+	for _, id := range ids {
+		if selected != nil && !set.Contains(selected, id) {
+			logger.Info("The Okta group is not synced", "group_id", id, "attribute", viper.GetString("OKTA_GROUP_ATTRIBUTE"))
+			continue
+		}
+		oktaRateLimit.Throttle()
+		g, resp, err := s.Client.Group.GetGroup(s.Ctx, id)
+		oktaRateLimit.Observe(resp)
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			logger.Info("The Okta group does not exist anymore", "group_id", id)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("getting the Okta group %s: %w", id, err)
+		}
+		gr := OktaGroup{ID: g.Id, OktaName: g.Profile.Name}
+		if s.Mappings != nil {
+			for i := range s.Mappings {
+				if strings.EqualFold(s.Mappings[i].OktaGroup, g.Profile.Name) {
+					gr.Name, gr.Mapping = s.Mappings[i].OktaGroup, &s.Mappings[i]
+					break
+				}
+			}
+		} else if gr.Name, _, err = namer.Name(g.Profile.Name); err != nil {
+			return nil, err
+		}
+		if gr.Name == "" {
+			continue
+		}
+		gr.Users, gr.Deprovisioned, err = oktaMembers.listGroupUsers(s.Ctx, s.Client, g)
+		if err == nil {
+			gr.Users, gr.Deprovisioned, err = membership.Resolve(g.Id, gr.Users, gr.Deprovisioned)
+		}
+		if err != nil {
+			warnDataQuality("Okta group %s is not synced: %v", gr.Name, err)
+			continue
+		}
+		groups = append(groups, gr)
+	}
+	logger.Info("Okta rate limit", "rate_limit", oktaRateLimit.String())
+	return groups, nil
+}
+
+// UserGroupIDs returns the IDs of the Okta groups of the user.
+func (s *OktaSource) UserGroupIDs(userID string) ([]string, error) {
+	groups, err := listOktaUserGroups(s.Ctx, s.Client, userID)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(groups))
+	for _, g := range groups {
+		ids = append(ids, g.Id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,464 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/spf13/viper"
+	// The pure Go SQLite driver, so that psync builds without cgo
+	_ "modernc.org/sqlite"
+)
+
+// SQL drivers of the SQL state stores
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "pgx"
+)
+
+// sqlSchema creates the tables of a SQL store. The state is a single row, the runs, their actions and
+// the memberships of the Gitlab groups after each run are kept for psync history.
+// The blob type is BLOB for SQLite and BYTEA for PostgreSQL.
+const sqlSchema = `
+CREATE TABLE IF NOT EXISTS state (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	data %s NOT NULL,
+	updated TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS runs (
+	id TEXT PRIMARY KEY,
+	started TEXT NOT NULL,
+	finished TEXT NOT NULL,
+	result TEXT NOT NULL,
+	failure TEXT NOT NULL DEFAULT '',
+	summary TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS actions (
+	run_id TEXT NOT NULL REFERENCES runs (id),
+	time TEXT NOT NULL,
+	action TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	username TEXT NOT NULL,
+	group_name TEXT NOT NULL,
+	gitlab_id INTEGER NOT NULL,
+	before TEXT NOT NULL,
+	after TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS actions_run ON actions (run_id);
+CREATE TABLE IF NOT EXISTS memberships (
+	run_id TEXT NOT NULL REFERENCES runs (id),
+	gitlab_id INTEGER NOT NULL,
+	user_id TEXT NOT NULL,
+	PRIMARY KEY (run_id, gitlab_id, user_id)
+);
+`
+
+// Keys of the PostgreSQL advisory locks: the runs take "psync" in ASCII, the creation of the tables "schema"
+const (
+	sqlRunLockKey    = 0x7073796e63
+	sqlSchemaLockKey = 0x736368656d61
+)
+
+// SQLStateStore keeps the state in a SQL database, together with the history of the runs for psync history:
+// a local SQLite database for the CLI users, or a PostgreSQL database shared by the instances of psync
+// of several schedulers or regions, whose runs take turns through an advisory lock.
+// The tables have generic names, give psync a database of its own, or a schema with search_path in the DSN.
+// The state is encrypted like a state file.
+type SQLStateStore struct {
+	// Driver is DriverSQLite or DriverPostgres
+	Driver string
+	// Source is the path of the SQLite database, or the PostgreSQL connection URL
+	Source string
+
+	// mu guards migrated, which is set once the tables are created, so that they are created once per store
+	mu       sync.Mutex
+	migrated bool
+}
+
+// RunHistory is a run as recorded in the SQL store.
+type RunHistory struct {
+	Summary RunSummary
+	// Failure is why the run failed, empty if it didn't
+	Failure string
+	Actions []AuditRecord
+	// Memberships are the Okta user IDs of the members of the Gitlab groups after the run, by Gitlab group ID
+	Memberships map[int][]string
+}
+
+// RunLocker is a state store that keeps the runs of several psync instances sharing it from overlapping.
+type RunLocker interface {
+	// TryLockRun takes the lock of the runs, if no other instance holds it, and returns the function releasing it.
+	TryLockRun() (unlock func(), locked bool, err error)
+}
+
+// open opens the database, creating its tables the first time.
+// The password of a PostgreSQL database is read from POSTGRES_PASSWORD_SECRET if set, rather than from the URL.
+func (s *SQLStateStore) open() (*sql.DB, error) {
+	var db *sql.DB
+	blob := "BLOB"
+	switch s.Driver {
+	case DriverSQLite:
+		var err error
+		if db, err = sql.Open(DriverSQLite, s.Source); err != nil {
+			return nil, err
+		}
+	case DriverPostgres:
+		config, err := pgx.ParseConfig(s.Source)
+		if err != nil {
+			// The error holds the URL and its password
+			return nil, errors.New("invalid PostgreSQL state URL")
+		}
+		if hasCredential("POSTGRES_PASSWORD_SECRET") {
+			password, err := credential(nil, "POSTGRES_PASSWORD_SECRET")
+			if err != nil {
+				return nil, err
+			}
+			config.Password = strings.TrimSpace(string(password))
+		}
+		db, blob = stdlib.OpenDB(*config), "BYTEA"
+	default:
+		return nil, fmt.Errorf("unknown SQL driver %q", s.Driver)
+	}
+	if err := s.migrate(db, blob); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("%s: %w", s, err)
+	}
+	return db, nil
+}
+
+// migrate creates the tables of the database if needed, once per store. On PostgreSQL they are created under
+// a transaction-level advisory lock, as concurrent CREATE TABLE IF NOT EXISTS of the first runs of several
+// runners can fail on the unique index of the types.
+func (s *SQLStateStore) migrate(db *sql.DB, blob string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.migrated {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if s.Driver == DriverPostgres {
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, sqlSchemaLockKey); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(fmt.Sprintf(sqlSchema, blob)); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.migrated = true
+	return nil
+}
+
+// String names the database without the credentials of its URL.
+func (s *SQLStateStore) String() string {
+	if s.Driver != DriverPostgres {
+		return s.Source
+	}
+	config, err := pgx.ParseConfig(s.Source)
+	if err != nil {
+		return "PostgreSQL"
+	}
+	return fmt.Sprintf("PostgreSQL %s/%s", config.Host, config.Database)
+}
+
+// q returns the query with the placeholders of the driver: ? for SQLite, $1, $2... for PostgreSQL.
+func (s *SQLStateStore) q(query string) string {
+	if s.Driver != DriverPostgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// Load reads the state. A database without state results in an empty state.
+func (s *SQLStateStore) Load() (*State, error) {
+	db, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	var data []byte
+	err = db.QueryRow(`SELECT data FROM state WHERE id = 1`).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &State{Groups: map[string]GroupMapping{}, Frozen: map[string]Freeze{}, Secrets: map[string]string{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c, err := LocalCipher()
+	if err != nil {
+		return nil, err
+	}
+	if data, err = c.Open(data); err != nil {
+		return nil, fmt.Errorf("%s: %w", s, err)
+	}
+	return DecodeState(bytes.NewReader(data))
+}
+
+// Save writes the state, encrypted if an encryption key is configured.
+func (s *SQLStateStore) Save(state *State) error {
+	var buf bytes.Buffer
+	if err := EncodeState(&buf, state); err != nil {
+		return err
+	}
+	c, err := LocalCipher()
+	if err != nil {
+		return err
+	}
+	data, err := c.Seal(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.Exec(s.q(`INSERT INTO state (id, data, updated) VALUES (1, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET data = excluded.data, updated = excluded.updated`),
+		data, clock.Now().UTC().Format(time.RFC3339Nano))
+	return err
+}
+
+// TryLockRun takes a PostgreSQL advisory lock, held by the session until it is released or psync exits.
+// SQLite databases are local to one runner, their runs aren't locked.
+func (s *SQLStateStore) TryLockRun() (func(), bool, error) {
+	if s.Driver != DriverPostgres {
+		return func() {}, true, nil
+	}
+	db, err := s.open()
+	if err != nil {
+		return nil, false, err
+	}
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		_ = db.Close()
+		return nil, false, err
+	}
+	release := func() {
+		_ = conn.Close()
+		_ = db.Close()
+	}
+	var locked bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, sqlRunLockKey).Scan(&locked); err != nil || !locked {
+		release()
+		return nil, false, err
+	}
+	return func() {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, sqlRunLockKey); err != nil {
+			logger.Warn("Could not release the run lock, it is released with the connection", "error", err)
+		}
+		release()
+	}, true, nil
+}
+
+// RecordRun records the run, its actions and the memberships of the Gitlab groups after it, in one transaction.
+func (s *SQLStateStore) RecordRun(run *Run, failure error) error {
+	summary := *run.Summary
+	if summary.Finished.IsZero() {
+		summary.Finished = clock.Now()
+	}
+	reason := ""
+	if failure != nil {
+		summary.Result, reason = ResultFailed, failure.Error()
+	}
+	encoded, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.Exec(s.q(`INSERT INTO runs (id, started, finished, result, failure, summary) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET finished = excluded.finished, result = excluded.result, failure = excluded.failure, summary = excluded.summary`),
+		run.ID, summary.Started.UTC().Format(time.RFC3339Nano), summary.Finished.UTC().Format(time.RFC3339Nano),
+		summary.Result, reason, string(encoded)); err != nil {
+		return err
+	}
+	run.mu.Lock()
+	actions := append([]AuditRecord{}, run.Audit...)
+	run.mu.Unlock()
+	for _, r := range actions {
+		if _, err := tx.Exec(s.q(`INSERT INTO actions (run_id, time, action, user_id, username, group_name, gitlab_id, before, after)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+			r.RunID, r.Time.UTC().Format(time.RFC3339Nano), r.Action, r.UserID, r.Username, r.Group, r.GitlabID, r.Before, r.After); err != nil {
+			return err
+		}
+	}
+	run.State.mu.Lock()
+	memberships := make(map[int][]string, len(run.State.Memberships))
+	for id, users := range run.State.Memberships {
+		memberships[id] = users
+	}
+	run.State.mu.Unlock()
+	for id, users := range memberships {
+		for _, u := range users {
+			if _, err := tx.Exec(s.q(`INSERT INTO memberships (run_id, gitlab_id, user_id) VALUES (?, ?, ?) ON CONFLICT DO NOTHING`), run.ID, id, u); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// Runs returns the summaries of the last runs recorded, the most recent first.
+func (s *SQLStateStore) Runs(limit int) ([]RunSummary, error) {
+	db, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.Query(s.q(`SELECT summary FROM runs ORDER BY started DESC LIMIT ?`), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	runs := make([]RunSummary, 0)
+	for rows.Next() {
+		var encoded string
+		if err := rows.Scan(&encoded); err != nil {
+			return nil, err
+		}
+		var summary RunSummary
+		if err := json.Unmarshal([]byte(encoded), &summary); err != nil {
+			return nil, err
+		}
+		runs = append(runs, summary)
+	}
+	return runs, rows.Err()
+}
+
+// Run returns the run recorded with the ID.
+func (s *SQLStateStore) Run(id string) (*RunHistory, error) {
+	db, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	h := &RunHistory{Memberships: map[int][]string{}}
+	var encoded string
+	err = db.QueryRow(s.q(`SELECT summary, failure FROM runs WHERE id = ?`), id).Scan(&encoded, &h.Failure)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("no run %s in %s", id, s)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(encoded), &h.Summary); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(s.q(`SELECT time, action, user_id, username, group_name, gitlab_id, before, after
+		FROM actions WHERE run_id = ? ORDER BY time`), id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		r := AuditRecord{RunID: id}
+		var t string
+		if err := rows.Scan(&t, &r.Action, &r.UserID, &r.Username, &r.Group, &r.GitlabID, &r.Before, &r.After); err != nil {
+			return nil, err
+		}
+		if r.Time, err = time.Parse(time.RFC3339Nano, t); err != nil {
+			return nil, err
+		}
+		h.Actions = append(h.Actions, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	members, err := db.Query(s.q(`SELECT gitlab_id, user_id FROM memberships WHERE run_id = ? ORDER BY gitlab_id, user_id`), id)
+	if err != nil {
+		return nil, err
+	}
+	defer members.Close()
+	for members.Next() {
+		var gitlabID int
+		var user string
+		if err := members.Scan(&gitlabID, &user); err != nil {
+			return nil, err
+		}
+		h.Memberships[gitlabID] = append(h.Memberships[gitlabID], user)
+	}
+	return h, members.Err()
+}
+
+// recordRunHistory records the run in the state store if it keeps the history of the runs, see SQLStateStore.
+func recordRunHistory(store StateStore, run *Run, failure error) error {
+	s, ok := store.(*SQLStateStore)
+	if !ok {
+		return nil
+	}
+	return s.RecordRun(run, failure)
+}
+
+// lockRun waits until no other psync instance sharing the state store runs, for at most STATE_LOCK_TIMEOUT,
+// and returns the function releasing the lock. Stores other than PostgreSQL have no lock.
+func lockRun(store StateStore) (func(), error) {
+	locker, ok := store.(RunLocker)
+	if !ok {
+		return func() {}, nil
+	}
+	deadline := clock.Now().Add(viper.GetDuration("STATE_LOCK_TIMEOUT"))
+	for waited := false; ; waited = true {
+		unlock, locked, err := locker.TryLockRun()
+		if err != nil {
+			return nil, fmt.Errorf("taking the run lock: %w", err)
+		}
+		if locked {
+			return unlock, nil
+		}
+		if clock.Now().After(deadline) {
+			return nil, fmt.Errorf("another psync instance has been running for more than STATE_LOCK_TIMEOUT %s", viper.GetDuration("STATE_LOCK_TIMEOUT"))
+		}
+		if !waited {
+			logger.Info("Waiting for the run of another psync instance to end")
+		}
+		clock.Sleep(5 * time.Second)
+	}
+}
+
+// sortedGitlabIDs returns the Gitlab group IDs of the memberships, sorted.
+func sortedGitlabIDs(memberships map[int][]string) []int {
+	ids := make([]int, 0, len(memberships))
+	for id := range memberships {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
@@ -0,0 +1,188 @@
+package cmd
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+func TestSQLStateStoreQuery(t *testing.T) {
+	query := `INSERT INTO runs (id, result) VALUES (?, ?) ON CONFLICT (id) DO UPDATE SET result = ?`
+	tests := []struct {
+		driver string
+		want   string
+	}{
+		{DriverSQLite, query},
+		{DriverPostgres, `INSERT INTO runs (id, result) VALUES ($1, $2) ON CONFLICT (id) DO UPDATE SET result = $3`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.driver, func(t *testing.T) {
+			s := &SQLStateStore{Driver: tt.driver}
+			if got := s.q(query); got != tt.want {
+				t.Errorf("q() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// sqliteStore returns a store on a new SQLite database.
+func sqliteStore(t *testing.T) *SQLStateStore {
+	return &SQLStateStore{Driver: DriverSQLite, Source: filepath.Join(t.TempDir(), "psync.db")}
+}
+
+func TestSQLStateStoreState(t *testing.T) {
+	s := sqliteStore(t)
+	state, err := s.Load()
+	if err != nil {
+		t.Fatalf("Load() of a new database error = %v", err)
+	}
+	if len(state.Groups) != 0 {
+		t.Errorf("new database has %d group mappings, want none", len(state.Groups))
+	}
+	if !s.migrated {
+		t.Errorf("the tables are not recorded as created after the first open")
+	}
+	state.SetGroupMapping("00ga", "payments", 101)
+	state.SetMembership(101, []string{"00u1"})
+	if err := s.Save(state); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	// A second store on the same database creates the existing tables again, without error
+	loaded, err := (&SQLStateStore{Driver: DriverSQLite, Source: s.Source}).Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if id, ok := loaded.GitlabGroupID("00ga"); !ok || id != 101 {
+		t.Errorf("loaded mapping of 00ga = %d, %v, want 101", id, ok)
+	}
+	if members, _ := loaded.Membership(101); !reflect.DeepEqual(members, []string{"00u1"}) {
+		t.Errorf("loaded membership of 101 = %v, want [00u1]", members)
+	}
+}
+
+func TestSQLStateStoreRecordRun(t *testing.T) {
+	quietLogger(t)
+	s := sqliteStore(t)
+	started := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	state := &State{Groups: map[string]GroupMapping{}}
+	state.SetMembership(101, []string{"00u1", "00u2"})
+	runs := []*Run{
+		{ID: "run-1", State: state, Summary: &RunSummary{ID: "run-1", Started: started, Result: ResultSuccess, Added: 1}},
+		{ID: "run-2", State: state, Summary: &RunSummary{ID: "run-2", Started: started.Add(time.Hour), Result: ResultSuccess}},
+	}
+	runs[0].audit("added", "00u2", "bert", "payments", 101, 0, gitlab.DeveloperPermissions)
+	if err := s.RecordRun(runs[0], nil); err != nil {
+		t.Fatalf("RecordRun() error = %v", err)
+	}
+	if err := s.RecordRun(runs[1], errors.New("gitlab unavailable")); err != nil {
+		t.Fatalf("RecordRun() of a failed run error = %v", err)
+	}
+
+	summaries, err := s.Runs(10)
+	if err != nil {
+		t.Fatalf("Runs() error = %v", err)
+	}
+	if len(summaries) != 2 || summaries[0].ID != "run-2" || summaries[1].ID != "run-1" {
+		t.Fatalf("Runs() = %+v, want run-2 then run-1", summaries)
+	}
+	if summaries[0].Result != ResultFailed {
+		t.Errorf("result of the failed run = %s, want %s", summaries[0].Result, ResultFailed)
+	}
+
+	h, err := s.Run("run-1")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(h.Actions) != 1 || h.Actions[0].Username != "bert" || h.Actions[0].After != "developer" {
+		t.Errorf("actions of run-1 = %+v, want bert added as developer", h.Actions)
+	}
+	if want := map[int][]string{101: {"00u1", "00u2"}}; !reflect.DeepEqual(h.Memberships, want) {
+		t.Errorf("memberships after run-1 = %v, want %v", h.Memberships, want)
+	}
+	if h, err := s.Run("run-2"); err != nil || h.Failure != "gitlab unavailable" {
+		t.Errorf("Run(run-2) = %+v, %v, want the failure recorded", h, err)
+	}
+	if _, err := s.Run("run-3"); err == nil {
+		t.Errorf("Run() of an unknown run succeeded")
+	}
+}
+
+func TestSQLStateStoreTryLockRunSQLite(t *testing.T) {
+	unlock, locked, err := sqliteStore(t).TryLockRun()
+	if err != nil || !locked {
+		t.Fatalf("TryLockRun() = %v, %v, want the SQLite runs never locked out", locked, err)
+	}
+	unlock()
+}
+
+// fakeLocker is a state store whose run lock is held by another instance for a number of attempts.
+type fakeLocker struct {
+	FileStateStore
+	busy     int
+	err      error
+	attempts int
+	unlocked bool
+}
+
+func (l *fakeLocker) TryLockRun() (func(), bool, error) {
+	l.attempts++
+	if l.err != nil {
+		return nil, false, l.err
+	}
+	if l.attempts <= l.busy {
+		return nil, false, nil
+	}
+	return func() { l.unlocked = true }, true, nil
+}
+
+func TestLockRun(t *testing.T) {
+	quietLogger(t)
+	viper.Set("STATE_LOCK_TIMEOUT", time.Minute)
+	t.Cleanup(viper.Reset)
+	saved := clock
+	t.Cleanup(func() { clock = saved })
+
+	tests := []struct {
+		name         string
+		locker       *fakeLocker
+		wantErr      bool
+		wantAttempts int
+		wantWaited   time.Duration
+	}{
+		{name: "free lock", locker: &fakeLocker{}, wantAttempts: 1},
+		{name: "waits for the other run", locker: &fakeLocker{busy: 3}, wantAttempts: 4, wantWaited: 15 * time.Second},
+		{name: "times out", locker: &fakeLocker{busy: 100}, wantErr: true, wantAttempts: 14, wantWaited: 65 * time.Second},
+		{name: "lock error", locker: &fakeLocker{err: errors.New("connection refused")}, wantErr: true, wantAttempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+			manual := &ManualClock{T: start}
+			clock = manual
+			unlock, err := lockRun(tt.locker)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("lockRun() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.locker.attempts != tt.wantAttempts {
+				t.Errorf("%d attempts, want %d", tt.locker.attempts, tt.wantAttempts)
+			}
+			if waited := manual.T.Sub(start); waited != tt.wantWaited {
+				t.Errorf("waited %s, want %s", waited, tt.wantWaited)
+			}
+			if err == nil {
+				unlock()
+				if !tt.locker.unlocked {
+					t.Errorf("the lock is not released")
+				}
+			}
+		})
+	}
+	if unlock, err := lockRun(&FileStateStore{}); err != nil || unlock == nil {
+		t.Errorf("lockRun() of a store without lock = %v, want no lock to take", err)
+	}
+}
@@ -0,0 +1,350 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/spf13/viper"
+
+	"psync/internal/set"
+)
+
+// State is the data psync persists between runs.
+type State struct {
+	// Groups maps Okta group IDs to the Gitlab groups they were resolved to
+	Groups map[string]GroupMapping `json:"groups"`
+	// Grants are the memberships given through just-in-time groups, until they lapse
+	Grants []Grant `json:"grants,omitempty"`
+	// Frozen are the Okta group IDs whose Gitlab groups receive no new members
+	Frozen map[string]Freeze `json:"frozen,omitempty"`
+	// Secrets are the secret versions activated by rotate-check, by config key
+	Secrets map[string]string `json:"secrets,omitempty"`
+	// Managed are the Okta user IDs of the members psync added to the Gitlab groups, by Gitlab group ID
+	Managed map[int][]string `json:"managed,omitempty"`
+	// Digest are the changes not sent in a digest yet, see DIGEST_NOTIFIER
+	Digest *Digest `json:"digest,omitempty"`
+	// OktaMembers are the Okta group users cached with OKTA_MEMBERSHIP_CACHE
+	OktaMembers *OktaMembersCache `json:"okta_members,omitempty"`
+	// Recerts are the access recertification campaigns, see psync recert
+	Recerts []RecertCampaign `json:"recerts,omitempty"`
+	// Revocations are the Okta user IDs whose access a recertification revoked, by Okta group ID
+	Revocations map[string][]string `json:"revocations,omitempty"`
+	// Checkpoint is the run psync cloudrun applies over several requests, nil when none is in progress
+	Checkpoint *RunCheckpoint `json:"checkpoint,omitempty"`
+	// Memberships are the Okta user IDs of the members of the Gitlab groups after the last run, by Gitlab group ID,
+	// to tell the changes made in Gitlab outside of psync since then
+	Memberships map[int][]string `json:"memberships,omitempty"`
+
+	// mu guards the group mappings, grants and managed members changed while planning and applying
+	mu sync.Mutex
+}
+
+// GroupMapping links an Okta group to a Gitlab group by their IDs.
+// The names are informational only, so that renaming either group doesn't break the mapping.
+type GroupMapping struct {
+	OktaName string `json:"okta_name"`
+	GitlabID int    `json:"gitlab_id"`
+}
+
+// StateStore loads and saves the psync state.
+type StateStore interface {
+	Load() (*State, error)
+	Save(state *State) error
+}
+
+// FileStateStore keeps the state in a local JSON file.
+type FileStateStore struct {
+	Path string
+}
+
+// NewStateStore returns the state store configured with STATE_FILE.
+func NewStateStore() (StateStore, error) {
+	return OpenStateStore(viper.GetString("STATE_FILE"))
+}
+
+// LoadState loads the state from the state store configured with STATE_FILE.
+func LoadState() (*State, error) {
+	store, err := NewStateStore()
+	if err != nil {
+		return nil, err
+	}
+	return store.Load()
+}
+
+// OpenStateStore returns the state store for the location.
+// gs://bucket/object locations are stored in Google Cloud Storage, firestore://project/collection/document locations
+// in a Firestore document of the default database, sqlite:PATH locations in a local SQLite database with the history
+// of the runs, postgres:// URLs in a PostgreSQL database shared by several psync instances, with the history and
+// the lock of the runs, anything else is a local file path.
+func OpenStateStore(location string) (StateStore, error) {
+	if strings.HasPrefix(location, "sqlite:") {
+		path := strings.TrimPrefix(location, "sqlite:")
+		if path == "" {
+			return nil, fmt.Errorf("invalid state location %s, expected sqlite:PATH", location)
+		}
+		return &SQLStateStore{Driver: DriverSQLite, Source: path}, nil
+	}
+	if strings.HasPrefix(location, "postgres://") || strings.HasPrefix(location, "postgresql://") {
+		return &SQLStateStore{Driver: DriverPostgres, Source: location}, nil
+	}
+	if strings.HasPrefix(location, "firestore://") {
+		parts := strings.Split(strings.TrimPrefix(location, "firestore://"), "/")
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("invalid state location %s, expected firestore://project/collection/document", location)
+		}
+		return &FirestoreStateStore{Project: parts[0], Collection: parts[1], Document: parts[2]}, nil
+	}
+	if strings.HasPrefix(location, "gs://") {
+		parts := strings.SplitN(strings.TrimPrefix(location, "gs://"), "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid state location %s, expected gs://bucket/object", location)
+		}
+		return &GCSStateStore{Bucket: parts[0], Object: parts[1]}, nil
+	}
+	if location == "" {
+		return nil, errors.New("no state location configured")
+	}
+	return &FileStateStore{Path: location}, nil
+}
+
+// Load reads the state file. A missing file results in an empty state.
+func (f *FileStateStore) Load() (*State, error) {
+	data, err := ioutil.ReadFile(f.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &State{Groups: map[string]GroupMapping{}, Frozen: map[string]Freeze{}, Secrets: map[string]string{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c, err := LocalCipher()
+	if err != nil {
+		return nil, err
+	}
+	if data, err = c.Open(data); err != nil {
+		return nil, fmt.Errorf("%s: %w", f.Path, err)
+	}
+	return DecodeState(bytes.NewReader(data))
+}
+
+// Save writes the state file, encrypted if an encryption key is configured.
+func (f *FileStateStore) Save(state *State) error {
+	var buf bytes.Buffer
+	if err := EncodeState(&buf, state); err != nil {
+		return err
+	}
+	c, err := LocalCipher()
+	if err != nil {
+		return err
+	}
+	data, err := c.Seal(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(f.Path, data, 0600)
+}
+
+// GCSStateStore keeps the state in a Google Cloud Storage object, for deployments without a persistent disk.
+type GCSStateStore struct {
+	Bucket string
+	Object string
+}
+
+// Load reads the state object. A missing object results in an empty state.
+func (g *GCSStateStore) Load() (*State, error) {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx, gcpOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	r, err := client.Bucket(g.Bucket).Object(g.Object).NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return &State{Groups: map[string]GroupMapping{}, Frozen: map[string]Freeze{}, Secrets: map[string]string{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return DecodeState(r)
+}
+
+// Save writes the state object.
+func (g *GCSStateStore) Save(state *State) error {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx, gcpOptions()...)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	w := client.Bucket(g.Bucket).Object(g.Object).NewWriter(ctx)
+	w.ContentType = "application/json"
+	if err := EncodeState(w, state); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// DecodeState reads a JSON encoded state.
+func DecodeState(r io.Reader) (*State, error) {
+	state := &State{}
+	if err := json.NewDecoder(r).Decode(state); err != nil {
+		return nil, err
+	}
+	if state.Groups == nil {
+		state.Groups = map[string]GroupMapping{}
+	}
+	if state.Frozen == nil {
+		state.Frozen = map[string]Freeze{}
+	}
+	if state.Secrets == nil {
+		state.Secrets = map[string]string{}
+	}
+	return state, nil
+}
+
+// EncodeState writes the state as indented JSON.
+func EncodeState(w io.Writer, state *State) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(state)
+}
+
+// GitlabGroupID returns the Gitlab group ID the Okta group is mapped to.
+func (s *State) GitlabGroupID(oktaID string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.Groups[oktaID]
+	return m.GitlabID, ok
+}
+
+// OktaGroupIDs returns the IDs of the Okta groups mapped to the Gitlab group.
+func (s *State) OktaGroupIDs(gitlabID int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0)
+	for id, m := range s.Groups {
+		if m.GitlabID == gitlabID {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// SetGroupMapping maps the Okta group to the Gitlab group.
+func (s *State) SetGroupMapping(oktaID, oktaName string, gitlabID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Groups[oktaID] = GroupMapping{OktaName: oktaName, GitlabID: gitlabID}
+}
+
+// OktaGroupID returns the ID of the mapped Okta group with the name.
+func (s *State) OktaGroupID(name string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, m := range s.Groups {
+		if m.OktaName == name {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("no mapped Okta group %s", name)
+}
+
+// IsManaged reports whether psync added the Okta user to the Gitlab group.
+func (s *State) IsManaged(gitlabID int, userID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range s.Managed[gitlabID] {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// SetManaged records whether psync manages the membership of the Okta user in the Gitlab group.
+func (s *State) SetManaged(gitlabID int, userID string, managed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Managed == nil {
+		s.Managed = map[int][]string{}
+	}
+	ids := make([]string, 0, len(s.Managed[gitlabID])+1)
+	for _, id := range s.Managed[gitlabID] {
+		if id != userID {
+			ids = append(ids, id)
+		}
+	}
+	if managed {
+		ids = append(ids, userID)
+	}
+	if len(ids) == 0 {
+		delete(s.Managed, gitlabID)
+		return
+	}
+	s.Managed[gitlabID] = ids
+}
+
+// Membership returns the Okta user IDs of the members of the Gitlab group after the last run, and whether it was recorded.
+func (s *State) Membership(gitlabID int) ([]string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids, ok := s.Memberships[gitlabID]
+	return append([]string{}, ids...), ok
+}
+
+// SetMembership records the Okta user IDs of the members of the Gitlab group after the run.
+func (s *State) SetMembership(gitlabID int, userIDs []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Memberships == nil {
+		s.Memberships = map[int][]string{}
+	}
+	s.Memberships[gitlabID] = userIDs
+}
+
+// Revoked returns the Okta user IDs whose access to the Okta group's Gitlab group a recertification revoked.
+func (s *State) Revoked(oktaGroupID string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.Revocations[oktaGroupID]...)
+}
+
+// Revoke records that a recertification revoked the access of the user to the Okta group's Gitlab group.
+func (s *State) Revoke(oktaGroupID, userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Revocations == nil {
+		s.Revocations = map[string][]string{}
+	}
+	if !set.Contains(s.Revocations[oktaGroupID], userID) {
+		s.Revocations[oktaGroupID] = append(s.Revocations[oktaGroupID], userID)
+	}
+}
+
+// Unrevoke ends the revocation of the access of the user to the Okta group's Gitlab group.
+func (s *State) Unrevoke(oktaGroupID, userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Revocations[oktaGroupID] = set.Difference(s.Revocations[oktaGroupID], []string{userID})
+	if len(s.Revocations[oktaGroupID]) == 0 {
+		delete(s.Revocations, oktaGroupID)
+	}
+}
+
+// AddGrant records a just-in-time grant of the user to a Gitlab group through the Okta group.
+func (s *State) AddGrant(oktaGroupID string, gitlabID int, userID string, expires time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Grants = append(s.Grants, Grant{OktaGroupID: oktaGroupID, GitlabID: gitlabID, UserID: userID, Expires: expires})
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// stateCmd groups the commands that operate on the state store
+var stateCmd = &cobra.Command{
+	Use:   "state",
+	Short: "Inspect and move the psync state",
+	Long: `Export, import and migrate the state psync persists between runs.
+State locations are local file paths, sqlite:PATH databases, postgres://user@host/database,
+gs://bucket/object or firestore://project/collection/document URLs.`,
+}
+
+var stateExportCmd = &cobra.Command{
+	Use:   "export [file]",
+	Short: "Export the state as JSON to a file or stdout",
+	Long: `Export the state as JSON to stdout, or to a file encrypted like the state file with ENCRYPTION_KEY_SECRET.
+psync state import reads both.`,
+	Example: `  psync state export > state.json`,
+	Args:    cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// Like with psync export, stdout only receives the state and the logs go to stderr
+		stdout := os.Stdout
+		if len(args) == 0 {
+			os.Stdout = os.Stderr
+			cobra.CheckErr(setupLogging(viper.GetString("LOG_LEVEL"), viper.GetString("LOG_FORMAT")))
+		}
+		state, err := LoadState()
+		cobra.CheckErr(err)
+		if len(args) == 0 {
+			cobra.CheckErr(EncodeState(stdout, state))
+			return
+		}
+		cobra.CheckErr((&FileStateStore{Path: args[0]}).Save(state))
+	},
+}
+
+var stateImportCmd = &cobra.Command{
+	Use:     "import <file>",
+	Short:   "Replace the state with a JSON export",
+	Example: `  psync state import state.json`,
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// Exports to a file are encrypted like the state file
+		data, err := readLocalFile(args[0])
+		cobra.CheckErr(err)
+		state, err := DecodeState(bytes.NewReader(data))
+		cobra.CheckErr(err)
+		store, err := NewStateStore()
+		cobra.CheckErr(err)
+		cobra.CheckErr(store.Save(state))
+		fmt.Printf("Imported %d group mappings\n", len(state.Groups))
+	},
+}
+
+var stateMigrateFrom string
+
+var stateMigrateCmd = &cobra.Command{
+	Use:   "migrate <to>",
+	Short: "Copy the state to another backend",
+	Long: `Copy the state from the configured state location, or the one given with --from, to another location.
+Update STATE_FILE to the new location afterwards.`,
+	Example: `  # Move the state from the local file to Cloud Storage
+  psync state migrate gs://psync-state/state.json --from .psync-state.json`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		from := stateMigrateFrom
+		if from == "" {
+			from = viper.GetString("STATE_FILE")
+		}
+		src, err := OpenStateStore(from)
+		cobra.CheckErr(err)
+		dst, err := OpenStateStore(args[0])
+		cobra.CheckErr(err)
+		state, err := src.Load()
+		cobra.CheckErr(err)
+		cobra.CheckErr(dst.Save(state))
+		fmt.Printf("Migrated %d group mappings from %s to %s\n", len(state.Groups), from, args[0])
+	},
+}
+
+func init() {
+	stateMigrateCmd.Flags().StringVar(&stateMigrateFrom, "from", "", "state location to migrate from (default is STATE_FILE)")
+	stateCmd.AddCommand(stateExportCmd, stateImportCmd, stateMigrateCmd)
+	rootCmd.AddCommand(stateCmd)
+}
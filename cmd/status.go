@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// serveStatus keeps the summary of the last run for the status endpoints
+type serveStatus struct {
+	mu      sync.RWMutex
+	last    *RunSummary
+	running bool
+}
+
+func (s *serveStatus) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running = true
+}
+
+func (s *serveStatus) finish(summary *RunSummary) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running = false
+	s.last = summary
+}
+
+// ServeJSON serves the last run summary as /status.json.
+func (s *serveStatus) ServeJSON(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	_ = json.NewEncoder(w).Encode(struct {
+		Running bool        `json:"running"`
+		LastRun *RunSummary `json:"last_run"`
+	}{s.running, s.last})
+}
+
+// badgeTemplate is a flat badge in the style of shields.io
+const badgeTemplate = `<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="psync: %[3]s">
+<rect width="44" height="20" fill="#555"/><rect x="44" width="%[2]d" height="20" fill="%[4]s"/>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,DejaVu Sans,sans-serif" font-size="11">
+<text x="22" y="14">psync</text><text x="%[5]d" y="14">%[3]s</text>
+</g>
+</svg>`
+
+// badgeColors are the colors of the badge by result
+var badgeColors = map[string]string{
+	ResultSuccess: "#4c1",
+	ResultWarning: "#dfb317",
+	ResultFailed:  "#e05d44",
+}
+
+// ServeBadge serves an SVG badge with the result and drift of the last run.
+func (s *serveStatus) ServeBadge(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	text, color := "no runs", "#9f9f9f"
+	if s.last != nil {
+		text = fmt.Sprintf("%s, drift %d", s.last.Result, s.last.Drift)
+		if c, ok := badgeColors[s.last.Result]; ok {
+			color = c
+		}
+	}
+	// Approximate the text width, Verdana 11px averages 7px per character
+	width := len(text)*7 + 10
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = fmt.Fprintf(w, badgeTemplate, 44+width, width, text, color, 44+width/2)
+}
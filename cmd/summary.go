@@ -0,0 +1,50 @@
+package cmd
+
+import "time"
+
+// Run results
+const (
+	ResultSuccess = "success"
+	// ResultWarning means the run completed but raised warnings, alerts or conflicts
+	ResultWarning = "warning"
+	// ResultFailed means the run stopped on an error, see RunSummary.Failure
+	ResultFailed = "failed"
+)
+
+// RunSummary describes the outcome of a sync run.
+type RunSummary struct {
+	ID       string    `json:"run_id"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Result   string    `json:"result"`
+	// Failure is why the run failed, empty if it didn't
+	Failure string `json:"failure,omitempty"`
+	// Drift is the number of membership changes the run found necessary
+	Drift int `json:"drift"`
+	// ExternalDrift is the number of membership changes made in the Gitlab groups outside of psync since the last run.
+	// The changes of the Drift that don't revert them are driven by Okta.
+	ExternalDrift int `json:"external_drift"`
+	Added         int `json:"added"`
+	Removed       int `json:"removed"`
+	Skipped       int `json:"skipped"`
+	Conflicts     int `json:"conflicts"`
+	Warnings      int `json:"warnings"`
+	Alerts        int `json:"alerts"`
+	// Quota is the API quota the run consumed
+	Quota *QuotaUsage `json:"quota,omitempty"`
+	// GitlabTokenExpires is when the Gitlab token expires, nil if it never does or is unknown
+	GitlabTokenExpires *time.Time `json:"gitlab_token_expires,omitempty"`
+}
+
+// failedSummary returns the summary of a failed run: the summary Sync returned, or a summary of its own for a run
+// that failed before it started.
+func failedSummary(summary *RunSummary, started time.Time, err error) *RunSummary {
+	if summary == nil {
+		summary = &RunSummary{Started: started}
+	}
+	if summary.Finished.IsZero() {
+		summary.Finished = clock.Now()
+	}
+	summary.Result, summary.Failure = ResultFailed, err.Error()
+	return summary
+}
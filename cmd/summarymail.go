@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// RunReport is the data of the summary email of a run.
+type RunReport struct {
+	ID       string
+	Started  time.Time
+	Finished time.Time
+	// Result is success, warning or failed
+	Result  string
+	Failure string
+	Added   int
+	Removed int
+	// Quota summarizes the API requests and secret accesses of the run
+	Quota string
+	// Groups are the changes by Okta group, sorted by group
+	Groups    []RunReportGroup
+	Skipped   []string
+	Conflicts []string
+	Alerts    []string
+}
+
+// RunReportGroup lists the changes applied for an Okta group.
+type RunReportGroup struct {
+	Name    string
+	Changes []string
+}
+
+// runReportHTML lays out the summary email for the mail clients showing HTML
+var runReportHTML = template.Must(template.New("report").Parse(`<html><body style="font-family: sans-serif">
+<h2>psync run {{.ID}}</h2>
+<p>{{.Started.Format "2006-01-02 15:04 MST"}} to {{.Finished.Format "15:04 MST"}}: <b>{{.Result}}</b>,
+{{.Added}} added and {{.Removed}} removed in {{len .Groups}} groups.</p>
+<p style="color: #555555">Quota: {{.Quota}}.</p>
+{{if .Failure}}<p style="color: #b00020">The run failed: {{.Failure}}</p>{{end}}
+{{range .Groups}}<h3>{{.Name}}</h3>
+<ul>{{range .Changes}}<li>{{.}}</li>{{end}}</ul>
+{{end}}{{if .Skipped}}<h3>Skipped groups</h3>
+<ul>{{range .Skipped}}<li>{{.}}</li>{{end}}</ul>
+{{end}}{{if .Conflicts}}<h3>Conflicts</h3>
+<ul>{{range .Conflicts}}<li>{{.}}</li>{{end}}</ul>
+{{end}}{{if .Alerts}}<h3>Alerts</h3>
+<ul>{{range .Alerts}}<li>{{.}}</li>{{end}}</ul>
+{{end}}</body></html>
+`))
+
+// emailRunSummary emails the summary of the run to the SUMMARY_EMAIL_RECIPIENTS through the SMTP server,
+// for the readers of neither the chat channels nor the logs. Runs without changes, conflicts, alerts
+// or failure aren't reported unless SUMMARY_EMAIL_UNCHANGED is set. Does nothing without recipients.
+func emailRunSummary(run *Run, failure error) error {
+	recipients := viper.GetStringSlice("SUMMARY_EMAIL_RECIPIENTS")
+	if len(recipients) == 0 {
+		return nil
+	}
+	report := newRunReport(run, failure)
+	if len(report.Groups)+len(report.Conflicts)+len(report.Alerts) == 0 && failure == nil && !viper.GetBool("SUMMARY_EMAIL_UNCHANGED") {
+		return nil
+	}
+	n, err := report.Notification()
+	if err != nil {
+		return err
+	}
+	email, err := NewNotifier("email")
+	if err != nil {
+		return err
+	}
+	return email.Send(strings.Join(recipients, ","), n)
+}
+
+// newRunReport collects the outcome of the run.
+func newRunReport(run *Run, failure error) RunReport {
+	report := RunReport{
+		ID:        run.ID,
+		Finished:  clock.Now(),
+		Result:    ResultSuccess,
+		Skipped:   run.Skipped,
+		Conflicts: run.Conflicts,
+		Alerts:    alerts,
+		Quota:     runQuota.String(),
+	}
+	if run.Summary != nil {
+		report.Started, report.Added, report.Removed = run.Summary.Started, run.Summary.Added, run.Summary.Removed
+	}
+	run.mu.Lock()
+	for g, changes := range run.groupChanges {
+		report.Groups = append(report.Groups, RunReportGroup{Name: g, Changes: changes})
+	}
+	run.mu.Unlock()
+	sort.Slice(report.Groups, func(i, j int) bool { return report.Groups[i].Name < report.Groups[j].Name })
+	switch {
+	case failure != nil:
+		report.Result, report.Failure = ResultFailed, failure.Error()
+	case len(report.Conflicts)+len(report.Alerts)+len(dataWarnings) > 0:
+		report.Result = ResultWarning
+	}
+	return report
+}
+
+// Notification renders the report as plain text and HTML.
+func (r RunReport) Notification() (Notification, error) {
+	var text strings.Builder
+	fmt.Fprintf(&text, "psync run %s, %s to %s: %s, %d added and %d removed in %d groups.\n",
+		r.ID, r.Started.Format("2006-01-02 15:04 MST"), r.Finished.Format("15:04 MST"), r.Result, r.Added, r.Removed, len(r.Groups))
+	fmt.Fprintf(&text, "Quota: %s.\n", r.Quota)
+	if r.Failure != "" {
+		fmt.Fprintf(&text, "The run failed: %s\n", r.Failure)
+	}
+	for _, g := range r.Groups {
+		fmt.Fprintf(&text, "\n%s:\n- %s\n", g.Name, strings.Join(g.Changes, "\n- "))
+	}
+	for _, section := range []struct {
+		title string
+		lines []string
+	}{{"Skipped groups", r.Skipped}, {"Conflicts", r.Conflicts}, {"Alerts", r.Alerts}} {
+		if len(section.lines) > 0 {
+			fmt.Fprintf(&text, "\n%s:\n- %s\n", section.title, strings.Join(section.lines, "\n- "))
+		}
+	}
+	var html bytes.Buffer
+	if err := runReportHTML.Execute(&html, r); err != nil {
+		return Notification{}, fmt.Errorf("rendering the summary email: %w", err)
+	}
+	subject := fmt.Sprintf("psync run %s: %d added, %d removed in %d groups", r.ID, r.Added, r.Removed, len(r.Groups))
+	if r.Failure != "" {
+		subject = fmt.Sprintf("psync run %s failed after %d added, %d removed", r.ID, r.Added, r.Removed)
+	}
+	return Notification{Subject: subject, Text: text.String(), HTML: html.String()}, nil
+}
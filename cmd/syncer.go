@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"psync/internal/engine"
+	"psync/internal/set"
+)
+
+// Syncer plans the membership changes of the runs of psync and applies them, with the sync engine.
+type Syncer = engine.Syncer[*Run, OktaGroup, GroupSync]
+
+// SyncerOption configures a Syncer.
+type SyncerOption = engine.Option[*Run, OktaGroup, GroupSync]
+
+// WithTransforms replaces the configured PIPELINE_TRANSFORMS.
+func WithTransforms(names ...string) SyncerOption {
+	return func(s *Syncer) error {
+		ts, err := namedTransforms(names)
+		if err != nil {
+			return err
+		}
+		s.Pipeline.Transforms = ts
+		return nil
+	}
+}
+
+// WithPolicies replaces the configured PIPELINE_POLICIES.
+func WithPolicies(names ...string) SyncerOption {
+	return func(s *Syncer) error {
+		ps, err := namedPolicies(names)
+		if err != nil {
+			return err
+		}
+		s.Pipeline.Policies = ps
+		return nil
+	}
+}
+
+// WithCheckpoints applies the groups in chunks of the size, saving the state to the store after each chunk with
+// the IDs of the Okta groups left in its Checkpoint, and leaves the chunks after the deadline to a resumed run.
+// The Checkpoint is cleared once all the groups are applied.
+func WithCheckpoints(store StateStore, size int, deadline time.Time) SyncerOption {
+	return engine.WithCheckpoints[*Run, OktaGroup](size, deadline, func(run *Run, remaining []GroupSync) error {
+		return saveCheckpoint(store, run, remaining)
+	})
+}
+
+// saveCheckpoint saves the state with the IDs of the Okta groups left to apply, or without checkpoint when none are left.
+func saveCheckpoint(store StateStore, run *Run, remaining []GroupSync) error {
+	state := run.State
+	state.mu.Lock()
+	if len(remaining) == 0 {
+		state.Checkpoint = nil
+	} else {
+		ids := make([]string, 0, len(remaining))
+		for _, gs := range remaining {
+			ids = set.Union(ids, []string{gs.Group.ID})
+		}
+		if state.Checkpoint == nil {
+			state.Checkpoint = &RunCheckpoint{Started: run.Summary.Started}
+		}
+		state.Checkpoint.Remaining = ids
+	}
+	state.mu.Unlock()
+	return store.Save(state)
+}
+
+// NewSyncer builds a Syncer from the source to the target, starting from the state.
+// The transforms and policies are configured with PIPELINE_TRANSFORMS and PIPELINE_POLICIES unless an option replaces them.
+func NewSyncer(source Source, target SyncTarget, state *State, opts ...SyncerOption) (*Syncer, error) {
+	ts, ps, err := configuredStages()
+	if err != nil {
+		return nil, err
+	}
+	checkCapabilities(target)
+	newRun := func() *Run {
+		resetRun()
+		run := &Run{ID: ids.NewID(), State: state, Target: target}
+		setCurrentRun(run.ID)
+		run.Summary = &RunSummary{ID: run.ID, Started: clock.Now()}
+		setRunDeadline(run.Summary.Started)
+		return run
+	}
+	base := []SyncerOption{
+		engine.WithTransforms[*Run, OktaGroup, GroupSync](ts...),
+		engine.WithPolicies[*Run, OktaGroup](ps...),
+		engine.WithHooks(syncHooks),
+		engine.WithClock[*Run, OktaGroup, GroupSync](clock.Now),
+		engine.WithLogger[*Run, OktaGroup, GroupSync](logger),
+	}
+	return engine.New[*Run, OktaGroup, GroupSync](newRun, source, target, append(base, opts...)...)
+}
+
+// syncHooks trace the stages of the runs, and report and record their changes.
+var syncHooks = engine.Hooks[*Run, OktaGroup, GroupSync]{
+	Stage: func(run *Run, stage string) func(int, error) {
+		span := startSpan(stageSpans[stage])
+		return func(n int, err error) {
+			if stage == engine.StageSource {
+				span.SetAttributes(attribute.Int("psync.groups", n))
+			}
+			span.end(err)
+		}
+	},
+	Grouped: func(run *Run, groups []OktaGroup) {
+		run.Groups = groups
+	},
+	Planned: reportExternalDrift,
+	Applying: func(run *Run, syncs []GroupSync) {
+		for _, gs := range syncs {
+			run.Summary.Drift += len(gs.Plan.Add) + len(gs.Plan.Remove)
+		}
+		run.emit(EventPlanned, "", "", nil)
+	},
+	Applied: recordMemberships,
+}
+
+// stageSpans are the names of the spans of the stages of the runs
+var stageSpans = map[string]string{
+	engine.StageSource: "psync.source.groups",
+	engine.StagePlan:   "psync.target.plan",
+	engine.StageApply:  "psync.target.apply",
+}
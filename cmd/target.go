@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// Capability is an optional feature that a sync target may or may not support.
+type Capability string
+
+const (
+	// CapabilityExpiration allows memberships to expire at a given date
+	CapabilityExpiration Capability = "expiration"
+	// CapabilityCustomRoles allows assigning roles beyond the built-in access levels
+	CapabilityCustomRoles Capability = "custom roles"
+	// CapabilityInvitations allows inviting users that aren't members yet
+	CapabilityInvitations Capability = "invitations"
+	// CapabilityGroupSharing allows granting a whole group access to another group
+	CapabilityGroupSharing Capability = "group sharing"
+	// CapabilityAccessLevels allows adding members with different access levels
+	CapabilityAccessLevels Capability = "access levels"
+)
+
+// capabilities lists all capabilities in the order they are reported
+var capabilities = []Capability{CapabilityExpiration, CapabilityCustomRoles, CapabilityInvitations, CapabilityGroupSharing, CapabilityAccessLevels}
+
+// featureCapabilities are the capabilities the configured features need, by config key
+var featureCapabilities = map[string]Capability{
+	"JIT_GROUPS":             CapabilityExpiration,
+	"ACCESS_LEVEL_ATTRIBUTE": CapabilityAccessLevels,
+	"GROUP_ACCESS_LEVELS":    CapabilityAccessLevels,
+}
+
+// Target is a system psync syncs group memberships to.
+// The engine queries its capabilities and degrades gracefully when a configured feature isn't supported.
+type Target interface {
+	Name() string
+	Supports(c Capability) bool
+}
+
+// GitlabTarget is the Gitlab groups target. Its capabilities depend on the Gitlab version.
+type GitlabTarget struct {
+	Client  *gitlab.Client
+	Version string
+	// Notifier tells users about the changes made to their memberships, nil disables notifications
+	Notifier *UserNotifier
+	// Marker records the memberships psync made, the MANAGED_MARKER one if nil
+	Marker MemberMarker
+	// afklMembers are the members of the parent group, fetched when planning
+	afklMembers []*gitlab.GroupMember
+}
+
+// NewGitlabTarget negotiates the capabilities of the Gitlab instance the client is connected to.
+func NewGitlabTarget(clt *gitlab.Client) (*GitlabTarget, error) {
+	v, _, err := clt.Version.GetVersion()
+	if err != nil {
+		return nil, err
+	}
+	return &GitlabTarget{Client: clt, Version: v.Version}, nil
+}
+
+// Name returns the name of the target including its version.
+func (t *GitlabTarget) Name() string {
+	return "gitlab " + t.Version
+}
+
+// Supports reports whether the Gitlab instance supports the capability.
+func (t *GitlabTarget) Supports(c Capability) bool {
+	switch c {
+	case CapabilityExpiration, CapabilityGroupSharing, CapabilityAccessLevels:
+		return true
+	case CapabilityInvitations:
+		// The invitations API was introduced in Gitlab 13.6
+		return t.versionAtLeast(13, 6)
+	}
+	// Custom roles can't be assigned through the Gitlab client psync uses
+	return false
+}
+
+// versionAtLeast compares the major and minor version of the Gitlab instance.
+func (t *GitlabTarget) versionAtLeast(major, minor int) bool {
+	parts := strings.SplitN(t.Version, ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	ma, err1 := strconv.Atoi(parts[0])
+	mi, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	return ma > major || ma == major && mi >= minor
+}
+
+// NewSyncTarget returns the TARGET sync target, and the Gitlab client when syncing to Gitlab.
+func NewSyncTarget(identity IdentityProvider, state *State) (SyncTarget, *gitlab.Client, error) {
+	switch kind := viper.GetString("TARGET"); kind {
+	case "gitlab":
+		gitlabClt, err := NewGitlabClient(state)
+		if err != nil {
+			return nil, nil, err
+		}
+		target, err := NewGitlabTarget(gitlabClt)
+		if err != nil {
+			return nil, nil, err
+		}
+		target.Notifier, err = NewUserNotifier(identity)
+		return target, gitlabClt, err
+	case "scim":
+		target, err := NewScimTarget()
+		return target, nil, err
+	case "github":
+		target, err := NewGithubTarget()
+		return target, nil, err
+	default:
+		return nil, nil, fmt.Errorf("unknown TARGET %q, expected gitlab, scim or github", kind)
+	}
+}
+
+// DescribeCapabilities lists the capabilities supported by the target.
+func DescribeCapabilities(t Target) string {
+	supported := make([]string, 0, len(capabilities))
+	for _, c := range capabilities {
+		if t.Supports(c) {
+			supported = append(supported, string(c))
+		}
+	}
+	return fmt.Sprintf("%s (supports: %s)", t.Name(), strings.Join(supported, ", "))
+}
+
+// RequireCapability checks that the target supports the capability a configured feature needs.
+// Reports the feature as ignored when it doesn't.
+func RequireCapability(t Target, c Capability, feature string) bool {
+	if t.Supports(c) {
+		return true
+	}
+	logger.Warn("Ignoring the feature the target does not support", "feature", feature, "target", t.Name(), "capability", c)
+	return false
+}
+
+// checkCapabilities reports the configured features the target does not support, which the run ignores.
+func checkCapabilities(t Target) {
+	keys := make([]string, 0, len(featureCapabilities))
+	for key := range featureCapabilities {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		// The features are set as a string, a list or a map
+		if len(viper.GetStringSlice(key)) > 0 || len(viper.GetStringMap(key)) > 0 {
+			RequireCapability(t, featureCapabilities[key], key)
+		}
+	}
+}
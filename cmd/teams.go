@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// TeamsNotifier posts notifications to Microsoft Teams channels through incoming webhooks, as Adaptive Cards.
+// Recipients are webhook URLs, or the name of the secret holding the URL, as the URL alone allows posting
+// to the channel. Both the Office 365 connectors and the Workflows webhooks accept the card.
+type TeamsNotifier struct {
+	Client *http.Client
+}
+
+// teamsMessage is the payload of an incoming webhook carrying one Adaptive Card.
+type teamsMessage struct {
+	Type        string            `json:"type"`
+	Attachments []teamsAttachment `json:"attachments"`
+}
+
+type teamsAttachment struct {
+	ContentType string    `json:"contentType"`
+	Content     teamsCard `json:"content"`
+}
+
+type teamsCard struct {
+	Schema  string           `json:"$schema"`
+	Type    string           `json:"type"`
+	Version string           `json:"version"`
+	Body    []teamsTextBlock `json:"body"`
+}
+
+type teamsTextBlock struct {
+	Type    string `json:"type"`
+	Text    string `json:"text"`
+	Wrap    bool   `json:"wrap"`
+	Weight  string `json:"weight,omitempty"`
+	Size    string `json:"size,omitempty"`
+	Spacing string `json:"spacing,omitempty"`
+}
+
+// Send posts the notification as a card with the subject as title and a line of text per line of the notification.
+func (t *TeamsNotifier) Send(recipient string, n Notification) error {
+	webhook := recipient
+	if !strings.HasPrefix(recipient, "https://") {
+		secret, err := AccessSecret(recipient)
+		if err != nil {
+			return fmt.Errorf("teams webhook: %w", err)
+		}
+		webhook = strings.TrimSpace(string(secret))
+	}
+	body, err := json.Marshal(teamsMessage{
+		Type: "message",
+		Attachments: []teamsAttachment{{
+			ContentType: "application/vnd.microsoft.card.adaptive",
+			Content: teamsCard{
+				Schema:  "http://adaptivecards.io/schemas/adaptive-card.json",
+				Type:    "AdaptiveCard",
+				Version: "1.4",
+				Body:    teamsCardBody(n),
+			},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := t.Client.Post(webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// The error holds the URL, which must not end up in the logs
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return fmt.Errorf("teams webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("teams webhook: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// teamsCardBody lays out the notification, each line in its own block so that the lists keep their line breaks.
+// Blank lines separate paragraphs.
+func teamsCardBody(n Notification) []teamsTextBlock {
+	blocks := make([]teamsTextBlock, 0)
+	if n.Subject != "" {
+		blocks = append(blocks, teamsTextBlock{Type: "TextBlock", Text: n.Subject, Wrap: true, Weight: "Bolder", Size: "Medium"})
+	}
+	paragraph := true
+	for _, line := range strings.Split(n.Text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			paragraph = true
+			continue
+		}
+		block := teamsTextBlock{Type: "TextBlock", Text: line, Wrap: true}
+		if !paragraph {
+			block.Spacing = "None"
+		}
+		paragraph = false
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
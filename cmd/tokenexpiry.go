@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/spf13/viper"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+)
+
+// GitlabTokenExpiry returns when the Gitlab token of the client expires, nil if it never does.
+// Uses the token self-information endpoint of Gitlab 15.5 and later.
+func GitlabTokenExpiry(clt *gitlab.Client) (*time.Time, error) {
+	req, err := clt.NewRequest(http.MethodGet, "personal_access_tokens/self", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var token struct {
+		ExpiresAt *gitlab.ISOTime `json:"expires_at"`
+	}
+	if _, err := clt.Do(req, &token); err != nil {
+		return nil, fmt.Errorf("getting the Gitlab token information: %w", err)
+	}
+	if token.ExpiresAt == nil {
+		return nil, nil
+	}
+	expires := time.Time(*token.ExpiresAt)
+	return &expires, nil
+}
+
+// checkGitlabTokenExpiry records the expiry of the Gitlab token in the summary of the run, and warns when it expires
+// within GITLAB_TOKEN_EXPIRY_WARNING_DAYS, raising an alert too if GITLAB_TOKEN_EXPIRY_ALERT is set,
+// so that the sync doesn't silently stop when the token expires.
+// Failing to get the expiry never fails the sync, older Gitlab versions don't tell it.
+func checkGitlabTokenExpiry(run *Run, clt *gitlab.Client) {
+	expires, err := GitlabTokenExpiry(clt)
+	if err != nil {
+		logger.Warn("Could not check the expiry of the Gitlab token", "error", err)
+		return
+	}
+	if expires == nil {
+		return
+	}
+	run.Summary.GitlabTokenExpires = expires
+	days := int(expires.Sub(clock.Now()).Hours() / 24)
+	if days >= viper.GetInt("GITLAB_TOKEN_EXPIRY_WARNING_DAYS") {
+		return
+	}
+	logger.Warn("The Gitlab token expires soon, rotate it with psync rotate-check", "days", days, "expires", expires.Format("2006-01-02"))
+	if viper.GetBool("GITLAB_TOKEN_EXPIRY_ALERT") {
+		raiseAlert("the Gitlab token expires in %d days, on %s, the sync stops working when it does", days, expires.Format("2006-01-02"))
+	}
+}
@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// apiToken returns the API token of the provider, OKTA or GITLAB. The token is read from <provider>_TOKEN if set,
+// e.g. in the environment, or from the file at <provider>_TOKEN_FILE, e.g. a mounted Kubernetes secret,
+// so that local development needs no GCP credentials. Otherwise it is fetched from the <provider>_SECRET
+// secret, in the version activated by rotate-check if any. SECRET_SOURCES for <provider>_SECRET replace
+// this lookup with their own order.
+func apiToken(state *State, provider string) (string, error) {
+	if len(secretSources(provider+"_SECRET")) == 0 {
+		if token := viper.GetString(provider + "_TOKEN"); token != "" {
+			return token, nil
+		}
+		if path := viper.GetString(provider + "_TOKEN_FILE"); path != "" {
+			token, err := ioutil.ReadFile(path)
+			if err != nil {
+				return "", fmt.Errorf("reading %s_TOKEN_FILE: %w", provider, err)
+			}
+			return strings.TrimSpace(string(token)), nil
+		}
+	}
+	token, err := credential(state, provider+"_SECRET")
+	return string(token), err
+}
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// tracer starts the spans of the runs: the run, the secret fetches, the listings of the source and target groups,
+// the reconcile of each group, the change of each member, and the API requests made meanwhile.
+// It discards the spans unless TRACING is otlp.
+var (
+	tracer    trace.Tracer = noop.NewTracerProvider().Tracer("psync")
+	traceProv *sdktrace.TracerProvider
+)
+
+// traceCtx is the context of the innermost span in progress, the parent of the next spans.
+// The API clients don't pass the context of the run to their requests, so the spans are nested through it.
+var (
+	traceCtx   = context.Background()
+	traceCtxMu sync.Mutex
+)
+
+// setupTracing configures the export of the spans: none, or otlp to export them over OTLP/HTTP to the TRACING_ENDPOINT,
+// or to the endpoint of the standard OTEL_EXPORTER_OTLP_* environment variables if unset.
+func setupTracing(exporter, endpoint string) error {
+	switch strings.ToLower(exporter) {
+	case "", "none":
+		return nil
+	case "otlp":
+	default:
+		return fmt.Errorf("unknown TRACING %q, expected none or otlp", exporter)
+	}
+	opts := make([]otlptracehttp.Option, 0, 1)
+	if endpoint != "" {
+		opts = append(opts, otlptracehttp.WithEndpointURL(endpoint))
+	}
+	exp, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return fmt.Errorf("TRACING: %w", err)
+	}
+	res := resource.NewSchemaless(semconv.ServiceName("psync"), semconv.ServiceVersion(Version))
+	traceProv = sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res))
+	tracer = traceProv.Tracer("psync")
+	return nil
+}
+
+// flushTraces exports the spans ended so far, before the process may exit.
+func flushTraces() {
+	if traceProv == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := traceProv.ForceFlush(ctx); err != nil {
+		logger.Warn("Could not export the traces", "error", err)
+	}
+}
+
+// runSpan is a span in progress, the parent of the spans started until it ends.
+type runSpan struct {
+	trace.Span
+	parent context.Context
+}
+
+// startSpan starts a span, child of the span in progress if any.
+func startSpan(name string, attrs ...attribute.KeyValue) *runSpan {
+	traceCtxMu.Lock()
+	defer traceCtxMu.Unlock()
+	parent := traceCtx
+	ctx, span := tracer.Start(parent, name, trace.WithAttributes(attrs...))
+	traceCtx = ctx
+	return &runSpan{Span: span, parent: parent}
+}
+
+// end ends the span, failed with the error if any, and makes its parent the span in progress again.
+func (s *runSpan) end(err error) {
+	if err != nil {
+		s.RecordError(err)
+		s.SetStatus(codes.Error, err.Error())
+	}
+	s.End()
+	traceCtxMu.Lock()
+	defer traceCtxMu.Unlock()
+	traceCtx = s.parent
+}
+
+// TracingTransport records each API request of a provider as a span, child of the span in progress.
+type TracingTransport struct {
+	Provider string
+	Next     http.RoundTripper
+}
+
+// RoundTrip sends the request within its span.
+func (t *TracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	traceCtxMu.Lock()
+	parent := traceCtx
+	traceCtxMu.Unlock()
+	_, span := tracer.Start(parent, fmt.Sprintf("%s %s", t.Provider, req.Method), trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("psync.provider", t.Provider),
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.ServerAddress(req.URL.Hostname()),
+			semconv.URLPath(req.URL.Path),
+		))
+	defer span.End()
+	resp, err := t.Next.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return resp, err
+	}
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	return resp, err
+}
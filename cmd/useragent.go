@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/spf13/viper"
+	"google.golang.org/api/option"
+)
+
+// currentRunID is the ID of the run in progress, sent in the User-Agent so the providers can attribute requests to runs.
+// It is read while runMu is held, by the logs of the warnings, so it is not guarded by runMu.
+var currentRunID atomic.Value
+
+// setCurrentRun records the ID of the run in progress.
+func setCurrentRun(id string) {
+	currentRunID.Store(id)
+}
+
+// runID returns the ID of the run in progress, empty before the first run.
+func runID() string {
+	id, _ := currentRunID.Load().(string)
+	return id
+}
+
+// userAgent identifies psync to the providers, e.g. "psync/1.4.0 (run 01H...; prod-eu)": its version,
+// the run in progress if any, and the USER_AGENT_SUFFIX naming the deployment.
+func userAgent() string {
+	details := make([]string, 0, 2)
+	if id := runID(); id != "" {
+		details = append(details, "run "+id)
+	}
+	if suffix := viper.GetString("USER_AGENT_SUFFIX"); suffix != "" {
+		details = append(details, suffix)
+	}
+	if len(details) == 0 {
+		return "psync/" + Version
+	}
+	return fmt.Sprintf("psync/%s (%s)", Version, strings.Join(details, "; "))
+}
+
+// UserAgentTransport puts the psync user agent in front of the User-Agent of the API client making the request.
+// The user agent is computed for each request, since the clients are created before the run starts.
+type UserAgentTransport struct {
+	Next http.RoundTripper
+}
+
+// RoundTrip sends the request with the psync user agent.
+func (t *UserAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", strings.TrimSpace(userAgent()+" "+req.Header.Get("User-Agent")))
+	return t.Next.RoundTrip(req)
+}
+
+// gcpOptions are the options of the GCP clients, which identify psync with its user agent.
+func gcpOptions() []option.ClientOption {
+	return []option.ClientOption{option.WithUserAgent(userAgent())}
+}
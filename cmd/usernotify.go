@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// MembershipChange is the data available to the user notification templates.
+type MembershipChange struct {
+	// Action is either "added" or "removed"
+	Action    string
+	User      string
+	Group     string
+	OktaGroup string
+	Contact   string
+}
+
+// UserNotifier tells users that psync added them to or removed them from a Gitlab group.
+type UserNotifier struct {
+	identity IdentityProvider
+	notifier Notifier
+}
+
+// NewUserNotifier returns the user notifier configured with NOTIFY_USERS, or nil if notifications are disabled.
+func NewUserNotifier(identity IdentityProvider) (*UserNotifier, error) {
+	kind := viper.GetString("NOTIFY_USERS")
+	if kind == "" {
+		return nil, nil
+	}
+	n, err := NewNotifier(kind)
+	if err != nil {
+		return nil, err
+	}
+	return &UserNotifier{identity: identity, notifier: n}, nil
+}
+
+// Notify looks up the email of the user in the identity provider and sends them the templated message.
+// Failing to notify never fails the sync, the error is only reported.
+func (u *UserNotifier) Notify(action, oktaUserID string, g OktaGroup) {
+	if u == nil {
+		return
+	}
+	if err := u.notify(action, oktaUserID, g); err != nil {
+		logger.Warn("Could not notify the user", "group", g.Name, "user", oktaUserID, "action", action, "error", err)
+	}
+}
+
+func (u *UserNotifier) notify(action, oktaUserID string, g OktaGroup) error {
+	email, err := u.identity.UserEmail(oktaUserID)
+	if err != nil {
+		return err
+	}
+	if email == "" {
+		return fmt.Errorf("user has no email")
+	}
+	change := MembershipChange{
+		Action:    action,
+		User:      email,
+		Group:     g.Name,
+		OktaGroup: g.OktaName,
+		Contact:   viper.GetString("NOTIFY_CONTACT"),
+	}
+	subject, err := executeTemplate(viper.GetString("NOTIFY_SUBJECT_TEMPLATE"), change)
+	if err != nil {
+		return err
+	}
+	text, err := executeTemplate(viper.GetString("NOTIFY_TEMPLATE"), change)
+	if err != nil {
+		return err
+	}
+	return u.notifier.Send(email, Notification{Subject: subject, Text: text})
+}
@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"fmt"
+	"sync"
+)
+
+// runMu guards the collectors of the current run: dataWarnings, alerts and the Okta user attributes
+var runMu sync.Mutex
+
+// dataWarnings collects the data-quality warnings raised during the run.
+// The sync can continue despite them, unless strict mode is enabled.
+var dataWarnings []string
+
+// warnDataQuality prints and records a data-quality warning.
+func warnDataQuality(format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	runMu.Lock()
+	defer runMu.Unlock()
+	logger.Warn(msg, "data_quality", true)
+	dataWarnings = append(dataWarnings, msg)
+	writeRecord(OutputRecord{Type: "warning", RunID: runID(), Message: msg})
+}
@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"psync/internal/set"
+)
+
+// WebhookEvent is a change announced by an Okta event hook or a Gitlab system hook.
+type WebhookEvent struct {
+	// Provider is okta or gitlab
+	Provider string
+	Type     string
+	// UserID is the Okta user ID for Okta events, and the Gitlab username for Gitlab events
+	UserID string
+	// GroupID is the Okta group ID for Okta events, and the Gitlab group ID for Gitlab events
+	GroupID string
+}
+
+// webhookEventTypes are the event types that can change the memberships psync manages, by provider
+var webhookEventTypes = map[string]map[string]bool{
+	"okta": {
+		"user.lifecycle.activate":      true,
+		"user.lifecycle.reactivate":    true,
+		"user.lifecycle.deactivate":    true,
+		"user.lifecycle.suspend":       true,
+		"user.lifecycle.unsuspend":     true,
+		"group.user_membership.add":    true,
+		"group.user_membership.remove": true,
+		"user.account.update_profile":  true,
+		"group.profile.updated":        true,
+		"group.lifecycle.delete":       true,
+	},
+	"gitlab": {
+		"user_add_to_group":      true,
+		"user_remove_from_group": true,
+		"user_update_for_group":  true,
+	},
+}
+
+// ParseWebhook parses the events of an Okta event hook or of a Gitlab system hook payload.
+// Events that can't change the memberships psync manages are left out.
+func ParseWebhook(provider string, body []byte) ([]WebhookEvent, error) {
+	events := make([]WebhookEvent, 0)
+	switch provider {
+	case "okta":
+		var hook struct {
+			Data struct {
+				Events []struct {
+					EventType string `json:"eventType"`
+					Target    []struct {
+						ID   string `json:"id"`
+						Type string `json:"type"`
+					} `json:"target"`
+				} `json:"events"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(body, &hook); err != nil {
+			return nil, fmt.Errorf("parsing the Okta event hook: %w", err)
+		}
+		for _, e := range hook.Data.Events {
+			if !webhookEventTypes["okta"][e.EventType] {
+				continue
+			}
+			event := WebhookEvent{Provider: "okta", Type: e.EventType}
+			for _, t := range e.Target {
+				switch t.Type {
+				case "User":
+					event.UserID = t.ID
+				case "UserGroup":
+					event.GroupID = t.ID
+				}
+			}
+			events = append(events, event)
+		}
+	case "gitlab":
+		var hook struct {
+			EventName    string `json:"event_name"`
+			GroupID      int    `json:"group_id"`
+			UserUsername string `json:"user_username"`
+		}
+		if err := json.Unmarshal(body, &hook); err != nil {
+			return nil, fmt.Errorf("parsing the Gitlab system hook: %w", err)
+		}
+		if webhookEventTypes["gitlab"][hook.EventName] {
+			events = append(events, WebhookEvent{
+				Provider: "gitlab",
+				Type:     hook.EventName,
+				UserID:   hook.UserUsername,
+				GroupID:  strconv.Itoa(hook.GroupID),
+			})
+		}
+	default:
+		return nil, fmt.Errorf("unknown webhook provider %q, expected okta or gitlab", provider)
+	}
+	return events, nil
+}
+
+// WithEvents limits the sync to the groups the events concern: the Okta groups they name or whose Gitlab group
+// they name, and the groups of the Okta users they name. It is applied after the configured transforms.
+// Sources that can read single groups only read those, see WEBHOOK_SPARSE.
+func WithEvents(events []WebhookEvent) SyncerOption {
+	return func(s *Syncer) error {
+		s.Pipeline.Source = withSparseSource(s.Pipeline.Source, events)
+		s.Pipeline.Transforms = append(s.Pipeline.Transforms, eventScope(events))
+		return nil
+	}
+}
+
+// eventScope is the transform that keeps the groups the events concern.
+func eventScope(events []WebhookEvent) Transform {
+	return func(run *Run, groups []OktaGroup) ([]OktaGroup, error) {
+		scoped := make([]OktaGroup, 0, len(groups))
+		for _, g := range groups {
+			gitlabID, _ := run.State.GitlabGroupID(g.ID)
+			for _, e := range events {
+				concerned := false
+				switch e.Provider {
+				case "okta":
+					concerned = e.GroupID == g.ID ||
+						(e.UserID != "" && (set.Contains(g.Users, e.UserID) || set.Contains(g.Deprovisioned, e.UserID)))
+				case "gitlab":
+					concerned = e.GroupID == strconv.Itoa(gitlabID)
+				}
+				if concerned {
+					scoped = append(scoped, g)
+					break
+				}
+			}
+		}
+		logger.Info("Syncing the groups concerned by the events", "groups", len(scoped), "read", len(groups), "events", len(events))
+		return scoped, nil
+	}
+}
+
+// WebhookHandler serves the Okta event hooks and Gitlab system hooks, and syncs the groups their events concern.
+// Requests must carry the token stored in the WEBHOOK_TOKEN_SECRET secret: as bearer token in the Authorization
+// header for Okta, and in the X-Gitlab-Token header for Gitlab.
+type WebhookHandler struct {
+	Provider string
+	Token    string
+	// Sync syncs the groups the events concern
+	Sync func(events []WebhookEvent)
+	// mu serializes the syncs, which share the state of the run
+	mu *sync.Mutex
+}
+
+// ServeHTTP answers the one-time Okta verification challenge, and syncs the groups the events of the hook concern.
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if h.Provider == "gitlab" {
+		given = r.Header.Get("X-Gitlab-Token")
+	}
+	if h.Token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(h.Token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method == http.MethodGet && h.Provider == "okta" {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"verification": r.Header.Get("X-Okta-Verification-Challenge")})
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	events, err := ParseWebhook(h.Provider, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Hooks expect a quick answer, the groups are synced in the background
+	w.WriteHeader(http.StatusNoContent)
+	if len(events) == 0 {
+		return
+	}
+	go func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.Sync(events)
+	}()
+}
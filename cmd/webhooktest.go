@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/spf13/cobra"
+	gitlab "gitlab.com/gitlab-org/api/client-go"
+
+	"psync/internal/set"
+)
+
+var (
+	webhookTestEvent    string
+	webhookTestProvider string
+	webhookTestWorld    string
+)
+
+// webhookCmd groups the webhook commands
+var webhookCmd = &cobra.Command{
+	Use:   "webhook",
+	Short: "Develop the event-driven syncs of psync serve --webhooks",
+}
+
+// webhookTestCmd feeds a webhook payload through the webhook handling with fake providers
+var webhookTestCmd = &cobra.Command{
+	Use:   "test",
+	Short: "Feed a sample webhook payload through the sync locally",
+	Long: `Parse an Okta event hook or Gitlab system hook payload like psync serve --webhooks does, and sync the groups
+its events concern with the configured transforms and policies, against fake Okta and Gitlab providers.
+No Okta org or Gitlab instance is needed. The fake providers start from the --world file, a JSON file with
+the Okta groups and the members of the Gitlab groups they are mapped to, identified by Okta user ID:
+
+  {"okta_groups": [{"id": "00g1", "name": "payments", "users": ["00u1"], "deprovisioned": []}],
+   "gitlab_groups": {"00g1": {"id": 101, "members": ["00u1", "00u2"]}}}
+
+The fake Okta applies the events to its groups first, e.g. a deactivated user is deprovisioned in all of them.
+The provider of the payload is detected from its content unless --provider is given.`,
+	Example: `  # Check that deactivating a user in Okta removes them from their Gitlab groups
+  psync webhook test --event fixtures/okta_user_deactivate.json --world fixtures/world.json`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if webhookTestEvent == "" {
+			cobra.CheckErr("--event is required")
+		}
+		body, err := ioutil.ReadFile(webhookTestEvent)
+		cobra.CheckErr(err)
+		provider := webhookTestProvider
+		if provider == "" {
+			provider = detectWebhookProvider(body)
+		}
+		events, err := ParseWebhook(provider, body)
+		cobra.CheckErr(err)
+		fmt.Printf("Parsed %d %s events from %s\n", len(events), provider, webhookTestEvent)
+		for _, e := range events {
+			fmt.Printf("  %s user=%s group=%s\n", e.Type, e.UserID, e.GroupID)
+		}
+		if len(events) == 0 {
+			return
+		}
+
+		world := &fakeWorld{GitlabGroups: map[string]fakeGitlabGroup{}}
+		if webhookTestWorld != "" {
+			raw, err := ioutil.ReadFile(webhookTestWorld)
+			cobra.CheckErr(err)
+			cobra.CheckErr(json.Unmarshal(raw, world))
+		}
+		// Runs are reproducible, and nothing is saved
+		clock = &ManualClock{T: time.Unix(0, 0)}
+		ids = &SequentialIDs{Prefix: "webhook-test-"}
+		state := &State{Groups: map[string]GroupMapping{}, Frozen: map[string]Freeze{}, Secrets: map[string]string{}}
+		// The groups of the world are mapped like after a first sync, so Gitlab events find their Okta group
+		for _, g := range world.OktaGroups {
+			if group, ok := world.GitlabGroups[g.ID]; ok {
+				state.SetGroupMapping(g.ID, g.Name, group.ID)
+			}
+		}
+		syncer, err := NewSyncer(&fakeOkta{world: world, events: events}, &fakeGitlab{world: world}, state, WithEvents(events))
+		cobra.CheckErr(err)
+		run, syncs, err := syncer.Plan()
+		if err == nil {
+			err = syncer.Apply(run, syncs)
+		}
+		for _, s := range run.Skipped {
+			fmt.Printf("Skipped %s\n", s)
+		}
+		cobra.CheckErr(err)
+		fmt.Printf("Run %s: added %d, removed %d of %d changes\n", run.ID, run.Summary.Added, run.Summary.Removed, run.Summary.Drift)
+	},
+}
+
+// detectWebhookProvider tells an Okta event hook from a Gitlab system hook payload.
+func detectWebhookProvider(body []byte) string {
+	var probe struct {
+		EventName string `json:"event_name"`
+	}
+	if json.Unmarshal(body, &probe) == nil && probe.EventName != "" {
+		return "gitlab"
+	}
+	return "okta"
+}
+
+// fakeWorld is the data of the fake providers of psync webhook test
+type fakeWorld struct {
+	OktaGroups []struct {
+		ID            string   `json:"id"`
+		Name          string   `json:"name"`
+		Users         []string `json:"users"`
+		Deprovisioned []string `json:"deprovisioned"`
+	} `json:"okta_groups"`
+	// GitlabGroups are the Gitlab groups by the ID of the Okta group they are mapped to
+	GitlabGroups map[string]fakeGitlabGroup `json:"gitlab_groups"`
+}
+
+type fakeGitlabGroup struct {
+	ID      int      `json:"id"`
+	Members []string `json:"members"`
+}
+
+// fakeOkta is the source of psync webhook test, serving the groups of the world after the events.
+type fakeOkta struct {
+	world  *fakeWorld
+	events []WebhookEvent
+}
+
+// Groups returns the Okta groups of the world, with the Okta events applied.
+func (s *fakeOkta) Groups(run *Run) ([]OktaGroup, error) {
+	groups := make([]OktaGroup, 0, len(s.world.OktaGroups))
+	for _, g := range s.world.OktaGroups {
+		gr := OktaGroup{ID: g.ID, Name: g.Name, OktaName: g.Name,
+			Users: append([]string{}, g.Users...), Deprovisioned: append([]string{}, g.Deprovisioned...)}
+		for _, e := range s.events {
+			if e.Provider != "okta" || e.UserID == "" {
+				continue
+			}
+			member := set.Contains(gr.Users, e.UserID) || set.Contains(gr.Deprovisioned, e.UserID)
+			switch e.Type {
+			case "user.lifecycle.deactivate", "user.lifecycle.suspend":
+				if member {
+					gr.Users = set.Difference(gr.Users, []string{e.UserID})
+					gr.Deprovisioned = append(set.Difference(gr.Deprovisioned, []string{e.UserID}), e.UserID)
+				}
+			case "user.lifecycle.activate", "user.lifecycle.reactivate", "user.lifecycle.unsuspend":
+				if member {
+					gr.Deprovisioned = set.Difference(gr.Deprovisioned, []string{e.UserID})
+					gr.Users = append(set.Difference(gr.Users, []string{e.UserID}), e.UserID)
+				}
+			case "group.user_membership.add":
+				if e.GroupID == gr.ID && !member {
+					gr.Users = append(gr.Users, e.UserID)
+				}
+			case "group.user_membership.remove":
+				if e.GroupID == gr.ID {
+					gr.Users = set.Difference(gr.Users, []string{e.UserID})
+				}
+			}
+		}
+		groups = append(groups, gr)
+	}
+	return groups, nil
+}
+
+// fakeGitlab is the target of psync webhook test, changing the Gitlab groups of the world in memory.
+// Gitlab users are identified by their Okta user ID, which is also their username.
+type fakeGitlab struct {
+	world *fakeWorld
+}
+
+// Name returns the name of the target.
+func (t *fakeGitlab) Name() string {
+	return "fake gitlab"
+}
+
+// Supports reports the capabilities of the target, the fake has none.
+func (t *fakeGitlab) Supports(c Capability) bool {
+	return false
+}
+
+// Plan computes the membership changes of the Gitlab groups the Okta groups are mapped to in the world.
+// All Okta users of the world are members of the parent group.
+func (t *fakeGitlab) Plan(run *Run, groups []OktaGroup) ([]GroupSync, error) {
+	parent := []string{}
+	for _, g := range t.world.OktaGroups {
+		parent = append(parent, set.Difference(append(append([]string{}, g.Users...), g.Deprovisioned...), parent)...)
+	}
+	syncs := make([]GroupSync, 0, len(groups))
+	for _, g := range groups {
+		group, ok := t.world.GitlabGroups[g.ID]
+		if !ok {
+			run.report(&run.Skipped, "%s: no Gitlab group in the world", g.Name)
+			continue
+		}
+		run.State.SetGroupMapping(g.ID, g.Name, group.ID)
+		members := make([]GitlabMember, 0, len(group.Members))
+		for _, u := range group.Members {
+			members = append(members, GitlabMember{User: &gitlab.GroupMember{Username: u, State: "active"}, SAMLID: u})
+		}
+		syncs = append(syncs, GroupSync{Group: g, GitlabID: group.ID, Members: members, Plan: PlanGroup(g, parent, members)})
+	}
+	return syncs, nil
+}
+
+// Apply prints the changes and makes them in the world.
+func (t *fakeGitlab) Apply(run *Run, syncs []GroupSync) error {
+	for _, gs := range syncs {
+		g := gs.Group
+		group := t.world.GitlabGroups[g.ID]
+		for _, u := range gs.Plan.Remove {
+			group.Members = set.Difference(group.Members, []string{u})
+			run.Summary.Removed++
+			run.emit(EventRemoved, g.Name, u, nil)
+			run.reportChange(g.Name, "Removed %s from %s: deprovisioned or suspended in Okta", u, g.Name)
+			fmt.Printf("Removed %s from %s (%d)\n", u, g.Name, group.ID)
+		}
+		for _, u := range gs.Plan.Add {
+			level := memberAccessLevel(gs, u)
+			group.Members = append(group.Members, u)
+			run.Summary.Added++
+			run.emit(EventAdded, g.Name, u, nil)
+			run.reportChange(g.Name, "Added %s to %s as %s: active member of the Okta group", u, g.Name, accessLevelName(level))
+			fmt.Printf("Added %s to %s (%d) as %s\n", u, g.Name, group.ID, accessLevelName(level))
+		}
+		t.world.GitlabGroups[g.ID] = group
+	}
+	return nil
+}
+
+func init() {
+	webhookTestCmd.Flags().StringVar(&webhookTestEvent, "event", "", "file with the webhook payload")
+	webhookTestCmd.Flags().StringVar(&webhookTestProvider, "provider", "", "okta or gitlab, detected from the payload if empty")
+	webhookTestCmd.Flags().StringVar(&webhookTestWorld, "world", "", "file with the Okta groups and Gitlab members of the fake providers")
+	webhookCmd.AddCommand(webhookTestCmd)
+	rootCmd.AddCommand(webhookCmd)
+}
@@ -0,0 +1,233 @@
+// Package engine plans and applies the changes of the sync runs in stages: the source of the groups, the transforms,
+// the plan of the target, the policies and the apply of the target. It holds no global state and reads no
+// configuration, the caller provides the runs, the stages and the hooks, so that other programs can reuse it.
+//
+// The engine is generic in the run R the stages share, the groups G the source produces and the planned changes S
+// of a group the target applies.
+package engine
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Source produces the groups to sync.
+type Source[R, G any] interface {
+	Groups(run R) ([]G, error)
+}
+
+// Transform rewrites the groups produced by the source, e.g. to normalize names or enrich them.
+type Transform[R, G any] func(run R, groups []G) ([]G, error)
+
+// Target plans the changes of the groups and applies them.
+type Target[R, G, S any] interface {
+	Plan(run R, groups []G) ([]S, error)
+	Apply(run R, syncs []S) error
+}
+
+// Policy filters the planned changes before they are applied. Returning an error aborts the run.
+type Policy[R, S any] func(run R, syncs []S) ([]S, error)
+
+// Pipeline are the stages of a run. Each stage can be replaced or tested on its own.
+type Pipeline[R, G, S any] struct {
+	Source     Source[R, G]
+	Transforms []Transform[R, G]
+	Target     Target[R, G, S]
+	Policies   []Policy[R, S]
+}
+
+// Stages of a run, see Hooks.Stage
+const (
+	StageSource = "source"
+	StagePlan   = "plan"
+	StageApply  = "apply"
+)
+
+// Hooks are called at the stages of the runs, e.g. to trace, report or record them. All of them are optional.
+type Hooks[R, G, S any] struct {
+	// Stage is called when a stage starts, and returns the function called when it ends with the number of groups
+	// or changes it produced
+	Stage func(run R, stage string) func(n int, err error)
+	// Grouped is called with the groups after the transforms
+	Grouped func(run R, groups []G)
+	// Planned is called with the changes after the policies, when planning succeeded
+	Planned func(run R, syncs []S)
+	// Applying is called with all the changes before they are applied
+	Applying func(run R, syncs []S)
+	// Applied is called after the target applied changes, also when applying them failed
+	Applied func(run R, syncs []S)
+}
+
+// Syncer plans the changes of a run and applies them.
+type Syncer[R, G, S any] struct {
+	// Pipeline are the stages of the runs, which options may change
+	Pipeline Pipeline[R, G, S]
+
+	newRun      func() R
+	hooks       Hooks[R, G, S]
+	checkpoints *checkpoints[R, S]
+	now         func() time.Time
+	logger      *slog.Logger
+}
+
+// checkpoints configures the runs applied in chunks, see WithCheckpoints.
+type checkpoints[R, S any] struct {
+	size     int
+	deadline time.Time
+	save     func(run R, remaining []S) error
+}
+
+// Option configures a Syncer.
+type Option[R, G, S any] func(s *Syncer[R, G, S]) error
+
+// WithTransforms replaces the transforms of the pipeline.
+func WithTransforms[R, G, S any](transforms ...Transform[R, G]) Option[R, G, S] {
+	return func(s *Syncer[R, G, S]) error {
+		s.Pipeline.Transforms = transforms
+		return nil
+	}
+}
+
+// WithPolicies replaces the policies of the pipeline.
+func WithPolicies[R, G, S any](policies ...Policy[R, S]) Option[R, G, S] {
+	return func(s *Syncer[R, G, S]) error {
+		s.Pipeline.Policies = policies
+		return nil
+	}
+}
+
+// WithHooks sets the hooks called at the stages of the runs.
+func WithHooks[R, G, S any](hooks Hooks[R, G, S]) Option[R, G, S] {
+	return func(s *Syncer[R, G, S]) error {
+		s.hooks = hooks
+		return nil
+	}
+}
+
+// WithCheckpoints applies the changes in chunks of the size, calling save with the changes left before each chunk,
+// and with none once all of them are applied. The chunks after the deadline are left to a resumed run.
+func WithCheckpoints[R, G, S any](size int, deadline time.Time, save func(run R, remaining []S) error) Option[R, G, S] {
+	return func(s *Syncer[R, G, S]) error {
+		if size < 1 {
+			return fmt.Errorf("the checkpoint chunks need at least one group, got %d", size)
+		}
+		s.checkpoints = &checkpoints[R, S]{size: size, deadline: deadline, save: save}
+		return nil
+	}
+}
+
+// WithClock sets the clock the deadline of the checkpoints is checked against, time.Now by default.
+func WithClock[R, G, S any](now func() time.Time) Option[R, G, S] {
+	return func(s *Syncer[R, G, S]) error {
+		s.now = now
+		return nil
+	}
+}
+
+// WithLogger sets the logger of the Syncer, slog.Default() by default.
+func WithLogger[R, G, S any](logger *slog.Logger) Option[R, G, S] {
+	return func(s *Syncer[R, G, S]) error {
+		s.logger = logger
+		return nil
+	}
+}
+
+// New builds a Syncer from the source to the target. Each run is started with newRun.
+func New[R, G, S any](newRun func() R, source Source[R, G], target Target[R, G, S], opts ...Option[R, G, S]) (*Syncer[R, G, S], error) {
+	s := &Syncer[R, G, S]{
+		Pipeline: Pipeline[R, G, S]{Source: source, Target: target},
+		newRun:   newRun,
+		now:      time.Now,
+		logger:   slog.Default(),
+	}
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Plan starts a run and returns the changes to apply. The run is returned even if planning fails,
+// with what was reported until then.
+func (s *Syncer[R, G, S]) Plan() (R, []S, error) {
+	run := s.newRun()
+	end := s.stage(run, StageSource)
+	groups, err := s.Pipeline.Source.Groups(run)
+	end(len(groups), err)
+	if err != nil {
+		return run, nil, err
+	}
+	for _, t := range s.Pipeline.Transforms {
+		if groups, err = t(run, groups); err != nil {
+			return run, nil, err
+		}
+	}
+	if s.hooks.Grouped != nil {
+		s.hooks.Grouped(run, groups)
+	}
+	end = s.stage(run, StagePlan)
+	syncs, err := s.Pipeline.Target.Plan(run, groups)
+	end(len(syncs), err)
+	if err != nil {
+		return run, syncs, err
+	}
+	for _, policy := range s.Pipeline.Policies {
+		if syncs, err = policy(run, syncs); err != nil {
+			return run, syncs, err
+		}
+	}
+	if s.hooks.Planned != nil {
+		s.hooks.Planned(run, syncs)
+	}
+	return run, syncs, nil
+}
+
+// Apply makes the planned changes of the run, in chunks when the Syncer has checkpoints.
+func (s *Syncer[R, G, S]) Apply(run R, syncs []S) error {
+	if s.hooks.Applying != nil {
+		s.hooks.Applying(run, syncs)
+	}
+	if s.checkpoints == nil {
+		return s.apply(run, syncs)
+	}
+	for applied := false; ; applied = true {
+		// The planned changes are saved before the first chunk too, so that a slow plan is never repeated
+		if err := s.checkpoints.save(run, syncs); err != nil {
+			return fmt.Errorf("saving the checkpoint: %w", err)
+		}
+		if len(syncs) == 0 {
+			return nil
+		}
+		// Each run applies a chunk at least, so that the runs progress even when planning takes until the deadline
+		if applied && s.now().After(s.checkpoints.deadline) {
+			s.logger.Info("Leaving the groups left to the resumed run", "groups", len(syncs))
+			return nil
+		}
+		n := min(s.checkpoints.size, len(syncs))
+		if err := s.apply(run, syncs[:n]); err != nil {
+			return err
+		}
+		syncs = syncs[n:]
+	}
+}
+
+// apply has the target apply the changes.
+func (s *Syncer[R, G, S]) apply(run R, syncs []S) error {
+	end := s.stage(run, StageApply)
+	err := s.Pipeline.Target.Apply(run, syncs)
+	end(len(syncs), err)
+	if s.hooks.Applied != nil {
+		s.hooks.Applied(run, syncs)
+	}
+	return err
+}
+
+// stage calls the Stage hook, if any.
+func (s *Syncer[R, G, S]) stage(run R, stage string) func(n int, err error) {
+	if s.hooks.Stage == nil {
+		return func(int, error) {}
+	}
+	return s.hooks.Stage(run, stage)
+}
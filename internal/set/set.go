@@ -0,0 +1,58 @@
+// Package set implements the set operations psync computes its plans with, on slices of comparable items.
+// The results keep the order of the items in the slices, so that plans and reports are stable.
+package set
+
+// Intersection returns the items of b that are also in a.
+// Used to identify which group members exist both in the okta developers group and the afkl-mcp group.
+func Intersection[T comparable](a, b []T) (c []T) {
+	m := make(map[T]bool, len(a))
+	for _, item := range a {
+		m[item] = true
+	}
+	for _, item := range b {
+		if m[item] {
+			c = append(c, item)
+		}
+	}
+	return
+}
+
+// Union returns the items of a then of b, without duplicates.
+// Used to merge the members of nested Okta groups.
+func Union[T comparable](a, b []T) (c []T) {
+	m := make(map[T]bool, len(a)+len(b))
+	for _, s := range [][]T{a, b} {
+		for _, item := range s {
+			if !m[item] {
+				m[item] = true
+				c = append(c, item)
+			}
+		}
+	}
+	return
+}
+
+// Difference returns the items of a that are not in b.
+// Used to identify which users in the afkl-mcp group haven't been granted access to the given dev group in Gitlab.
+func Difference[T comparable](a, b []T) (c []T) {
+	m := make(map[T]bool, len(b))
+	for _, item := range b {
+		m[item] = true
+	}
+	for _, item := range a {
+		if !m[item] {
+			c = append(c, item)
+		}
+	}
+	return
+}
+
+// Contains tells whether the item is in the slice.
+func Contains[T comparable](s []T, item T) bool {
+	for _, v := range s {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package set
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIntersection(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+		want []string
+	}{
+		{name: "both empty", a: nil, b: nil, want: nil},
+		{name: "a empty", a: nil, b: []string{"x"}, want: nil},
+		{name: "b empty", a: []string{"x"}, b: nil, want: nil},
+		{name: "disjoint", a: []string{"x"}, b: []string{"y"}, want: nil},
+		{name: "keeps the order of b", a: []string{"x", "y", "z"}, b: []string{"z", "x"}, want: []string{"z", "x"}},
+		{name: "duplicates in a", a: []string{"x", "x"}, b: []string{"x", "y"}, want: []string{"x"}},
+		{name: "duplicates in b are kept", a: []string{"x"}, b: []string{"x", "y", "x"}, want: []string{"x", "x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Intersection(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Intersection(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnion(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+		want []string
+	}{
+		{name: "both empty", a: nil, b: nil, want: nil},
+		{name: "a empty", a: nil, b: []string{"x", "y"}, want: []string{"x", "y"}},
+		{name: "b empty", a: []string{"x", "y"}, b: nil, want: []string{"x", "y"}},
+		{name: "items of a then of b", a: []string{"z", "x"}, b: []string{"y", "x"}, want: []string{"z", "x", "y"}},
+		{name: "duplicates within a slice", a: []string{"x", "x"}, b: []string{"y", "y"}, want: []string{"x", "y"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Union(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Union(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDifference(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+		want []string
+	}{
+		{name: "both empty", a: nil, b: nil, want: nil},
+		{name: "a empty", a: nil, b: []string{"x"}, want: nil},
+		{name: "b empty", a: []string{"y", "x"}, b: nil, want: []string{"y", "x"}},
+		{name: "keeps the order of a", a: []string{"z", "y", "x"}, b: []string{"y"}, want: []string{"z", "x"}},
+		{name: "all removed", a: []string{"x", "y"}, b: []string{"y", "x"}, want: nil},
+		{name: "duplicates in a are kept", a: []string{"x", "y", "x"}, b: []string{"y"}, want: []string{"x", "x"}},
+		{name: "duplicates in b", a: []string{"x", "y"}, b: []string{"x", "x"}, want: []string{"y"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Difference(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Difference(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContains(t *testing.T) {
+	tests := []struct {
+		name string
+		s    []int
+		item int
+		want bool
+	}{
+		{name: "empty", s: nil, item: 1, want: false},
+		{name: "present", s: []int{3, 1, 2}, item: 1, want: true},
+		{name: "absent", s: []int{3, 2}, item: 1, want: false},
+		{name: "duplicates", s: []int{1, 1}, item: 1, want: true},
+		{name: "zero value", s: []int{0}, item: 0, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Contains(tt.s, tt.item); got != tt.want {
+				t.Errorf("Contains(%v, %d) = %v, want %v", tt.s, tt.item, got, tt.want)
+			}
+		})
+	}
+}